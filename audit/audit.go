@@ -0,0 +1,199 @@
+// Package audit keeps an append-only trail of administrative actions in
+// organizations: who did what to which resource, what changed, and from where.
+package audit
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"zuri.chat/zccore/logger"
+	"zuri.chat/zccore/utils"
+)
+
+const (
+	Collection = "audit_logs"
+
+	recordTimeout = 5 * time.Second
+)
+
+// Actions recorded in the audit log.
+const (
+	ActionOrgUpdate         = "organization.update"
+	ActionOrgSettingsUpdate = "organization.settings.update"
+	ActionOrgBillingUpdate  = "organization.billing.update"
+	ActionOrgUpgrade        = "organization.upgrade"
+	ActionOrgDelete         = "organization.delete"
+	ActionOrgDeleteCancel   = "organization.delete.cancel"
+	ActionOwnershipTransfer = "organization.ownership.transfer"
+	ActionTransferOffer     = "organization.ownership.offer"
+	ActionTransferCancel    = "organization.ownership.cancel"
+	ActionTransferDecline   = "organization.ownership.decline"
+	ActionSSOUpdate         = "organization.sso.update"
+	ActionSSODelete         = "organization.sso.delete"
+	ActionMemberAdd         = "member.add"
+	ActionMemberInvite      = "member.invite"
+	ActionInviteRevoke      = "invite.revoke"
+	ActionInviteResend      = "invite.resend"
+	ActionInviteLinkCreate  = "invite_link.create"
+	ActionInviteLinkRevoke  = "invite_link.revoke"
+	ActionMemberJoin        = "member.join"
+	ActionMemberImport      = "member.import"
+	ActionMemberExport      = "member.export"
+	ActionMemberRoleUpdate  = "member.role.update"
+	ActionMemberDeactivate  = "member.deactivate"
+	ActionMemberReactivate  = "member.reactivate"
+	ActionRoleCreate        = "role.create"
+	ActionRoleUpdate        = "role.update"
+	ActionRoleDelete        = "role.delete"
+	ActionGroupCreate       = "group.create"
+	ActionGroupUpdate       = "group.update"
+	ActionGroupArchive      = "group.archive"
+	ActionGroupUnarchive    = "group.unarchive"
+	ActionGroupMembersAdd   = "group.members.add"
+	ActionGroupMemberRemove = "group.members.remove"
+	ActionGroupManagers     = "group.managers.update"
+	ActionAPIKeyCreate      = "api_key.create"
+	ActionAPIKeyRevoke      = "api_key.revoke"
+	ActionPluginInstall     = "plugin.install"
+	ActionPluginRemove      = "plugin.remove"
+	ActionPluginRegister    = "plugin.register"
+	ActionPluginUpdate      = "plugin.update"
+	ActionPluginDelete      = "plugin.delete"
+)
+
+// Target types.
+const (
+	TargetOrganization = "organization"
+	TargetMember       = "member"
+	TargetInvite       = "invite"
+	TargetInviteLink   = "invite_link"
+	TargetRole         = "role"
+	TargetGroup        = "group"
+	TargetAPIKey       = "api_key"
+	TargetPlugin       = "plugin"
+)
+
+// Event is an entry of the audit log. Events are only ever inserted.
+type Event struct {
+	ID         primitive.ObjectID     `json:"id" bson:"_id,omitempty"`
+	OrgID      string                 `json:"org_id" bson:"org_id,omitempty"`
+	ActorID    string                 `json:"actor_id" bson:"actor_id"`
+	ActorEmail string                 `json:"actor_email" bson:"actor_email"`
+	Action     string                 `json:"action" bson:"action"`
+	TargetType string                 `json:"target_type" bson:"target_type"`
+	TargetID   string                 `json:"target_id" bson:"target_id"`
+	Before     map[string]interface{} `json:"before,omitempty" bson:"before,omitempty"`
+	After      map[string]interface{} `json:"after,omitempty" bson:"after,omitempty"`
+	IP         string                 `json:"ip" bson:"ip"`
+	UserAgent  string                 `json:"user_agent" bson:"user_agent"`
+	CreatedAt  time.Time              `json:"created_at" bson:"created_at"`
+}
+
+// Actor is implemented by the logged in user stored in the request context.
+type Actor interface {
+	AuditActor() (id, email string)
+}
+
+// Record appends an event for the request, filling in the actor, IP address, user
+// agent and time. It is best effort: failures are logged and never fail the request,
+// which has already taken effect.
+func Record(r *http.Request, e Event) {
+	if actor, ok := r.Context().Value("user").(Actor); ok && e.ActorID == "" {
+		e.ActorID, e.ActorEmail = actor.AuditActor()
+		e.ActorEmail = strings.ToLower(e.ActorEmail)
+	}
+
+	e.ID = primitive.NilObjectID
+	e.IP = utils.ClientIP(r)
+	e.UserAgent = r.UserAgent()
+	e.CreatedAt = time.Now()
+
+	if utils.GetDefaultMongoClient() == nil {
+		logger.Error("Error recording audit event %s: database is not connected", e.Action)
+		return
+	}
+
+	// the action happened whether or not the client is still waiting
+	ctx, cancel := context.WithTimeout(context.Background(), recordTimeout)
+	defer cancel()
+
+	if _, err := utils.GetCollection(Collection).InsertOne(ctx, e); err != nil {
+		logger.Error("Error recording audit event %s: %s", e.Action, err.Error())
+	}
+}
+
+// Diff compares two snapshots of a document and returns the fields that changed, keyed
+// by dotted path, with their values before and after. Snapshots are structs or maps
+// and are compared in their bson form.
+func Diff(before, after interface{}) (changedFrom, changedTo map[string]interface{}) {
+	b, a := flatten("", toMap(before)), flatten("", toMap(after))
+	changedFrom, changedTo = map[string]interface{}{}, map[string]interface{}{}
+
+	for k, v := range b {
+		if w, ok := a[k]; !ok || !reflect.DeepEqual(v, w) {
+			changedFrom[k] = v
+
+			if ok {
+				changedTo[k] = w
+			}
+		}
+	}
+
+	for k, w := range a {
+		if _, ok := b[k]; !ok {
+			changedTo[k] = w
+		}
+	}
+
+	return changedFrom, changedTo
+}
+
+func toMap(v interface{}) bson.M {
+	if v == nil {
+		return nil
+	}
+
+	data, err := bson.Marshal(v)
+	if err != nil {
+		return nil
+	}
+
+	var m bson.M
+	if err := bson.Unmarshal(data, &m); err != nil {
+		return nil
+	}
+
+	return m
+}
+
+// flatten turns nested documents into dotted paths, arrays are compared whole.
+func flatten(prefix string, m bson.M) map[string]interface{} {
+	flat := map[string]interface{}{}
+
+	for k, v := range m {
+		key := k
+		if prefix != "" {
+			key = prefix + "." + k
+		}
+
+		switch doc := v.(type) {
+		case bson.M:
+			for fk, fv := range flatten(key, doc) {
+				flat[fk] = fv
+			}
+		case bson.D:
+			for fk, fv := range flatten(key, doc.Map()) {
+				flat[fk] = fv
+			}
+		default:
+			flat[key] = v
+		}
+	}
+
+	return flat
+}
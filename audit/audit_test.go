@@ -0,0 +1,157 @@
+package audit
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type settings struct {
+	Name   string   `bson:"name"`
+	Prefs  prefs    `bson:"prefs"`
+	Emojis []string `bson:"emojis"`
+}
+
+type prefs struct {
+	Theme  string `bson:"theme"`
+	Public bool   `bson:"public"`
+}
+
+func TestDiff(t *testing.T) {
+	before := settings{Name: "zuri", Prefs: prefs{Theme: "dark", Public: true}, Emojis: []string{"a"}}
+	after := settings{Name: "zuri", Prefs: prefs{Theme: "light", Public: true}, Emojis: []string{"a", "b"}}
+
+	from, to := Diff(before, after)
+
+	if len(from) != 2 || from["prefs.theme"] != "dark" {
+		t.Errorf("unexpected before %v", from)
+	}
+
+	if len(to) != 2 || to["prefs.theme"] != "light" {
+		t.Errorf("unexpected after %v", to)
+	}
+
+	if _, ok := to["emojis"]; !ok {
+		t.Errorf("expected changed array in after %v", to)
+	}
+}
+
+func TestDiffAddedAndRemovedFields(t *testing.T) {
+	from, to := Diff(bson.M{"role": "member", "old": 1}, bson.M{"role": "admin", "new": 2})
+
+	if !reflect.DeepEqual(from, map[string]interface{}{"role": "member", "old": int32(1)}) {
+		t.Errorf("unexpected before %v", from)
+	}
+
+	if !reflect.DeepEqual(to, map[string]interface{}{"role": "admin", "new": int32(2)}) {
+		t.Errorf("unexpected after %v", to)
+	}
+}
+
+func TestDiffNil(t *testing.T) {
+	from, to := Diff(nil, bson.M{"name": "zuri"})
+
+	if len(from) != 0 || to["name"] != "zuri" {
+		t.Errorf("unexpected diff %v %v", from, to)
+	}
+
+	from, to = Diff(bson.M{"name": "zuri"}, bson.M{"name": "zuri"})
+	if len(from) != 0 || len(to) != 0 {
+		t.Errorf("expected no changes, got %v %v", from, to)
+	}
+}
+
+func TestLogFilter(t *testing.T) {
+	r := httptest.NewRequest("GET", "/organizations/org1/audit-logs?action=member.deactivate,member.reactivate&actor=Admin@Zuri.chat&target_type=member&from=2021-09-01T00:00:00Z", nil)
+	r = mux.SetURLVars(r, map[string]string{"id": "org1"})
+
+	filter, err := logFilter(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := bson.M{
+		"org_id":      "org1",
+		"action":      bson.M{"$in": []string{"member.deactivate", "member.reactivate"}},
+		"actor_email": "admin@zuri.chat",
+		"target_type": "member",
+		"created_at":  bson.M{"$gte": time.Date(2021, 9, 1, 0, 0, 0, 0, time.UTC)},
+	}
+
+	if !reflect.DeepEqual(filter, want) {
+		t.Errorf("got %v, want %v", filter, want)
+	}
+}
+
+func TestLogFilterActorID(t *testing.T) {
+	r := httptest.NewRequest("GET", "/organizations/org1/audit-logs?actor=6145d4a4", nil)
+	r = mux.SetURLVars(r, map[string]string{"id": "org1"})
+
+	filter, err := logFilter(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if filter["actor_id"] != "6145d4a4" {
+		t.Errorf("expected actor id filter, got %v", filter)
+	}
+}
+
+func TestLogFilterInvalidTime(t *testing.T) {
+	r := httptest.NewRequest("GET", "/organizations/org1/audit-logs?to=yesterday", nil)
+	r = mux.SetURLVars(r, map[string]string{"id": "org1"})
+
+	if _, err := logFilter(r); err != ErrInvalidTime {
+		t.Errorf("expected ErrInvalidTime, got %v", err)
+	}
+}
+
+func TestCSVRow(t *testing.T) {
+	e := &Event{
+		CreatedAt:  time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC),
+		Action:     ActionMemberRoleUpdate,
+		ActorEmail: "admin@zuri.chat",
+		TargetType: TargetMember,
+		TargetID:   "m1",
+		Before:     map[string]interface{}{"role": "member"},
+		After:      map[string]interface{}{"role": "admin"},
+		UserAgent:  "=HYPERLINK(\"http://evil\")",
+	}
+
+	row := csvRow(e)
+
+	if len(row) != len(csvHeader) {
+		t.Fatalf("row has %d columns, header has %d", len(row), len(csvHeader))
+	}
+
+	if row[0] != "2021-09-01T12:00:00Z" || row[6] != `{"role":"member"}` || row[7] != `{"role":"admin"}` {
+		t.Errorf("unexpected row %v", row)
+	}
+
+	if row[9] != `'=HYPERLINK("http://evil")` {
+		t.Errorf("formula was not neutralized: %s", row[9])
+	}
+}
+
+func TestGetLimitAndPage(t *testing.T) {
+	tests := []struct {
+		limit, page         string
+		wantLimit, wantPage int
+	}{
+		{"", "", defaultLimit, 1},
+		{"10", "3", 10, 3},
+		{"100000", "0", maxLimit, 1},
+		{"-1", "x", defaultLimit, 1},
+	}
+
+	for _, tt := range tests {
+		limit, page := getLimitAndPage(tt.limit, tt.page)
+		if limit != tt.wantLimit || page != tt.wantPage {
+			t.Errorf("getLimitAndPage(%q, %q) = %d, %d", tt.limit, tt.page, limit, page)
+		}
+	}
+}
@@ -0,0 +1,446 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"zuri.chat/zccore/logger"
+	"zuri.chat/zccore/service"
+	"zuri.chat/zccore/user"
+	"zuri.chat/zccore/utils"
+)
+
+const (
+	// accountJobInterval is how often scheduled deletions and expired exports are purged.
+	accountJobInterval = time.Hour
+	exportTimeout      = 30 * time.Minute
+)
+
+var (
+	ErrAccountSuspended    = errors.New("this account has been suspended, contact support for help")
+	ErrAccountTokenDenied  = errors.New("api tokens can't manage the account, sign in with a session instead")
+	ErrOwnsOrganizations   = errors.New("transfer ownership of your organizations or delete them before deleting your account")
+	ErrDeletionScheduled   = errors.New("account deletion is already scheduled")
+	ErrDeletionNotFound    = errors.New("account deletion is not scheduled")
+	ErrExportInProgress    = errors.New("a data export is already being prepared")
+	ErrExportNotFound      = errors.New("data export not found or has expired")
+	ErrExportNotReady      = errors.New("data export is not ready yet")
+	ErrSuspendSelf         = errors.New("you can't suspend your own account")
+	ErrAccountNotSuspended = errors.New("account is not suspended")
+)
+
+type DeleteAccountRequest struct {
+	Password string `json:"password"`
+}
+
+type SuspendUserRequest struct {
+	Reason string `json:"reason" validate:"max=512"`
+}
+
+// accountStatusError reports why u can't sign in, if it can't.
+func accountStatusError(u *user.User) error {
+	if u.Status == user.Suspended {
+		return ErrAccountSuspended
+	}
+
+	return nil
+}
+
+// RequestAccountDeletion schedules the logged in user's account for deletion after
+// the grace period. Users who own organizations have to hand them over first.
+func (au *AuthHandler) RequestAccountDeletion(w http.ResponseWriter, r *http.Request) {
+	u, ok := accountOwner(w, r)
+	if !ok {
+		return
+	}
+
+	var req DeleteAccountRequest
+	if err := utils.ParseJSONFromRequest(r, &req); err != nil {
+		utils.GetError(err, http.StatusUnprocessableEntity, w)
+		return
+	}
+
+	// accounts created through an identity provider may have no password
+	if u.Password != "" && !ComparePassword(req.Password, u.Password) {
+		utils.GetError(ErrCurrentPassword, http.StatusBadRequest, w)
+		return
+	}
+
+	if u.DeletionScheduledAt != nil {
+		utils.GetError(ErrDeletionScheduled, http.StatusConflict, w)
+		return
+	}
+
+	owned := utils.CountCollection(r.Context(), user.MemberCollectionName,
+		bson.M{"email": u.Email, "role": "owner", "deleted": bson.M{"$ne": true}})
+	if owned > 0 {
+		utils.GetError(ErrOwnsOrganizations, http.StatusConflict, w)
+		return
+	}
+
+	now := time.Now()
+	deleteAt := now.Add(time.Duration(au.configs.AccountDeletionGracePeriod) * time.Second)
+
+	// a suspension outlives the deletion request
+	update := bson.M{"deletion_scheduled_at": deleteAt}
+	if u.Status == user.Active {
+		update["status"], update["status_changed_at"] = user.Disabled, now
+	}
+
+	if _, err := utils.UpdateOneMongoDBDoc(userCollection, u.ID, update); err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	msger := au.mailService.NewMail([]string{u.Email}, "Your Zuri Chat account will be deleted", service.AccountDeletion, map[string]interface{}{
+		"FirstName":    u.FirstName,
+		"DeletionDate": deleteAt.UTC().Format("January 2, 2006"),
+	})
+
+	go func() {
+		if err := au.mailService.SendMail(msger); err != nil {
+			logger.Error("Error occurred while sending mail: %s", err.Error())
+		}
+	}()
+
+	utils.GetSuccess("account deletion scheduled", utils.M{"deletion_scheduled_at": deleteAt}, w)
+}
+
+// CancelAccountDeletion keeps an account that was scheduled for deletion.
+func (au *AuthHandler) CancelAccountDeletion(w http.ResponseWriter, r *http.Request) {
+	u, ok := accountOwner(w, r)
+	if !ok {
+		return
+	}
+
+	if u.DeletionScheduledAt == nil {
+		utils.GetError(ErrDeletionNotFound, http.StatusBadRequest, w)
+		return
+	}
+
+	update := bson.M{"$unset": bson.M{"deletion_scheduled_at": ""}}
+	if u.Status == user.Disabled {
+		update["$set"] = bson.M{"status": user.Active, "status_changed_at": time.Now()}
+	}
+
+	userID, _ := primitive.ObjectIDFromHex(u.ID)
+	if _, err := utils.GetCollection(userCollection).UpdateOne(r.Context(), bson.M{"_id": userID}, update); err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	utils.GetSuccess("account deletion cancelled", nil, w)
+}
+
+// RequestDataExport starts building an archive of the logged in user's data. The
+// archive is listed by GetDataExports once ready.
+func (au *AuthHandler) RequestDataExport(w http.ResponseWriter, r *http.Request) {
+	u, ok := accountOwner(w, r)
+	if !ok {
+		return
+	}
+
+	coll := utils.GetCollection(user.AccountExportCollectionName)
+
+	if utils.CountCollection(r.Context(), user.AccountExportCollectionName, bson.M{"user_id": u.ID, "status": user.ExportPending}) > 0 {
+		utils.GetError(ErrExportInProgress, http.StatusConflict, w)
+		return
+	}
+
+	now := time.Now()
+	export := user.AccountExport{
+		UserID:    u.ID,
+		Status:    user.ExportPending,
+		CreatedAt: now,
+		ExpiresAt: now.Add(time.Duration(au.configs.AccountExportTTL) * time.Second),
+	}
+
+	res, err := coll.InsertOne(r.Context(), export)
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	export.ID, _ = res.InsertedID.(primitive.ObjectID)
+
+	go au.buildExport(u, export.ID)
+
+	utils.GetSuccess("data export started, it will be listed once ready", export, w)
+}
+
+// GetDataExports lists the logged in user's data exports that have not expired.
+func (au *AuthHandler) GetDataExports(w http.ResponseWriter, r *http.Request) {
+	u, ok := accountOwner(w, r)
+	if !ok {
+		return
+	}
+
+	opts := options.Find().SetSort(bson.M{"created_at": -1})
+
+	cursor, err := utils.GetCollection(user.AccountExportCollectionName).Find(r.Context(),
+		bson.M{"user_id": u.ID, "expires_at": bson.M{"$gt": time.Now()}}, opts)
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	exports := []user.AccountExport{}
+	if err := cursor.All(r.Context(), &exports); err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	utils.GetSuccess("data exports retrieved successfully", exports, w)
+}
+
+// DownloadDataExport sends one of the logged in user's ready data exports.
+func (au *AuthHandler) DownloadDataExport(w http.ResponseWriter, r *http.Request) {
+	u, ok := accountOwner(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := primitive.ObjectIDFromHex(mux.Vars(r)["export_id"])
+	if err != nil {
+		utils.GetError(ErrorInvalid, http.StatusBadRequest, w)
+		return
+	}
+
+	var export user.AccountExport
+	if err := utils.GetCollection(user.AccountExportCollectionName).FindOne(r.Context(),
+		bson.M{"_id": id, "user_id": u.ID, "expires_at": bson.M{"$gt": time.Now()}}).Decode(&export); err != nil {
+		utils.GetError(ErrExportNotFound, http.StatusNotFound, w)
+		return
+	}
+
+	if export.Status != user.ExportReady {
+		utils.GetError(ErrExportNotReady, http.StatusConflict, w)
+		return
+	}
+
+	f, err := os.Open(export.Path)
+	if err != nil {
+		utils.GetError(ErrExportNotFound, http.StatusNotFound, w)
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"zuri-chat-export-%s.zip\"", export.CreatedAt.UTC().Format("2006-01-02")))
+
+	http.ServeContent(w, r, "", export.CreatedAt, f)
+}
+
+// SuspendUser lets zuri admins block an account: it can't sign in and its sessions and
+// personal access tokens are revoked.
+func (au *AuthHandler) SuspendUser(w http.ResponseWriter, r *http.Request) {
+	loggedIn, _ := r.Context().Value("user").(*AuthUser)
+	userID := mux.Vars(r)["user_id"]
+
+	var req SuspendUserRequest
+	if err := utils.ParseJSONFromRequest(r, &req); err != nil {
+		utils.GetError(err, http.StatusUnprocessableEntity, w)
+		return
+	}
+
+	if err := validate.Struct(req); err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	if userID == loggedIn.ID.Hex() {
+		utils.GetError(ErrSuspendSelf, http.StatusBadRequest, w)
+		return
+	}
+
+	u, err := FetchUserByID(userID)
+	if err != nil {
+		utils.GetError(ErrUserNotFound, http.StatusNotFound, w)
+		return
+	}
+
+	update := bson.M{"status": user.Suspended, "status_reason": strings.TrimSpace(req.Reason), "status_changed_at": time.Now()}
+	if _, err := utils.UpdateOneMongoDBDoc(userCollection, u.ID, update); err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	DeleteOtherSessions(u.ID, "")
+	revokePersonalTokens(r.Context(), u.ID)
+
+	utils.GetSuccess("user suspended", nil, w)
+}
+
+// UnsuspendUser lifts a suspension. Accounts scheduled for deletion stay scheduled.
+func (au *AuthHandler) UnsuspendUser(w http.ResponseWriter, r *http.Request) {
+	u, err := FetchUserByID(mux.Vars(r)["user_id"])
+	if err != nil {
+		utils.GetError(ErrUserNotFound, http.StatusNotFound, w)
+		return
+	}
+
+	if u.Status != user.Suspended {
+		utils.GetError(ErrAccountNotSuspended, http.StatusBadRequest, w)
+		return
+	}
+
+	status := user.Active
+	if u.DeletionScheduledAt != nil {
+		status = user.Disabled
+	}
+
+	userID, _ := primitive.ObjectIDFromHex(u.ID)
+	if _, err := utils.GetCollection(userCollection).UpdateOne(r.Context(), bson.M{"_id": userID}, bson.M{
+		"$set":   bson.M{"status": status, "status_changed_at": time.Now()},
+		"$unset": bson.M{"status_reason": ""},
+	}); err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	utils.GetSuccess("user suspension lifted", nil, w)
+}
+
+// StartAccountJobs purges accounts whose deletion grace period is over and expired
+// data exports, now and then every accountJobInterval until ctx is done.
+func (au *AuthHandler) StartAccountJobs(ctx context.Context) {
+	ticker := time.NewTicker(accountJobInterval)
+	defer ticker.Stop()
+
+	for {
+		if utils.GetDefaultMongoClient() != nil {
+			au.purgeAccounts(ctx)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (au *AuthHandler) purgeAccounts(ctx context.Context) {
+	now := time.Now()
+
+	if err := user.RemoveExports(ctx, bson.M{"expires_at": bson.M{"$lte": now}}); err != nil {
+		logger.Error("Error removing expired data exports: %s", err.Error())
+	}
+
+	cursor, err := utils.GetCollection(userCollection).Find(ctx, bson.M{"deletion_scheduled_at": bson.M{"$lte": now}})
+	if err != nil {
+		logger.Error("Error finding accounts to delete: %s", err.Error())
+		return
+	}
+
+	var users []user.User
+	if err := cursor.All(ctx, &users); err != nil {
+		logger.Error("Error finding accounts to delete: %s", err.Error())
+		return
+	}
+
+	for i := range users {
+		if err := purgeAccount(ctx, &users[i]); err != nil {
+			logger.Error("Error deleting account %s: %s", users[i].ID, err.Error())
+		}
+	}
+}
+
+// purgeAccount signs the user out everywhere, removes their credentials and then
+// their data.
+func purgeAccount(ctx context.Context, u *user.User) error {
+	DeleteOtherSessions(u.ID, "")
+
+	deletes := map[string]bson.M{
+		RefreshTokenCollection:       {"user_id": u.ID},
+		APITokenCollection:           {"user_id": u.ID},
+		PasskeyCollection:            {"user_id": u.ID},
+		WebAuthnChallengeCollection:  {"user_id": u.ID},
+		KnownDeviceCollection:        {"user_id": u.ID},
+		MagicLinkCollection:          {"user_id": u.ID},
+		TwoFactorChallengeCollection: {"user_id": u.ID},
+	}
+
+	for coll, filter := range deletes {
+		if _, err := utils.GetCollection(coll).DeleteMany(ctx, filter); err != nil {
+			return err
+		}
+	}
+
+	return user.PurgeUserData(ctx, u)
+}
+
+// buildExport writes the user's archive and marks the export ready, or failed.
+func (au *AuthHandler) buildExport(u *user.User, id primitive.ObjectID) {
+	ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+	defer cancel()
+
+	path := filepath.Join(au.configs.AccountExportDir, id.Hex()+".zip")
+	update := bson.M{"status": user.ExportReady, "path": path, "completed_at": time.Now()}
+
+	size, err := writeExportFile(ctx, u, path)
+	if err != nil {
+		logger.Error("Error exporting data of user %s: %s", u.ID, err.Error())
+
+		//nolint:errcheck //CODEI8: best effort cleanup, expired exports are removed anyway
+		os.Remove(path)
+
+		update = bson.M{"status": user.ExportFailed, "error": "the export could not be completed, try again later", "completed_at": time.Now()}
+	} else {
+		update["size"] = size
+	}
+
+	//nolint:errcheck //CODEI8: nothing left to tell the user
+	utils.GetCollection(user.AccountExportCollectionName).UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": update})
+}
+
+func writeExportFile(ctx context.Context, u *user.User, path string) (int64, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return 0, err
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	if err := user.WriteExport(ctx, u, f); err != nil {
+		return 0, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	return info.Size(), f.Close()
+}
+
+// accountOwner returns the logged in user, account management needs a session.
+func accountOwner(w http.ResponseWriter, r *http.Request) (*user.User, bool) {
+	loggedIn, _ := r.Context().Value("user").(*AuthUser)
+
+	if loggedIn.Token != nil {
+		utils.GetError(ErrAccountTokenDenied, http.StatusForbidden, w)
+		return nil, false
+	}
+
+	u, err := FetchUserByEmail(bson.M{"email": strings.ToLower(loggedIn.Email)})
+	if err != nil {
+		utils.GetError(ErrUserNotFound, http.StatusBadRequest, w)
+		return nil, false
+	}
+
+	return u, true
+}
@@ -0,0 +1,349 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"zuri.chat/zccore/audit"
+	"zuri.chat/zccore/utils"
+)
+
+const (
+	APITokenCollection = "api_tokens"
+
+	// PersonalTokenPrefix and APIKeyPrefix mark the two kinds of credentials, so they
+	// are told apart from JWTs and are easy to spot in leaked secrets.
+	PersonalTokenPrefix = "zcp_"
+	APIKeyPrefix        = "zck_"
+
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+	ScopeAdmin = "admin"
+
+	apiTokenBytes      = 32
+	apiTokenHintLength = 8
+)
+
+var (
+	ErrInvalidAPIToken   = errors.New("api token is invalid, revoked or has expired")
+	ErrAPITokenScope     = errors.New("api token does not have the scope this request needs")
+	ErrAPIKeyOrg         = errors.New("organization api keys can only be used on their organization's endpoints")
+	ErrAPITokenForbidden = errors.New("api tokens can't manage api tokens, sign in with a session instead")
+	ErrSessionRequired   = errors.New("api tokens can't manage sign in credentials, sign in with a session instead")
+	ErrAPITokenExpiry    = errors.New("expires_at must be in the future and within the maximum token lifetime")
+	ErrAPITokenNotFound  = errors.New("api token not found")
+)
+
+// scopeLevels orders the scopes, each scope includes the ones below it.
+var scopeLevels = map[string]int{ScopeRead: 1, ScopeWrite: 2, ScopeAdmin: 3}
+
+// APIToken is a long-lived credential for scripts and bots. A personal access token
+// acts as its owner; an organization API key acts as the admin who created it, but
+// only on that organization's endpoints. Only a hash of the token is stored.
+type APIToken struct {
+	ID         primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserID     string             `json:"-" bson:"user_id"`
+	OrgID      string             `json:"org_id,omitempty" bson:"org_id,omitempty"`
+	Name       string             `json:"name" bson:"name"`
+	Scopes     []string           `json:"scopes" bson:"scopes"`
+	TokenHash  string             `json:"-" bson:"token_hash"`
+	Hint       string             `json:"hint" bson:"hint"`
+	CreatedBy  string             `json:"created_by" bson:"created_by"`
+	CreatedAt  time.Time          `json:"created_at" bson:"created_at"`
+	ExpiresAt  time.Time          `json:"expires_at" bson:"expires_at"`
+	LastUsedAt *time.Time         `json:"last_used_at" bson:"last_used_at,omitempty"`
+}
+
+type CreateAPITokenRequest struct {
+	Name   string   `json:"name" validate:"required,max=100"`
+	Scopes []string `json:"scopes" validate:"required,min=1,dive,oneof=read write admin"`
+	// ExpiresAt defaults to the configured token lifetime from now.
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+type CreateAPITokenResponse struct {
+	APIToken
+	// Secret is the token itself, it is only ever returned here.
+	Secret string `json:"token"`
+}
+
+// HasScope reports whether the token grants scope, directly or through a wider scope.
+func (t *APIToken) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if scopeLevels[s] >= scopeLevels[scope] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isAPIToken reports whether a bearer token is a personal access token or API key
+// rather than a JWT.
+func isAPIToken(token string) bool {
+	return strings.HasPrefix(token, PersonalTokenPrefix) || strings.HasPrefix(token, APIKeyPrefix)
+}
+
+// bearerToken returns the bearer token of r, if any.
+func bearerToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if len(header) < len("Bearer ") || !strings.EqualFold(header[:len("Bearer ")], "Bearer ") {
+		return ""
+	}
+
+	return strings.TrimSpace(header[len("Bearer "):])
+}
+
+// requestScope is the scope a request needs, by its method.
+func requestScope(r *http.Request) string {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return ScopeRead
+	default:
+		return ScopeWrite
+	}
+}
+
+// authenticateAPIToken resolves the user an API token acts as and records its use.
+func authenticateAPIToken(r *http.Request, secret string) (*AuthUser, error) {
+	ctx := r.Context()
+
+	var t APIToken
+	if err := utils.GetCollection(APITokenCollection).FindOneAndUpdate(ctx,
+		bson.M{"token_hash": utils.HashToken(secret), "expires_at": bson.M{"$gt": time.Now()}},
+		bson.M{"$set": bson.M{"last_used_at": time.Now()}},
+	).Decode(&t); err != nil {
+		return nil, ErrInvalidAPIToken
+	}
+
+	// the plugin data api names the organization elsewhere, RequireDataPermission
+	// checks it against the key
+	if t.OrgID != "" && !strings.HasPrefix(r.URL.Path, "/data/") &&
+		(mux.Vars(r)["id"] != t.OrgID || !strings.HasPrefix(r.URL.Path, "/organizations/")) {
+		return nil, ErrAPIKeyOrg
+	}
+
+	if !t.HasScope(requestScope(r)) {
+		return nil, ErrAPITokenScope
+	}
+
+	u, err := FetchUserByID(t.UserID)
+	if err != nil || u.Deactivated || accountStatusError(u) != nil {
+		return nil, ErrInvalidAPIToken
+	}
+
+	id, _ := primitive.ObjectIDFromHex(u.ID)
+
+	return &AuthUser{ID: id, Email: u.Email, Token: &t}, nil
+}
+
+// Create a personal access token for the logged in user.
+func (au *AuthHandler) CreatePersonalToken(w http.ResponseWriter, r *http.Request) {
+	au.createAPIToken(w, r, "", PersonalTokenPrefix)
+}
+
+// List the personal access tokens of the logged in user.
+func (au *AuthHandler) GetPersonalTokens(w http.ResponseWriter, r *http.Request) {
+	loggedIn, _ := r.Context().Value("user").(*AuthUser)
+
+	u, err := FetchUserByEmail(bson.M{"email": strings.ToLower(loggedIn.Email)})
+	if err != nil {
+		utils.GetError(ErrUserNotFound, http.StatusBadRequest, w)
+		return
+	}
+
+	au.listAPITokens(w, r, bson.M{"user_id": u.ID, "org_id": bson.M{"$exists": false}})
+}
+
+// Revoke one of the logged in user's personal access tokens.
+func (au *AuthHandler) RevokePersonalToken(w http.ResponseWriter, r *http.Request) {
+	loggedIn, _ := r.Context().Value("user").(*AuthUser)
+
+	u, err := FetchUserByEmail(bson.M{"email": strings.ToLower(loggedIn.Email)})
+	if err != nil {
+		utils.GetError(ErrUserNotFound, http.StatusBadRequest, w)
+		return
+	}
+
+	au.revokeAPIToken(w, r, bson.M{"user_id": u.ID, "org_id": bson.M{"$exists": false}})
+}
+
+// Create an API key for the organization in the route.
+func (au *AuthHandler) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	au.createAPIToken(w, r, mux.Vars(r)["id"], APIKeyPrefix)
+}
+
+// List the API keys of an organization.
+func (au *AuthHandler) GetAPIKeys(w http.ResponseWriter, r *http.Request) {
+	au.listAPITokens(w, r, bson.M{"org_id": mux.Vars(r)["id"]})
+}
+
+// Revoke an API key of an organization.
+func (au *AuthHandler) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	au.revokeAPIToken(w, r, bson.M{"org_id": mux.Vars(r)["id"]})
+}
+
+func (au *AuthHandler) createAPIToken(w http.ResponseWriter, r *http.Request, orgID, prefix string) {
+	loggedIn, _ := r.Context().Value("user").(*AuthUser)
+
+	if loggedIn.Token != nil {
+		utils.GetError(ErrAPITokenForbidden, http.StatusForbidden, w)
+		return
+	}
+
+	var req CreateAPITokenRequest
+	if err := utils.ParseJSONFromRequest(r, &req); err != nil {
+		utils.GetError(err, http.StatusUnprocessableEntity, w)
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+
+	if err := validate.Struct(req); err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	now := time.Now()
+	maxExpiry := now.Add(time.Duration(au.configs.APITokenMaxTTL) * time.Second)
+	expiresAt := now.Add(time.Duration(au.configs.APITokenTTL) * time.Second)
+
+	if req.ExpiresAt != nil {
+		expiresAt = *req.ExpiresAt
+	}
+
+	if !expiresAt.After(now) || expiresAt.After(maxExpiry) {
+		utils.GetError(ErrAPITokenExpiry, http.StatusBadRequest, w)
+		return
+	}
+
+	u, err := FetchUserByEmail(bson.M{"email": strings.ToLower(loggedIn.Email)})
+	if err != nil {
+		utils.GetError(ErrUserNotFound, http.StatusBadRequest, w)
+		return
+	}
+
+	secret, err := utils.RandomToken(apiTokenBytes)
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	secret = prefix + secret
+
+	t := APIToken{
+		UserID:    u.ID,
+		OrgID:     orgID,
+		Name:      req.Name,
+		Scopes:    uniqueScopes(req.Scopes),
+		TokenHash: utils.HashToken(secret),
+		Hint:      secret[:len(prefix)+apiTokenHintLength],
+		CreatedBy: u.Email,
+		CreatedAt: now,
+		ExpiresAt: expiresAt,
+	}
+
+	res, err := utils.GetCollection(APITokenCollection).InsertOne(r.Context(), t)
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	t.ID, _ = res.InsertedID.(primitive.ObjectID)
+
+	if orgID != "" {
+		audit.Record(r, audit.Event{
+			OrgID:      orgID,
+			Action:     audit.ActionAPIKeyCreate,
+			TargetType: audit.TargetAPIKey,
+			TargetID:   t.ID.Hex(),
+			After:      map[string]interface{}{"name": t.Name, "scopes": t.Scopes, "expires_at": t.ExpiresAt},
+		})
+	}
+
+	utils.GetSuccess("api token created successfully, it will not be shown again", CreateAPITokenResponse{APIToken: t, Secret: secret}, w)
+}
+
+func (au *AuthHandler) listAPITokens(w http.ResponseWriter, r *http.Request, filter bson.M) {
+	opts := options.Find().SetSort(bson.M{"created_at": -1})
+
+	cursor, err := utils.GetCollection(APITokenCollection).Find(r.Context(), filter, opts)
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	tokens := []APIToken{}
+	if err := cursor.All(r.Context(), &tokens); err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	utils.GetSuccess("api tokens retrieved successfully", tokens, w)
+}
+
+func (au *AuthHandler) revokeAPIToken(w http.ResponseWriter, r *http.Request, filter bson.M) {
+	loggedIn, _ := r.Context().Value("user").(*AuthUser)
+
+	if loggedIn.Token != nil {
+		utils.GetError(ErrAPITokenForbidden, http.StatusForbidden, w)
+		return
+	}
+
+	tokenID, err := primitive.ObjectIDFromHex(mux.Vars(r)["token_id"])
+	if err != nil {
+		utils.GetError(ErrorInvalid, http.StatusBadRequest, w)
+		return
+	}
+
+	filter["_id"] = tokenID
+
+	res, err := utils.GetCollection(APITokenCollection).DeleteOne(r.Context(), filter)
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	if res.DeletedCount == 0 {
+		utils.GetError(ErrAPITokenNotFound, http.StatusNotFound, w)
+		return
+	}
+
+	if orgID, ok := filter["org_id"].(string); ok {
+		audit.Record(r, audit.Event{OrgID: orgID, Action: audit.ActionAPIKeyRevoke, TargetType: audit.TargetAPIKey, TargetID: tokenID.Hex()})
+	}
+
+	utils.GetSuccess("api token revoked successfully", nil, w)
+}
+
+func uniqueScopes(scopes []string) []string {
+	seen := make(map[string]bool, len(scopes))
+	unique := make([]string, 0, len(scopes))
+
+	for _, s := range scopes {
+		if !seen[s] {
+			seen[s] = true
+			unique = append(unique, s)
+		}
+	}
+
+	return unique
+}
+
+// revokePersonalTokens deletes the personal access tokens of a user, used when whoever
+// knew the old password may have created some. Organization API keys are left alone so
+// integrations keep working.
+func revokePersonalTokens(ctx context.Context, userID string) {
+	_, err := utils.GetCollection(APITokenCollection).DeleteMany(ctx, bson.M{"user_id": userID, "org_id": bson.M{"$exists": false}})
+	if err != nil {
+		fmt.Printf("%v", err)
+	}
+}
@@ -0,0 +1,289 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"zuri.chat/zccore/logger"
+	"zuri.chat/zccore/service"
+	"zuri.chat/zccore/user"
+	"zuri.chat/zccore/utils"
+)
+
+const (
+	KnownDeviceCollection = "known_devices"
+	deviceCookieName      = "zc_device"
+	deviceCookieMaxAge    = 60 * 60 * 24 * 365 * 2
+	deviceIDBytes         = 24
+	lastSeenResolution    = time.Minute
+)
+
+var ErrSessionNotFound = errors.New("session not found")
+
+// KnownDevice records a device a user has signed in from before, so only logins
+// from unfamiliar devices trigger a notification.
+type KnownDevice struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty"`
+	UserID      string             `bson:"user_id"`
+	DeviceHash  string             `bson:"device_hash"`
+	UserAgent   string             `bson:"user_agent"`
+	Network     string             `bson:"network"`
+	FirstSeenAt time.Time          `bson:"first_seen_at"`
+	LastSeenAt  time.Time          `bson:"last_seen_at"`
+}
+
+type SessionResponse struct {
+	ID         string    `json:"id"`
+	Name       string    `json:"name"`
+	Device     string    `json:"device"`
+	UserAgent  string    `json:"user_agent"`
+	IPAddress  string    `json:"ip_address"`
+	Location   string    `json:"location"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	Current    bool      `json:"current"`
+}
+
+type SessionRenameRequest struct {
+	Name string `json:"name" validate:"required,max=64"`
+}
+
+// GetSessions lists the active sessions of the logged in user, most recently used first.
+func (au *AuthHandler) GetSessions(w http.ResponseWriter, r *http.Request) {
+	loggedIn, _ := r.Context().Value("user").(*AuthUser)
+
+	userID, err := sessionOwnerID(loggedIn)
+	if err != nil {
+		utils.GetError(ErrUserNotFound, http.StatusBadRequest, w)
+		return
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "last_seen_at", Value: -1}})
+
+	cursor, err := utils.GetCollection(sessionCollection).Find(r.Context(), bson.M{"user_id": userID}, opts)
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	var docs []Session
+	if err := cursor.All(r.Context(), &docs); err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	resp := make([]SessionResponse, 0, len(docs))
+
+	for i := range docs {
+		s := docs[i]
+		resp = append(resp, SessionResponse{
+			ID:         s.ID.Hex(),
+			Name:       s.Name,
+			Device:     utils.DeviceLabel(s.UserAgent),
+			UserAgent:  s.UserAgent,
+			IPAddress:  s.IPAddress,
+			Location:   s.Location,
+			CreatedAt:  s.CreatedAt,
+			LastSeenAt: s.LastSeenAt,
+			Current:    s.ID == loggedIn.ID,
+		})
+	}
+
+	utils.GetSuccess("sessions retrieved successfully", resp, w)
+}
+
+// RenameSession sets a user chosen label on one of the user's sessions.
+func (au *AuthHandler) RenameSession(w http.ResponseWriter, r *http.Request) {
+	loggedIn, _ := r.Context().Value("user").(*AuthUser)
+
+	var req SessionRenameRequest
+	if err := utils.ParseJSONFromRequest(r, &req); err != nil {
+		utils.GetError(err, http.StatusUnprocessableEntity, w)
+		return
+	}
+
+	if err := validate.Struct(req); err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	filter, err := ownedSessionFilter(r, loggedIn)
+	if err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	res, err := utils.GetCollection(sessionCollection).UpdateOne(r.Context(), filter,
+		bson.M{"$set": bson.M{"name": strings.TrimSpace(req.Name)}})
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	if res.MatchedCount == 0 {
+		utils.GetError(ErrSessionNotFound, http.StatusNotFound, w)
+		return
+	}
+
+	utils.GetSuccess("session renamed", nil, w)
+}
+
+// RevokeSession logs out a single session of the logged in user, including the current one.
+func (au *AuthHandler) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	loggedIn, _ := r.Context().Value("user").(*AuthUser)
+
+	filter, err := ownedSessionFilter(r, loggedIn)
+	if err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	res, err := utils.GetCollection(sessionCollection).DeleteOne(r.Context(), filter)
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	if res.DeletedCount == 0 {
+		utils.GetError(ErrSessionNotFound, http.StatusNotFound, w)
+		return
+	}
+
+	revokeRefreshTokens(r.Context(), bson.M{"session_id": filter["_id"]})
+
+	utils.GetSuccess("session revoked", nil, w)
+}
+
+// touchSession bumps last_seen_at, at most once per lastSeenResolution to keep
+// authenticated requests from writing on every call.
+func touchSession(ctx context.Context, id primitive.ObjectID) {
+	now := time.Now()
+	filter := bson.M{"_id": id, "last_seen_at": bson.M{"$not": bson.M{"$gte": now.Add(-lastSeenResolution)}}}
+
+	//nolint:errcheck //CODEI8: last seen is informational only
+	utils.GetCollection(sessionCollection).UpdateOne(ctx, filter, bson.M{"$set": bson.M{"last_seen_at": now}})
+}
+
+// recognizeDevice identifies the device behind r with a long-lived cookie, falling
+// back to the user agent from the same network for clients that drop cookies, and
+// emails the user the first time a login comes from a device they have not used before.
+// A user agent alone is shared by everyone on the same browser build.
+func (au *AuthHandler) recognizeDevice(w http.ResponseWriter, r *http.Request, u *user.User) {
+	deviceID := ""
+	if c, err := r.Cookie(deviceCookieName); err == nil {
+		deviceID = c.Value
+	}
+
+	if deviceID == "" {
+		var err error
+		if deviceID, err = utils.RandomToken(deviceIDBytes); err != nil {
+			return
+		}
+
+		http.SetCookie(w, &http.Cookie{
+			Name:     deviceCookieName,
+			Value:    deviceID,
+			Path:     "/",
+			MaxAge:   deviceCookieMaxAge,
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+
+	ctx := r.Context()
+	coll := utils.GetCollection(KnownDeviceCollection)
+	ua := r.UserAgent()
+	network := deviceNetwork(r)
+
+	known := []bson.M{{"device_hash": utils.HashToken(deviceID)}}
+	if network != "" {
+		known = append(known, bson.M{"user_agent": ua, "network": network})
+	}
+
+	filter := bson.M{"user_id": u.ID, "$or": known}
+
+	res, err := coll.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"last_seen_at": time.Now()}})
+	if err != nil || res.MatchedCount > 0 {
+		return
+	}
+
+	// the very first login of an account is expected, only later new devices are news
+	firstDevice := utils.CountCollection(ctx, KnownDeviceCollection, bson.M{"user_id": u.ID}) == 0
+
+	if _, err := coll.InsertOne(ctx, KnownDevice{
+		UserID:      u.ID,
+		DeviceHash:  utils.HashToken(deviceID),
+		UserAgent:   ua,
+		Network:     network,
+		FirstSeenAt: time.Now(),
+		LastSeenAt:  time.Now(),
+	}); err != nil || firstDevice || !au.configs.NewDeviceEmail {
+		return
+	}
+
+	msger := au.mailService.NewMail([]string{u.Email}, "New sign-in to your Zuri Chat account", service.NewDeviceLogin, map[string]interface{}{
+		"FirstName": u.FirstName,
+		"Device":    utils.DeviceLabel(ua),
+		"IPAddress": utils.ClientIP(r),
+		"Location":  utils.LocationLabel(r),
+		"Time":      time.Now().UTC().Format("Jan 2, 2006 at 15:04 MST"),
+	})
+
+	go func() {
+		if err := au.mailService.SendMail(msger); err != nil {
+			logger.Error("Error occurred while sending mail: %s", err.Error())
+		}
+	}()
+}
+
+// deviceNetwork returns where r comes from, coarsely enough to stay the same across
+// logins: the CDN location if there is one, otherwise the client's /24 or /48 network.
+func deviceNetwork(r *http.Request) string {
+	if location := utils.LocationLabel(r); location != "" {
+		return location
+	}
+
+	ip := net.ParseIP(utils.ClientIP(r))
+	if ip == nil {
+		return ""
+	}
+
+	if v4 := ip.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(24, 32)).String() + "/24"
+	}
+
+	return ip.Mask(net.CIDRMask(48, 128)).String() + "/48"
+}
+
+// sessionOwnerID resolves the user id sessions are stored under for the logged in user.
+func sessionOwnerID(loggedIn *AuthUser) (primitive.ObjectID, error) {
+	u, err := FetchUserByEmail(bson.M{"email": strings.ToLower(loggedIn.Email)})
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+
+	return primitive.ObjectIDFromHex(u.ID)
+}
+
+// ownedSessionFilter matches the session in the route only if it belongs to the logged in user.
+func ownedSessionFilter(r *http.Request, loggedIn *AuthUser) (bson.M, error) {
+	sessionID, err := primitive.ObjectIDFromHex(mux.Vars(r)["session_id"])
+	if err != nil {
+		return nil, ErrorInvalid
+	}
+
+	userID, err := sessionOwnerID(loggedIn)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+
+	return bson.M{"_id": sessionID, "user_id": userID}, nil
+}
@@ -0,0 +1,309 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"zuri.chat/zccore/logger"
+	"zuri.chat/zccore/service"
+	"zuri.chat/zccore/user"
+	"zuri.chat/zccore/utils"
+)
+
+const (
+	EmailRevertCollection = "email_reverts"
+	emailRevertTokenBytes = 32
+	// accounts without a password prove themselves by having signed in this recently
+	recentSignInWindow = 10 * time.Minute
+)
+
+var (
+	ErrSameEmail          = errors.New("this is already the email address of your account")
+	ErrEmailChangeCode    = errors.New("invalid or expired code, confirm and try again or request a new one")
+	ErrInvalidEmailRevert = errors.New("this link is invalid or has expired, contact support to recover your account")
+	ErrRecentSignIn       = errors.New("kindly sign in again before changing your email address")
+)
+
+// EmailRevert lets the previous address of an account undo an email change for a few
+// days, in case the change was made by someone else.
+type EmailRevert struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	UserID    string             `bson:"user_id"`
+	OldEmail  string             `bson:"old_email"`
+	NewEmail  string             `bson:"new_email"`
+	TokenHash string             `bson:"token_hash"`
+	ExpiresAt time.Time          `bson:"expires_at"`
+	CreatedAt time.Time          `bson:"created_at"`
+}
+
+type EmailChangeRequest struct {
+	Email    string `json:"email" validate:"required,email"`
+	Password string `json:"password"`
+}
+
+type EmailChangeVerifyRequest struct {
+	Email string `json:"email" validate:"required,email"`
+	Code  string `json:"code" validate:"required"`
+}
+
+type EmailRevertRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+// RequestEmailChange emails a code to the new address of the logged in user and warns
+// the current address. The email only changes once the code is confirmed.
+func (au *AuthHandler) RequestEmailChange(w http.ResponseWriter, r *http.Request) {
+	u, ok := accountOwner(w, r)
+	if !ok {
+		return
+	}
+
+	var req EmailChangeRequest
+	if err := utils.ParseJSONFromRequest(r, &req); err != nil {
+		utils.GetError(err, http.StatusUnprocessableEntity, w)
+		return
+	}
+
+	if err := validate.Struct(req); err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	// accounts created through an identity provider may have no password
+	if u.Password == "" && !signedInRecently(r) {
+		utils.GetError(ErrRecentSignIn, http.StatusForbidden, w)
+		return
+	}
+
+	if u.Password != "" && !ComparePassword(req.Password, u.Password) {
+		utils.GetError(ErrCurrentPassword, http.StatusBadRequest, w)
+		return
+	}
+
+	email := strings.ToLower(strings.TrimSpace(req.Email))
+
+	if email == u.Email {
+		utils.GetError(ErrSameEmail, http.StatusBadRequest, w)
+		return
+	}
+
+	if !utils.IsValidEmail(email) {
+		utils.GetError(errors.New("email address is not valid"), http.StatusBadRequest, w)
+		return
+	}
+
+	if _, err := FetchUserByEmail(bson.M{"email": email}); err == nil {
+		utils.GetError(user.ErrEmailTaken, http.StatusConflict, w)
+		return
+	}
+
+	code, err := user.IssueToken(r.Context(), user.PurposeEmailChange, u.ID, email, map[string]interface{}{"old_email": u.Email})
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	confirm := au.mailService.NewMail([]string{email}, "Confirm your new Zuri Chat email address", service.EmailChange, map[string]interface{}{
+		"FirstName": u.FirstName,
+		"Email":     email,
+		"Code":      code,
+		"ExpiresIn": "1 hour",
+	})
+	notice := au.mailService.NewMail([]string{u.Email}, "Your Zuri Chat email address is changing", service.EmailChangeNotice, map[string]interface{}{
+		"FirstName": u.FirstName,
+		"NewEmail":  email,
+	})
+
+	go au.sendMails(confirm, notice)
+
+	utils.GetSuccess("a confirmation code has been sent to the new email address", nil, w)
+}
+
+// VerifyEmailChange confirms the code sent to the new address and moves the account,
+// its workspace profiles and organizations to it. Every session is signed out, and the
+// previous address gets a link to undo the change.
+func (au *AuthHandler) VerifyEmailChange(w http.ResponseWriter, r *http.Request) {
+	u, ok := accountOwner(w, r)
+	if !ok {
+		return
+	}
+
+	var req EmailChangeVerifyRequest
+	if err := utils.ParseJSONFromRequest(r, &req); err != nil {
+		utils.GetError(err, http.StatusUnprocessableEntity, w)
+		return
+	}
+
+	if err := validate.Struct(req); err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	email := strings.ToLower(strings.TrimSpace(req.Email))
+
+	t, err := user.ConsumeToken(r.Context(), user.PurposeEmailChange, email, req.Code)
+	if err != nil || t.UserID != u.ID || t.Data["old_email"] != u.Email {
+		utils.GetError(ErrEmailChangeCode, http.StatusBadRequest, w)
+		return
+	}
+
+	if !au.changeEmail(w, r, u.ID, u.Email, email) {
+		return
+	}
+
+	token, err := utils.RandomToken(emailRevertTokenBytes)
+	if err != nil {
+		logger.Error("Error issuing email revert link: %s", err.Error())
+		utils.GetSuccess("email address changed, kindly sign in again", nil, w)
+
+		return
+	}
+
+	revert := EmailRevert{
+		UserID:    u.ID,
+		OldEmail:  u.Email,
+		NewEmail:  email,
+		TokenHash: utils.HashToken(token),
+		ExpiresAt: time.Now().Add(time.Duration(au.configs.EmailRevertTTL) * time.Second),
+		CreatedAt: time.Now(),
+	}
+
+	if _, err := utils.GetCollection(EmailRevertCollection).InsertOne(r.Context(), revert); err != nil {
+		logger.Error("Error issuing email revert link: %s", err.Error())
+	}
+
+	notice := au.mailService.NewMail([]string{u.Email}, "Your Zuri Chat email address has changed", service.EmailChangeNotice, map[string]interface{}{
+		"FirstName":    u.FirstName,
+		"NewEmail":     email,
+		"RevertLink":   au.configs.EmailRevertURL + "?token=" + url.QueryEscape(token),
+		"RevertBefore": revert.ExpiresAt.UTC().Format("January 2, 2006 15:04 MST"),
+	})
+
+	go au.sendMails(notice)
+
+	utils.GetSuccess("email address changed, kindly sign in again", nil, w)
+}
+
+// RevertEmailChange moves an account back to the address it had before an email
+// change, auth not required since whoever made the change may hold the account. The
+// link and those of later changes stop working, while links of earlier changes keep
+// working so a chained change can't be used to take them away. Every other way to sign
+// in is revoked, see revokeCredentials.
+func (au *AuthHandler) RevertEmailChange(w http.ResponseWriter, r *http.Request) {
+	var req EmailRevertRequest
+	if err := utils.ParseJSONFromRequest(r, &req); err != nil {
+		utils.GetError(err, http.StatusUnprocessableEntity, w)
+		return
+	}
+
+	if err := validate.Struct(req); err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	var revert EmailRevert
+	if err := utils.GetCollection(EmailRevertCollection).FindOne(r.Context(), bson.M{
+		"token_hash": utils.HashToken(req.Token),
+		"expires_at": bson.M{"$gt": time.Now()},
+	}).Decode(&revert); err != nil {
+		utils.GetError(ErrInvalidEmailRevert, http.StatusBadRequest, w)
+		return
+	}
+
+	u, err := FetchUserByID(revert.UserID)
+	if err != nil {
+		utils.GetError(ErrInvalidEmailRevert, http.StatusBadRequest, w)
+		return
+	}
+
+	if u.Email != revert.OldEmail && !au.changeEmail(w, r, u.ID, u.Email, revert.OldEmail) {
+		return
+	}
+
+	if err := revokeCredentials(r.Context(), u); err != nil {
+		utils.GetError(fmt.Errorf("could not secure the account: %w", err), http.StatusInternalServerError, w)
+		return
+	}
+
+	// the account may already be back on its address, sign it out all the same
+	DeleteOtherSessions(u.ID, "")
+	revokePersonalTokens(r.Context(), u.ID)
+
+	//nolint:errcheck //CODEI8: they expire anyway
+	utils.GetCollection(EmailRevertCollection).DeleteMany(r.Context(), bson.M{
+		"user_id":    u.ID,
+		"created_at": bson.M{"$gte": revert.CreatedAt},
+	})
+
+	utils.GetSuccess("email address restored, kindly reset your password to sign in again", nil, w)
+}
+
+// signedInRecently reports whether the session making r was started within
+// recentSignInWindow, which stands in for the password of accounts that have none.
+func signedInRecently(r *http.Request) bool {
+	loggedIn, _ := r.Context().Value("user").(*AuthUser)
+	if loggedIn == nil || loggedIn.Token != nil {
+		return false
+	}
+
+	return utils.CountCollection(r.Context(), sessionCollection, bson.M{
+		"_id":        loggedIn.ID,
+		"created_at": bson.M{"$gte": time.Now().Add(-recentSignInWindow)},
+	}) > 0
+}
+
+// revokeCredentials removes the passkeys, two factor enrollment and linked identities
+// of u and clears its password. Whoever changed the email may have added any of them
+// while holding the account, even before requesting the change, so the owner sets them
+// up again after resetting the password from the restored address.
+func revokeCredentials(ctx context.Context, u *user.User) error {
+	if _, err := utils.GetCollection(PasskeyCollection).DeleteMany(ctx, bson.M{"user_id": u.ID}); err != nil {
+		return err
+	}
+
+	_, err := utils.GetCollection(userCollection).UpdateOne(ctx, bson.M{"_id": objectID(u.ID)}, bson.M{
+		"$set":   bson.M{"password": "", "social": user.Socials{}},
+		"$unset": bson.M{"two_factor": ""},
+	})
+
+	return err
+}
+
+// changeEmail moves the account and signs it out everywhere, since sessions and tokens
+// were issued for the previous address.
+func (au *AuthHandler) changeEmail(w http.ResponseWriter, r *http.Request, userID, from, to string) bool {
+	err := user.ChangeEmail(r.Context(), userID, from, to)
+
+	switch {
+	case errors.Is(err, user.ErrEmailTaken):
+		utils.GetError(err, http.StatusConflict, w)
+		return false
+	case errors.Is(err, user.ErrEmailChanged):
+		utils.GetError(err, http.StatusConflict, w)
+		return false
+	case err != nil:
+		utils.GetError(fmt.Errorf("could not change the email address: %w", err), http.StatusInternalServerError, w)
+		return false
+	}
+
+	DeleteOtherSessions(userID, "")
+	revokePersonalTokens(r.Context(), userID)
+	clearAttempts(context.Background(), accountScope.key(from))
+
+	return true
+}
+
+func (au *AuthHandler) sendMails(mails ...*service.Mail) {
+	for _, m := range mails {
+		if err := au.mailService.SendMail(m); err != nil {
+			logger.Error("Error occurred while sending mail: %s", err.Error())
+		}
+	}
+}
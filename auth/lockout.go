@@ -0,0 +1,224 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"zuri.chat/zccore/logger"
+	"zuri.chat/zccore/service"
+	"zuri.chat/zccore/user"
+	"zuri.chat/zccore/utils"
+)
+
+const (
+	LoginAttemptCollection = "login_attempts"
+
+	// failures are forgotten once a key has been quiet for this long
+	attemptWindow = time.Hour
+	// failures before each further attempt has to wait, doubling up to maxAttemptDelay
+	attemptDelayAfter = 3
+	maxAttemptDelay   = time.Minute
+	baseLockDuration  = 15 * time.Minute
+	maxLockDuration   = 24 * time.Hour
+)
+
+// attemptScope is a class of keys failures are counted against, e.g. an account or an IP.
+type attemptScope struct {
+	prefix    string
+	threshold int
+}
+
+var (
+	accountScope = attemptScope{prefix: "account", threshold: 10}
+	ipScope      = attemptScope{prefix: "ip", threshold: 50}
+	codeScope    = attemptScope{prefix: "code", threshold: 10}
+	// code guesses spread over many IPs still count against the email
+	codeEmailScope = attemptScope{prefix: "code-email", threshold: 10}
+)
+
+// LoginAttempt counts recent failures for a single key. Documents are shared by every
+// API instance, so limits hold no matter which instance serves a request.
+type LoginAttempt struct {
+	Key           string    `bson:"key" json:"key"`
+	Failures      int       `bson:"failures" json:"failures"`
+	Lockouts      int       `bson:"lockouts" json:"lockouts"`
+	LastFailureAt time.Time `bson:"last_failure_at" json:"last_failure_at"`
+	LockedUntil   time.Time `bson:"locked_until" json:"locked_until"`
+}
+
+// AttemptLimitError is returned while a key is delayed or locked out.
+type AttemptLimitError struct {
+	RetryAfter time.Duration
+	Locked     bool
+}
+
+func (e *AttemptLimitError) Error() string {
+	wait := int(math.Ceil(e.RetryAfter.Seconds()))
+	if e.Locked {
+		return fmt.Sprintf("too many failed attempts, access is temporarily locked, try again in %d seconds", wait)
+	}
+
+	return fmt.Sprintf("too many failed attempts, try again in %d seconds", wait)
+}
+
+func (s attemptScope) key(id string) string {
+	return s.prefix + ":" + strings.ToLower(id)
+}
+
+// checkAttempts returns an AttemptLimitError if any of keys is currently locked or
+// has to wait out a progressive delay.
+func checkAttempts(ctx context.Context, keys ...string) error {
+	cursor, err := utils.GetCollection(LoginAttemptCollection).Find(ctx, bson.M{"key": bson.M{"$in": keys}})
+	if err != nil {
+		return nil
+	}
+
+	var attempts []LoginAttempt
+	if err := cursor.All(ctx, &attempts); err != nil {
+		return nil
+	}
+
+	var limit *AttemptLimitError
+
+	now := time.Now()
+
+	for _, a := range attempts {
+		var e *AttemptLimitError
+
+		switch {
+		case a.LockedUntil.After(now):
+			e = &AttemptLimitError{RetryAfter: a.LockedUntil.Sub(now), Locked: true}
+		case a.Failures >= attemptDelayAfter && now.Sub(a.LastFailureAt) < attemptWindow:
+			if next := a.LastFailureAt.Add(attemptDelay(a.Failures)); next.After(now) {
+				e = &AttemptLimitError{RetryAfter: next.Sub(now)}
+			}
+		}
+
+		if e != nil && (limit == nil || e.RetryAfter > limit.RetryAfter) {
+			limit = e
+		}
+	}
+
+	if limit == nil {
+		return nil
+	}
+
+	return limit
+}
+
+// recordFailure counts a failed attempt against id in scope and reports whether it
+// just tipped the key into a lockout.
+func recordFailure(ctx context.Context, scope attemptScope, id string) bool {
+	coll := utils.GetCollection(LoginAttemptCollection)
+	key := scope.key(id)
+	now := time.Now()
+
+	// a quiet key starts counting from scratch
+	//nolint:errcheck //CODEI8: best effort reset
+	coll.UpdateOne(ctx, bson.M{"key": key, "last_failure_at": bson.M{"$lt": now.Add(-attemptWindow)}},
+		bson.M{"$set": bson.M{"failures": 0}})
+
+	var a LoginAttempt
+
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	if err := coll.FindOneAndUpdate(ctx, bson.M{"key": key},
+		bson.M{"$inc": bson.M{"failures": 1}, "$set": bson.M{"last_failure_at": now}}, opts,
+	).Decode(&a); err != nil {
+		logger.Error("could not record failed attempt for %s: %v", key, err)
+		return false
+	}
+
+	if a.Failures < scope.threshold {
+		return false
+	}
+
+	// every repeated lockout doubles in length
+	lockFor := time.Duration(float64(baseLockDuration) * math.Pow(2, float64(a.Lockouts)))
+	if lockFor > maxLockDuration {
+		lockFor = maxLockDuration
+	}
+
+	res, err := coll.UpdateOne(ctx, bson.M{"key": key, "failures": a.Failures},
+		bson.M{"$set": bson.M{"failures": 0, "locked_until": now.Add(lockFor)}, "$inc": bson.M{"lockouts": 1}})
+
+	return err == nil && res.ModifiedCount == 1
+}
+
+// clearAttempts forgets all failures for keys, e.g. after a successful login.
+func clearAttempts(ctx context.Context, keys ...string) {
+	//nolint:errcheck //CODEI8: best effort cleanup
+	utils.GetCollection(LoginAttemptCollection).DeleteMany(ctx, bson.M{"key": bson.M{"$in": keys}})
+}
+
+func attemptDelay(failures int) time.Duration {
+	d := time.Second * time.Duration(math.Pow(2, float64(failures-attemptDelayAfter)))
+	if d > maxAttemptDelay || d <= 0 {
+		return maxAttemptDelay
+	}
+
+	return d
+}
+
+// writeAttemptError responds with 429 and a Retry-After header if err is an
+// AttemptLimitError, otherwise it falls back to status.
+func writeAttemptError(w http.ResponseWriter, err error, status int) {
+	var limit *AttemptLimitError
+	if errors.As(err, &limit) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(limit.RetryAfter.Seconds()))))
+		utils.GetError(err, http.StatusTooManyRequests, w)
+
+		return
+	}
+
+	utils.GetError(err, status, w)
+}
+
+// recordLoginFailure counts a failed login against both the account and the client
+// IP, and emails the owner when the account gets locked.
+func (au *AuthHandler) recordLoginFailure(r *http.Request, email string, u *user.User) {
+	recordFailure(r.Context(), ipScope, utils.ClientIP(r))
+
+	if !recordFailure(r.Context(), accountScope, email) || u == nil {
+		return
+	}
+
+	msger := au.mailService.NewMail([]string{u.Email}, "Your Zuri Chat account has been locked", service.AccountLocked, map[string]interface{}{
+		"FirstName": u.FirstName,
+		"IPAddress": utils.ClientIP(r),
+	})
+
+	go func() {
+		if err := au.mailService.SendMail(msger); err != nil {
+			logger.Error("Error occurred while sending mail: %s", err.Error())
+		}
+	}()
+}
+
+// UnlockAccount lets zuri admins clear a lockout before it expires.
+func (au *AuthHandler) UnlockAccount(w http.ResponseWriter, r *http.Request) {
+	u, err := FetchUserByID(mux.Vars(r)["user_id"])
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			utils.GetError(ErrUserNotFound, http.StatusNotFound, w)
+			return
+		}
+
+		utils.GetError(err, http.StatusInternalServerError, w)
+
+		return
+	}
+
+	clearAttempts(r.Context(), accountScope.key(u.Email))
+
+	utils.GetSuccess("account unlocked", nil, w)
+}
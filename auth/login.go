@@ -13,9 +13,11 @@ import (
 	"github.com/go-playground/validator/v10"
 	"github.com/golang-jwt/jwt"
 	"github.com/gorilla/mux"
+	"github.com/gorilla/securecookie"
 	"github.com/gorilla/sessions"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"zuri.chat/zccore/logger"
 	"zuri.chat/zccore/user"
 	"zuri.chat/zccore/utils"
 )
@@ -35,21 +37,35 @@ var (
 )
 
 func (au *AuthHandler) GetAuthToken(u *user.User, sess *sessions.Session) (*Token, error) {
-	retoken := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"session_name": Resptoken.SessionName,
-		"cookie":       Resptoken.Cookie,
-		"options":      Resptoken.Options,
-		"id":           Resptoken.ID,
+	store, ok := sess.Store().(*MongoStore)
+	if !ok {
+		return nil, ErrorInvalid
+	}
+
+	// the cookie is derived from the session itself rather than the last saved
+	// session, so concurrent logins can't leak each other's session into a token
+	cookie, err := securecookie.EncodeMulti(sess.Name(), sess.ID, store.Codecs...)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	tokenString, err := au.configs.SigningKeys.Sign(jwt.MapClaims{
+		"session_name": sess.Name(),
+		"cookie":       cookie,
+		"options":      sess.Options,
+		"id":           sess.ID,
 		"email":        u.Email,
+		"iat":          now.Unix(),
+		"exp":          now.Add(time.Duration(au.configs.AccessTokenTTL) * time.Second).Unix(),
 	})
-
-	tokenString, err := retoken.SignedString([]byte(au.configs.HmacSampleSecret))
 	if err != nil {
 		return nil, err
 	}
 
 	resp := &Token{
 		SessionID: sess.ID,
+		ExpiresIn: au.configs.AccessTokenTTL,
 		User: UserResponse{
 			ID:        u.ID,
 			FirstName: u.FirstName,
@@ -66,6 +82,50 @@ func (au *AuthHandler) GetAuthToken(u *user.User, sess *sessions.Session) (*Toke
 	return resp, nil
 }
 
+// StartSession creates a new session for u and returns the token the client
+// authenticates subsequent requests with.
+func (au *AuthHandler) StartSession(w http.ResponseWriter, r *http.Request, u *user.User) (*Token, error) {
+	if err := accountStatusError(u); err != nil {
+		return nil, err
+	}
+
+	store := NewMongoStore(utils.GetCollection(sessionCollection), au.configs.SessionMaxAge, true, []byte(au.configs.SecretKey))
+
+	session, err := store.Get(r, au.configs.SessionKey)
+	if err != nil {
+		return nil, err
+	}
+
+	session.Values["id"] = u.ID
+	session.Values["email"] = u.Email
+
+	if err := sessions.Save(r, w); err != nil {
+		return nil, fmt.Errorf("error saving session: %w", err)
+	}
+
+	au.recognizeDevice(w, r, u)
+
+	token, err := au.issueTokens(r.Context(), u, session, "")
+	if err != nil {
+		return nil, err
+	}
+
+	token.JoinableOrganizations = joinableOrganizations(r.Context(), u.Email)
+
+	return token, nil
+}
+
+// joinableOrganizations lists the organizations email can join, failures only cost the
+// suggestion.
+func joinableOrganizations(ctx context.Context, email string) []user.JoinableOrganization {
+	orgs, err := user.JoinableOrganizations(ctx, email)
+	if err != nil {
+		logger.Error("Error listing joinable organizations: %s", err.Error())
+	}
+
+	return orgs
+}
+
 func (au *AuthHandler) LoginIn(response http.ResponseWriter, request *http.Request) {
 	response.Header().Add("content-type", "application/json")
 
@@ -80,9 +140,17 @@ func (au *AuthHandler) LoginIn(response http.ResponseWriter, request *http.Reque
 		return
 	}
 
-	vser, err := FetchUserByEmail(bson.M{"email": strings.ToLower(creds.Email)})
+	email := strings.ToLower(creds.Email)
+	if err := checkAttempts(request.Context(), accountScope.key(email), ipScope.key(utils.ClientIP(request))); err != nil {
+		writeAttemptError(response, err, http.StatusTooManyRequests)
+		return
+	}
+
+	vser, err := FetchUserByEmail(bson.M{"email": email})
 	if err != nil {
+		au.recordLoginFailure(request, email, nil)
 		utils.GetError(ErrUserNotFound, http.StatusUnauthorized, response)
+
 		return
 	}
 	// check if user is verified
@@ -93,31 +161,37 @@ func (au *AuthHandler) LoginIn(response http.ResponseWriter, request *http.Reque
 
 	// check password
 	if check := ComparePassword(creds.Password, vser.Password); !check {
+		au.recordLoginFailure(request, email, vser)
 		utils.GetError(ErrInvalidCredentials, http.StatusBadRequest, response)
+
 		return
 	}
 
-	store := NewMongoStore(utils.GetCollection(sessionCollection), au.configs.SessionMaxAge, true, []byte(au.configs.SecretKey))
-
-	var session, e = store.Get(request, au.configs.SessionKey)
-	if e != nil {
-		msg := fmt.Errorf("%s", e.Error())
-		utils.GetError(msg, http.StatusBadRequest, response)
+	if err := accountStatusError(vser); err != nil {
+		utils.GetError(err, http.StatusForbidden, response)
+		return
+	}
 
+	// members of organizations that require single sign-on can't use their password
+	required, err := requiresSSO(request.Context(), email)
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, response)
 		return
 	}
 
-	// store session
-	session.Values["id"] = vser.ID
-	session.Values["email"] = vser.Email
+	if required {
+		utils.GetError(ErrSSORequired, http.StatusForbidden, response)
+		return
+	}
 
-	if err = sessions.Save(request, response); err != nil {
-		fmt.Printf("Error saving session: %s", err)
+	// accounts with two factor authentication get a challenge instead of a session
+	if respondTwoFactorChallenge(response, request, vser) {
 		return
 	}
 
-	resp, err := au.GetAuthToken(vser, session)
+	clearAttempts(request.Context(), accountScope.key(email))
 
+	resp, err := au.StartSession(response, request, vser)
 	if err != nil {
 		utils.GetError(err, http.StatusBadRequest, response)
 		return
@@ -141,7 +215,7 @@ func (au *AuthHandler) LogOutUser(w http.ResponseWriter, r *http.Request) {
 	)
 
 	session, err = store.Get(r, au.configs.SessionKey)
-	status, sessData, _ := GetSessionDataFromToken(r, []byte(au.configs.HmacSampleSecret))
+	status, sessData, _ := GetSessionDataFromToken(r, au.configs.SigningKeys)
 
 	if err != nil && status {
 		utils.GetError(ErrNotAuthorized, http.StatusUnauthorized, w)
@@ -163,6 +237,10 @@ func (au *AuthHandler) LogOutUser(w http.ResponseWriter, r *http.Request) {
 
 	session.Options.MaxAge = -1
 
+	if sid, err := primitive.ObjectIDFromHex(session.ID); err == nil {
+		revokeRefreshTokens(r.Context(), bson.M{"session_id": sid})
+	}
+
 	if err = ClearSession(store, w, session); err != nil {
 		fmt.Printf("Error saving session: %s", err)
 		utils.GetError(fmt.Errorf("logout Failed"), http.StatusUnauthorized, w)
@@ -210,7 +288,7 @@ func (au *AuthHandler) LogOutOtherSessions(w http.ResponseWriter, r *http.Reques
 
 	// Get  current session
 	session, err = store.Get(r, au.configs.SessionKey)
-	status, sessData, _ := GetSessionDataFromToken(r, []byte(au.configs.HmacSampleSecret))
+	status, sessData, _ := GetSessionDataFromToken(r, au.configs.SigningKeys)
 
 	if err != nil && status {
 		utils.GetError(ErrNotAuthorized, http.StatusUnauthorized, w)
@@ -302,14 +380,6 @@ func (au *AuthHandler) SocialAuth(w http.ResponseWriter, r *http.Request) {
 	}
 	defer resp.Body.Close()
 
-	store := NewMongoStore(utils.GetCollection(sessionCollection), au.configs.SessionMaxAge, true, []byte(au.configs.SecretKey))
-	session, e := store.Get(r, au.configs.SessionKey)
-
-	if e != nil {
-		utils.GetError(e, http.StatusBadRequest, w)
-		return
-	}
-
 	switch p := strings.ToLower(social.Provider); p {
 	case "google":
 		socialUser := struct {
@@ -324,62 +394,48 @@ func (au *AuthHandler) SocialAuth(w http.ResponseWriter, r *http.Request) {
 		//nolint:errcheck //CODEI8:
 		json.NewDecoder(resp.Body).Decode(&socialUser)
 
-		filter := bson.M{"$or": []bson.M{
-			{"email": socialUser.Email},
-			{"social.provider": p, "social.provider_id": socialUser.ID},
-		}}
+		// tokeninfo reports the flag as a string
+		identity := &oidcIdentity{
+			Subject:       socialUser.ID,
+			Email:         socialUser.Email,
+			EmailVerified: socialUser.EmailVerified == "true",
+			FirstName:     socialUser.FirstName,
+			LastName:      socialUser.LastName,
+		}
 
-		vser, err := FetchUserByEmail(filter)
+		vser, err := resolveOIDCUser(r.Context(), p, identity)
 		if err != nil {
-			social := &user.Social{ID: socialUser.ID, Provider: p}
-			b := &user.User{
-				FirstName:     socialUser.FirstName,
-				LastName:      socialUser.LastName,
-				Email:         socialUser.Email,
-				Password:      "",
-				Deactivated:   false,
-				IsVerified:    true,
-				Social:        social,
-				Timezone:      "Africa/Lagos",                       // set default timezone
-				Organizations: []string{"614679ee1a5607b13c00bcb7"}, // set default org
-				CreatedAt:     time.Now(),
+			status := http.StatusInternalServerError
+			if errors.Is(err, ErrOIDCEmailUnverified) {
+				status = http.StatusForbidden
 			}
-			detail, _ := utils.StructToMap(b)
-			res, er := utils.CreateMongoDBDoc(userCollection, detail)
 
-			if er != nil {
-				utils.GetError(er, http.StatusInternalServerError, w)
-				return
-			}
+			utils.GetError(err, status, w)
 
-			session.Values["id"] = res.InsertedID
-			session.Values["email"] = b.Email
-		} else {
-			// update record
-			social := map[string]interface{}{
-				"provider_id": socialUser.ID,
-				"provider":    p,
-			}
+			return
+		}
 
-			id, _ := primitive.ObjectIDFromHex(vser.ID)
-			filter := bson.M{"_id": id}
-			update := bson.M{"$set": bson.M{"social": social, "email": strings.ToLower(socialUser.Email)}}
+		if err := accountStatusError(vser); err != nil {
+			utils.GetError(err, http.StatusForbidden, w)
+			return
+		}
 
-			if _, e := utils.GetCollection(userCollection).UpdateOne(context.Background(), filter, update); e != nil {
-				utils.GetError(e, http.StatusInternalServerError, w)
-				return
-			}
+		required, err := requiresSSO(r.Context(), vser.Email)
+		if err != nil {
+			utils.GetError(err, http.StatusInternalServerError, w)
+			return
+		}
 
-			session.Values["id"] = vser.ID
-			session.Values["email"] = vser.Email
+		if required {
+			utils.GetError(ErrSSORequired, http.StatusForbidden, w)
+			return
 		}
 
-		if err = sessions.Save(r, w); err != nil {
-			fmt.Printf("Error saving session: %s", err)
+		if respondTwoFactorChallenge(w, r, vser) {
 			return
 		}
 
-		resp, err := au.GetAuthToken(vser, session)
+		resp, err := au.StartSession(w, r, vser)
 		if err != nil {
 			utils.GetError(err, http.StatusBadRequest, w)
 			return
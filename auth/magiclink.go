@@ -0,0 +1,194 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"zuri.chat/zccore/logger"
+	"zuri.chat/zccore/service"
+	"zuri.chat/zccore/user"
+	"zuri.chat/zccore/utils"
+)
+
+const (
+	MagicLinkCollection   = "magic_links"
+	magicLinkTTL          = 15 * time.Minute
+	magicLinkTokenBytes   = 32
+	magicLinkCookieName   = "zc_magic_link"
+	magicLinkBrowserBytes = 24
+)
+
+var ErrInvalidMagicLink = errors.New("sign in link is invalid, has expired or was requested from another browser, kindly request a new one")
+
+// MagicLink is a single-use sign in link sent by email. It can only be redeemed from
+// the browser that requested it, which holds the nonce its BrowserHash was made from.
+type MagicLink struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty"`
+	UserID      string             `bson:"user_id"`
+	TokenHash   string             `bson:"token_hash"`
+	BrowserHash string             `bson:"browser_hash"`
+	ExpiresAt   time.Time          `bson:"expires_at"`
+	CreatedAt   time.Time          `bson:"created_at"`
+}
+
+type MagicLinkRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+type MagicLinkVerifyRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+// RequestMagicLink emails a sign in link to the account, auth not required. Like
+// password resets, the response never reveals whether the email has an account.
+func (au *AuthHandler) RequestMagicLink(w http.ResponseWriter, r *http.Request) {
+	var req MagicLinkRequest
+	if err := utils.ParseJSONFromRequest(r, &req); err != nil {
+		utils.GetError(err, http.StatusUnprocessableEntity, w)
+		return
+	}
+
+	if err := validate.Struct(req); err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	// the nonce is set whether or not the account exists, so the response is the same
+	nonce, err := utils.RandomToken(magicLinkBrowserBytes)
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     magicLinkCookieName,
+		Value:    nonce,
+		Path:     "/",
+		MaxAge:   int(magicLinkTTL.Seconds()),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	if u, err := FetchUserByEmail(bson.M{"email": strings.ToLower(req.Email)}); err == nil && !u.Deactivated {
+		// sent in the background so response times don't reveal whether the account exists
+		go au.sendMagicLink(u, nonce)
+	}
+
+	utils.GetSuccess("If an account exists for this email, a sign in link has been sent", nil, w)
+}
+
+func (au *AuthHandler) sendMagicLink(u *user.User, nonce string) {
+	ctx := context.Background()
+
+	token, err := utils.RandomToken(magicLinkTokenBytes)
+	if err != nil {
+		logger.Error("Error issuing sign in link: %s", err.Error())
+		return
+	}
+
+	link := MagicLink{
+		UserID:      u.ID,
+		TokenHash:   utils.HashToken(token),
+		BrowserHash: utils.HashToken(nonce),
+		ExpiresAt:   time.Now().Add(magicLinkTTL),
+		CreatedAt:   time.Now(),
+	}
+
+	// a new link replaces any earlier one
+	//nolint:errcheck //CODEI8: stale links expire anyway
+	utils.GetCollection(MagicLinkCollection).DeleteMany(ctx, bson.M{"user_id": u.ID})
+
+	if _, err := utils.GetCollection(MagicLinkCollection).InsertOne(ctx, link); err != nil {
+		logger.Error("Error issuing sign in link: %s", err.Error())
+		return
+	}
+
+	msger := au.mailService.NewMail([]string{u.Email}, "Your Zuri Chat sign in link", service.MagicLink, map[string]interface{}{
+		"FirstName": u.FirstName,
+		"Link":      au.configs.MagicLinkURL + "?token=" + url.QueryEscape(token),
+		"ExpiresIn": fmt.Sprintf("%d minutes", int(magicLinkTTL.Minutes())),
+	})
+
+	if err := au.mailService.SendMail(msger); err != nil {
+		logger.Error("Error occurred while sending mail: %s", err.Error())
+	}
+}
+
+// VerifyMagicLink redeems a sign in link for a session. It must be called from the
+// browser that requested the link.
+func (au *AuthHandler) VerifyMagicLink(w http.ResponseWriter, r *http.Request) {
+	var req MagicLinkVerifyRequest
+	if err := utils.ParseJSONFromRequest(r, &req); err != nil {
+		utils.GetError(err, http.StatusUnprocessableEntity, w)
+		return
+	}
+
+	if err := validate.Struct(req); err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	cookie, err := r.Cookie(magicLinkCookieName)
+	if err != nil || cookie.Value == "" {
+		utils.GetError(ErrInvalidMagicLink, http.StatusUnauthorized, w)
+		return
+	}
+
+	var link MagicLink
+
+	// deleting on lookup makes the link single use, a link opened in another browser
+	// doesn't match and stays usable from the right one
+	err = utils.GetCollection(MagicLinkCollection).FindOneAndDelete(r.Context(), bson.M{
+		"token_hash":   utils.HashToken(req.Token),
+		"browser_hash": utils.HashToken(cookie.Value),
+	}).Decode(&link)
+	if err != nil || time.Now().After(link.ExpiresAt) {
+		utils.GetError(ErrInvalidMagicLink, http.StatusUnauthorized, w)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{Name: magicLinkCookieName, Path: "/", MaxAge: -1})
+
+	u, err := FetchUserByID(link.UserID)
+	if err != nil || u.Deactivated {
+		utils.GetError(ErrUserNotFound, http.StatusUnauthorized, w)
+		return
+	}
+
+	required, err := requiresSSO(r.Context(), u.Email)
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	if required {
+		utils.GetError(ErrSSORequired, http.StatusForbidden, w)
+		return
+	}
+
+	// following the link proves the user owns the email
+	if !u.IsVerified {
+		//nolint:errcheck //CODEI8: verification is a side effect of signing in
+		utils.GetCollection(userCollection).UpdateOne(r.Context(), bson.M{"_id": objectID(u.ID)},
+			bson.M{"$set": bson.M{"isverified": true}})
+	}
+
+	if respondTwoFactorChallenge(w, r, u) {
+		return
+	}
+
+	resp, err := au.StartSession(w, r, u)
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	utils.GetSuccess("login successful", resp, w)
+}
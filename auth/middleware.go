@@ -6,7 +6,6 @@ import (
 	"net/http"
 	"strings"
 
-	"github.com/gorilla/mux"
 	"github.com/gorilla/sessions"
 	"github.com/mitchellh/mapstructure"
 	"go.mongodb.org/mongo-driver/bson"
@@ -20,6 +19,32 @@ func (au *AuthHandler) IsAuthenticated(nextHandler http.HandlerFunc) http.Handle
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Add("content-type", "application/json")
 
+		if token := bearerToken(r); isAPIToken(token) {
+			u, err := authenticateAPIToken(r, token)
+			if err != nil {
+				status := http.StatusUnauthorized
+				if !errors.Is(err, ErrInvalidAPIToken) {
+					status = http.StatusForbidden
+				}
+
+				utils.GetError(err, status, w)
+
+				return
+			}
+
+			// tokens minted before the organization enforced two factor are held to it too
+			if requiresTwoFactor(r, u.Email) {
+				utils.GetError(ErrTwoFactorRequired, http.StatusForbidden, w)
+				return
+			}
+
+			//nolint:staticcheck //CODEI8: lint ignore
+			ctx := context.WithValue(r.Context(), UserContext, u)
+			nextHandler.ServeHTTP(w, r.WithContext(ctx))
+
+			return
+		}
+
 		var (
 			session      *sessions.Session
 			SessionEmail string
@@ -29,7 +54,7 @@ func (au *AuthHandler) IsAuthenticated(nextHandler http.HandlerFunc) http.Handle
 
 		store := NewMongoStore(utils.GetCollection(sessionCollection), au.configs.SessionMaxAge, true, []byte(au.configs.SecretKey))
 		session, _ = store.Get(r, au.configs.SessionKey)
-		status, sessData, _ := GetSessionDataFromToken(r, []byte(au.configs.HmacSampleSecret))
+		status, sessData, _ := GetSessionDataFromToken(r, au.configs.SigningKeys)
 
 		if status {
 			session, erro = NewS(store, sessData.Cookie, sessData.ID, sessData.Email, r, sessData.SessionName, sessData.Gothic)
@@ -57,6 +82,13 @@ func (au *AuthHandler) IsAuthenticated(nextHandler http.HandlerFunc) http.Handle
 			return
 		}
 
+		touchSession(r.Context(), objID)
+
+		if requiresTwoFactor(r, SessionEmail) {
+			utils.GetError(ErrTwoFactorRequired, http.StatusForbidden, w)
+			return
+		}
+
 		u := &AuthUser{
 			ID:    objID,
 			Email: SessionEmail,
@@ -67,30 +99,50 @@ func (au *AuthHandler) IsAuthenticated(nextHandler http.HandlerFunc) http.Handle
 	}
 }
 
+// RequireSession keeps api tokens off routes that manage sign in credentials, so a
+// leaked token can't be turned into a way to sign in. It goes after IsAuthenticated.
+func (au *AuthHandler) RequireSession(nextHandler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		loggedIn, _ := r.Context().Value("user").(*AuthUser)
+		if loggedIn == nil || loggedIn.Token != nil {
+			utils.GetError(ErrSessionRequired, http.StatusForbidden, w)
+			return
+		}
+
+		nextHandler.ServeHTTP(w, r)
+	}
+}
+
+// IsAuthorized checks a platform role, only "zuri_admin" for now. Organization routes
+// declare the permission they need with RequirePermission instead.
 func (au *AuthHandler) IsAuthorized(nextHandler http.HandlerFunc, role string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 
-		var (
-			orgID    string
-			authuser user.User
-			memb     RoleMember
-		)
+		var authuser user.User
+
+		loggedInUser, _ := r.Context().Value("user").(*AuthUser)
 
-		if mux.Vars(r)["id"] != "" {
-			orgID = mux.Vars(r)["id"]
+		// zuri admin access needs an admin scoped token
+		if loggedInUser.Token != nil && !loggedInUser.Token.HasScope(ScopeAdmin) {
+			utils.GetError(ErrAPITokenScope, http.StatusForbidden, w)
+			return
+		}
+
+		if loggedInUser.Token != nil && loggedInUser.Token.OrgID != "" {
+			utils.GetError(ErrAPIKeyOrg, http.StatusForbidden, w)
+			return
 		}
 
-		loggedInUser, _ := r.Context().Value("user").(*AuthUser)
 		lguser, ee := FetchUserByEmail(bson.M{"email": strings.ToLower(loggedInUser.Email)})
 
 		if ee != nil {
 			utils.GetError(errors.New("error Fetching Logged in User"), http.StatusBadRequest, w)
+			return
 		}
 
 		userID := lguser.ID
 		luHexid, _ := primitive.ObjectIDFromHex(userID)
-		_, userCollection, memberCollection := "organizations", "users", "members"
 		userDoc, _ := utils.GetMongoDBDoc(userCollection, bson.M{"_id": luHexid})
 
 		if userDoc == nil {
@@ -101,34 +153,15 @@ func (au *AuthHandler) IsAuthorized(nextHandler http.HandlerFunc, role string) h
 		//nolint:errcheck //CODEI8:
 		mapstructure.Decode(userDoc, &authuser)
 
-		if role == "zuri_admin" {
-			if authuser.Role != "admin" {
-				utils.GetError(errors.New("access Denied"), http.StatusUnauthorized, w)
-				return
-			}
-		} else {
-			// Getting member's document from db
-			orgMember, _ := utils.GetMongoDBDoc(memberCollection, bson.M{"org_id": orgID, "email": authuser.Email})
-			if orgMember == nil {
-				utils.GetError(errors.New("access Denied"), http.StatusUnauthorized, w)
-				return
-			}
-
-			//nolint:errcheck //CODEI8:
-			mapstructure.Decode(orgMember, &memb)
-
-			// check role's access
-			nA := map[string]int{"owner": 4, "admin": 3, "member": 2, "guest": 1}
-
-			if nA[role] > nA[memb.Role] {
-				utils.GetError(errors.New("access Denied"), http.StatusUnauthorized, w)
-				return
-			}
+		if role != "zuri_admin" || authuser.Role != "admin" {
+			utils.GetError(ErrAccessDenied, http.StatusUnauthorized, w)
+			return
 		}
 
 		u := &AuthUser{
 			ID:    luHexid,
 			Email: loggedInUser.Email,
+			Token: loggedInUser.Token,
 		}
 		//nolint:staticcheck //CODEI8: lint ignore
 		ctx := context.WithValue(r.Context(), UserContext, u)
@@ -145,7 +178,7 @@ func (au *AuthHandler) OptionalAuthentication(nextHandler http.HandlerFunc) http
 
 		store := NewMongoStore(utils.GetCollection(sessionCollection), au.configs.SessionMaxAge, true, []byte(au.configs.SecretKey))
 		_, er := store.Get(r, au.configs.SessionKey)
-		status, sessData, err := GetSessionDataFromToken(r, []byte(au.configs.HmacSampleSecret))
+		status, sessData, err := GetSessionDataFromToken(r, au.configs.SigningKeys)
 
 		if er != nil || err != nil {
 			if !status && sessData.Email == "" {
@@ -67,14 +67,30 @@ type Credentials struct {
 }
 
 type Token struct {
-	SessionID string       `json:"session_id"`
-	User      UserResponse `json:"user"`
+	SessionID    string       `json:"session_id"`
+	RefreshToken string       `json:"refresh_token,omitempty"`
+	ExpiresIn    int          `json:"expires_in,omitempty"`
+	User         UserResponse `json:"user"`
+	// JoinableOrganizations accept members from the domain of the user's email.
+	JoinableOrganizations []user.JoinableOrganization `json:"joinable_organizations,omitempty"`
 }
 
 //nolint:revive //CODEI8:
 type AuthUser struct {
 	ID    primitive.ObjectID `json:"id"`
 	Email string             `json:"email"`
+	// Token is the personal access token or API key the request was authenticated
+	// with, nil for sessions.
+	Token *APIToken `json:"-"`
+	// Role and Permissions are the user's in the organization of the request, set by
+	// RequirePermission.
+	Role        string   `json:"-"`
+	Permissions []string `json:"-"`
+}
+
+// AuditActor identifies the user in audit events.
+func (u *AuthUser) AuditActor() (id, email string) {
+	return u.ID.Hex(), u.Email
 }
 
 type MyCustomClaims struct {
@@ -115,6 +131,8 @@ func DeleteOtherSessions(userID, sessionID string) {
 	if err != nil {
 		fmt.Printf("%v", err)
 	}
+
+	revokeRefreshTokens(context.Background(), bson.M{"user_id": userID, "session_id": bson.M{"$ne": sid}})
 }
 
 func FetchUserByEmail(filter map[string]interface{}) (*user.User, error) {
@@ -144,7 +162,7 @@ func FetchUserByID(id string) (*user.User, error) {
 	return u, err
 }
 
-func GetSessionDataFromToken(r *http.Request, hmacSampleSecret []byte) (status bool, data ResToken, err error) {
+func GetSessionDataFromToken(r *http.Request, keys utils.SigningKeys) (status bool, data ResToken, err error) {
 	reqTokenh := r.Header.Get("Authorization")
 	if reqTokenh == "" {
 		return false, ResToken{}, fmt.Errorf("authorization access failed")
@@ -157,20 +175,15 @@ func GetSessionDataFromToken(r *http.Request, hmacSampleSecret []byte) (status b
 
 	reqToken := splitToken[1]
 
-	token, err := jwt.Parse(reqToken, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return hmacSampleSecret, nil
-	})
-
+	token, err := jwt.Parse(reqToken, keys.Keyfunc)
 	if err != nil {
 		return false, ResToken{}, fmt.Errorf("failed")
 	}
 
 	var retTokenD ResToken
 
-	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
+	// access tokens without an expiry are rejected outright
+	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid && claims.VerifyExpiresAt(time.Now().Unix(), true) {
 		//nolint:errcheck //CODEI8:
 		mapstructure.Decode(claims, &retTokenD)
 		retTokenD.SessionName = fmt.Sprintf("%v", claims["session_name"])
@@ -200,3 +213,79 @@ func ClearSession(m *MongoStore, w http.ResponseWriter, session *sessions.Sessio
 func NewAuthHandler(c *utils.Configurations, mail service.MailService) *AuthHandler {
 	return &AuthHandler{configs: c, mailService: mail}
 }
+
+// TwoFactorChallenge is issued after a successful password check for accounts with
+// two factor authentication enabled, and exchanged for a session once the code is verified.
+type TwoFactorChallenge struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	UserID    string             `bson:"user_id"`
+	TokenHash string             `bson:"token_hash"`
+	Attempts  int                `bson:"attempts"`
+	ExpiresAt time.Time          `bson:"expires_at"`
+	CreatedAt time.Time          `bson:"created_at"`
+}
+
+type TwoFactorChallengeResponse struct {
+	TwoFactorRequired bool   `json:"two_factor_required"`
+	ChallengeToken    string `json:"challenge_token"`
+	ExpiresIn         int    `json:"expires_in"`
+	// Methods lists the ways the challenge can be completed: "totp", "recovery_code"
+	// and, for users with passkeys, "passkey".
+	Methods []string `json:"methods"`
+}
+
+type TwoFactorLoginRequest struct {
+	ChallengeToken string `json:"challenge_token" validate:"required"`
+	Code           string `json:"code" validate:"required"`
+}
+
+type TwoFactorCodeRequest struct {
+	Code string `json:"code" validate:"required"`
+}
+
+type TwoFactorConfirmRequest struct {
+	Password string `json:"password" validate:"required"`
+	Code     string `json:"code" validate:"required"`
+}
+
+// OIDCState tracks an OpenID Connect authorization request between the redirect to
+// the provider and the callback. It is single use and only a hash of the state is stored.
+type OIDCState struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty"`
+	StateHash    string             `bson:"state_hash"`
+	Provider     string             `bson:"provider"`
+	Nonce        string             `bson:"nonce"`
+	CodeVerifier string             `bson:"code_verifier"`
+	RedirectURI  string             `bson:"redirect_uri"`
+	LinkUserID   string             `bson:"link_user_id,omitempty"`
+	ExpiresAt    time.Time          `bson:"expires_at"`
+	CreatedAt    time.Time          `bson:"created_at"`
+}
+
+type OIDCAuthorizeRequest struct {
+	RedirectURI string `json:"redirect_uri" validate:"required,url"`
+}
+
+type OIDCAuthorizeResponse struct {
+	AuthorizationURL string `json:"authorization_url"`
+	State            string `json:"state"`
+}
+
+type OIDCCallbackRequest struct {
+	Code  string `json:"code" validate:"required"`
+	State string `json:"state" validate:"required"`
+}
+
+// SSOHandoff carries a SAML login from the assertion consumer service to the client,
+// which exchanges it for a session. It is single use and only a hash of the code is stored.
+type SSOHandoff struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	UserID    string             `bson:"user_id"`
+	TokenHash string             `bson:"token_hash"`
+	ExpiresAt time.Time          `bson:"expires_at"`
+	CreatedAt time.Time          `bson:"created_at"`
+}
+
+type SSOExchangeRequest struct {
+	Code string `json:"code" validate:"required"`
+}
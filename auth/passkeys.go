@@ -0,0 +1,651 @@
+package auth
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"zuri.chat/zccore/user"
+	"zuri.chat/zccore/utils"
+	"zuri.chat/zccore/webauthn"
+)
+
+const (
+	PasskeyCollection           = "passkeys"
+	WebAuthnChallengeCollection = "webauthn_challenges"
+	webAuthnCeremonyTTL         = 5 * time.Minute
+	maxPasskeys                 = 20
+
+	ceremonyRegistration = "registration"
+	ceremonyLogin        = "login"
+	ceremonySecondFactor = "second_factor"
+)
+
+var (
+	ErrInvalidPasskey     = errors.New("passkey could not be verified, kindly try again")
+	ErrPasskeyExists      = errors.New("this passkey is already registered")
+	ErrPasskeyNotFound    = errors.New("passkey not found")
+	ErrNoPasskeys         = errors.New("no passkeys are registered for this account")
+	ErrTooManyPasskeys    = errors.New("maximum number of passkeys reached, remove one first")
+	ErrPasskeyTokenDenied = errors.New("api tokens can't manage passkeys, sign in with a session instead")
+)
+
+// Passkey is a WebAuthn credential a user signs in with. The public key is stored in
+// its COSE form, as the authenticator returned it.
+type Passkey struct {
+	ID             primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserID         string             `json:"-" bson:"user_id"`
+	CredentialID   string             `json:"-" bson:"credential_id"`
+	PublicKey      []byte             `json:"-" bson:"public_key"`
+	Algorithm      int64              `json:"algorithm" bson:"algorithm"`
+	SignCount      uint32             `json:"-" bson:"sign_count"`
+	Transports     []string           `json:"transports" bson:"transports"`
+	Nickname       string             `json:"nickname" bson:"nickname"`
+	AAGUID         string             `json:"aaguid" bson:"aaguid"`
+	BackupEligible bool               `json:"synced" bson:"backup_eligible"`
+	CreatedAt      time.Time          `json:"created_at" bson:"created_at"`
+	LastUsedAt     *time.Time         `json:"last_used_at" bson:"last_used_at,omitempty"`
+}
+
+// WebAuthnChallenge is a pending ceremony. It is found by the hash of the challenge
+// the browser echoes back in its client data, and can only be used once.
+type WebAuthnChallenge struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty"`
+	ChallengeHash string             `bson:"challenge_hash"`
+	Ceremony      string             `bson:"ceremony"`
+	UserID        string             `bson:"user_id,omitempty"`
+	// TwoFactorChallengeID ties a second factor ceremony to the login it completes.
+	TwoFactorChallengeID primitive.ObjectID `bson:"two_factor_challenge_id,omitempty"`
+	ExpiresAt            time.Time          `bson:"expires_at"`
+	CreatedAt            time.Time          `bson:"created_at"`
+}
+
+// PasskeyCredential is a PublicKeyCredential as serialized by its toJSON() method,
+// with binary values base64url encoded.
+type PasskeyCredential struct {
+	ID       string `json:"id" validate:"required"`
+	RawID    string `json:"rawId" validate:"required"`
+	Type     string `json:"type" validate:"required,eq=public-key"`
+	Response struct {
+		ClientDataJSON    string   `json:"clientDataJSON" validate:"required"`
+		AttestationObject string   `json:"attestationObject"`
+		Transports        []string `json:"transports"`
+		AuthenticatorData string   `json:"authenticatorData"`
+		Signature         string   `json:"signature"`
+		UserHandle        string   `json:"userHandle"`
+	} `json:"response"`
+}
+
+type PasskeyRegistrationRequest struct {
+	Nickname   string            `json:"nickname" validate:"max=64"`
+	Credential PasskeyCredential `json:"credential" validate:"required"`
+}
+
+type PasskeyLoginRequest struct {
+	Credential PasskeyCredential `json:"credential" validate:"required"`
+}
+
+type PasskeySecondFactorOptionsRequest struct {
+	ChallengeToken string `json:"challenge_token" validate:"required"`
+}
+
+type PasskeySecondFactorRequest struct {
+	ChallengeToken string            `json:"challenge_token" validate:"required"`
+	Credential     PasskeyCredential `json:"credential" validate:"required"`
+}
+
+// CredentialDescriptor, CreationOptions and RequestOptions are the options handed to
+// navigator.credentials.create() and get(), with binary values base64url encoded.
+type CredentialDescriptor struct {
+	Type       string   `json:"type"`
+	ID         string   `json:"id"`
+	Transports []string `json:"transports,omitempty"`
+}
+
+type CreationOptions struct {
+	Challenge              string                   `json:"challenge"`
+	RP                     map[string]string        `json:"rp"`
+	User                   map[string]string        `json:"user"`
+	PubKeyCredParams       []map[string]interface{} `json:"pubKeyCredParams"`
+	Timeout                int64                    `json:"timeout"`
+	ExcludeCredentials     []CredentialDescriptor   `json:"excludeCredentials"`
+	AuthenticatorSelection map[string]interface{}   `json:"authenticatorSelection"`
+	Attestation            string                   `json:"attestation"`
+}
+
+type RequestOptions struct {
+	Challenge        string                 `json:"challenge"`
+	RPID             string                 `json:"rpId"`
+	Timeout          int64                  `json:"timeout"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+func (au *AuthHandler) relyingParty() *webauthn.RelyingParty {
+	return &webauthn.RelyingParty{ID: au.configs.WebAuthnRPID, Name: au.configs.WebAuthnRPName, Origins: au.configs.WebAuthnOrigins}
+}
+
+// BeginPasskeyRegistration returns the options to create a passkey for the logged in user.
+func (au *AuthHandler) BeginPasskeyRegistration(w http.ResponseWriter, r *http.Request) {
+	u, ok := passkeyOwner(w, r)
+	if !ok {
+		return
+	}
+
+	passkeys, err := userPasskeys(r.Context(), u.ID)
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	if len(passkeys) >= maxPasskeys {
+		utils.GetError(ErrTooManyPasskeys, http.StatusBadRequest, w)
+		return
+	}
+
+	challenge, err := issueWebAuthnChallenge(r.Context(), WebAuthnChallenge{Ceremony: ceremonyRegistration, UserID: u.ID})
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	params := make([]map[string]interface{}, 0, len(webauthn.SupportedAlgorithms))
+	for _, alg := range webauthn.SupportedAlgorithms {
+		params = append(params, map[string]interface{}{"type": "public-key", "alg": alg})
+	}
+
+	displayName := strings.TrimSpace(u.FirstName + " " + u.LastName)
+	if displayName == "" {
+		displayName = u.Email
+	}
+
+	utils.GetSuccess("passkey registration options", CreationOptions{
+		Challenge:        challenge,
+		RP:               map[string]string{"id": au.configs.WebAuthnRPID, "name": au.configs.WebAuthnRPName},
+		User:             map[string]string{"id": userHandle(u.ID), "name": u.Email, "displayName": displayName},
+		PubKeyCredParams: params,
+		Timeout:          webAuthnCeremonyTTL.Milliseconds(),
+		// a passkey already on the authenticator is not registered twice
+		ExcludeCredentials: descriptors(passkeys),
+		AuthenticatorSelection: map[string]interface{}{
+			"residentKey":      "preferred",
+			"userVerification": "preferred",
+		},
+		Attestation: "none",
+	}, w)
+}
+
+// FinishPasskeyRegistration verifies and stores a passkey created with the options
+// from BeginPasskeyRegistration.
+func (au *AuthHandler) FinishPasskeyRegistration(w http.ResponseWriter, r *http.Request) {
+	u, ok := passkeyOwner(w, r)
+	if !ok {
+		return
+	}
+
+	var req PasskeyRegistrationRequest
+	if err := utils.ParseJSONFromRequest(r, &req); err != nil {
+		utils.GetError(err, http.StatusUnprocessableEntity, w)
+		return
+	}
+
+	if err := validate.Struct(req); err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	clientData, challenge, err := consumeWebAuthnChallenge(r.Context(), req.Credential, bson.M{"ceremony": ceremonyRegistration, "user_id": u.ID})
+	if err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	attestation, err := webauthn.DecodeBase64URL(req.Credential.Response.AttestationObject)
+	if err != nil {
+		utils.GetError(ErrInvalidPasskey, http.StatusBadRequest, w)
+		return
+	}
+
+	cred, err := au.relyingParty().VerifyRegistration(challenge, clientData, attestation, false)
+	if err != nil {
+		utils.GetError(ErrInvalidPasskey, http.StatusBadRequest, w)
+		return
+	}
+
+	if rawID, err := webauthn.DecodeBase64URL(req.Credential.RawID); err != nil || string(rawID) != string(cred.ID) {
+		utils.GetError(ErrInvalidPasskey, http.StatusBadRequest, w)
+		return
+	}
+
+	nickname := strings.TrimSpace(req.Nickname)
+	if nickname == "" {
+		nickname = utils.DeviceLabel(r.UserAgent())
+	}
+
+	passkey := Passkey{
+		UserID:         u.ID,
+		CredentialID:   base64.RawURLEncoding.EncodeToString(cred.ID),
+		PublicKey:      cred.PublicKey,
+		Algorithm:      cred.Algorithm,
+		SignCount:      cred.SignCount,
+		Transports:     req.Credential.Response.Transports,
+		Nickname:       nickname,
+		AAGUID:         hex.EncodeToString(cred.AAGUID),
+		BackupEligible: cred.BackupEligible,
+		CreatedAt:      time.Now(),
+	}
+
+	res, err := utils.GetCollection(PasskeyCollection).InsertOne(r.Context(), passkey)
+	if mongo.IsDuplicateKeyError(err) {
+		utils.GetError(ErrPasskeyExists, http.StatusConflict, w)
+		return
+	}
+
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	passkey.ID, _ = res.InsertedID.(primitive.ObjectID)
+
+	utils.GetSuccess("passkey registered", passkey, w)
+}
+
+// GetPasskeys lists the passkeys of the logged in user.
+func (au *AuthHandler) GetPasskeys(w http.ResponseWriter, r *http.Request) {
+	loggedIn, _ := r.Context().Value("user").(*AuthUser)
+
+	u, err := FetchUserByEmail(bson.M{"email": strings.ToLower(loggedIn.Email)})
+	if err != nil {
+		utils.GetError(ErrUserNotFound, http.StatusBadRequest, w)
+		return
+	}
+
+	passkeys, err := userPasskeys(r.Context(), u.ID)
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	utils.GetSuccess("passkeys retrieved successfully", passkeys, w)
+}
+
+// DeletePasskey removes one of the logged in user's passkeys.
+func (au *AuthHandler) DeletePasskey(w http.ResponseWriter, r *http.Request) {
+	u, ok := passkeyOwner(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := primitive.ObjectIDFromHex(mux.Vars(r)["passkey_id"])
+	if err != nil {
+		utils.GetError(ErrorInvalid, http.StatusBadRequest, w)
+		return
+	}
+
+	res, err := utils.GetCollection(PasskeyCollection).DeleteOne(r.Context(), bson.M{"_id": id, "user_id": u.ID})
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	if res.DeletedCount == 0 {
+		utils.GetError(ErrPasskeyNotFound, http.StatusNotFound, w)
+		return
+	}
+
+	utils.GetSuccess("passkey removed", nil, w)
+}
+
+// BeginPasskeyLogin returns the options to sign in with a passkey. No account is named
+// up front, the authenticator offers the passkeys it holds for this site.
+func (au *AuthHandler) BeginPasskeyLogin(w http.ResponseWriter, r *http.Request) {
+	challenge, err := issueWebAuthnChallenge(r.Context(), WebAuthnChallenge{Ceremony: ceremonyLogin})
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	utils.GetSuccess("passkey login options", RequestOptions{
+		Challenge:        challenge,
+		RPID:             au.configs.WebAuthnRPID,
+		Timeout:          webAuthnCeremonyTTL.Milliseconds(),
+		AllowCredentials: []CredentialDescriptor{},
+		UserVerification: "required",
+	}, w)
+}
+
+// FinishPasskeyLogin signs in with a passkey. A user verified passkey is already two
+// factors, so no further two factor challenge follows.
+func (au *AuthHandler) FinishPasskeyLogin(w http.ResponseWriter, r *http.Request) {
+	var req PasskeyLoginRequest
+	if err := utils.ParseJSONFromRequest(r, &req); err != nil {
+		utils.GetError(err, http.StatusUnprocessableEntity, w)
+		return
+	}
+
+	if err := validate.Struct(req); err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	if err := checkAttempts(r.Context(), ipScope.key(utils.ClientIP(r))); err != nil {
+		writeAttemptError(w, err, http.StatusTooManyRequests)
+		return
+	}
+
+	passkey, err := au.verifyPasskeyAssertion(r.Context(), req.Credential, bson.M{"ceremony": ceremonyLogin}, "", true)
+	if err != nil {
+		recordFailure(r.Context(), ipScope, utils.ClientIP(r))
+		utils.GetError(err, http.StatusUnauthorized, w)
+
+		return
+	}
+
+	u, err := FetchUserByID(passkey.UserID)
+	if err != nil || u.Deactivated {
+		utils.GetError(ErrUserNotFound, http.StatusUnauthorized, w)
+		return
+	}
+
+	if err := checkAttempts(r.Context(), accountScope.key(u.Email)); err != nil {
+		writeAttemptError(w, err, http.StatusTooManyRequests)
+		return
+	}
+
+	required, err := requiresSSO(r.Context(), u.Email)
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	if required {
+		utils.GetError(ErrSSORequired, http.StatusForbidden, w)
+		return
+	}
+
+	resp, err := au.StartSession(w, r, u)
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	utils.GetSuccess("login successful", resp, w)
+}
+
+// BeginPasskeySecondFactor returns the options to complete a two factor login
+// challenge with one of the user's passkeys instead of a code.
+func (au *AuthHandler) BeginPasskeySecondFactor(w http.ResponseWriter, r *http.Request) {
+	var req PasskeySecondFactorOptionsRequest
+	if err := utils.ParseJSONFromRequest(r, &req); err != nil {
+		utils.GetError(err, http.StatusUnprocessableEntity, w)
+		return
+	}
+
+	if err := validate.Struct(req); err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	tfChallenge, err := findTwoFactorChallenge(r.Context(), req.ChallengeToken)
+	if err != nil {
+		utils.GetError(err, http.StatusUnauthorized, w)
+		return
+	}
+
+	passkeys, err := userPasskeys(r.Context(), tfChallenge.UserID)
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	if len(passkeys) == 0 {
+		utils.GetError(ErrNoPasskeys, http.StatusBadRequest, w)
+		return
+	}
+
+	challenge, err := issueWebAuthnChallenge(r.Context(), WebAuthnChallenge{
+		Ceremony:             ceremonySecondFactor,
+		UserID:               tfChallenge.UserID,
+		TwoFactorChallengeID: tfChallenge.ID,
+	})
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	utils.GetSuccess("passkey two factor options", RequestOptions{
+		Challenge:        challenge,
+		RPID:             au.configs.WebAuthnRPID,
+		Timeout:          webAuthnCeremonyTTL.Milliseconds(),
+		AllowCredentials: descriptors(passkeys),
+		UserVerification: "preferred",
+	}, w)
+}
+
+// FinishPasskeySecondFactor completes a two factor login challenge with a passkey.
+func (au *AuthHandler) FinishPasskeySecondFactor(w http.ResponseWriter, r *http.Request) {
+	var req PasskeySecondFactorRequest
+	if err := utils.ParseJSONFromRequest(r, &req); err != nil {
+		utils.GetError(err, http.StatusUnprocessableEntity, w)
+		return
+	}
+
+	if err := validate.Struct(req); err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	coll := utils.GetCollection(TwoFactorChallengeCollection)
+
+	tfChallenge, err := findTwoFactorChallenge(r.Context(), req.ChallengeToken)
+	if err != nil {
+		utils.GetError(err, http.StatusUnauthorized, w)
+		return
+	}
+
+	u, err := FetchUserByID(tfChallenge.UserID)
+	if err != nil {
+		utils.GetError(ErrUserNotFound, http.StatusUnauthorized, w)
+		return
+	}
+
+	if err := checkAttempts(r.Context(), accountScope.key(u.Email)); err != nil {
+		writeAttemptError(w, err, http.StatusTooManyRequests)
+		return
+	}
+
+	_, err = au.verifyPasskeyAssertion(r.Context(), req.Credential,
+		bson.M{"ceremony": ceremonySecondFactor, "two_factor_challenge_id": tfChallenge.ID}, u.ID, false)
+	if err != nil {
+		//nolint:errcheck //CODEI8: best effort counter
+		coll.UpdateOne(r.Context(), bson.M{"_id": tfChallenge.ID}, bson.M{"$inc": bson.M{"attempts": 1}})
+		au.recordLoginFailure(r, u.Email, u)
+		utils.GetError(err, http.StatusUnauthorized, w)
+
+		return
+	}
+
+	clearAttempts(r.Context(), accountScope.key(u.Email))
+
+	// a challenge can only be redeemed once
+	if res, err := coll.DeleteOne(r.Context(), bson.M{"_id": tfChallenge.ID}); err != nil || res.DeletedCount == 0 {
+		utils.GetError(ErrInvalidChallenge, http.StatusUnauthorized, w)
+		return
+	}
+
+	resp, err := au.StartSession(w, r, u)
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	utils.GetSuccess("login successful", resp, w)
+}
+
+// verifyPasskeyAssertion checks an assertion against the pending ceremony matching
+// filter and the stored passkey, which must belong to userID when it is given, and
+// records the passkey's use.
+func (au *AuthHandler) verifyPasskeyAssertion(ctx context.Context, cred PasskeyCredential, filter bson.M, userID string, requireUV bool) (*Passkey, error) {
+	clientData, challenge, err := consumeWebAuthnChallenge(ctx, cred, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	rawID, err := webauthn.DecodeBase64URL(cred.RawID)
+	if err != nil {
+		return nil, ErrInvalidPasskey
+	}
+
+	query := bson.M{"credential_id": base64.RawURLEncoding.EncodeToString(rawID)}
+	if userID != "" {
+		query["user_id"] = userID
+	}
+
+	var passkey Passkey
+	if err := utils.GetCollection(PasskeyCollection).FindOne(ctx, query).Decode(&passkey); err != nil {
+		return nil, ErrInvalidPasskey
+	}
+
+	// the user handle, when the authenticator returns one, names the passkey's owner
+	if cred.Response.UserHandle != "" && cred.Response.UserHandle != userHandle(passkey.UserID) {
+		return nil, ErrInvalidPasskey
+	}
+
+	authData, err := webauthn.DecodeBase64URL(cred.Response.AuthenticatorData)
+	if err != nil {
+		return nil, ErrInvalidPasskey
+	}
+
+	signature, err := webauthn.DecodeBase64URL(cred.Response.Signature)
+	if err != nil {
+		return nil, ErrInvalidPasskey
+	}
+
+	ad, err := au.relyingParty().VerifyAssertion(challenge, clientData, authData, signature, passkey.PublicKey, passkey.SignCount, requireUV)
+	if err != nil {
+		return nil, ErrInvalidPasskey
+	}
+
+	// matching the stored counter keeps two concurrent uses of a cloned key from both passing
+	now := time.Now()
+
+	res, err := utils.GetCollection(PasskeyCollection).UpdateOne(ctx,
+		bson.M{"_id": passkey.ID, "sign_count": passkey.SignCount},
+		bson.M{"$set": bson.M{"sign_count": ad.SignCount, "last_used_at": now}})
+	if err != nil || res.MatchedCount == 0 {
+		return nil, ErrInvalidPasskey
+	}
+
+	passkey.SignCount, passkey.LastUsedAt = ad.SignCount, &now
+
+	return &passkey, nil
+}
+
+// issueWebAuthnChallenge stores a pending ceremony and returns its challenge.
+func issueWebAuthnChallenge(ctx context.Context, c WebAuthnChallenge) (string, error) {
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		return "", err
+	}
+
+	c.ChallengeHash = utils.HashToken(challenge)
+	c.CreatedAt = time.Now()
+	c.ExpiresAt = c.CreatedAt.Add(webAuthnCeremonyTTL)
+
+	if _, err := utils.GetCollection(WebAuthnChallengeCollection).InsertOne(ctx, c); err != nil {
+		return "", err
+	}
+
+	return challenge, nil
+}
+
+// consumeWebAuthnChallenge finds and deletes the pending ceremony the credential's
+// client data answers, and returns the decoded client data and its challenge.
+func consumeWebAuthnChallenge(ctx context.Context, cred PasskeyCredential, filter bson.M) ([]byte, string, error) {
+	clientData, err := webauthn.DecodeBase64URL(cred.Response.ClientDataJSON)
+	if err != nil {
+		return nil, "", ErrInvalidPasskey
+	}
+
+	var cd webauthn.ClientData
+	if err := json.Unmarshal(clientData, &cd); err != nil || cd.Challenge == "" {
+		return nil, "", ErrInvalidPasskey
+	}
+
+	challenge := strings.TrimRight(cd.Challenge, "=")
+	filter["challenge_hash"] = utils.HashToken(challenge)
+
+	var c WebAuthnChallenge
+	if err := utils.GetCollection(WebAuthnChallengeCollection).FindOneAndDelete(ctx, filter).Decode(&c); err != nil {
+		return nil, "", ErrInvalidChallenge
+	}
+
+	if time.Now().After(c.ExpiresAt) {
+		return nil, "", ErrInvalidChallenge
+	}
+
+	return clientData, challenge, nil
+}
+
+// passkeyOwner returns the logged in user for passkey management, which needs a session.
+func passkeyOwner(w http.ResponseWriter, r *http.Request) (*user.User, bool) {
+	loggedIn, _ := r.Context().Value("user").(*AuthUser)
+
+	if loggedIn.Token != nil {
+		utils.GetError(ErrPasskeyTokenDenied, http.StatusForbidden, w)
+		return nil, false
+	}
+
+	u, err := FetchUserByEmail(bson.M{"email": strings.ToLower(loggedIn.Email)})
+	if err != nil {
+		utils.GetError(ErrUserNotFound, http.StatusBadRequest, w)
+		return nil, false
+	}
+
+	return u, true
+}
+
+func userPasskeys(ctx context.Context, userID string) ([]Passkey, error) {
+	opts := options.Find().SetSort(bson.M{"created_at": 1})
+
+	cursor, err := utils.GetCollection(PasskeyCollection).Find(ctx, bson.M{"user_id": userID}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	passkeys := []Passkey{}
+
+	return passkeys, cursor.All(ctx, &passkeys)
+}
+
+func hasPasskeys(ctx context.Context, userID string) bool {
+	n, err := utils.GetCollection(PasskeyCollection).CountDocuments(ctx, bson.M{"user_id": userID}, options.Count().SetLimit(1))
+	return err == nil && n > 0
+}
+
+func descriptors(passkeys []Passkey) []CredentialDescriptor {
+	list := make([]CredentialDescriptor, 0, len(passkeys))
+
+	for i := range passkeys {
+		list = append(list, CredentialDescriptor{Type: "public-key", ID: passkeys[i].CredentialID, Transports: passkeys[i].Transports})
+	}
+
+	return list
+}
+
+// userHandle is the WebAuthn user id of a user, the raw bytes of their object id.
+func userHandle(userID string) string {
+	b, _ := hex.DecodeString(userID)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
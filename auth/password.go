@@ -0,0 +1,92 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"golang.org/x/crypto/bcrypt"
+	"zuri.chat/zccore/user"
+	"zuri.chat/zccore/utils"
+)
+
+var ErrCurrentPassword = errors.New("current password is incorrect, confirm and try again")
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" validate:"required"`
+	NewPassword     string `json:"new_password" validate:"required"`
+	ConfirmPassword string `json:"confirm_password" validate:"required"`
+}
+
+// checkNewPassword applies the password policy of every workspace u belongs to.
+func (au *AuthHandler) checkNewPassword(r *http.Request, u *user.User, password string) error {
+	policy := user.PolicyForUser(r.Context(), user.GlobalPasswordPolicy(au.configs), u.Email)
+	return user.CheckNewPassword(au.configs, policy, password, u)
+}
+
+// storePassword hashes password and saves it for u, keeping the previous hash for reuse checks.
+func (au *AuthHandler) storePassword(r *http.Request, u *user.User, password string) error {
+	bytes, err := bcrypt.GenerateFromPassword([]byte(password), DefaultHashCode)
+	if err != nil {
+		return err
+	}
+
+	return user.SetPassword(r.Context(), u, string(bytes))
+}
+
+// ChangePassword lets a logged in user pick a new password after confirming the
+// current one. Every other session of the user is signed out.
+func (au *AuthHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("content-type", "application/json")
+
+	loggedIn, _ := r.Context().Value("user").(*AuthUser)
+
+	var req ChangePasswordRequest
+	if err := utils.ParseJSONFromRequest(r, &req); err != nil {
+		utils.GetError(err, http.StatusUnprocessableEntity, w)
+		return
+	}
+
+	if err := validate.Struct(req); err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	if req.NewPassword != req.ConfirmPassword {
+		utils.GetError(ErrConfirmPassword, http.StatusBadRequest, w)
+		return
+	}
+
+	u, err := FetchUserByEmail(bson.M{"email": strings.ToLower(loggedIn.Email)})
+	if err != nil {
+		utils.GetError(ErrUserNotFound, http.StatusBadRequest, w)
+		return
+	}
+
+	if err := checkAttempts(r.Context(), accountScope.key(u.Email)); err != nil {
+		writeAttemptError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if !ComparePassword(req.CurrentPassword, u.Password) {
+		au.recordLoginFailure(r, u.Email, u)
+		utils.GetError(ErrCurrentPassword, http.StatusBadRequest, w)
+
+		return
+	}
+
+	if err := au.checkNewPassword(r, u, req.NewPassword); err != nil {
+		utils.GetError(err, user.PasswordErrorStatus(err), w)
+		return
+	}
+
+	if err := au.storePassword(r, u, req.NewPassword); err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	DeleteOtherSessions(u.ID, loggedIn.ID.Hex())
+
+	utils.GetSuccess("Password changed successfully", nil, w)
+}
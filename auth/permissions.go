@@ -0,0 +1,334 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"zuri.chat/zccore/utils"
+)
+
+// Organization permissions. Every organization route declares the one it needs with
+// RequirePermission, and a member's role decides which they hold.
+const (
+	PermOrgRead          = "org.read"
+	PermOrgSettingsWrite = "org.settings.write"
+	PermOrgSSOManage     = "org.sso.manage"
+	PermOrgDelete        = "org.delete"
+	PermOrgTransfer      = "org.transfer"
+	PermMembersRead      = "members.read"
+	PermMembersInvite    = "members.invite"
+	PermMembersManage    = "members.manage"
+	PermRolesManage      = "roles.manage"
+	PermGroupsManage     = "groups.manage"
+	PermProfileWrite     = "profile.write"
+	PermPluginsRead      = "plugins.read"
+	PermPluginsInstall   = "plugins.install"
+	PermPluginDataRead   = "plugins.data.read"
+	PermPluginDataWrite  = "plugins.data.write"
+	PermReportsRead      = "reports.read"
+	PermReportsWrite     = "reports.write"
+	PermBillingRead      = "billing.read"
+	PermBillingManage    = "billing.manage"
+	PermAPIKeysManage    = "api_keys.manage"
+	PermAuditRead        = "audit.read"
+)
+
+var ErrPermissionDenied = errors.New("you don't have permission to do this in this organization")
+
+// Permission describes a permission for role editors.
+type Permission struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// Permissions is the catalogue of organization permissions, in display order.
+var Permissions = []Permission{
+	{PermOrgRead, "View the organization"},
+	{PermOrgSettingsWrite, "Change the organization's name, URL, logo and settings"},
+	{PermOrgSSOManage, "Configure single sign-on and provisioning"},
+	{PermOrgDelete, "Delete the organization"},
+	{PermOrgTransfer, "Transfer ownership of the organization"},
+	{PermMembersRead, "View members"},
+	{PermMembersInvite, "Invite and add members"},
+	{PermMembersManage, "Deactivate and reactivate members"},
+	{PermRolesManage, "Create roles and change members' roles"},
+	{PermGroupsManage, "Create, rename and archive user groups and manage their members"},
+	{PermProfileWrite, "Edit your own member profile and settings"},
+	{PermPluginsRead, "View installed plugins"},
+	{PermPluginsInstall, "Install and remove plugins"},
+	{PermPluginDataRead, "Read the data of installed plugins"},
+	{PermPluginDataWrite, "Write and delete the data of installed plugins"},
+	{PermReportsRead, "View reports"},
+	{PermReportsWrite, "Report members"},
+	{PermBillingRead, "View billing and token transactions"},
+	{PermBillingManage, "Manage billing, payment and subscriptions"},
+	{PermAPIKeysManage, "Manage organization API keys"},
+	{PermAuditRead, "View and export the audit log"},
+}
+
+// builtinRoles maps the roles every organization has onto their permissions.
+var builtinRoles = map[string][]string{
+	"owner": allPermissions(),
+	"admin": {
+		PermOrgRead, PermOrgSettingsWrite, PermOrgSSOManage, PermOrgDelete,
+		PermMembersRead, PermMembersInvite, PermMembersManage, PermRolesManage, PermGroupsManage,
+		PermProfileWrite, PermPluginsRead, PermPluginsInstall, PermPluginDataRead, PermPluginDataWrite,
+		PermReportsRead, PermReportsWrite, PermBillingRead, PermBillingManage, PermAPIKeysManage, PermAuditRead,
+	},
+	"editor": {
+		PermOrgRead, PermMembersRead, PermProfileWrite, PermPluginsRead, PermPluginsInstall,
+		PermPluginDataRead, PermPluginDataWrite, PermReportsRead, PermReportsWrite,
+	},
+	"member": {
+		PermOrgRead, PermMembersRead, PermProfileWrite, PermPluginsRead,
+		PermPluginDataRead, PermPluginDataWrite, PermReportsWrite,
+	},
+	"guest": {PermOrgRead, PermMembersRead, PermProfileWrite, PermPluginDataRead},
+}
+
+func allPermissions() []string {
+	perms := make([]string, 0, len(Permissions))
+	for _, p := range Permissions {
+		perms = append(perms, p.Name)
+	}
+
+	return perms
+}
+
+// IsBuiltinRole reports whether role is one every organization has.
+func IsBuiltinRole(role string) bool {
+	_, ok := builtinRoles[role]
+	return ok
+}
+
+// IsPermission reports whether name is in the permission catalogue.
+func IsPermission(name string) bool {
+	for _, p := range Permissions {
+		if p.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RolePermissions returns the permissions of a built-in role or of one of the
+// organization's custom roles.
+func RolePermissions(ctx context.Context, orgID, role string) ([]string, error) {
+	if perms, ok := builtinRoles[role]; ok {
+		return perms, nil
+	}
+
+	var custom OrgRole
+	if err := utils.GetCollection(OrgRoleCollection).FindOne(ctx, bson.M{"org_id": orgID, "name": role}).Decode(&custom); err != nil {
+		return nil, ErrRoleNotFound
+	}
+
+	return custom.Permissions, nil
+}
+
+// RoleHasPermission reports whether role grants perm in the organization.
+func RoleHasPermission(ctx context.Context, orgID, role, perm string) bool {
+	perms, err := RolePermissions(ctx, orgID, role)
+	return err == nil && hasPermission(perms, perm)
+}
+
+// GrantsAll reports whether held includes every permission in perms, which keeps
+// members from handing out more than they have themselves.
+func GrantsAll(held, perms []string) bool {
+	for _, p := range perms {
+		if !hasPermission(held, p) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// withGroupPermissions adds to perms those the member gets from the roles of the
+// active user groups they are in. perms is left as it is.
+func withGroupPermissions(ctx context.Context, orgID, memberID string, perms []string) []string {
+	roles, err := utils.GetCollection(UserGroupCollection).Distinct(ctx, "role", bson.M{
+		"org_id":   orgID,
+		"members":  memberID,
+		"archived": bson.M{"$ne": true},
+		"role":     bson.M{"$nin": bson.A{nil, ""}},
+	})
+	if err != nil || len(roles) == 0 {
+		return perms
+	}
+
+	merged := append([]string{}, perms...)
+
+	for _, role := range roles {
+		name, _ := role.(string)
+
+		granted, err := RolePermissions(ctx, orgID, name)
+		if err != nil {
+			continue
+		}
+
+		for _, p := range granted {
+			if !hasPermission(merged, p) {
+				merged = append(merged, p)
+			}
+		}
+	}
+
+	return merged
+}
+
+func hasPermission(perms []string, perm string) bool {
+	for _, p := range perms {
+		if p == perm {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RequirePermission lets the request through when the logged in user is an active
+// member of the organization in the "id" route variable and their role grants perm.
+// Roles of the user groups the member is in add to those of their own role. The
+// member's role and permissions are added to the user in the request context.
+func (au *AuthHandler) RequirePermission(nextHandler http.HandlerFunc, perm string) http.HandlerFunc {
+	return au.requireOrgPermission(nextHandler, perm, func(r *http.Request) string { return mux.Vars(r)["id"] })
+}
+
+// RequireDataPermission is RequirePermission for the plugin data API, whose routes name
+// the organization in an "org_id" path variable or the "organization_id" body field.
+func (au *AuthHandler) RequireDataPermission(nextHandler http.HandlerFunc, perm string) http.HandlerFunc {
+	return au.requireOrgPermission(nextHandler, perm, dataOrgID)
+}
+
+// dataOrgID returns the organization a plugin data request is for. The body is read
+// and put back for the handler.
+func dataOrgID(r *http.Request) string {
+	if orgID := mux.Vars(r)["org_id"]; orgID != "" {
+		return orgID
+	}
+
+	if r.Body == nil {
+		return ""
+	}
+
+	body, err := io.ReadAll(r.Body)
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	if err != nil {
+		return ""
+	}
+
+	var req struct {
+		OrganizationID string `json:"organization_id"`
+	}
+
+	//nolint:errcheck //CODEI8: a body without an organization is denied below
+	json.Unmarshal(body, &req)
+
+	return req.OrganizationID
+}
+
+func (au *AuthHandler) requireOrgPermission(nextHandler http.HandlerFunc, perm string, orgIDOf func(*http.Request) string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		orgID := orgIDOf(r)
+		loggedInUser, _ := r.Context().Value("user").(*AuthUser)
+
+		if loggedInUser.Token != nil && loggedInUser.Token.OrgID != "" && loggedInUser.Token.OrgID != orgID {
+			utils.GetError(ErrAPIKeyOrg, http.StatusForbidden, w)
+			return
+		}
+
+		// permissions beyond those of a plain member need an admin scoped token
+		if loggedInUser.Token != nil && !hasPermission(builtinRoles["member"], perm) && !loggedInUser.Token.HasScope(ScopeAdmin) {
+			utils.GetError(ErrAPITokenScope, http.StatusForbidden, w)
+			return
+		}
+
+		lguser, err := FetchUserByEmail(bson.M{"email": strings.ToLower(loggedInUser.Email)})
+		if err != nil {
+			utils.GetError(ErrUserNotFound, http.StatusBadRequest, w)
+			return
+		}
+
+		var memb struct {
+			ID   primitive.ObjectID `bson:"_id"`
+			Role string             `bson:"role"`
+		}
+
+		err = utils.GetCollection("members").FindOne(r.Context(), bson.M{
+			"org_id":  orgID,
+			"email":   lguser.Email,
+			"deleted": bson.M{"$ne": true},
+		}).Decode(&memb)
+		if err != nil {
+			utils.GetError(ErrAccessDenied, http.StatusUnauthorized, w)
+			return
+		}
+
+		perms, err := RolePermissions(r.Context(), orgID, memb.Role)
+		if err == nil {
+			perms = withGroupPermissions(r.Context(), orgID, memb.ID.Hex(), perms)
+		}
+
+		if err != nil || !hasPermission(perms, perm) {
+			utils.GetError(ErrPermissionDenied, http.StatusForbidden, w)
+			return
+		}
+
+		userID, _ := primitive.ObjectIDFromHex(lguser.ID)
+		u := &AuthUser{
+			ID:          userID,
+			Email:       loggedInUser.Email,
+			Token:       loggedInUser.Token,
+			Role:        memb.Role,
+			Permissions: perms,
+		}
+
+		//nolint:staticcheck //CODEI8: lint ignore
+		ctx := context.WithValue(r.Context(), UserContext, u)
+		nextHandler.ServeHTTP(w, r.WithContext(ctx))
+	}
+}
+
+var ErrPluginDeveloperOnly = errors.New("only the developer of the plugin can do this")
+
+// RequirePluginDeveloper lets only the developer who registered the plugin in the
+// route change it.
+func (au *AuthHandler) RequirePluginDeveloper(nextHandler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		objID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+		if err != nil {
+			utils.GetError(errors.New("invalid id"), http.StatusBadRequest, w)
+			return
+		}
+
+		var p struct {
+			DeveloperEmail string `bson:"developer_email"`
+		}
+
+		if err := utils.GetCollection("plugins").FindOne(r.Context(), bson.M{"_id": objID}).Decode(&p); err != nil {
+			utils.GetError(errors.New("plugin not found"), http.StatusNotFound, w)
+			return
+		}
+
+		loggedInUser, _ := r.Context().Value("user").(*AuthUser)
+		if loggedInUser == nil || !strings.EqualFold(p.DeveloperEmail, loggedInUser.Email) {
+			utils.GetError(ErrPluginDeveloperOnly, http.StatusForbidden, w)
+			return
+		}
+
+		nextHandler.ServeHTTP(w, r)
+	}
+}
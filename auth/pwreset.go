@@ -6,12 +6,10 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
-	"time"
 
 	"github.com/gorilla/mux"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"golang.org/x/crypto/bcrypt"
 	"zuri.chat/zccore/service"
 	"zuri.chat/zccore/user"
 	"zuri.chat/zccore/utils"
@@ -19,53 +17,65 @@ import (
 
 var (
 	DefaultHashCode     = 14
-	GenNumberLength     = 6
 	ErrConfirmationCode = errors.New("Account confirmation code used or expired, confirm and try again")
 	ErrResetCode        = errors.New("Invalid password reset code, already used or expired, confirm and try again")
 )
 
-func (au *AuthHandler) validateCode(r *http.Request, column string, errMsg error, vcode string) (*user.User, error) {
-	c := struct {
-		Code string `json:"code" validate:"required"`
-	}{}
-
-	var filter primitive.M
-
-	if vcode == "" {
-		if err := utils.ParseJSONFromRequest(r, &c); err != nil {
-			return nil, err
-		}
+type CodeRequest struct {
+	Email string `json:"email" validate:"email,required"`
+	Code  string `json:"code" validate:"required"`
+}
 
-		if err := validate.Struct(c); err != nil {
-			return nil, err
-		}
+// checkCode validates a one-time code for purpose, counting failures against the
+// client IP and the email on top of the token's own attempt limit. consume redeems
+// the code.
+func checkCode(r *http.Request, purpose user.TokenPurpose, email, code string, errMsg error, consume bool) (*user.OneTimeToken, error) {
+	ip := utils.ClientIP(r)
+	if err := checkAttempts(r.Context(), codeScope.key(ip), codeEmailScope.key(email)); err != nil {
+		return nil, err
+	}
 
-		filter = bson.M{column: c.Code}
-	} else {
-		filter = bson.M{column: vcode}
+	check := user.CheckToken
+	if consume {
+		check = user.ConsumeToken
 	}
 
-	u, err := FetchUserByEmail(filter)
+	t, err := check(r.Context(), purpose, email, code)
 	if err != nil {
+		recordFailure(r.Context(), codeScope, ip)
+		recordFailure(r.Context(), codeEmailScope, email)
+
 		return nil, errMsg
 	}
 
-	return u, nil
+	clearAttempts(r.Context(), codeEmailScope.key(email))
+
+	return t, nil
 }
 
 func (au *AuthHandler) VerifyAccount(w http.ResponseWriter, r *http.Request) {
 	w.Header().Add("content-type", "application/json")
 
-	u, err := au.validateCode(r, "email_verification.token", ErrConfirmationCode, "")
-	if err != nil {
+	var req CodeRequest
+	if err := utils.ParseJSONFromRequest(r, &req); err != nil {
+		utils.GetError(err, http.StatusUnprocessableEntity, w)
+		return
+	}
+
+	if err := validate.Struct(req); err != nil {
 		utils.GetError(err, http.StatusBadRequest, w)
 		return
 	}
 
-	// set email_verification null
+	t, err := checkCode(r, user.PurposeEmailVerification, req.Email, req.Code, ErrConfirmationCode, true)
+	if err != nil {
+		writeAttemptError(w, err, http.StatusBadRequest)
+		return
+	}
+
 	// update isverified to true
-	id, _ := primitive.ObjectIDFromHex(u.ID)
-	update := bson.M{"$set": bson.M{"email_verification": nil, "isverified": true}}
+	id, _ := primitive.ObjectIDFromHex(t.UserID)
+	update := bson.M{"$set": bson.M{"isverified": true}, "$unset": bson.M{"email_verification": ""}}
 
 	if _, err := utils.GetCollection(userCollection).UpdateByID(
 		context.Background(),
@@ -74,43 +84,44 @@ func (au *AuthHandler) VerifyAccount(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	utils.GetSuccess("Email verified, you can now login", nil, w)
+	utils.GetSuccess("Email verified, you can now login", utils.M{
+		"joinable_organizations": joinableOrganizations(r.Context(), strings.ToLower(req.Email)),
+	}, w)
 }
 
+// VerifyPasswordResetCode confirms a reset code is valid before the client asks for
+// the new password. The code stays usable for UpdatePassword.
 func (au *AuthHandler) VerifyPasswordResetCode(w http.ResponseWriter, r *http.Request) {
 	w.Header().Add("content-type", "application/json")
 
-	u, err := au.validateCode(r, "password_resets.token", ErrResetCode, "")
-	if err != nil {
+	var req CodeRequest
+	if err := utils.ParseJSONFromRequest(r, &req); err != nil {
+		utils.GetError(err, http.StatusUnprocessableEntity, w)
+		return
+	}
+
+	if err := validate.Struct(req); err != nil {
 		utils.GetError(err, http.StatusBadRequest, w)
 		return
 	}
 
-	resp := map[string]interface{}{
-		"isverified":        true,
-		"verification_code": u.PasswordResets.Token,
+	if _, err := checkCode(r, user.PurposePasswordReset, req.Email, req.Code, ErrResetCode, false); err != nil {
+		writeAttemptError(w, err, http.StatusBadRequest)
+		return
 	}
 
-	utils.GetSuccess("Password reset code valid", resp, w)
+	utils.GetSuccess("Password reset code valid", map[string]interface{}{"isverified": true}, w)
 }
 
 func (au *AuthHandler) UpdatePassword(w http.ResponseWriter, r *http.Request) {
 	w.Header().Add("content-type", "application/json")
 
 	rBody := struct {
-		Password        string `json:"password" validate:"required,min=6"`
+		Email           string `json:"email" validate:"email,required"`
+		Password        string `json:"password" validate:"required"`
 		ConfirmPassword string `json:"confirm_password" validate:"required"`
 	}{}
 
-	params := mux.Vars(r)
-	verificationToken := params["verification_code"]
-
-	u, err := au.validateCode(r, "password_resets.token", ErrResetCode, verificationToken)
-	if err != nil {
-		utils.GetError(err, http.StatusBadRequest, w)
-		return
-	}
-
 	if e := utils.ParseJSONFromRequest(r, &rBody); e != nil {
 		utils.GetError(e, http.StatusUnprocessableEntity, w)
 		return
@@ -126,26 +137,46 @@ func (au *AuthHandler) UpdatePassword(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// update password & delete passwordreset object
-	bytes, err := bcrypt.GenerateFromPassword([]byte(rBody.Password), DefaultHashCode)
+	code := mux.Vars(r)["verification_code"]
+
+	t, err := checkCode(r, user.PurposePasswordReset, rBody.Email, code, ErrResetCode, false)
 	if err != nil {
-		utils.GetError(err, http.StatusInternalServerError, w)
+		writeAttemptError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	u, err := FetchUserByID(t.UserID)
+	if err != nil {
+		utils.GetError(ErrUserNotFound, http.StatusBadRequest, w)
 		return
 	}
 
-	id, _ := primitive.ObjectIDFromHex(u.ID)
-	filter := bson.M{"_id": id}
-	update := bson.M{"$set": bson.M{"password_resets": nil, "password": string(bytes)}}
+	// the code stays valid if the password is rejected so the user can pick another
+	if err := au.checkNewPassword(r, u, rBody.Password); err != nil {
+		utils.GetError(err, user.PasswordErrorStatus(err), w)
+		return
+	}
 
-	if _, err := utils.GetCollection(userCollection).UpdateOne(context.Background(), filter, update); err != nil {
+	if _, err := user.ConsumeToken(r.Context(), user.PurposePasswordReset, rBody.Email, code); err != nil {
+		utils.GetError(ErrResetCode, http.StatusBadRequest, w)
+		return
+	}
+
+	if err := au.storePassword(r, u, rBody.Password); err != nil {
 		utils.GetError(err, http.StatusInternalServerError, w)
 		return
 	}
 
+	// whoever knew the old password should not stay signed in
+	DeleteOtherSessions(t.UserID, "")
+	revokePersonalTokens(r.Context(), t.UserID)
+	clearAttempts(r.Context(), accountScope.key(t.Email))
+
 	utils.GetSuccess("Password update successful", nil, w)
 }
 
-// Send password reset code to user, auth not required.
+// Send password reset code to user, auth not required. The response never reveals
+// whether the email belongs to an account, and a new request replaces any earlier code.
 func (au *AuthHandler) RequestResetPasswordCode(w http.ResponseWriter, r *http.Request) {
 	w.Header().Add("content-type", "application/json")
 
@@ -163,38 +194,18 @@ func (au *AuthHandler) RequestResetPasswordCode(w http.ResponseWriter, r *http.R
 		return
 	}
 
-	u, err := FetchUserByEmail(bson.M{"email": strings.ToLower(email.Email)})
-	if err != nil {
-		utils.GetError(ErrUserNotFound, http.StatusBadRequest, w)
-		return
+	if u, err := FetchUserByEmail(bson.M{"email": strings.ToLower(email.Email)}); err == nil {
+		// sent in the background so response times don't reveal whether the account exists
+		go au.sendResetCode(u)
 	}
 
-	// check if user already requested for password
-	if u.PasswordResets != nil {
-		x2x := map[string]interface{}{"password_reset_code": u.PasswordResets.Token}
-		utils.GetSuccess("Password reset code already sent, check your email", x2x, w)
-
-		return
-	}
-
-	// Update user collection with UserPasswordReset - WIP
-	_, token := utils.RandomGen(GenNumberLength, "d")
-
-	userPasswordReset := map[string]interface{}{
-		"ip_address": strings.Split(r.RemoteAddr, ":")[0],
-		"token":      token,
-		"expired_at": time.Now(),
-		"updated_at": time.Now(),
-		"created_at": time.Now(),
-	}
-
-	id, _ := primitive.ObjectIDFromHex(u.ID)
-	filter := bson.M{"_id": id}
-	update := bson.M{"$set": bson.M{"password_resets": userPasswordReset}}
+	utils.GetSuccess("If an account exists for this email, a password reset code has been sent", nil, w)
+}
 
-	// update db;
-	if _, err := utils.GetCollection(userCollection).UpdateOne(context.Background(), filter, update); err != nil {
-		utils.GetError(err, http.StatusInternalServerError, w)
+func (au *AuthHandler) sendResetCode(u *user.User) {
+	code, err := user.IssueToken(context.Background(), user.PurposePasswordReset, u.ID, u.Email, nil)
+	if err != nil {
+		fmt.Printf("Error issuing password reset code: %s", err.Error())
 		return
 	}
 
@@ -202,12 +213,10 @@ func (au *AuthHandler) RequestResetPasswordCode(w http.ResponseWriter, r *http.R
 		[]string{u.Email},
 		"Reset Password Code", service.PasswordReset, map[string]interface{}{
 			"Username": u.Email,
-			"Code":     userPasswordReset["token"].(string),
+			"Code":     code,
 		})
 
 	if err := au.mailService.SendMail(msger); err != nil {
 		fmt.Printf("Error occurred while sending mail: %s", err.Error())
 	}
-
-	utils.GetSuccess("Password reset code sent", nil, w)
 }
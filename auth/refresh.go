@@ -0,0 +1,174 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"zuri.chat/zccore/user"
+	"zuri.chat/zccore/utils"
+)
+
+const (
+	RefreshTokenCollection = "refresh_tokens"
+	refreshTokenBytes      = 32
+)
+
+var (
+	ErrInvalidRefreshToken = errors.New("refresh token is invalid or has expired, kindly login again")
+	ErrRefreshTokenReused  = errors.New("refresh token has already been used, all sessions issued from it have been revoked")
+)
+
+// RefreshToken is an opaque, single-use token that can be exchanged for a new access
+// token. Every exchange rotates it within the same family; presenting a token that was
+// already exchanged revokes the entire family and its session.
+type RefreshToken struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	TokenHash string             `bson:"token_hash"`
+	FamilyID  string             `bson:"family_id"`
+	SessionID primitive.ObjectID `bson:"session_id"`
+	UserID    string             `bson:"user_id"`
+	Used      bool               `bson:"used"`
+	Revoked   bool               `bson:"revoked"`
+	ExpiresAt time.Time          `bson:"expires_at"`
+	CreatedAt time.Time          `bson:"created_at"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" validate:"required"`
+}
+
+// RefreshAccessToken exchanges a refresh token for a new access token and a rotated
+// refresh token.
+func (au *AuthHandler) RefreshAccessToken(w http.ResponseWriter, r *http.Request) {
+	var req RefreshRequest
+	if err := utils.ParseJSONFromRequest(r, &req); err != nil {
+		utils.GetError(err, http.StatusUnprocessableEntity, w)
+		return
+	}
+
+	if err := validate.Struct(req); err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	ctx := r.Context()
+	coll := utils.GetCollection(RefreshTokenCollection)
+
+	var rt RefreshToken
+	if err := coll.FindOne(ctx, bson.M{"token_hash": utils.HashToken(req.RefreshToken)}).Decode(&rt); err != nil {
+		utils.GetError(ErrInvalidRefreshToken, http.StatusUnauthorized, w)
+		return
+	}
+
+	if rt.Revoked || time.Now().After(rt.ExpiresAt) {
+		utils.GetError(ErrInvalidRefreshToken, http.StatusUnauthorized, w)
+		return
+	}
+
+	// claim the token atomically, losing the race counts as reuse too
+	claim := coll.FindOneAndUpdate(ctx,
+		bson.M{"_id": rt.ID, "used": false, "revoked": false},
+		bson.M{"$set": bson.M{"used": true}},
+	)
+	if rt.Used || claim.Err() != nil {
+		revokeRefreshFamily(ctx, rt)
+		utils.GetError(ErrRefreshTokenReused, http.StatusUnauthorized, w)
+
+		return
+	}
+
+	store := NewMongoStore(utils.GetCollection(sessionCollection), au.configs.SessionMaxAge, true, []byte(au.configs.SecretKey))
+	session := sessions.NewSession(store, au.configs.SessionKey)
+	session.ID = rt.SessionID.Hex()
+	session.Options = store.Options
+
+	if err := store.load(session); err != nil {
+		// the session was logged out, nothing in the family is valid anymore
+		revokeRefreshFamily(ctx, rt)
+		utils.GetError(ErrInvalidRefreshToken, http.StatusUnauthorized, w)
+
+		return
+	}
+
+	u, err := FetchUserByID(rt.UserID)
+	if err != nil {
+		utils.GetError(ErrUserNotFound, http.StatusUnauthorized, w)
+		return
+	}
+
+	resp, err := au.issueTokens(ctx, u, session, rt.FamilyID)
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	utils.GetSuccess("token refreshed", resp, w)
+}
+
+// issueTokens signs an access token for session and attaches a refresh token. An
+// empty familyID starts a new family, as happens on every fresh login.
+func (au *AuthHandler) issueTokens(ctx context.Context, u *user.User, session *sessions.Session, familyID string) (*Token, error) {
+	resp, err := au.GetAuthToken(u, session)
+	if err != nil {
+		return nil, err
+	}
+
+	sessionID, err := primitive.ObjectIDFromHex(session.ID)
+	if err != nil {
+		return nil, ErrorInvalid
+	}
+
+	if familyID == "" {
+		familyID = utils.GenUUID()
+	}
+
+	raw, err := utils.RandomToken(refreshTokenBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	rt := RefreshToken{
+		TokenHash: utils.HashToken(raw),
+		FamilyID:  familyID,
+		SessionID: sessionID,
+		UserID:    u.ID,
+		ExpiresAt: time.Now().Add(time.Duration(au.configs.RefreshTokenTTL) * time.Second),
+		CreatedAt: time.Now(),
+	}
+
+	if _, err := utils.GetCollection(RefreshTokenCollection).InsertOne(ctx, rt); err != nil {
+		return nil, err
+	}
+
+	resp.RefreshToken = raw
+
+	return resp, nil
+}
+
+// revokeRefreshFamily revokes every token descended from the same login as rt and
+// deletes the session they were bound to.
+func revokeRefreshFamily(ctx context.Context, rt RefreshToken) {
+	//nolint:errcheck //CODEI8: best effort revocation
+	utils.GetCollection(RefreshTokenCollection).UpdateMany(ctx,
+		bson.M{"family_id": rt.FamilyID},
+		bson.M{"$set": bson.M{"revoked": true}},
+	)
+
+	//nolint:errcheck //CODEI8: best effort revocation
+	utils.GetCollection(sessionCollection).DeleteOne(ctx, bson.M{"_id": rt.SessionID})
+}
+
+// revokeRefreshTokens revokes every refresh token matching filter, used when the
+// sessions they are bound to are logged out.
+func revokeRefreshTokens(ctx context.Context, filter bson.M) {
+	_, err := utils.GetCollection(RefreshTokenCollection).UpdateMany(ctx, filter, bson.M{"$set": bson.M{"revoked": true}})
+	if err != nil {
+		fmt.Printf("%v", err)
+	}
+}
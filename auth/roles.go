@@ -0,0 +1,322 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"zuri.chat/zccore/audit"
+	"zuri.chat/zccore/utils"
+)
+
+const (
+	OrgRoleCollection   = "organization_roles"
+	UserGroupCollection = "user_groups"
+)
+
+var (
+	ErrRoleNotFound      = errors.New("role not found")
+	ErrRoleExists        = errors.New("a role with this name already exists")
+	ErrRoleName          = errors.New("role names are 2 to 32 lowercase letters, digits, dashes or underscores and start with a letter")
+	ErrBuiltinRole       = errors.New("built-in roles can't be changed")
+	ErrRoleInUse         = errors.New("role is assigned to members or user groups, give them another role first")
+	ErrUnknownPermission = errors.New("unknown permission")
+	ErrRoleEscalation    = errors.New("you can only grant permissions you have yourself")
+	ErrOwnerPermission   = errors.New("ownership transfer is reserved to the owner role")
+
+	roleNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_-]{1,31}$`)
+
+	// builtinRoleOrder is the order built-in roles are listed in.
+	builtinRoleOrder = []string{"owner", "admin", "editor", "member", "guest"}
+)
+
+// OrgRole is an organization's custom role. Members hold it by name, like the
+// built-in roles.
+type OrgRole struct {
+	ID          primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	OrgID       string             `json:"org_id,omitempty" bson:"org_id"`
+	Name        string             `json:"name" bson:"name"`
+	Description string             `json:"description" bson:"description"`
+	Permissions []string           `json:"permissions" bson:"permissions"`
+	Builtin     bool               `json:"builtin" bson:"-"`
+	CreatedBy   string             `json:"created_by,omitempty" bson:"created_by"`
+	CreatedAt   time.Time          `json:"created_at,omitempty" bson:"created_at"`
+	UpdatedAt   time.Time          `json:"updated_at,omitempty" bson:"updated_at"`
+}
+
+type CreateRoleRequest struct {
+	Name        string   `json:"name" validate:"required"`
+	Description string   `json:"description" validate:"max=256"`
+	Permissions []string `json:"permissions" validate:"required,min=1"`
+}
+
+type UpdateRoleRequest struct {
+	Description *string  `json:"description" validate:"omitempty,max=256"`
+	Permissions []string `json:"permissions" validate:"omitempty,min=1"`
+}
+
+// GetPermissions lists the permissions roles are made of.
+func (au *AuthHandler) GetPermissions(w http.ResponseWriter, r *http.Request) {
+	utils.GetSuccess("permissions retrieved successfully", Permissions, w)
+}
+
+// GetRoles lists the organization's built-in and custom roles.
+func (au *AuthHandler) GetRoles(w http.ResponseWriter, r *http.Request) {
+	roles := make([]OrgRole, 0, len(builtinRoleOrder))
+	for _, name := range builtinRoleOrder {
+		roles = append(roles, OrgRole{Name: name, Permissions: builtinRoles[name], Builtin: true})
+	}
+
+	opts := options.Find().SetSort(bson.M{"name": 1})
+
+	cursor, err := utils.GetCollection(OrgRoleCollection).Find(r.Context(), bson.M{"org_id": mux.Vars(r)["id"]}, opts)
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	var custom []OrgRole
+	if err := cursor.All(r.Context(), &custom); err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	utils.GetSuccess("roles retrieved successfully", append(roles, custom...), w)
+}
+
+// CreateRole adds a custom role to the organization.
+func (au *AuthHandler) CreateRole(w http.ResponseWriter, r *http.Request) {
+	loggedIn, _ := r.Context().Value("user").(*AuthUser)
+
+	var req CreateRoleRequest
+	if err := utils.ParseJSONFromRequest(r, &req); err != nil {
+		utils.GetError(err, http.StatusUnprocessableEntity, w)
+		return
+	}
+
+	if err := validate.Struct(req); err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	name := strings.ToLower(strings.TrimSpace(req.Name))
+	if !roleNamePattern.MatchString(name) {
+		utils.GetError(ErrRoleName, http.StatusBadRequest, w)
+		return
+	}
+
+	if IsBuiltinRole(name) {
+		utils.GetError(ErrRoleExists, http.StatusConflict, w)
+		return
+	}
+
+	perms, err := checkRolePermissions(loggedIn.Permissions, req.Permissions)
+	if err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	now := time.Now()
+	role := OrgRole{
+		OrgID:       mux.Vars(r)["id"],
+		Name:        name,
+		Description: strings.TrimSpace(req.Description),
+		Permissions: perms,
+		CreatedBy:   loggedIn.ID.Hex(),
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+
+	res, err := utils.GetCollection(OrgRoleCollection).InsertOne(r.Context(), role)
+	if mongo.IsDuplicateKeyError(err) {
+		utils.GetError(ErrRoleExists, http.StatusConflict, w)
+		return
+	}
+
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	role.ID, _ = res.InsertedID.(primitive.ObjectID)
+
+	audit.Record(r, audit.Event{
+		OrgID:      role.OrgID,
+		Action:     audit.ActionRoleCreate,
+		TargetType: audit.TargetRole,
+		TargetID:   name,
+		After:      map[string]interface{}{"permissions": perms},
+	})
+
+	utils.GetSuccess("role created", role, w)
+}
+
+// UpdateRole changes a custom role's description or permissions. Members holding the
+// role get the new permissions on their next request.
+func (au *AuthHandler) UpdateRole(w http.ResponseWriter, r *http.Request) {
+	loggedIn, _ := r.Context().Value("user").(*AuthUser)
+	orgID, name := mux.Vars(r)["id"], mux.Vars(r)["role"]
+
+	if IsBuiltinRole(name) {
+		utils.GetError(ErrBuiltinRole, http.StatusBadRequest, w)
+		return
+	}
+
+	var req UpdateRoleRequest
+	if err := utils.ParseJSONFromRequest(r, &req); err != nil {
+		utils.GetError(err, http.StatusUnprocessableEntity, w)
+		return
+	}
+
+	if err := validate.Struct(req); err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	current, err := RolePermissions(r.Context(), orgID, name)
+	if err != nil {
+		utils.GetError(err, http.StatusNotFound, w)
+		return
+	}
+
+	// a role more powerful than the editor's own is out of their reach
+	if !GrantsAll(loggedIn.Permissions, current) {
+		utils.GetError(ErrRoleEscalation, http.StatusForbidden, w)
+		return
+	}
+
+	var before OrgRole
+
+	err = utils.GetCollection(OrgRoleCollection).FindOne(r.Context(), bson.M{"org_id": orgID, "name": name}).Decode(&before)
+	if err != nil {
+		utils.GetError(ErrRoleNotFound, http.StatusNotFound, w)
+		return
+	}
+
+	role := before
+	role.UpdatedAt = time.Now()
+
+	if req.Description != nil {
+		role.Description = strings.TrimSpace(*req.Description)
+	}
+
+	if req.Permissions != nil {
+		if role.Permissions, err = checkRolePermissions(loggedIn.Permissions, req.Permissions); err != nil {
+			utils.GetError(err, http.StatusBadRequest, w)
+			return
+		}
+	}
+
+	update := bson.M{"description": role.Description, "permissions": role.Permissions, "updated_at": role.UpdatedAt}
+
+	res, err := utils.GetCollection(OrgRoleCollection).UpdateOne(r.Context(), bson.M{"_id": before.ID}, bson.M{"$set": update})
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	if res.MatchedCount == 0 {
+		utils.GetError(ErrRoleNotFound, http.StatusNotFound, w)
+		return
+	}
+
+	from, to := audit.Diff(
+		bson.M{"description": before.Description, "permissions": before.Permissions},
+		bson.M{"description": role.Description, "permissions": role.Permissions},
+	)
+	audit.Record(r, audit.Event{OrgID: orgID, Action: audit.ActionRoleUpdate, TargetType: audit.TargetRole, TargetID: name, Before: from, After: to})
+
+	utils.GetSuccess("role updated", role, w)
+}
+
+// DeleteRole removes a custom role no member or user group holds.
+func (au *AuthHandler) DeleteRole(w http.ResponseWriter, r *http.Request) {
+	loggedIn, _ := r.Context().Value("user").(*AuthUser)
+	orgID, name := mux.Vars(r)["id"], mux.Vars(r)["role"]
+
+	if IsBuiltinRole(name) {
+		utils.GetError(ErrBuiltinRole, http.StatusBadRequest, w)
+		return
+	}
+
+	current, err := RolePermissions(r.Context(), orgID, name)
+	if err != nil {
+		utils.GetError(err, http.StatusNotFound, w)
+		return
+	}
+
+	if !GrantsAll(loggedIn.Permissions, current) {
+		utils.GetError(ErrRoleEscalation, http.StatusForbidden, w)
+		return
+	}
+
+	holders, err := utils.GetCollection("members").CountDocuments(r.Context(),
+		bson.M{"org_id": orgID, "role": name, "deleted": bson.M{"$ne": true}})
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	if holders == 0 {
+		holders, err = utils.GetCollection(UserGroupCollection).CountDocuments(r.Context(), bson.M{"org_id": orgID, "role": name})
+		if err != nil {
+			utils.GetError(err, http.StatusInternalServerError, w)
+			return
+		}
+	}
+
+	if holders > 0 {
+		utils.GetError(ErrRoleInUse, http.StatusConflict, w)
+		return
+	}
+
+	res, err := utils.GetCollection(OrgRoleCollection).DeleteOne(r.Context(), bson.M{"org_id": orgID, "name": name})
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	if res.DeletedCount == 0 {
+		utils.GetError(ErrRoleNotFound, http.StatusNotFound, w)
+		return
+	}
+
+	audit.Record(r, audit.Event{
+		OrgID:      orgID,
+		Action:     audit.ActionRoleDelete,
+		TargetType: audit.TargetRole,
+		TargetID:   name,
+		Before:     map[string]interface{}{"permissions": current},
+	})
+
+	utils.GetSuccess("role deleted", nil, w)
+}
+
+// checkRolePermissions validates the permissions of a custom role and returns them
+// without duplicates. Only permissions the member has can be granted.
+func checkRolePermissions(held, requested []string) ([]string, error) {
+	perms := make([]string, 0, len(requested))
+
+	for _, p := range requested {
+		switch {
+		case !IsPermission(p):
+			return nil, fmt.Errorf("%w: %s", ErrUnknownPermission, p)
+		case p == PermOrgTransfer:
+			return nil, ErrOwnerPermission
+		case !hasPermission(held, p):
+			return nil, ErrRoleEscalation
+		case !hasPermission(perms, p):
+			perms = append(perms, p)
+		}
+	}
+
+	return perms, nil
+}
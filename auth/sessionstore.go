@@ -13,6 +13,7 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"zuri.chat/zccore/utils"
 )
 
 var (
@@ -21,10 +22,16 @@ var (
 )
 
 type Session struct {
-	ID       primitive.ObjectID `bson:"_id,omitempty"`
-	UserID   primitive.ObjectID `bson:"user_id,omitempty" json:"user_id,omitempty"`
-	Data     string
-	Modified time.Time
+	ID         primitive.ObjectID `bson:"_id,omitempty"`
+	UserID     primitive.ObjectID `bson:"user_id,omitempty" json:"user_id,omitempty"`
+	Data       string
+	Modified   time.Time
+	Name       string    `bson:"name,omitempty" json:"name"`
+	UserAgent  string    `bson:"user_agent" json:"user_agent"`
+	IPAddress  string    `bson:"ip_address" json:"ip_address"`
+	Location   string    `bson:"location" json:"location"`
+	CreatedAt  time.Time `bson:"created_at" json:"created_at"`
+	LastSeenAt time.Time `bson:"last_seen_at" json:"last_seen_at"`
 }
 
 type ResToken struct {
@@ -139,7 +146,7 @@ func (m *MongoStore) Save(r *http.Request, w http.ResponseWriter, session *sessi
 		session.ID = primitive.NewObjectID().Hex()
 	}
 
-	if err := m.upsert(session); err != nil {
+	if err := m.upsert(r, session); err != nil {
 		return err
 	}
 
@@ -190,7 +197,7 @@ func (m *MongoStore) load(session *sessions.Session) error {
 	return nil
 }
 
-func (m *MongoStore) upsert(session *sessions.Session) error {
+func (m *MongoStore) upsert(r *http.Request, session *sessions.Session) error {
 	ctx := context.Background()
 
 	objID, err := primitive.ObjectIDFromHex(session.ID)
@@ -223,15 +230,21 @@ func (m *MongoStore) upsert(session *sessions.Session) error {
 	}
 
 	encoded, _ := securecookie.EncodeMulti(session.Name(), session.Values, m.Codecs...)
-	s := Session{
-		ID:       objID,
-		UserID:   userID,
-		Data:     encoded,
-		Modified: modified,
-	}
+	now := time.Now()
 	opts := options.Update().SetUpsert(true)
-	filter := bson.M{"_id": s.ID}
-	updateData := bson.M{"$set": s}
+	filter := bson.M{"_id": objID}
+	updateData := bson.M{
+		"$set": bson.M{
+			"user_id":      userID,
+			"data":         encoded,
+			"modified":     modified,
+			"user_agent":   r.UserAgent(),
+			"ip_address":   utils.ClientIP(r),
+			"location":     utils.LocationLabel(r),
+			"last_seen_at": now,
+		},
+		"$setOnInsert": bson.M{"created_at": now},
+	}
 
 	if _, err = m.coll.UpdateOne(ctx, filter, updateData, opts); err != nil {
 		return err
@@ -0,0 +1,121 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"zuri.chat/zccore/user"
+	"zuri.chat/zccore/utils"
+)
+
+const (
+	SSOHandoffCollection = "sso_handoffs"
+	ssoHandoffTTL        = time.Minute
+)
+
+var (
+	ErrSSORequired       = errors.New("your organization requires single sign-on, kindly sign in through your identity provider")
+	ErrInvalidSSOHandoff = errors.New("sign in code is invalid or has expired, kindly sign in again")
+)
+
+// IssueSSOHandoff stores a short-lived code that lets the client start a session for
+// userID after an identity provider signed them in. Only its hash is persisted.
+func IssueSSOHandoff(ctx context.Context, userID string) (string, error) {
+	code, err := utils.RandomToken(challengeTokenBytes)
+	if err != nil {
+		return "", err
+	}
+
+	handoff := SSOHandoff{
+		UserID:    userID,
+		TokenHash: utils.HashToken(code),
+		ExpiresAt: time.Now().Add(ssoHandoffTTL),
+		CreatedAt: time.Now(),
+	}
+
+	if _, err := utils.GetCollection(SSOHandoffCollection).InsertOne(ctx, handoff); err != nil {
+		return "", err
+	}
+
+	return code, nil
+}
+
+// ExchangeSSOHandoff redeems a code issued by IssueSSOHandoff for a session.
+func (au *AuthHandler) ExchangeSSOHandoff(w http.ResponseWriter, r *http.Request) {
+	var req SSOExchangeRequest
+	if err := utils.ParseJSONFromRequest(r, &req); err != nil {
+		utils.GetError(err, http.StatusUnprocessableEntity, w)
+		return
+	}
+
+	if err := validate.Struct(req); err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	var handoff SSOHandoff
+
+	// deleting on lookup makes the code single use
+	err := utils.GetCollection(SSOHandoffCollection).
+		FindOneAndDelete(r.Context(), bson.M{"token_hash": utils.HashToken(req.Code)}).
+		Decode(&handoff)
+	if err != nil || time.Now().After(handoff.ExpiresAt) {
+		utils.GetError(ErrInvalidSSOHandoff, http.StatusUnauthorized, w)
+		return
+	}
+
+	u, err := FetchUserByID(handoff.UserID)
+	if err != nil || u.Deactivated {
+		utils.GetError(ErrUserNotFound, http.StatusUnauthorized, w)
+		return
+	}
+
+	if respondTwoFactorChallenge(w, r, u) {
+		return
+	}
+
+	resp, err := au.StartSession(w, r, u)
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	utils.GetSuccess("login successful", resp, w)
+}
+
+// FindIdentityUser returns the user an external identity is linked to.
+func FindIdentityUser(provider, subject string) (*user.User, error) {
+	return FetchUserByEmail(identityFilter(provider, subject))
+}
+
+// requiresSSO reports whether any organization the user belongs to only allows
+// members to sign in through its identity provider.
+func requiresSSO(ctx context.Context, email string) (bool, error) {
+	orgIDs, err := utils.GetCollection("members").Distinct(ctx, "org_id", bson.M{
+		"email":   strings.ToLower(email),
+		"deleted": bson.M{"$ne": true},
+	})
+	if err != nil || len(orgIDs) == 0 {
+		return false, err
+	}
+
+	ids := make([]interface{}, 0, len(orgIDs))
+
+	for _, v := range orgIDs {
+		if s, ok := v.(string); ok {
+			ids = append(ids, objectID(s))
+		}
+	}
+
+	n, err := utils.GetCollection("organizations").CountDocuments(ctx, bson.M{
+		"_id": bson.M{"$in": ids},
+		"settings.authentication.authenticationmethod.sso_required": true,
+		"settings.authentication.authenticationmethod.saml.enabled": true,
+	})
+
+	return n > 0, err
+}
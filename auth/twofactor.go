@@ -0,0 +1,507 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"zuri.chat/zccore/user"
+	"zuri.chat/zccore/utils"
+)
+
+const (
+	TwoFactorChallengeCollection = "two_factor_challenges"
+	twoFactorIssuer              = "Zuri Chat"
+	twoFactorChallengeTTL        = 5 * time.Minute
+	twoFactorMaxAttempts         = 5
+	recoveryCodeCount            = 10
+	recoveryCodeBytes            = 5
+	challengeTokenBytes          = 32
+)
+
+var (
+	ErrTwoFactorEnabled     = errors.New("two factor authentication is already enabled")
+	ErrTwoFactorNotEnabled  = errors.New("two factor authentication is not enabled")
+	ErrTwoFactorNotEnrolled = errors.New("no pending two factor enrollment, kindly start enrollment first")
+	ErrInvalidTwoFactorCode = errors.New("invalid two factor authentication code")
+	ErrInvalidChallenge     = errors.New("login challenge is invalid or has expired, kindly login again")
+	ErrTwoFactorRequired    = errors.New("this organization requires two factor authentication, kindly enable it on your account")
+)
+
+// EnrollTwoFactor generates a new TOTP secret for the logged in user. The secret stays
+// pending until it is confirmed with ActivateTwoFactor.
+func (au *AuthHandler) EnrollTwoFactor(w http.ResponseWriter, r *http.Request) {
+	loggedIn, _ := r.Context().Value("user").(*AuthUser)
+
+	u, err := FetchUserByEmail(bson.M{"email": strings.ToLower(loggedIn.Email)})
+	if err != nil {
+		utils.GetError(ErrUserNotFound, http.StatusBadRequest, w)
+		return
+	}
+
+	if u.TwoFactor != nil && u.TwoFactor.Enabled {
+		utils.GetError(ErrTwoFactorEnabled, http.StatusBadRequest, w)
+		return
+	}
+
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	tf := user.TwoFactor{PendingSecret: au.sealSecret(secret)}
+	if _, err := utils.UpdateOneMongoDBDoc(userCollection, u.ID, map[string]interface{}{"two_factor": tf}); err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	utils.GetSuccess("scan the provisioning uri with an authenticator app, then activate with a code", map[string]string{
+		"secret":           secret,
+		"provisioning_uri": utils.TOTPProvisioningURI(secret, twoFactorIssuer, u.Email),
+	}, w)
+}
+
+// ActivateTwoFactor confirms a pending enrollment with a code from the authenticator app
+// and returns the one-time recovery codes. They are only ever shown here.
+func (au *AuthHandler) ActivateTwoFactor(w http.ResponseWriter, r *http.Request) {
+	loggedIn, _ := r.Context().Value("user").(*AuthUser)
+
+	var req TwoFactorCodeRequest
+	if err := utils.ParseJSONFromRequest(r, &req); err != nil {
+		utils.GetError(err, http.StatusUnprocessableEntity, w)
+		return
+	}
+
+	if err := validate.Struct(req); err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	u, err := FetchUserByEmail(bson.M{"email": strings.ToLower(loggedIn.Email)})
+	if err != nil {
+		utils.GetError(ErrUserNotFound, http.StatusBadRequest, w)
+		return
+	}
+
+	if u.TwoFactor != nil && u.TwoFactor.Enabled {
+		utils.GetError(ErrTwoFactorEnabled, http.StatusBadRequest, w)
+		return
+	}
+
+	if u.TwoFactor == nil || u.TwoFactor.PendingSecret == "" {
+		utils.GetError(ErrTwoFactorNotEnrolled, http.StatusBadRequest, w)
+		return
+	}
+
+	secret, err := au.openSecret(u.TwoFactor.PendingSecret)
+	if err != nil {
+		utils.GetError(ErrInvalidTwoFactorCode, http.StatusBadRequest, w)
+		return
+	}
+
+	step, ok := utils.MatchTOTP(secret, req.Code, time.Now())
+	if !ok {
+		utils.GetError(ErrInvalidTwoFactorCode, http.StatusBadRequest, w)
+		return
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	tf := user.TwoFactor{
+		Enabled:       true,
+		Secret:        u.TwoFactor.PendingSecret,
+		RecoveryCodes: hashes,
+		EnabledAt:     time.Now(),
+		LastStep:      step,
+	}
+
+	if _, err := utils.UpdateOneMongoDBDoc(userCollection, u.ID, map[string]interface{}{"two_factor": tf}); err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	utils.GetSuccess("two factor authentication enabled, store your recovery codes somewhere safe", map[string]interface{}{
+		"recovery_codes": codes,
+	}, w)
+}
+
+// DisableTwoFactor turns off two factor authentication after re-confirming the
+// password and a current code or recovery code.
+func (au *AuthHandler) DisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	loggedIn, _ := r.Context().Value("user").(*AuthUser)
+
+	var req TwoFactorConfirmRequest
+	if err := utils.ParseJSONFromRequest(r, &req); err != nil {
+		utils.GetError(err, http.StatusUnprocessableEntity, w)
+		return
+	}
+
+	if err := validate.Struct(req); err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	u, err := FetchUserByEmail(bson.M{"email": strings.ToLower(loggedIn.Email)})
+	if err != nil {
+		utils.GetError(ErrUserNotFound, http.StatusBadRequest, w)
+		return
+	}
+
+	if u.TwoFactor == nil || !u.TwoFactor.Enabled {
+		utils.GetError(ErrTwoFactorNotEnabled, http.StatusBadRequest, w)
+		return
+	}
+
+	if !au.confirmTwoFactorChange(w, r, u, &req, au.verifySecondFactor) {
+		return
+	}
+
+	if _, err := utils.UpdateOneMongoDBDoc(userCollection, u.ID, map[string]interface{}{"two_factor": nil}); err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	utils.GetSuccess("two factor authentication disabled", nil, w)
+}
+
+// RegenerateRecoveryCodes replaces every recovery code of the logged in user after
+// re-confirming the password and a current code.
+func (au *AuthHandler) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	loggedIn, _ := r.Context().Value("user").(*AuthUser)
+
+	var req TwoFactorConfirmRequest
+	if err := utils.ParseJSONFromRequest(r, &req); err != nil {
+		utils.GetError(err, http.StatusUnprocessableEntity, w)
+		return
+	}
+
+	if err := validate.Struct(req); err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	u, err := FetchUserByEmail(bson.M{"email": strings.ToLower(loggedIn.Email)})
+	if err != nil {
+		utils.GetError(ErrUserNotFound, http.StatusBadRequest, w)
+		return
+	}
+
+	if u.TwoFactor == nil || !u.TwoFactor.Enabled {
+		utils.GetError(ErrTwoFactorNotEnabled, http.StatusBadRequest, w)
+		return
+	}
+
+	if !au.confirmTwoFactorChange(w, r, u, &req, au.verifyTOTP) {
+		return
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	if _, err := utils.UpdateOneMongoDBDoc(userCollection, u.ID, map[string]interface{}{"two_factor.recovery_codes": hashes}); err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	utils.GetSuccess("recovery codes regenerated", map[string]interface{}{"recovery_codes": codes}, w)
+}
+
+// confirmTwoFactorChange checks the password and code of req before a change to the two
+// factor settings of u. Failures count against the account the same as failed logins, so
+// a session can't be used to guess codes.
+func (au *AuthHandler) confirmTwoFactorChange(w http.ResponseWriter, r *http.Request, u *user.User,
+	req *TwoFactorConfirmRequest, verify func(context.Context, *user.User, string) bool) bool {
+	if err := checkAttempts(r.Context(), accountScope.key(u.Email)); err != nil {
+		writeAttemptError(w, err, http.StatusTooManyRequests)
+		return false
+	}
+
+	if !ComparePassword(req.Password, u.Password) {
+		au.recordLoginFailure(r, u.Email, u)
+		utils.GetError(ErrInvalidCredentials, http.StatusBadRequest, w)
+
+		return false
+	}
+
+	if !verify(r.Context(), u, req.Code) {
+		au.recordLoginFailure(r, u.Email, u)
+		utils.GetError(ErrInvalidTwoFactorCode, http.StatusBadRequest, w)
+
+		return false
+	}
+
+	clearAttempts(r.Context(), accountScope.key(u.Email))
+
+	return true
+}
+
+// VerifyTwoFactorLogin completes a login started by LoginIn by exchanging the
+// challenge token and a TOTP or recovery code for a session.
+func (au *AuthHandler) VerifyTwoFactorLogin(w http.ResponseWriter, r *http.Request) {
+	var req TwoFactorLoginRequest
+	if err := utils.ParseJSONFromRequest(r, &req); err != nil {
+		utils.GetError(err, http.StatusUnprocessableEntity, w)
+		return
+	}
+
+	if err := validate.Struct(req); err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	coll := utils.GetCollection(TwoFactorChallengeCollection)
+
+	challenge, err := findTwoFactorChallenge(r.Context(), req.ChallengeToken)
+	if err != nil {
+		utils.GetError(err, http.StatusUnauthorized, w)
+		return
+	}
+
+	u, err := FetchUserByID(challenge.UserID)
+	if err != nil {
+		utils.GetError(ErrUserNotFound, http.StatusUnauthorized, w)
+		return
+	}
+
+	if err := checkAttempts(r.Context(), accountScope.key(u.Email)); err != nil {
+		writeAttemptError(w, err, http.StatusTooManyRequests)
+		return
+	}
+
+	if !au.verifySecondFactor(r.Context(), u, req.Code) {
+		//nolint:errcheck //CODEI8: best effort counter
+		coll.UpdateOne(r.Context(), bson.M{"_id": challenge.ID}, bson.M{"$inc": bson.M{"attempts": 1}})
+		au.recordLoginFailure(r, u.Email, u)
+		utils.GetError(ErrInvalidTwoFactorCode, http.StatusUnauthorized, w)
+
+		return
+	}
+
+	clearAttempts(r.Context(), accountScope.key(u.Email))
+
+	// a challenge can only be redeemed once
+	if res, err := coll.DeleteOne(r.Context(), bson.M{"_id": challenge.ID}); err != nil || res.DeletedCount == 0 {
+		utils.GetError(ErrInvalidChallenge, http.StatusUnauthorized, w)
+		return
+	}
+
+	resp, err := au.StartSession(w, r, u)
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	utils.GetSuccess("login successful", resp, w)
+}
+
+// findTwoFactorChallenge returns the pending challenge for token, discarding it once
+// it has expired or too many codes were tried.
+func findTwoFactorChallenge(ctx context.Context, token string) (*TwoFactorChallenge, error) {
+	coll := utils.GetCollection(TwoFactorChallengeCollection)
+
+	var challenge TwoFactorChallenge
+	if err := coll.FindOne(ctx, bson.M{"token_hash": utils.HashToken(token)}).Decode(&challenge); err != nil {
+		return nil, ErrInvalidChallenge
+	}
+
+	if time.Now().After(challenge.ExpiresAt) || challenge.Attempts >= twoFactorMaxAttempts {
+		//nolint:errcheck //CODEI8: best effort cleanup
+		coll.DeleteOne(ctx, bson.M{"_id": challenge.ID})
+		return nil, ErrInvalidChallenge
+	}
+
+	return &challenge, nil
+}
+
+// issueTwoFactorChallenge stores a short-lived challenge for userID and returns the
+// raw token. Only its hash is persisted.
+func issueTwoFactorChallenge(ctx context.Context, userID string) (string, error) {
+	token, err := utils.RandomToken(challengeTokenBytes)
+	if err != nil {
+		return "", err
+	}
+
+	coll := utils.GetCollection(TwoFactorChallengeCollection)
+
+	// a fresh password login supersedes any challenge still pending
+	if _, err := coll.DeleteMany(ctx, bson.M{"user_id": userID}); err != nil {
+		return "", err
+	}
+
+	challenge := TwoFactorChallenge{
+		UserID:    userID,
+		TokenHash: utils.HashToken(token),
+		ExpiresAt: time.Now().Add(twoFactorChallengeTTL),
+		CreatedAt: time.Now(),
+	}
+
+	if _, err := coll.InsertOne(ctx, challenge); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// verifySecondFactor accepts either a current TOTP code or an unused recovery code.
+// A matched recovery code is consumed.
+func (au *AuthHandler) verifySecondFactor(ctx context.Context, u *user.User, code string) bool {
+	if u.TwoFactor == nil || !u.TwoFactor.Enabled {
+		return false
+	}
+
+	if au.verifyTOTP(ctx, u, code) {
+		return true
+	}
+
+	hash := utils.HashToken(normalizeRecoveryCode(code))
+
+	for _, h := range u.TwoFactor.RecoveryCodes {
+		if h != hash {
+			continue
+		}
+
+		id, _ := primitive.ObjectIDFromHex(u.ID)
+		res, err := utils.GenericUpdateOneMongoDBDoc(userCollection, id, bson.M{
+			"$pull": bson.M{"two_factor.recovery_codes": hash},
+		})
+
+		// ModifiedCount guards against the same code being redeemed twice concurrently
+		return err == nil && res.ModifiedCount == 1
+	}
+
+	return false
+}
+
+// verifyTOTP accepts a current TOTP code of u once: the code's time step must come
+// after the last one accepted, and is claimed atomically so a code used concurrently
+// only passes for one request.
+func (au *AuthHandler) verifyTOTP(ctx context.Context, u *user.User, code string) bool {
+	secret, err := au.openSecret(u.TwoFactor.Secret)
+	if err != nil {
+		return false
+	}
+
+	step, ok := utils.MatchTOTP(secret, code, time.Now())
+	if !ok {
+		return false
+	}
+
+	res, err := utils.GetCollection(userCollection).UpdateOne(ctx, bson.M{
+		"_id":                  objectID(u.ID),
+		"two_factor.enabled":   true,
+		"two_factor.last_step": bson.M{"$not": bson.M{"$gte": step}},
+	}, bson.M{"$set": bson.M{"two_factor.last_step": step}})
+
+	return err == nil && res.ModifiedCount == 1
+}
+
+// requiresTwoFactor reports whether the organization in the route enforces two factor
+// authentication for a user who has not enabled it.
+func requiresTwoFactor(r *http.Request, email string) bool {
+	orgID := mux.Vars(r)["id"]
+	if orgID == "" || !strings.HasPrefix(r.URL.Path, "/organizations/") {
+		return false
+	}
+
+	objID, err := primitive.ObjectIDFromHex(orgID)
+	if err != nil {
+		return false
+	}
+
+	enforced := utils.CountCollection(r.Context(), "organizations", bson.M{
+		"_id": objID,
+		"settings.authentication.workspacewidetwofactorauthentication.required": true,
+	})
+	if enforced == 0 {
+		return false
+	}
+
+	u, err := FetchUserByEmail(bson.M{"email": strings.ToLower(email)})
+	if err != nil {
+		return false
+	}
+
+	return u.TwoFactor == nil || !u.TwoFactor.Enabled
+}
+
+func generateRecoveryCodes() (codes, hashes []string, err error) {
+	enc := base32.StdEncoding.WithPadding(base32.NoPadding)
+
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, recoveryCodeBytes)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+
+		raw := strings.ToLower(enc.EncodeToString(b))
+		codes = append(codes, raw[:4]+"-"+raw[4:])
+		hashes = append(hashes, utils.HashToken(raw))
+	}
+
+	return codes, hashes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+// sealSecret encrypts a TOTP secret with the server secret key before it is stored.
+func (au *AuthHandler) sealSecret(secret string) string {
+	return base64.StdEncoding.EncodeToString(utils.GCMEncrypt([]byte(secret), au.configs.SecretKey))
+}
+
+func (au *AuthHandler) openSecret(sealed string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return "", err
+	}
+
+	secret, err := utils.GCMDecrypt(data, au.configs.SecretKey)
+
+	return string(secret), err
+}
+
+// respondTwoFactorChallenge answers a first factor login with a challenge if u has
+// two factor authentication enabled, and reports whether it did.
+func respondTwoFactorChallenge(w http.ResponseWriter, r *http.Request, u *user.User) bool {
+	if u.TwoFactor == nil || !u.TwoFactor.Enabled {
+		return false
+	}
+
+	challenge, err := issueTwoFactorChallenge(r.Context(), u.ID)
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return true
+	}
+
+	methods := []string{"totp", "recovery_code"}
+	if hasPasskeys(r.Context(), u.ID) {
+		methods = append(methods, "passkey")
+	}
+
+	utils.GetSuccess("two factor authentication required", TwoFactorChallengeResponse{
+		TwoFactorRequired: true,
+		ChallengeToken:    challenge,
+		ExpiresIn:         int(twoFactorChallengeTTL.Seconds()),
+		Methods:           methods,
+	}, w)
+
+	return true
+}
@@ -1,6 +1,8 @@
 package http
 
 import (
+	"context"
+	"log"
 	"net/http"
 
 	socketio "github.com/googollee/go-socket.io"
@@ -9,6 +11,7 @@ import (
 	gqlHandler "github.com/graphql-go/handler"
 
 	"zuri.chat/zccore/agora"
+	"zuri.chat/zccore/audit"
 	"zuri.chat/zccore/auth"
 	"zuri.chat/zccore/blog"
 	"zuri.chat/zccore/contact"
@@ -19,6 +22,7 @@ import (
 	"zuri.chat/zccore/plugin"
 	"zuri.chat/zccore/realtime"
 	"zuri.chat/zccore/report"
+	"zuri.chat/zccore/scim"
 	"zuri.chat/zccore/service"
 	"zuri.chat/zccore/user"
 	"zuri.chat/zccore/utils"
@@ -40,6 +44,10 @@ func (h *Handler) SetupRoutes() {
 
 	// Load handlers(this to reduce dependency circle issue, might reverse if not working)
 	configs := utils.NewConfigurations()
+	if err := utils.SetTrustedProxies(configs.TrustedProxies); err != nil {
+		log.Fatal(err)
+	}
+
 	mailService := service.NewZcMailService(configs)
 
 	orgs := organizations.NewOrganizationHandler(configs, mailService)
@@ -48,6 +56,7 @@ func (h *Handler) SetupRoutes() {
 	au := auth.NewAuthHandler(configs, mailService)
 	us := user.NewUserHandler(configs, mailService)
 	gql := utils.NewGraphQlHandler(configs)
+	mh := marketplace.NewMarketplaceHandler(configs, mailService)
 
 	// Agora
 	ah := agora.NewAgoraHandler(configs)
@@ -56,6 +65,25 @@ func (h *Handler) SetupRoutes() {
 	ps := plugin.NewMongoService(client)
 	ph := plugin.NewHandler(ps)
 
+	sc := scim.NewHandler(configs)
+
+	// purge accounts past their deletion grace period and expired data exports
+	go au.StartAccountJobs(context.Background())
+
+	// purge organizations past their deletion grace period
+	go orgs.StartOrganizationJobs(context.Background())
+
+	// backfill the data new indexes rely on
+	go func() {
+		if err := orgs.MigrateWorkspaceURLs(context.Background()); err != nil {
+			log.Printf("workspace url migration failed: %v", err)
+		}
+
+		if err := orgs.MigrateMemberSearchKeys(context.Background()); err != nil {
+			log.Printf("member search keys migration failed: %v", err)
+		}
+	}()
+
 	// Setup and init
 	h.Router.HandleFunc("/", VersionHandler)
 	h.Router.HandleFunc("/loadapp/{appid}", LoadApp).Methods("GET")
@@ -73,114 +101,239 @@ func (h *Handler) SetupRoutes() {
 	h.Router.HandleFunc("/posts/mail", blog.MailingList).Methods("POST")
 
 	// Authentication
-	h.Router.HandleFunc("/auth/login", au.LoginIn).Methods(http.MethodPost)
+	h.Router.HandleFunc("/auth/login", utils.Throttle(au.LoginIn)).Methods(http.MethodPost)
+	h.Router.HandleFunc("/auth/login/2fa", utils.Throttle(au.VerifyTwoFactorLogin)).Methods(http.MethodPost)
+	h.Router.HandleFunc("/auth/refresh", au.RefreshAccessToken).Methods(http.MethodPost)
 	h.Router.HandleFunc("/auth/logout", au.LogOutUser).Methods(http.MethodPost)
 	h.Router.HandleFunc("/auth/logout/other-sessions", au.LogOutOtherSessions).Methods(http.MethodPost)
+	h.Router.HandleFunc("/auth/sessions", au.IsAuthenticated(au.RequireSession(au.GetSessions))).Methods(http.MethodGet)
+	h.Router.HandleFunc("/auth/sessions/{session_id}", au.IsAuthenticated(au.RequireSession(au.RenameSession))).Methods(http.MethodPatch)
+	h.Router.HandleFunc("/auth/sessions/{session_id}", au.IsAuthenticated(au.RequireSession(au.RevokeSession))).Methods(http.MethodDelete)
+	h.Router.HandleFunc("/auth/tokens", au.IsAuthenticated(au.GetPersonalTokens)).Methods(http.MethodGet)
+	h.Router.HandleFunc("/auth/tokens", au.IsAuthenticated(au.CreatePersonalToken)).Methods(http.MethodPost)
+	h.Router.HandleFunc("/auth/tokens/{token_id}", au.IsAuthenticated(au.RevokePersonalToken)).Methods(http.MethodDelete)
 	h.Router.HandleFunc("/auth/verify-token", au.IsAuthenticated(au.VerifyTokenHandler)).Methods(http.MethodGet, http.MethodPost)
-	h.Router.HandleFunc("/auth/confirm-password", au.IsAuthenticated(au.ConfirmUserPassword)).Methods(http.MethodPost)
+	h.Router.HandleFunc("/auth/confirm-password", au.IsAuthenticated(au.RequireSession(au.ConfirmUserPassword))).Methods(http.MethodPost)
+	h.Router.HandleFunc("/auth/2fa/enroll", au.IsAuthenticated(au.RequireSession(au.EnrollTwoFactor))).Methods(http.MethodPost)
+	h.Router.HandleFunc("/auth/2fa/activate", au.IsAuthenticated(au.RequireSession(au.ActivateTwoFactor))).Methods(http.MethodPost)
+	h.Router.HandleFunc("/auth/2fa/disable", utils.Throttle(au.IsAuthenticated(au.RequireSession(au.DisableTwoFactor)))).Methods(http.MethodPost)
+	h.Router.HandleFunc("/auth/2fa/recovery-codes", utils.Throttle(au.IsAuthenticated(au.RequireSession(au.RegenerateRecoveryCodes)))).Methods(http.MethodPost)
 	h.Router.HandleFunc("/auth/social-login/{provider}/{access_token}", au.SocialAuth).Methods(http.MethodGet)
-
-	h.Router.HandleFunc("/account/verify-account", au.VerifyAccount).Methods(http.MethodPost)
-	h.Router.HandleFunc("/account/request-password-reset-code", au.RequestResetPasswordCode).Methods(http.MethodPost)
-	h.Router.HandleFunc("/account/verify-reset-password", au.VerifyPasswordResetCode).Methods(http.MethodPost)
-	h.Router.HandleFunc("/account/update-password/{verification_code:[0-9]+}", au.UpdatePassword).Methods(http.MethodPost)
+	h.Router.HandleFunc("/auth/oidc/{provider}/authorize", utils.Throttle(au.AuthorizeOIDC)).Methods(http.MethodPost)
+	h.Router.HandleFunc("/auth/oidc/{provider}/callback", utils.Throttle(au.OIDCCallback)).Methods(http.MethodPost)
+	h.Router.HandleFunc("/auth/oidc/{provider}/link", au.IsAuthenticated(au.RequireSession(au.LinkOIDCIdentity))).Methods(http.MethodPost)
+	h.Router.HandleFunc("/auth/identities", au.IsAuthenticated(au.RequireSession(au.GetIdentities))).Methods(http.MethodGet)
+	h.Router.HandleFunc("/auth/identities/{provider}", au.IsAuthenticated(au.RequireSession(au.UnlinkIdentity))).Methods(http.MethodDelete)
+	h.Router.HandleFunc("/auth/sso/exchange", utils.Throttle(au.ExchangeSSOHandoff)).Methods(http.MethodPost)
+	h.Router.HandleFunc("/auth/magic-link", utils.Throttle(au.RequestMagicLink)).Methods(http.MethodPost)
+	h.Router.HandleFunc("/auth/magic-link/verify", utils.Throttle(au.VerifyMagicLink)).Methods(http.MethodPost)
+
+	h.Router.HandleFunc("/auth/passkeys", au.IsAuthenticated(au.GetPasskeys)).Methods(http.MethodGet)
+	h.Router.HandleFunc("/auth/passkeys/{passkey_id}", au.IsAuthenticated(au.DeletePasskey)).Methods(http.MethodDelete)
+	h.Router.HandleFunc("/auth/passkeys/register/options", au.IsAuthenticated(au.BeginPasskeyRegistration)).Methods(http.MethodPost)
+	h.Router.HandleFunc("/auth/passkeys/register", au.IsAuthenticated(au.FinishPasskeyRegistration)).Methods(http.MethodPost)
+	h.Router.HandleFunc("/auth/passkeys/login/options", utils.Throttle(au.BeginPasskeyLogin)).Methods(http.MethodPost)
+	h.Router.HandleFunc("/auth/passkeys/login", utils.Throttle(au.FinishPasskeyLogin)).Methods(http.MethodPost)
+	h.Router.HandleFunc("/auth/login/2fa/passkey/options", utils.Throttle(au.BeginPasskeySecondFactor)).Methods(http.MethodPost)
+	h.Router.HandleFunc("/auth/login/2fa/passkey", utils.Throttle(au.FinishPasskeySecondFactor)).Methods(http.MethodPost)
+
+	h.Router.HandleFunc("/account/verify-account", utils.Throttle(au.VerifyAccount)).Methods(http.MethodPost)
+	h.Router.HandleFunc("/account/resend-verification-code", utils.Throttle(us.ResendVerificationCode)).Methods(http.MethodPost)
+	h.Router.HandleFunc("/account/request-password-reset-code", utils.Throttle(au.RequestResetPasswordCode)).Methods(http.MethodPost)
+	h.Router.HandleFunc("/account/verify-reset-password", utils.Throttle(au.VerifyPasswordResetCode)).Methods(http.MethodPost)
+	h.Router.HandleFunc("/account/update-password/{verification_code:[0-9]+}", utils.Throttle(au.UpdatePassword)).Methods(http.MethodPost)
+	h.Router.HandleFunc("/account/change-password", utils.Throttle(au.IsAuthenticated(au.RequireSession(au.ChangePassword)))).Methods(http.MethodPost)
+	h.Router.HandleFunc("/account/email", utils.Throttle(au.IsAuthenticated(au.RequestEmailChange))).Methods(http.MethodPost)
+	h.Router.HandleFunc("/account/email/verify", utils.Throttle(au.IsAuthenticated(au.VerifyEmailChange))).Methods(http.MethodPost)
+	h.Router.HandleFunc("/account/email/revert", utils.Throttle(au.RevertEmailChange)).Methods(http.MethodPost)
+	h.Router.HandleFunc("/account/delete", utils.Throttle(au.IsAuthenticated(au.RequestAccountDeletion))).Methods(http.MethodPost)
+	h.Router.HandleFunc("/account/delete/cancel", au.IsAuthenticated(au.CancelAccountDeletion)).Methods(http.MethodPost)
+	h.Router.HandleFunc("/account/exports", au.IsAuthenticated(au.GetDataExports)).Methods(http.MethodGet)
+	h.Router.HandleFunc("/account/exports", au.IsAuthenticated(au.RequestDataExport)).Methods(http.MethodPost)
+	h.Router.HandleFunc("/account/exports/{export_id}/download", au.IsAuthenticated(au.DownloadDataExport)).Methods(http.MethodGet)
 
 	// Organization
 	h.Router.HandleFunc("/organizations", au.IsAuthenticated(orgs.Create)).Methods("POST")
 	h.Router.HandleFunc("/organizations", au.IsAuthenticated(orgs.GetOrganizations)).Methods("GET")
-	h.Router.HandleFunc("/organizations/{id}", au.IsAuthenticated(orgs.GetOrganization)).Methods("GET")
-	h.Router.HandleFunc("/organizations/{id}", au.IsAuthenticated(au.IsAuthorized(orgs.DeleteOrganization, "admin"))).Methods("DELETE")
+	h.Router.HandleFunc("/organizations/{id}", au.IsAuthenticated(au.RequirePermission(orgs.GetOrganization, auth.PermOrgRead))).Methods("GET")
+	h.Router.HandleFunc("/organizations/{id}", au.IsAuthenticated(au.RequirePermission(orgs.DeleteOrganization, auth.PermOrgDelete))).Methods("DELETE")
+	h.Router.HandleFunc("/organizations/{id}/deletion/cancel", au.IsAuthenticated(au.RequirePermission(orgs.CancelOrganizationDeletion, auth.PermOrgDelete))).Methods(http.MethodPost)
 	h.Router.HandleFunc("/organizations/url/{url}", orgs.GetOrganizationByURL).Methods("GET")
 
-	h.Router.HandleFunc("/organizations/{id}/url", au.IsAuthenticated(orgs.UpdateURL)).Methods("PATCH")
-	h.Router.HandleFunc("/organizations/{id}/name", au.IsAuthenticated(orgs.UpdateName)).Methods("PATCH")
-	h.Router.HandleFunc("/organizations/{id}/logo", au.IsAuthenticated(orgs.UpdateLogo)).Methods("PATCH")
-
-	h.Router.HandleFunc("/organizations/{id}/settings", au.IsAuthenticated(orgs.UpdateOrganizationSettings)).Methods("PATCH")
-	h.Router.HandleFunc("/organizations/{id}/permission", au.IsAuthenticated(orgs.UpdateOrganizationPermission)).Methods("PATCH")
-	h.Router.HandleFunc("/organizations/{id}/auth", au.IsAuthenticated(orgs.UpdateOrganizationAuthentication)).Methods("PATCH")
-	h.Router.HandleFunc("/organizations/{id}/change-owner", au.IsAuthenticated(au.IsAuthorized(orgs.TransferOwnership, "owner"))).Methods("PATCH")
-
-	h.Router.HandleFunc("/organizations/{id}/prefixes", au.IsAuthenticated(orgs.UpdateOrganizationPrefixes)).Methods("PATCH")
-	h.Router.HandleFunc("/organizations/{id}/slackbotresponses", au.IsAuthenticated(orgs.UpdateSlackBotResponses)).Methods("PATCH")
-	h.Router.HandleFunc("/organizations/{id}/customemoji", au.IsAuthenticated(orgs.AddSlackCustomEmoji)).Methods("PATCH")
+	h.Router.HandleFunc("/organizations/{id}/url", au.IsAuthenticated(au.RequirePermission(orgs.UpdateURL, auth.PermOrgSettingsWrite))).Methods("PATCH")
+	h.Router.HandleFunc("/organizations/{id}/url/availability", au.IsAuthenticated(au.RequirePermission(orgs.CheckURLAvailability, auth.PermOrgSettingsWrite))).Methods("GET")
+	h.Router.HandleFunc("/organizations/{id}/name", au.IsAuthenticated(au.RequirePermission(orgs.UpdateName, auth.PermOrgSettingsWrite))).Methods("PATCH")
+	h.Router.HandleFunc("/organizations/{id}/logo", au.IsAuthenticated(au.RequirePermission(orgs.UpdateLogo, auth.PermOrgSettingsWrite))).Methods("PATCH")
+
+	h.Router.HandleFunc("/organizations/{id}/settings", au.IsAuthenticated(au.RequirePermission(orgs.UpdateOrganizationSettings, auth.PermOrgSettingsWrite))).Methods("PATCH")
+	h.Router.HandleFunc("/organizations/{id}/permission", au.IsAuthenticated(au.RequirePermission(orgs.UpdateOrganizationPermission, auth.PermOrgSettingsWrite))).Methods("PATCH")
+	h.Router.HandleFunc("/organizations/{id}/auth", au.IsAuthenticated(au.RequirePermission(orgs.UpdateOrganizationAuthentication, auth.PermOrgSettingsWrite))).Methods("PATCH")
+	h.Router.HandleFunc("/organizations/{id}/change-owner", au.IsAuthenticated(au.RequirePermission(orgs.TransferOwnership, auth.PermOrgTransfer))).Methods("PATCH")
+	h.Router.HandleFunc("/organizations/{id}/ownership-transfer", au.IsAuthenticated(au.RequirePermission(orgs.GetOwnershipTransfer, auth.PermOrgTransfer))).Methods(http.MethodGet)
+	h.Router.HandleFunc("/organizations/{id}/ownership-transfer", au.IsAuthenticated(au.RequirePermission(orgs.CancelOwnershipTransfer, auth.PermOrgTransfer))).Methods(http.MethodDelete)
+	h.Router.HandleFunc("/organizations/ownership-transfers/{token}/accept", utils.Throttle(au.IsAuthenticated(orgs.AcceptOwnershipTransfer))).Methods(http.MethodPost)
+	h.Router.HandleFunc("/organizations/ownership-transfers/{token}/decline", utils.Throttle(au.IsAuthenticated(orgs.DeclineOwnershipTransfer))).Methods(http.MethodPost)
+
+	// Organization: roles and permissions
+	h.Router.HandleFunc("/organizations/{id}/permissions", au.IsAuthenticated(au.RequirePermission(au.GetPermissions, auth.PermOrgRead))).Methods("GET")
+	h.Router.HandleFunc("/organizations/{id}/roles", au.IsAuthenticated(au.RequirePermission(au.GetRoles, auth.PermOrgRead))).Methods("GET")
+	h.Router.HandleFunc("/organizations/{id}/roles", au.IsAuthenticated(au.RequirePermission(au.CreateRole, auth.PermRolesManage))).Methods("POST")
+	h.Router.HandleFunc("/organizations/{id}/roles/{role}", au.IsAuthenticated(au.RequirePermission(au.UpdateRole, auth.PermRolesManage))).Methods("PATCH")
+	h.Router.HandleFunc("/organizations/{id}/roles/{role}", au.IsAuthenticated(au.RequirePermission(au.DeleteRole, auth.PermRolesManage))).Methods("DELETE")
+
+	// Organization: audit log
+	h.Router.HandleFunc("/organizations/{id}/audit-logs", au.IsAuthenticated(au.RequirePermission(audit.GetLogs, auth.PermAuditRead))).Methods("GET")
+	h.Router.HandleFunc("/organizations/{id}/audit-logs/export", au.IsAuthenticated(au.RequirePermission(audit.ExportLogs, auth.PermAuditRead))).Methods("GET")
+
+	h.Router.HandleFunc("/organizations/{id}/sso/saml", au.IsAuthenticated(au.RequirePermission(orgs.GetSAMLSettings, auth.PermOrgSSOManage))).Methods("GET")
+	h.Router.HandleFunc("/organizations/{id}/sso/saml", au.IsAuthenticated(au.RequirePermission(orgs.UpdateSAMLSettings, auth.PermOrgSSOManage))).Methods("PUT")
+	h.Router.HandleFunc("/organizations/{id}/sso/saml", au.IsAuthenticated(au.RequirePermission(orgs.DeleteSAMLSettings, auth.PermOrgSSOManage))).Methods("DELETE")
+	h.Router.HandleFunc("/organizations/{id}/sso/saml/metadata", orgs.SAMLMetadata).Methods("GET")
+	h.Router.HandleFunc("/organizations/{id}/sso/saml/login", utils.Throttle(orgs.SAMLLogin)).Methods("GET")
+	h.Router.HandleFunc("/organizations/{id}/sso/saml/acs", utils.Throttle(orgs.SAMLAssertionConsumer)).Methods("POST")
+
+	// Organization: API keys
+	h.Router.HandleFunc("/organizations/{id}/api-keys", au.IsAuthenticated(au.RequirePermission(au.GetAPIKeys, auth.PermAPIKeysManage))).Methods("GET")
+	h.Router.HandleFunc("/organizations/{id}/api-keys", au.IsAuthenticated(au.RequirePermission(au.CreateAPIKey, auth.PermAPIKeysManage))).Methods("POST")
+	h.Router.HandleFunc("/organizations/{id}/api-keys/{token_id}", au.IsAuthenticated(au.RequirePermission(au.RevokeAPIKey, auth.PermAPIKeysManage))).Methods("DELETE")
+
+	// Organization: SCIM provisioning
+	h.Router.HandleFunc("/organizations/{id}/scim/tokens", au.IsAuthenticated(au.RequirePermission(sc.GetTokens, auth.PermOrgSSOManage))).Methods("GET")
+	h.Router.HandleFunc("/organizations/{id}/scim/tokens", au.IsAuthenticated(au.RequirePermission(sc.CreateToken, auth.PermOrgSSOManage))).Methods("POST")
+	h.Router.HandleFunc("/organizations/{id}/scim/tokens/{token_id}", au.IsAuthenticated(au.RequirePermission(sc.DeleteToken, auth.PermOrgSSOManage))).Methods("DELETE")
+	h.Router.HandleFunc("/scim/v2/{org}/ServiceProviderConfig", sc.Authenticate(sc.ServiceProviderConfig)).Methods("GET")
+	h.Router.HandleFunc("/scim/v2/{org}/ResourceTypes", sc.Authenticate(sc.ResourceTypes)).Methods("GET")
+	h.Router.HandleFunc("/scim/v2/{org}/Users", sc.Authenticate(sc.GetUsers)).Methods("GET")
+	h.Router.HandleFunc("/scim/v2/{org}/Users", sc.Authenticate(sc.CreateUser)).Methods("POST")
+	h.Router.HandleFunc("/scim/v2/{org}/Users/{user_id}", sc.Authenticate(sc.GetUser)).Methods("GET")
+	h.Router.HandleFunc("/scim/v2/{org}/Users/{user_id}", sc.Authenticate(sc.ReplaceUser)).Methods("PUT")
+	h.Router.HandleFunc("/scim/v2/{org}/Users/{user_id}", sc.Authenticate(sc.PatchUser)).Methods("PATCH")
+	h.Router.HandleFunc("/scim/v2/{org}/Users/{user_id}", sc.Authenticate(sc.DeleteUser)).Methods("DELETE")
+	h.Router.HandleFunc("/scim/v2/{org}/Groups", sc.Authenticate(sc.GetGroups)).Methods("GET")
+	h.Router.HandleFunc("/scim/v2/{org}/Groups", sc.Authenticate(sc.CreateGroup)).Methods("POST")
+	h.Router.HandleFunc("/scim/v2/{org}/Groups/{group_id}", sc.Authenticate(sc.GetGroup)).Methods("GET")
+	h.Router.HandleFunc("/scim/v2/{org}/Groups/{group_id}", sc.Authenticate(sc.ReplaceGroup)).Methods("PUT")
+	h.Router.HandleFunc("/scim/v2/{org}/Groups/{group_id}", sc.Authenticate(sc.PatchGroup)).Methods("PATCH")
+	h.Router.HandleFunc("/scim/v2/{org}/Groups/{group_id}", sc.Authenticate(sc.DeleteGroup)).Methods("DELETE")
+
+	h.Router.HandleFunc("/organizations/{id}/prefixes", au.IsAuthenticated(au.RequirePermission(orgs.UpdateOrganizationPrefixes, auth.PermOrgSettingsWrite))).Methods("PATCH")
+	h.Router.HandleFunc("/organizations/{id}/slackbotresponses", au.IsAuthenticated(au.RequirePermission(orgs.UpdateSlackBotResponses, auth.PermOrgSettingsWrite))).Methods("PATCH")
+	h.Router.HandleFunc("/organizations/{id}/customemoji", au.IsAuthenticated(au.RequirePermission(orgs.AddSlackCustomEmoji, auth.PermOrgSettingsWrite))).Methods("PATCH")
 
 	// Organization: Guest Invites
-	h.Router.HandleFunc("/organizations/{id}/send-invite", au.IsAuthenticated(au.IsAuthorized(orgs.SendInvite, "admin"))).Methods("POST")
-	h.Router.HandleFunc("/organizations/{id}/invite-stats", au.IsAuthenticated(au.IsAuthorized(orgs.InviteStats, "admin"))).Methods("GET")
+	h.Router.HandleFunc("/organizations/{id}/send-invite", au.IsAuthenticated(au.RequirePermission(orgs.SendInvite, auth.PermMembersInvite))).Methods("POST")
+	h.Router.HandleFunc("/organizations/{id}/invite-stats", au.IsAuthenticated(au.RequirePermission(orgs.InviteStats, auth.PermMembersInvite))).Methods("GET")
+	h.Router.HandleFunc("/organizations/{id}/invites/{invite_id}/revoke", au.IsAuthenticated(au.RequirePermission(orgs.RevokeInvite, auth.PermMembersInvite))).Methods(http.MethodPost)
+	h.Router.HandleFunc("/organizations/{id}/invites/{invite_id}/resend", au.IsAuthenticated(au.RequirePermission(orgs.ResendInvite, auth.PermMembersInvite))).Methods(http.MethodPost)
 	h.Router.HandleFunc("/organizations/invites/{uuid}", orgs.CheckGuestStatus).Methods(http.MethodGet)
 	h.Router.HandleFunc("/organizations/guests/{uuid}", orgs.GuestToOrganization).Methods(http.MethodPost)
-
-	h.Router.HandleFunc("/organizations/{id}/plugins", au.IsAuthenticated(orgs.AddOrganizationPlugin)).Methods("POST")
-	h.Router.HandleFunc("/organizations/{id}/plugins", au.IsAuthenticated(orgs.GetOrganizationPlugins)).Methods("GET")
-	h.Router.HandleFunc("/organizations/{id}/plugins/{plugin_id}", au.IsAuthenticated(orgs.GetOrganizationPlugin)).Methods("GET")
-	h.Router.HandleFunc("/organizations/{id}/plugins/{plugin_id}", au.IsAuthenticated(orgs.RemoveOrganizationPlugin)).Methods("DELETE")
-
-	h.Router.HandleFunc("/organizations/{id}/members", au.IsAuthenticated(au.IsAuthorized(orgs.CreateMember, "admin"))).Methods("POST")
-	h.Router.HandleFunc("/organizations/{id}/members", orgs.GetMembers).Methods("GET")
-	h.Router.HandleFunc("/organizations/{id}/members/multiple", au.IsAuthenticated(orgs.GetmultipleMembers)).Methods("GET")
-	h.Router.HandleFunc("/organizations/{id}/members/{mem_id}", au.IsAuthenticated(orgs.GetMember)).Methods("GET")
-	h.Router.HandleFunc("/organizations/{id}/members/{mem_id}", au.IsAuthenticated(au.IsAuthorized(orgs.DeactivateMember, "admin"))).Methods("DELETE")
-	h.Router.HandleFunc("/organizations/{id}/members/{mem_id}/reactivate", au.IsAuthenticated(au.IsAuthorized(orgs.ReactivateMember, "admin"))).Methods("POST")
-
-	h.Router.HandleFunc("/organizations/{id}/members/{mem_id}/status", au.IsAuthenticated(orgs.UpdateMemberStatus)).Methods("PATCH")
-	h.Router.HandleFunc("/organizations/{id}/members/{mem_id}/status/remove-history/{history_index}", au.IsAuthenticated(orgs.RemoveStatusHistory)).Methods("PATCH")
-	h.Router.HandleFunc("/organizations/{id}/members/{mem_id}/photo/{action}", au.IsAuthenticated(orgs.UpdateProfilePicture)).Methods("PATCH")
-	h.Router.HandleFunc("/organizations/{id}/members/{mem_id}/profile", au.IsAuthenticated(orgs.UpdateProfile)).Methods("PATCH")
-	h.Router.HandleFunc("/organizations/{id}/members/{mem_id}/uploadfile", au.IsAuthenticated(orgs.UploadFile)).Methods("POST")
-	h.Router.HandleFunc("/organizations/{id}/members/{mem_id}/presence", au.IsAuthenticated(orgs.TogglePresence)).Methods("POST")
-	h.Router.HandleFunc("/organizations/{id}/members/{mem_id}/settings", au.IsAuthenticated(orgs.UpdateMemberSettings)).Methods("PATCH")
-	h.Router.HandleFunc("/organizations/{id}/members/{mem_id}/role", au.IsAuthenticated(au.IsAuthorized(orgs.UpdateMemberRole, "admin"))).Methods("PATCH")
-	h.Router.HandleFunc("/organizations/{id}/members/{mem_id}/settings/notification", au.IsAuthenticated(orgs.UpdateNotification)).Methods("PATCH")
-	h.Router.HandleFunc("/organizations/{id}/members/{mem_id}/settings/theme", au.IsAuthenticated(orgs.UpdateUserTheme)).Methods("PATCH")
-	h.Router.HandleFunc("/organizations/{id}/members/{mem_id}/settings/message-media", au.IsAuthenticated(orgs.UpdateMemberMessageAndMediaSettings)).Methods("PATCH")
-	h.Router.HandleFunc("/organizations/{id}/members/{mem_id}/settings/accessibility", au.IsAuthenticated(orgs.UpdateMemberAccessibilitySettings)).Methods("PATCH")
-	h.Router.HandleFunc("/organizations/{id}/members/{mem_id}/settings/languages-and-region", au.IsAuthenticated(orgs.UpdateLanguagesAndRegions)).Methods("PATCH")
-	h.Router.HandleFunc("/organizations/{id}/members/{mem_id}/settings/advanced", au.IsAuthenticated(orgs.UpdateMemberAdvancedSettings)).Methods("PATCH")
-
-	h.Router.HandleFunc("/organizations/{id}/reports", au.IsAuthenticated(reps.AddReport)).Methods("POST")
-	h.Router.HandleFunc("/organizations/{id}/reports", au.IsAuthenticated(reps.GetReports)).Methods("GET")
-	h.Router.HandleFunc("/organizations/{id}/reports/{report_id}", au.IsAuthenticated(reps.GetReport)).Methods("GET")
-
-	h.Router.HandleFunc("/organizations/{id}/billing/settings", au.IsAuthenticated(orgs.UpdateBillingSettings)).Methods("PATCH")
-	h.Router.HandleFunc("/organizations/{id}/billing/contact", au.IsAuthenticated(orgs.UpdateBillingContact)).Methods("PATCH")
+	h.Router.HandleFunc("/organizations/{id}/invite-links", au.IsAuthenticated(au.RequirePermission(orgs.CreateInviteLink, auth.PermMembersInvite))).Methods(http.MethodPost)
+	h.Router.HandleFunc("/organizations/{id}/invite-links", au.IsAuthenticated(au.RequirePermission(orgs.GetInviteLinks, auth.PermMembersInvite))).Methods(http.MethodGet)
+	h.Router.HandleFunc("/organizations/{id}/invite-links/{link_id}", au.IsAuthenticated(au.RequirePermission(orgs.RevokeInviteLink, auth.PermMembersInvite))).Methods(http.MethodDelete)
+	h.Router.HandleFunc("/organizations/invite-links/{token}/join", utils.Throttle(au.IsAuthenticated(orgs.JoinWithInviteLink))).Methods(http.MethodPost)
+	h.Router.HandleFunc("/organizations/{id}/join", au.IsAuthenticated(orgs.JoinByEmailDomain)).Methods(http.MethodPost)
+	h.Router.HandleFunc("/account/joinable-organizations", au.IsAuthenticated(orgs.GetJoinableOrganizations)).Methods(http.MethodGet)
+
+	h.Router.HandleFunc("/organizations/{id}/plugins", au.IsAuthenticated(au.RequirePermission(orgs.AddOrganizationPlugin, auth.PermPluginsInstall))).Methods("POST")
+	h.Router.HandleFunc("/organizations/{id}/plugins", au.IsAuthenticated(au.RequirePermission(orgs.GetOrganizationPlugins, auth.PermPluginsRead))).Methods("GET")
+	h.Router.HandleFunc("/organizations/{id}/plugins/{plugin_id}", au.IsAuthenticated(au.RequirePermission(orgs.GetOrganizationPlugin, auth.PermPluginsRead))).Methods("GET")
+	h.Router.HandleFunc("/organizations/{id}/plugins/{plugin_id}", au.IsAuthenticated(au.RequirePermission(orgs.RemoveOrganizationPlugin, auth.PermPluginsInstall))).Methods("DELETE")
+
+	h.Router.HandleFunc("/organizations/{id}/groups", au.IsAuthenticated(au.RequirePermission(orgs.CreateUserGroup, auth.PermGroupsManage))).Methods(http.MethodPost)
+	h.Router.HandleFunc("/organizations/{id}/groups", au.IsAuthenticated(au.RequirePermission(orgs.GetUserGroups, auth.PermMembersRead))).Methods(http.MethodGet)
+	h.Router.HandleFunc("/organizations/{id}/groups/handle/{handle}", au.IsAuthenticated(au.RequirePermission(orgs.ResolveUserGroup, auth.PermMembersRead))).Methods(http.MethodGet)
+	h.Router.HandleFunc("/organizations/{id}/groups/{group_id}", au.IsAuthenticated(au.RequirePermission(orgs.GetUserGroup, auth.PermMembersRead))).Methods(http.MethodGet)
+	h.Router.HandleFunc("/organizations/{id}/groups/{group_id}", au.IsAuthenticated(au.RequirePermission(orgs.UpdateUserGroup, auth.PermGroupsManage))).Methods(http.MethodPatch)
+	h.Router.HandleFunc("/organizations/{id}/groups/{group_id}/archive", au.IsAuthenticated(au.RequirePermission(orgs.ArchiveUserGroup, auth.PermGroupsManage))).Methods(http.MethodPost)
+	h.Router.HandleFunc("/organizations/{id}/groups/{group_id}/unarchive", au.IsAuthenticated(au.RequirePermission(orgs.UnarchiveUserGroup, auth.PermGroupsManage))).Methods(http.MethodPost)
+	h.Router.HandleFunc("/organizations/{id}/groups/{group_id}/managers", au.IsAuthenticated(au.RequirePermission(orgs.SetUserGroupManagers, auth.PermGroupsManage))).Methods(http.MethodPut)
+	h.Router.HandleFunc("/organizations/{id}/groups/{group_id}/members", au.IsAuthenticated(au.RequirePermission(orgs.AddUserGroupMembers, auth.PermMembersRead))).Methods(http.MethodPost)
+	h.Router.HandleFunc("/organizations/{id}/groups/{group_id}/members/{mem_id}", au.IsAuthenticated(au.RequirePermission(orgs.RemoveUserGroupMember, auth.PermMembersRead))).Methods(http.MethodDelete)
+
+	h.Router.HandleFunc("/organizations/{id}/members", au.IsAuthenticated(au.RequirePermission(orgs.CreateMember, auth.PermMembersInvite))).Methods("POST")
+	h.Router.HandleFunc("/organizations/{id}/members", au.IsAuthenticated(au.RequirePermission(orgs.GetMembers, auth.PermMembersRead))).Methods("GET")
+	h.Router.HandleFunc("/organizations/{id}/members/multiple", au.IsAuthenticated(au.RequirePermission(orgs.GetmultipleMembers, auth.PermMembersRead))).Methods("GET")
+	h.Router.HandleFunc("/organizations/{id}/members/search", au.IsAuthenticated(au.RequirePermission(orgs.SearchMembers, auth.PermMembersRead))).Methods(http.MethodGet)
+	h.Router.HandleFunc("/organizations/{id}/members/import", au.IsAuthenticated(au.RequirePermission(orgs.ImportMembers, auth.PermMembersInvite))).Methods(http.MethodPost)
+	h.Router.HandleFunc("/organizations/{id}/members/imports/{import_id}", au.IsAuthenticated(au.RequirePermission(orgs.GetMemberImport, auth.PermMembersInvite))).Methods(http.MethodGet)
+	h.Router.HandleFunc("/organizations/{id}/members/imports/{import_id}/results", au.IsAuthenticated(au.RequirePermission(orgs.DownloadMemberImportResults, auth.PermMembersInvite))).Methods(http.MethodGet)
+	h.Router.HandleFunc("/organizations/{id}/members/export", au.IsAuthenticated(au.RequirePermission(orgs.ExportMembers, auth.PermMembersManage))).Methods(http.MethodGet)
+	h.Router.HandleFunc("/organizations/{id}/members/{mem_id}", au.IsAuthenticated(au.RequirePermission(orgs.GetMember, auth.PermMembersRead))).Methods("GET")
+	h.Router.HandleFunc("/organizations/{id}/members/{mem_id}", au.IsAuthenticated(au.RequirePermission(orgs.DeactivateMember, auth.PermMembersManage))).Methods("DELETE")
+	h.Router.HandleFunc("/organizations/{id}/members/{mem_id}/reactivate", au.IsAuthenticated(au.RequirePermission(orgs.ReactivateMember, auth.PermMembersManage))).Methods("POST")
+
+	h.Router.HandleFunc("/organizations/{id}/members/{mem_id}/status", au.IsAuthenticated(au.RequirePermission(orgs.UpdateMemberStatus, auth.PermProfileWrite))).Methods("PATCH")
+	h.Router.HandleFunc("/organizations/{id}/members/{mem_id}/status/remove-history/{history_index}", au.IsAuthenticated(au.RequirePermission(orgs.RemoveStatusHistory, auth.PermProfileWrite))).Methods("PATCH")
+	h.Router.HandleFunc("/organizations/{id}/members/{mem_id}/photo/{action}", au.IsAuthenticated(au.RequirePermission(orgs.UpdateProfilePicture, auth.PermProfileWrite))).Methods("PATCH")
+	h.Router.HandleFunc("/organizations/{id}/members/{mem_id}/profile", au.IsAuthenticated(au.RequirePermission(orgs.UpdateProfile, auth.PermProfileWrite))).Methods("PATCH")
+	h.Router.HandleFunc("/organizations/{id}/members/{mem_id}/uploadfile", au.IsAuthenticated(au.RequirePermission(orgs.UploadFile, auth.PermProfileWrite))).Methods("POST")
+	h.Router.HandleFunc("/organizations/{id}/members/{mem_id}/presence", au.IsAuthenticated(au.RequirePermission(orgs.TogglePresence, auth.PermProfileWrite))).Methods("POST")
+	h.Router.HandleFunc("/organizations/{id}/members/{mem_id}/settings", au.IsAuthenticated(au.RequirePermission(orgs.UpdateMemberSettings, auth.PermProfileWrite))).Methods("PATCH")
+	h.Router.HandleFunc("/organizations/{id}/members/{mem_id}/role", au.IsAuthenticated(au.RequirePermission(orgs.UpdateMemberRole, auth.PermRolesManage))).Methods("PATCH")
+	h.Router.HandleFunc("/organizations/{id}/members/{mem_id}/settings/notification", au.IsAuthenticated(au.RequirePermission(orgs.UpdateNotification, auth.PermProfileWrite))).Methods("PATCH")
+	h.Router.HandleFunc("/organizations/{id}/members/{mem_id}/settings/theme", au.IsAuthenticated(au.RequirePermission(orgs.UpdateUserTheme, auth.PermProfileWrite))).Methods("PATCH")
+	h.Router.HandleFunc("/organizations/{id}/members/{mem_id}/settings/message-media", au.IsAuthenticated(au.RequirePermission(orgs.UpdateMemberMessageAndMediaSettings, auth.PermProfileWrite))).Methods("PATCH")
+	h.Router.HandleFunc("/organizations/{id}/members/{mem_id}/settings/accessibility", au.IsAuthenticated(au.RequirePermission(orgs.UpdateMemberAccessibilitySettings, auth.PermProfileWrite))).Methods("PATCH")
+	h.Router.HandleFunc("/organizations/{id}/members/{mem_id}/settings/languages-and-region", au.IsAuthenticated(au.RequirePermission(orgs.UpdateLanguagesAndRegions, auth.PermProfileWrite))).Methods("PATCH")
+	h.Router.HandleFunc("/organizations/{id}/members/{mem_id}/settings/advanced", au.IsAuthenticated(au.RequirePermission(orgs.UpdateMemberAdvancedSettings, auth.PermProfileWrite))).Methods("PATCH")
+
+	h.Router.HandleFunc("/organizations/{id}/reports", au.IsAuthenticated(au.RequirePermission(reps.AddReport, auth.PermReportsWrite))).Methods("POST")
+	h.Router.HandleFunc("/organizations/{id}/reports", au.IsAuthenticated(au.RequirePermission(reps.GetReports, auth.PermReportsRead))).Methods("GET")
+	h.Router.HandleFunc("/organizations/{id}/reports/{report_id}", au.IsAuthenticated(au.RequirePermission(reps.GetReport, auth.PermReportsRead))).Methods("GET")
+
+	h.Router.HandleFunc("/organizations/{id}/billing/settings", au.IsAuthenticated(au.RequirePermission(orgs.UpdateBillingSettings, auth.PermBillingManage))).Methods("PATCH")
+	h.Router.HandleFunc("/organizations/{id}/billing/contact", au.IsAuthenticated(au.RequirePermission(orgs.UpdateBillingContact, auth.PermBillingManage))).Methods("PATCH")
 
 	//organization: payment
-	h.Router.HandleFunc("/organizations/{id}/add-token", au.IsAuthenticated(orgs.AddToken)).Methods("POST")
-	h.Router.HandleFunc("/organizations/{id}/token-transactions", au.IsAuthenticated(orgs.GetTokenTransaction)).Methods("GET")
-	h.Router.HandleFunc("/organizations/{id}/upgrade-to-pro", au.IsAuthenticated(orgs.UpgradeToPro)).Methods("POST")
-	h.Router.HandleFunc("/organizations/{id}/charge-tokens", au.IsAuthenticated(orgs.ChargeTokens)).Methods("POST")
-	h.Router.HandleFunc("/organizations/{id}/checkout-session", au.IsAuthenticated(orgs.CreateCheckoutSession)).Methods("POST")
-	h.Router.HandleFunc("/organizations/{id}/members/{mem_id}/cards", au.IsAuthenticated(orgs.AddCard)).Methods("POST")
-	h.Router.HandleFunc("/organizations/{id}/members/{mem_id}/cards/{card_id}", au.IsAuthenticated(orgs.DeleteCard)).Methods("DELETE")
-
-	// Data
-	h.Router.HandleFunc("/data/write", data.WriteData)
-	h.Router.HandleFunc("/data/read", data.NewRead).Methods("POST")
-	h.Router.HandleFunc("/data/read/{plugin_id}/{coll_name}/{org_id}", data.ReadData).Methods("GET")
-	h.Router.HandleFunc("/data/delete", data.DeleteData).Methods("POST")
-	h.Router.HandleFunc("/data/collections/info/{plugin_id}/{coll_name}/{org_id}", data.CollectionDetail).Methods("GET")
-
-	// Plugins
-	h.Router.HandleFunc("/plugins/register", ph.Register).Methods("POST")
-	h.Router.HandleFunc("/plugins/{id}", ph.Update).Methods("PATCH")
-	h.Router.HandleFunc("/plugins/{id}", ph.Delete).Methods("DELETE")
-	h.Router.HandleFunc("/plugins/{id}/sync", plugin.SyncUpdate).Methods("PATCH")
+	h.Router.HandleFunc("/organizations/{id}/add-token", au.IsAuthenticated(au.RequirePermission(orgs.AddToken, auth.PermBillingManage))).Methods("POST")
+	h.Router.HandleFunc("/organizations/{id}/token-transactions", au.IsAuthenticated(au.RequirePermission(orgs.GetTokenTransaction, auth.PermBillingRead))).Methods("GET")
+	h.Router.HandleFunc("/organizations/{id}/upgrade-to-pro", au.IsAuthenticated(au.RequirePermission(orgs.UpgradeToPro, auth.PermBillingManage))).Methods("POST")
+	h.Router.HandleFunc("/organizations/{id}/charge-tokens", au.IsAuthenticated(au.RequirePermission(orgs.ChargeTokens, auth.PermBillingManage))).Methods("POST")
+	h.Router.HandleFunc("/organizations/{id}/checkout-session", au.IsAuthenticated(au.RequirePermission(orgs.CreateCheckoutSession, auth.PermBillingManage))).Methods("POST")
+	h.Router.HandleFunc("/organizations/{id}/members/{mem_id}/cards", au.IsAuthenticated(au.RequirePermission(orgs.AddCard, auth.PermBillingManage))).Methods("POST")
+	h.Router.HandleFunc("/organizations/{id}/members/{mem_id}/cards/{card_id}", au.IsAuthenticated(au.RequirePermission(orgs.DeleteCard, auth.PermBillingManage))).Methods("DELETE")
+
+	// Data, plugins read and write an organization's data with a member's session or
+	// token, or an API key of that organization
+	h.Router.HandleFunc("/data/write", au.IsAuthenticated(au.RequireDataPermission(data.WriteData, auth.PermPluginDataWrite)))
+	h.Router.HandleFunc("/data/read", au.IsAuthenticated(au.RequireDataPermission(data.NewRead, auth.PermPluginDataRead))).Methods("POST")
+	h.Router.HandleFunc("/data/read/{plugin_id}/{coll_name}/{org_id}", au.IsAuthenticated(au.RequireDataPermission(data.ReadData, auth.PermPluginDataRead))).Methods("GET")
+	h.Router.HandleFunc("/data/delete", au.IsAuthenticated(au.RequireDataPermission(data.DeleteData, auth.PermPluginDataWrite))).Methods("POST")
+	h.Router.HandleFunc("/data/collections/info/{plugin_id}/{coll_name}/{org_id}", au.IsAuthenticated(au.RequireDataPermission(data.CollectionDetail, auth.PermPluginDataRead))).Methods("GET")
+
+	// Plugins are not organization scoped: any signed in developer can register one, and
+	// only its developer can change, sync or remove it
+	h.Router.HandleFunc("/plugins/register", au.IsAuthenticated(ph.Register)).Methods("POST")
+	h.Router.HandleFunc("/plugins/{id}", au.IsAuthenticated(au.RequirePluginDeveloper(ph.Update))).Methods("PATCH")
+	h.Router.HandleFunc("/plugins/{id}", au.IsAuthenticated(au.RequirePluginDeveloper(ph.Delete))).Methods("DELETE")
+	h.Router.HandleFunc("/plugins/{id}/sync", au.IsAuthenticated(au.RequirePluginDeveloper(plugin.SyncUpdate))).Methods("PATCH")
 
 	// Marketplace
 	h.Router.HandleFunc("/marketplace/plugins", marketplace.GetAllPlugins).Methods("GET")
 	h.Router.HandleFunc("/marketplace/plugins/popular", marketplace.GetPopularPlugins).Methods("GET")
 	h.Router.HandleFunc("/marketplace/plugins/recommended", marketplace.GetRecomendedPlugins).Methods("GET")
+	h.Router.HandleFunc("/marketplace/plugins/featured", marketplace.GetFeaturedPlugins).Methods("GET")
 	h.Router.HandleFunc("/marketplace/plugins/search", marketplace.Search).Methods("GET")
 	h.Router.HandleFunc("/marketplace/plugins/{id}", marketplace.GetPlugin).Methods("GET")
 	h.Router.HandleFunc("/marketplace/plugins/urls/url", marketplace.GetPluginByURL).Methods("GET")
-	h.Router.HandleFunc("/marketplace/plugins/{id}", marketplace.RemovePlugin).Methods("DELETE")
+	h.Router.HandleFunc("/marketplace/plugins/{id}", au.IsAuthenticated(au.IsAuthorized(mh.DelistPlugin, "zuri_admin"))).Methods("DELETE")
+	h.Router.HandleFunc("/marketplace/plugins/{id}/feature", au.IsAuthenticated(au.IsAuthorized(mh.FeaturePlugin, "zuri_admin"))).Methods("PUT")
+	h.Router.HandleFunc("/marketplace/plugins/{id}/feature", au.IsAuthenticated(au.IsAuthorized(mh.UnfeaturePlugin, "zuri_admin"))).Methods("DELETE")
+
+	h.Router.HandleFunc("/marketplace/collections", marketplace.GetCollections).Methods("GET")
+	h.Router.HandleFunc("/marketplace/collections", au.IsAuthenticated(au.IsAuthorized(mh.CreateCollection, "zuri_admin"))).Methods("POST")
+	h.Router.HandleFunc("/marketplace/collections/{slug}", marketplace.GetCollection).Methods("GET")
+	h.Router.HandleFunc("/marketplace/collections/{collection_id}", au.IsAuthenticated(au.IsAuthorized(mh.UpdateCollection, "zuri_admin"))).Methods("PATCH")
+	h.Router.HandleFunc("/marketplace/collections/{collection_id}", au.IsAuthenticated(au.IsAuthorized(mh.DeleteCollection, "zuri_admin"))).Methods("DELETE")
 
 	// Users
 	h.Router.HandleFunc("/users", us.Create).Methods("POST")
 	h.Router.HandleFunc("/users/{user_id}", au.IsAuthenticated(au.IsAuthorized(us.UpdateUser, "zuri_admin"))).Methods("PATCH")
 	h.Router.HandleFunc("/users/{user_id}", au.IsAuthenticated(au.IsAuthorized(us.GetUser, "zuri_admin"))).Methods("GET")
 	h.Router.HandleFunc("/users/{user_id}", au.IsAuthenticated(au.IsAuthorized(us.DeleteUser, "zuri_admin"))).Methods("DELETE")
+	h.Router.HandleFunc("/users/{user_id}/unlock", au.IsAuthenticated(au.IsAuthorized(au.UnlockAccount, "zuri_admin"))).Methods("POST")
+	h.Router.HandleFunc("/users/{user_id}/suspend", au.IsAuthenticated(au.IsAuthorized(au.SuspendUser, "zuri_admin"))).Methods("POST")
+	h.Router.HandleFunc("/users/{user_id}/unsuspend", au.IsAuthenticated(au.IsAuthorized(au.UnsuspendUser, "zuri_admin"))).Methods("POST")
 	h.Router.HandleFunc("/users", au.IsAuthenticated(au.IsAuthorized(us.GetUsers, "zuri_admin"))).Methods("GET")
 	h.Router.HandleFunc("/users/{email}/organizations", au.IsAuthenticated(us.GetUserOrganizations)).Methods("GET")
 
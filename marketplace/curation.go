@@ -0,0 +1,409 @@
+package marketplace
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"zuri.chat/zccore/auth"
+	"zuri.chat/zccore/logger"
+	"zuri.chat/zccore/plugin"
+	"zuri.chat/zccore/service"
+	"zuri.chat/zccore/utils"
+)
+
+var (
+	validate              = validator.New()
+	ErrPluginNotApproved  = errors.New("only approved plugins can be featured")
+	ErrCollectionNotFound = errors.New("collection not found")
+	ErrCollectionExists   = errors.New("a collection with this name already exists")
+)
+
+// FeaturePlugin marks an approved plugin as featured at the given position.
+func (mh *Handler) FeaturePlugin(w http.ResponseWriter, r *http.Request) {
+	pluginID := mux.Vars(r)["id"]
+
+	var req FeatureRequest
+	if err := utils.ParseJSONFromRequest(r, &req); err != nil {
+		utils.GetError(err, http.StatusUnprocessableEntity, w)
+		return
+	}
+
+	if err := validate.Struct(req); err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	p, err := plugin.FindPluginByID(r.Context(), pluginID)
+	if err != nil {
+		utils.GetError(errors.New("plugin does not exist"), http.StatusNotFound, w)
+		return
+	}
+
+	if !p.Approved {
+		utils.GetError(ErrPluginNotApproved, http.StatusBadRequest, w)
+		return
+	}
+
+	update := bson.M{"featured": true, "featured_order": req.Order}
+	if _, err = utils.UpdateOneMongoDBDoc(plugin.PluginCollectionName, pluginID, update); err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	utils.GetSuccess("plugin featured", utils.M{"plugin_id": pluginID, "featured_order": req.Order}, w)
+}
+
+// UnfeaturePlugin removes a plugin from the featured list.
+func (mh *Handler) UnfeaturePlugin(w http.ResponseWriter, r *http.Request) {
+	pluginID := mux.Vars(r)["id"]
+
+	if _, err := plugin.FindPluginByID(r.Context(), pluginID); err != nil {
+		utils.GetError(errors.New("plugin does not exist"), http.StatusNotFound, w)
+		return
+	}
+
+	update := bson.M{"featured": false, "featured_order": 0}
+	if _, err := utils.UpdateOneMongoDBDoc(plugin.PluginCollectionName, pluginID, update); err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	utils.GetSuccess("plugin unfeatured", nil, w)
+}
+
+// DelistPlugin removes a plugin from the marketplace and tells its developer why.
+func (mh *Handler) DelistPlugin(w http.ResponseWriter, r *http.Request) {
+	pluginID := mux.Vars(r)["id"]
+
+	var req DelistRequest
+	if err := utils.ParseJSONFromRequest(r, &req); err != nil {
+		utils.GetError(err, http.StatusUnprocessableEntity, w)
+		return
+	}
+
+	if err := validate.Struct(req); err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	p, err := plugin.FindPluginByID(r.Context(), pluginID)
+	if err != nil {
+		utils.GetError(errors.New("plugin does not exist"), http.StatusNotFound, w)
+		return
+	}
+
+	if p.Delisted {
+		utils.GetError(errors.New("plugin is already delisted"), http.StatusBadRequest, w)
+		return
+	}
+
+	update := bson.M{
+		"approved":       false,
+		"featured":       false,
+		"featured_order": 0,
+		"delisted":       true,
+		"delist_reason":  req.Reason,
+		"delisted_at":    time.Now().String(),
+	}
+
+	if _, err = utils.UpdateOneMongoDBDoc(plugin.PluginCollectionName, pluginID, update); err != nil {
+		utils.GetError(errors.New("plugin removal failed"), http.StatusInternalServerError, w)
+		return
+	}
+
+	// a delisted plugin should not linger in any curated collection
+	if _, err = utils.GetCollection(CollectionsCollectionName).UpdateMany(r.Context(),
+		bson.M{"plugin_ids": pluginID},
+		bson.M{"$pull": bson.M{"plugin_ids": pluginID}, "$set": bson.M{"updated_at": time.Now()}},
+	); err != nil {
+		logger.Error("could not remove plugin %s from collections: %v", pluginID, err)
+	}
+
+	msger := mh.mailService.NewMail(
+		[]string{p.DeveloperEmail}, "Your plugin has been delisted", service.PluginDelisted, map[string]interface{}{
+			"DeveloperName": p.DeveloperName,
+			"PluginName":    p.Name,
+			"Reason":        req.Reason,
+		})
+
+	if err := mh.mailService.SendMail(msger); err != nil {
+		logger.Error("Error occurred while sending mail: %s", err.Error())
+	}
+
+	utils.GetSuccess("plugin delisted", nil, w)
+}
+
+// GetFeaturedPlugins returns approved featured plugins in their curated order.
+func GetFeaturedPlugins(w http.ResponseWriter, r *http.Request) {
+	filter := bson.M{"approved": true, "featured": true}
+	sort := bson.D{primitive.E{Key: "featured_order", Value: 1}, primitive.E{Key: "install_count", Value: -1}}
+
+	ps, err := plugin.SortPlugins(r.Context(), filter, sort)
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	utils.GetSuccess("success", ps, w)
+}
+
+// CreateCollection creates a named collection of plugins.
+func (mh *Handler) CreateCollection(w http.ResponseWriter, r *http.Request) {
+	var req CollectionRequest
+	if err := utils.ParseJSONFromRequest(r, &req); err != nil {
+		utils.GetError(err, http.StatusUnprocessableEntity, w)
+		return
+	}
+
+	if req.Name == nil {
+		utils.GetError(errors.New("collection name is required"), http.StatusBadRequest, w)
+		return
+	}
+
+	slug := utils.Slugify(*req.Name)
+	if slug == "" {
+		utils.GetError(errors.New("collection name is required"), http.StatusBadRequest, w)
+		return
+	}
+
+	if doc, _ := utils.GetMongoDBDoc(CollectionsCollectionName, bson.M{"slug": slug}); doc != nil {
+		utils.GetError(ErrCollectionExists, http.StatusBadRequest, w)
+		return
+	}
+
+	pluginIDs, err := approvedPluginIDs(r, req.PluginIDs)
+	if err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	loggedInUser, _ := r.Context().Value("user").(*auth.AuthUser)
+
+	collection := Collection{
+		Name:      strings.TrimSpace(*req.Name),
+		Slug:      slug,
+		PluginIDs: pluginIDs,
+		CreatedBy: loggedInUser.Email,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+
+	if req.Description != nil {
+		collection.Description = *req.Description
+	}
+
+	res, err := utils.GetCollection(CollectionsCollectionName).InsertOne(r.Context(), collection)
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	collection.ID, _ = res.InsertedID.(primitive.ObjectID)
+
+	utils.GetSuccess("collection created", collection, w)
+}
+
+// UpdateCollection renames a collection or replaces its description or plugin list.
+func (mh *Handler) UpdateCollection(w http.ResponseWriter, r *http.Request) {
+	collectionID := mux.Vars(r)["collection_id"]
+
+	objID, err := primitive.ObjectIDFromHex(collectionID)
+	if err != nil {
+		utils.GetError(errors.New("invalid collection id"), http.StatusBadRequest, w)
+		return
+	}
+
+	var req CollectionRequest
+	if err = utils.ParseJSONFromRequest(r, &req); err != nil {
+		utils.GetError(err, http.StatusUnprocessableEntity, w)
+		return
+	}
+
+	if doc, _ := utils.GetMongoDBDoc(CollectionsCollectionName, bson.M{"_id": objID}); doc == nil {
+		utils.GetError(ErrCollectionNotFound, http.StatusNotFound, w)
+		return
+	}
+
+	update := bson.M{"updated_at": time.Now()}
+
+	if req.Name != nil {
+		slug := utils.Slugify(*req.Name)
+		if slug == "" {
+			utils.GetError(errors.New("collection name is required"), http.StatusBadRequest, w)
+			return
+		}
+
+		if doc, _ := utils.GetMongoDBDoc(CollectionsCollectionName, bson.M{"slug": slug, "_id": bson.M{"$ne": objID}}); doc != nil {
+			utils.GetError(ErrCollectionExists, http.StatusBadRequest, w)
+			return
+		}
+
+		update["name"], update["slug"] = strings.TrimSpace(*req.Name), slug
+	}
+
+	if req.Description != nil {
+		update["description"] = *req.Description
+	}
+
+	if req.PluginIDs != nil {
+		pluginIDs, err := approvedPluginIDs(r, req.PluginIDs)
+		if err != nil {
+			utils.GetError(err, http.StatusBadRequest, w)
+			return
+		}
+
+		update["plugin_ids"] = pluginIDs
+	}
+
+	if _, err = utils.UpdateOneMongoDBDoc(CollectionsCollectionName, collectionID, update); err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	utils.GetSuccess("collection updated", nil, w)
+}
+
+// DeleteCollection deletes a collection, the plugins in it are left untouched.
+func (mh *Handler) DeleteCollection(w http.ResponseWriter, r *http.Request) {
+	res, err := utils.DeleteOneMongoDBDoc(CollectionsCollectionName, mux.Vars(r)["collection_id"])
+	if err != nil {
+		utils.GetError(errors.New("invalid collection id"), http.StatusBadRequest, w)
+		return
+	}
+
+	if res.DeletedCount == 0 {
+		utils.GetError(ErrCollectionNotFound, http.StatusNotFound, w)
+		return
+	}
+
+	utils.GetSuccess("collection deleted", nil, w)
+}
+
+// GetCollections returns every collection with its approved plugins.
+func GetCollections(w http.ResponseWriter, r *http.Request) {
+	opts := options.Find().SetSort(bson.D{primitive.E{Key: "created_at", Value: -1}})
+
+	docs, err := utils.GetMongoDBDocs(CollectionsCollectionName, bson.M{}, opts)
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	collections := make([]utils.M, 0, len(docs))
+
+	for _, doc := range docs {
+		var c Collection
+		if err := utils.BsonToStruct(doc, &c); err != nil {
+			utils.GetError(err, http.StatusInternalServerError, w)
+			return
+		}
+
+		resolved, err := resolveCollection(r, &c)
+		if err != nil {
+			utils.GetError(err, http.StatusInternalServerError, w)
+			return
+		}
+
+		collections = append(collections, resolved)
+	}
+
+	utils.GetSuccess("success", collections, w)
+}
+
+// GetCollection returns a single collection, looked up by its slug.
+func GetCollection(w http.ResponseWriter, r *http.Request) {
+	slug := mux.Vars(r)["slug"]
+
+	doc, _ := utils.GetMongoDBDoc(CollectionsCollectionName, bson.M{"slug": slug})
+	if doc == nil {
+		utils.GetError(ErrCollectionNotFound, http.StatusNotFound, w)
+		return
+	}
+
+	var c Collection
+	if err := utils.BsonToStruct(doc, &c); err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	resolved, err := resolveCollection(r, &c)
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	utils.GetSuccess("success", resolved, w)
+}
+
+// resolveCollection replaces the collection's plugin ids with the approved plugins
+// they reference, keeping the curated order.
+func resolveCollection(r *http.Request, c *Collection) (utils.M, error) {
+	objIDs := make([]primitive.ObjectID, 0, len(c.PluginIDs))
+
+	for _, id := range c.PluginIDs {
+		if objID, err := primitive.ObjectIDFromHex(id); err == nil {
+			objIDs = append(objIDs, objID)
+		}
+	}
+
+	ps, err := plugin.FindPlugins(r.Context(), bson.M{"_id": bson.M{"$in": objIDs}, "approved": true})
+	if err != nil {
+		return nil, err
+	}
+
+	byID := make(map[string]*plugin.Plugin, len(ps))
+	for _, p := range ps {
+		byID[p.ID.Hex()] = p
+	}
+
+	ordered := make([]*plugin.Plugin, 0, len(ps))
+
+	for _, id := range c.PluginIDs {
+		if p, ok := byID[id]; ok {
+			ordered = append(ordered, p)
+		}
+	}
+
+	return utils.M{
+		"id":          c.ID,
+		"name":        c.Name,
+		"slug":        c.Slug,
+		"description": c.Description,
+		"plugins":     ordered,
+		"updated_at":  c.UpdatedAt,
+	}, nil
+}
+
+// approvedPluginIDs de-duplicates ids and checks that each one is an approved plugin.
+func approvedPluginIDs(r *http.Request, ids []string) ([]string, error) {
+	seen := make(map[string]bool, len(ids))
+	out := make([]string, 0, len(ids))
+
+	for _, id := range ids {
+		if seen[id] {
+			continue
+		}
+
+		p, err := plugin.FindPluginByID(r.Context(), id)
+		if err != nil {
+			return nil, fmt.Errorf("plugin %s does not exist", id)
+		}
+
+		if !p.Approved {
+			return nil, fmt.Errorf("plugin %s is not approved", id)
+		}
+
+		seen[id] = true
+		out = append(out, id)
+	}
+
+	return out, nil
+}
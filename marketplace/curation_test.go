@@ -0,0 +1,93 @@
+package marketplace
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestCreateCollectionName(t *testing.T) {
+	mh := NewMarketplaceHandler(nil, nil)
+
+	for _, body := range []string{`{}`, `{"name": ""}`, `{"name": "   "}`, `{"name": "!?#"}`} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/marketplace/collections", strings.NewReader(body))
+
+		mh.CreateCollection(w, r)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: got status %d, want %d", body, w.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestUpdateCollectionInvalidID(t *testing.T) {
+	mh := NewMarketplaceHandler(nil, nil)
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPatch, "/marketplace/collections/nope", strings.NewReader(`{"name": "tools"}`))
+	r = mux.SetURLVars(r, map[string]string{"collection_id": "nope"})
+
+	mh.UpdateCollection(w, r)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestFeaturePluginRequest(t *testing.T) {
+	mh := NewMarketplaceHandler(nil, nil)
+
+	tests := []struct {
+		name, id, body string
+		want           int
+	}{
+		{"malformed body", "6145d0b9285e4a184020742c", `{"order":`, http.StatusUnprocessableEntity},
+		{"negative order", "6145d0b9285e4a184020742c", `{"order": -1}`, http.StatusBadRequest},
+		{"invalid plugin id", "nope", `{"order": 1}`, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPut, "/marketplace/plugins/"+tt.id+"/feature", strings.NewReader(tt.body))
+			r = mux.SetURLVars(r, map[string]string{"id": tt.id})
+
+			mh.FeaturePlugin(w, r)
+
+			if w.Code != tt.want {
+				t.Errorf("got status %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestDelistPluginRequest(t *testing.T) {
+	mh := NewMarketplaceHandler(nil, nil)
+
+	tests := []struct {
+		name, id, body string
+		want           int
+	}{
+		{"malformed body", "6145d0b9285e4a184020742c", `{"reason":`, http.StatusUnprocessableEntity},
+		{"missing reason", "6145d0b9285e4a184020742c", `{}`, http.StatusBadRequest},
+		{"short reason", "6145d0b9285e4a184020742c", `{"reason": "spam"}`, http.StatusBadRequest},
+		{"invalid plugin id", "nope", `{"reason": "violates the marketplace policy"}`, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodDelete, "/marketplace/plugins/"+tt.id, strings.NewReader(tt.body))
+			r = mux.SetURLVars(r, map[string]string{"id": tt.id})
+
+			mh.DelistPlugin(w, r)
+
+			if w.Code != tt.want {
+				t.Errorf("got status %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package marketplace
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"zuri.chat/zccore/service"
+	"zuri.chat/zccore/utils"
+)
+
+const (
+	CollectionsCollectionName = "marketplace_collections"
+)
+
+// Collection is a named, ordered list of plugins curated by zuri admins,
+// e.g. "Productivity picks".
+type Collection struct {
+	ID          primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	Name        string             `json:"name" bson:"name"`
+	Slug        string             `json:"slug" bson:"slug"`
+	Description string             `json:"description" bson:"description"`
+	PluginIDs   []string           `json:"plugin_ids" bson:"plugin_ids"`
+	CreatedBy   string             `json:"created_by" bson:"created_by"`
+	CreatedAt   time.Time          `json:"created_at" bson:"created_at"`
+	UpdatedAt   time.Time          `json:"updated_at" bson:"updated_at"`
+}
+
+type CollectionRequest struct {
+	Name        *string  `json:"name"`
+	Description *string  `json:"description"`
+	PluginIDs   []string `json:"plugin_ids"`
+}
+
+type FeatureRequest struct {
+	Order int `json:"order" validate:"gte=0"`
+}
+
+type DelistRequest struct {
+	Reason string `json:"reason" validate:"required,min=10"`
+}
+
+// Handler holds the dependencies of the marketplace curation endpoints.
+type Handler struct {
+	configs     *utils.Configurations
+	mailService service.MailService
+}
+
+func NewMarketplaceHandler(c *utils.Configurations, mail service.MailService) *Handler {
+	return &Handler{configs: c, mailService: mail}
+}
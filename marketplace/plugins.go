@@ -82,34 +82,6 @@ func GetPluginByURL(w http.ResponseWriter, r *http.Request) {
 	utils.GetSuccess("success", p, w)
 }
 
-// RemovePlugin handles removal of plugins from marketplace.
-func RemovePlugin(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("content-type", "application/json")
-
-	pluginID := mux.Vars(r)["id"]
-
-	pluginExists, err := plugin.FindPluginByID(r.Context(), pluginID)
-
-	if err != nil {
-		utils.GetError(err, http.StatusNotFound, w)
-		return
-	}
-
-	if pluginExists == nil {
-		utils.GetError(errors.New("plugin does not exist"), http.StatusBadRequest, w)
-		return
-	}
-
-	update := bson.M{"approved": false}
-
-	if _, err = utils.UpdateOneMongoDBDoc(plugin.PluginCollectionName, pluginID, update); err != nil {
-		utils.GetError(errors.New("plugin removal failed"), http.StatusBadRequest, w)
-		return
-	}
-
-	utils.GetSuccess("plugin removed", nil, w)
-}
-
 // GetPopularPlugins returns all approved plugins available in the database by popularity.
 func GetPopularPlugins(w http.ResponseWriter, r *http.Request) {
 	ps, err := plugin.SortPlugins(r.Context(), bson.M{"approved": true}, bson.D{primitive.E{Key: "install_count", Value: -1}})
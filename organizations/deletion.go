@@ -0,0 +1,301 @@
+package organizations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"zuri.chat/zccore/audit"
+	"zuri.chat/zccore/auth"
+	"zuri.chat/zccore/logger"
+	"zuri.chat/zccore/service"
+	"zuri.chat/zccore/user"
+	"zuri.chat/zccore/utils"
+)
+
+// orgJobInterval is how often organizations past their deletion grace period are purged.
+const orgJobInterval = time.Hour
+
+var (
+	ErrOwnerOnly            = errors.New("only the owner of the organization can do this")
+	ErrOrgNameMismatch      = errors.New("type the name of the organization to confirm its deletion")
+	ErrOrgDeletionScheduled = errors.New("organization deletion is already scheduled")
+	ErrOrgDeletionNotFound  = errors.New("organization deletion is not scheduled")
+)
+
+// orgDependents maps the collections holding an organization's data onto the field
+// naming the organization. Plugin data is found by collection name, see purgePluginData.
+// The audit log is append only and outlives the organization, its deletion included.
+var orgDependents = map[string]string{
+	MemberCollectionName:             "org_id",
+	OrganizationInviteCollectionName: "org_id",
+	InviteLinkCollectionName:         "org_id",
+	MemberImportCollectionName:       "org_id",
+	UserGroupCollectionName:          "org_id",
+	OwnershipTransferCollectionName:  "org_id",
+	URLRedirectCollectionName:        "org_id",
+	TokenTransactionCollectionName:   "org_id",
+	CardCollectionName:               "org_id",
+	SAMLRequestCollectionName:        "org_id",
+	SAMLAssertionCollectionName:      "org_id",
+	auth.OrgRoleCollection:           "org_id",
+	auth.APITokenCollection:          "org_id",
+	"scim_tokens":                    "org_id",
+	user.ReportCollectionName:        "organization_id",
+}
+
+// orgUploadDirs returns the folders the upload service keeps the organization's logo,
+// member pictures and files in.
+func orgUploadDirs(orgID string) []string {
+	if _, err := primitive.ObjectIDFromHex(orgID); err != nil {
+		return nil
+	}
+
+	return []string{
+		filepath.Join("files", "logo", orgID),
+		filepath.Join("files", "profile_image", orgID),
+		filepath.Join("files", "fileupload", orgID),
+	}
+}
+
+// ownedOrg returns the organization in the request path, the logged in user must own it.
+func ownedOrg(w http.ResponseWriter, r *http.Request) (*Organization, bool) {
+	orgID := mux.Vars(r)["id"]
+
+	objID, err := primitive.ObjectIDFromHex(orgID)
+	if err != nil {
+		utils.GetError(errors.New("invalid id"), http.StatusBadRequest, w)
+		return nil, false
+	}
+
+	loggedInUser, _ := r.Context().Value("user").(*auth.AuthUser)
+	if loggedInUser == nil || loggedInUser.Role != OwnerRole {
+		utils.GetError(ErrOwnerOnly, http.StatusForbidden, w)
+		return nil, false
+	}
+
+	org := &Organization{}
+	if err := utils.GetCollection(OrganizationCollectionName).FindOne(r.Context(), bson.M{"_id": objID}).Decode(org); err != nil {
+		utils.GetError(fmt.Errorf("organization %s not found", orgID), http.StatusNotFound, w)
+		return nil, false
+	}
+
+	return org, true
+}
+
+func emitOrgEvent(orgID, eventName string, payload map[string]interface{}) {
+	eventChannel := fmt.Sprintf("organizations_%s", orgID)
+	event := utils.Event{Identifier: orgID, Type: "Organization", Event: eventName, Channel: eventChannel, Payload: payload}
+
+	go utils.Emitter(event)
+}
+
+// Delete an organization. The owner confirms with its name and their password, the
+// organization stays usable and its deletion can be cancelled during the grace period,
+// then all its data is purged.
+func (oh *OrganizationHandler) DeleteOrganization(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	org, ok := ownedOrg(w, r)
+	if !ok {
+		return
+	}
+
+	var req DeleteOrganizationRequest
+	if err := utils.ParseJSONFromRequest(r, &req); err != nil {
+		utils.GetError(err, http.StatusUnprocessableEntity, w)
+		return
+	}
+
+	if strings.TrimSpace(req.Name) != org.Name {
+		utils.GetError(ErrOrgNameMismatch, http.StatusBadRequest, w)
+		return
+	}
+
+	loggedInUser, _ := r.Context().Value("user").(*auth.AuthUser)
+
+	owner, err := auth.FetchUserByEmail(bson.M{"email": strings.ToLower(loggedInUser.Email)})
+	if err != nil {
+		utils.GetError(auth.ErrUserNotFound, http.StatusNotFound, w)
+		return
+	}
+
+	// accounts created through an identity provider may have no password
+	if owner.Password != "" && !auth.ComparePassword(req.Password, owner.Password) {
+		utils.GetError(auth.ErrCurrentPassword, http.StatusBadRequest, w)
+		return
+	}
+
+	if org.DeletionScheduledAt != nil {
+		utils.GetError(ErrOrgDeletionScheduled, http.StatusConflict, w)
+		return
+	}
+
+	deleteAt := time.Now().Add(time.Duration(oh.configs.OrgDeletionGracePeriod) * time.Second)
+
+	update := bson.M{"deletion_scheduled_at": deleteAt, "deletion_requested_by": owner.ID}
+	if _, err := utils.UpdateOneMongoDBDoc(OrganizationCollectionName, org.ID, update); err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	msger := oh.mailService.NewMail([]string{owner.Email}, fmt.Sprintf("%s will be deleted", org.Name), service.OrgDeletion, map[string]interface{}{
+		"FirstName":    owner.FirstName,
+		"OrgName":      org.Name,
+		"DeletionDate": deleteAt.UTC().Format("January 2, 2006"),
+	})
+
+	go func() {
+		if err := oh.mailService.SendMail(msger); err != nil {
+			logger.Error("Error occurred while sending mail: %s", err.Error())
+		}
+	}()
+
+	emitOrgEvent(org.ID, ScheduleOrganizationDeletion, map[string]interface{}{"deletion_scheduled_at": deleteAt})
+
+	audit.Record(r, audit.Event{OrgID: org.ID, Action: audit.ActionOrgDelete, TargetType: audit.TargetOrganization, TargetID: org.ID,
+		After: map[string]interface{}{"deletion_scheduled_at": deleteAt}})
+
+	utils.GetSuccess("organization deletion scheduled", utils.M{"deletion_scheduled_at": deleteAt}, w)
+}
+
+// Cancel the scheduled deletion of an organization.
+func (oh *OrganizationHandler) CancelOrganizationDeletion(w http.ResponseWriter, r *http.Request) {
+	org, ok := ownedOrg(w, r)
+	if !ok {
+		return
+	}
+
+	if org.DeletionScheduledAt == nil {
+		utils.GetError(ErrOrgDeletionNotFound, http.StatusBadRequest, w)
+		return
+	}
+
+	objID, _ := primitive.ObjectIDFromHex(org.ID)
+
+	update := bson.M{"$unset": bson.M{"deletion_scheduled_at": "", "deletion_requested_by": ""}}
+	if _, err := utils.GetCollection(OrganizationCollectionName).UpdateOne(r.Context(), bson.M{"_id": objID}, update); err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	emitOrgEvent(org.ID, CancelOrganizationDeletion, map[string]interface{}{})
+
+	audit.Record(r, audit.Event{OrgID: org.ID, Action: audit.ActionOrgDeleteCancel, TargetType: audit.TargetOrganization, TargetID: org.ID,
+		Before: map[string]interface{}{"deletion_scheduled_at": org.DeletionScheduledAt}})
+
+	utils.GetSuccess("organization deletion cancelled", nil, w)
+}
+
+// StartOrganizationJobs purges the organizations whose deletion grace period is over,
+// now and then every orgJobInterval until ctx is done.
+func (oh *OrganizationHandler) StartOrganizationJobs(ctx context.Context) {
+	ticker := time.NewTicker(orgJobInterval)
+	defer ticker.Stop()
+
+	for {
+		if utils.GetDefaultMongoClient() != nil {
+			purgeOrganizations(ctx)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func purgeOrganizations(ctx context.Context) {
+	cursor, err := utils.GetCollection(OrganizationCollectionName).Find(ctx, bson.M{"deletion_scheduled_at": bson.M{"$lte": time.Now()}})
+	if err != nil {
+		logger.Error("Error finding organizations to delete: %s", err.Error())
+		return
+	}
+
+	var orgs []Organization
+	if err := cursor.All(ctx, &orgs); err != nil {
+		logger.Error("Error finding organizations to delete: %s", err.Error())
+		return
+	}
+
+	for i := range orgs {
+		if err := purgeOrganization(ctx, &orgs[i]); err != nil {
+			logger.Error("Error deleting organization %s: %s", orgs[i].ID, err.Error())
+		}
+	}
+}
+
+// purgeOrganization tells the installed plugins, removes the organization's data from
+// every store and the organization itself last, so a purge that fails is tried again.
+func purgeOrganization(ctx context.Context, org *Organization) error {
+	plugins := make([]string, 0, len(org.Plugins))
+	for pluginID := range org.Plugins {
+		plugins = append(plugins, pluginID)
+	}
+
+	message := OrganizationMessage{OrganizationID: org.ID}
+	if err := AddToPluginsQueue(plugins, "delete_organization", message); err != nil {
+		log.Printf("sync error: %v", err)
+	} else if err := PingPlugins(plugins); err != nil {
+		log.Printf("sync error: %v", err)
+	}
+
+	if err := purgePluginData(ctx, org.ID); err != nil {
+		return err
+	}
+
+	for coll, field := range orgDependents {
+		if _, err := utils.GetCollection(coll).DeleteMany(ctx, bson.M{field: org.ID}); err != nil {
+			return err
+		}
+	}
+
+	for _, dir := range orgUploadDirs(org.ID) {
+		if err := os.RemoveAll(dir); err != nil {
+			return err
+		}
+	}
+
+	if _, err := utils.GetCollection(user.UserCollectionName).UpdateMany(ctx, bson.M{"workspaces": org.ID},
+		bson.M{"$pull": bson.M{"workspaces": org.ID}}); err != nil {
+		return err
+	}
+
+	objID, _ := primitive.ObjectIDFromHex(org.ID)
+	if _, err := utils.GetCollection(OrganizationCollectionName).DeleteOne(ctx, bson.M{"_id": objID}); err != nil {
+		return err
+	}
+
+	emitOrgEvent(org.ID, PurgeOrganization, map[string]interface{}{})
+
+	return nil
+}
+
+// purgePluginData removes the organization's documents from the collections plugins
+// keep their data in, named {plugin_id}__{collection}.
+func purgePluginData(ctx context.Context, orgID string) error {
+	db := utils.GetCollection(OrganizationCollectionName).Database()
+
+	names, err := db.ListCollectionNames(ctx, bson.M{"name": primitive.Regex{Pattern: "__"}})
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		if _, err := db.Collection(name).DeleteMany(ctx, bson.M{"organization_id": orgID}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
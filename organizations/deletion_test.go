@@ -0,0 +1,33 @@
+package organizations
+
+import (
+	"path/filepath"
+	"testing"
+
+	"zuri.chat/zccore/audit"
+)
+
+func TestOrgUploadDirs(t *testing.T) {
+	dirs := orgUploadDirs("6145d0b9285e4a184020742c")
+	if len(dirs) != 3 {
+		t.Fatalf("got %d upload dirs, want 3", len(dirs))
+	}
+
+	for _, dir := range dirs {
+		if filepath.Base(dir) != "6145d0b9285e4a184020742c" {
+			t.Errorf("%s is not a folder of the organization", dir)
+		}
+	}
+
+	for _, id := range []string{"", "..", "../6145d0b9285e4a184020742c", "6145d0b9285e4a18402074"} {
+		if dirs := orgUploadDirs(id); dirs != nil {
+			t.Errorf("expected no upload dirs for %q, got %v", id, dirs)
+		}
+	}
+}
+
+func TestOrgDependentsKeepAuditLog(t *testing.T) {
+	if _, ok := orgDependents[audit.Collection]; ok {
+		t.Error("purging an organization must not delete its audit log")
+	}
+}
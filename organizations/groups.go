@@ -0,0 +1,623 @@
+package organizations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"zuri.chat/zccore/audit"
+	"zuri.chat/zccore/auth"
+	"zuri.chat/zccore/utils"
+)
+
+var (
+	ErrGroupNotFound     = errors.New("user group not found")
+	ErrGroupHandle       = errors.New("group handles are 2 to 32 lowercase letters, digits, dots, dashes or underscores and start with a letter or digit")
+	ErrGroupHandleTaken  = errors.New("this handle is already used by a group or a member")
+	ErrGroupArchived     = errors.New("this user group is archived")
+	ErrGroupMembersOnly  = errors.New("groups can only hold active members of the organization")
+	ErrGroupManagersOnly = errors.New("only admins and managers of this group can change its members")
+	ErrNotGroupMember    = errors.New("member is not in this group")
+
+	groupHandlePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{1,31}$`)
+
+	// reservedHandles already mean something in a mention.
+	reservedHandles = map[string]bool{"all": true, "channel": true, "everyone": true, "here": true}
+)
+
+// normalizeGroupHandle lower cases a group handle, without its leading @, and checks it.
+func normalizeGroupHandle(handle string) (string, error) {
+	handle = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(handle), "@"))
+
+	if !groupHandlePattern.MatchString(handle) || reservedHandles[handle] {
+		return "", ErrGroupHandle
+	}
+
+	return handle, nil
+}
+
+// groupHandleFree reports whether no member of the organization goes by handle, a
+// mention must resolve to either a member or a group. Groups are kept apart by index.
+func groupHandleFree(ctx context.Context, orgID, handle string) bool {
+	userName := primitive.Regex{Pattern: "^" + regexp.QuoteMeta(handle) + "$", Options: "i"}
+	return utils.CountCollection(ctx, MemberCollectionName, bson.M{"org_id": orgID, "user_name": userName}) == 0
+}
+
+// groupMembers dedupes member ids and checks they are all active members of orgID.
+func groupMembers(ctx context.Context, orgID string, ids []string) ([]string, error) {
+	members := []string{}
+	objIDs := bson.A{}
+
+	for _, id := range ids {
+		id = strings.TrimSpace(id)
+		if contains(members, id) {
+			continue
+		}
+
+		objID, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a valid member id", id)
+		}
+
+		members = append(members, id)
+		objIDs = append(objIDs, objID)
+	}
+
+	if len(members) == 0 {
+		return members, nil
+	}
+
+	active := utils.CountCollection(ctx, MemberCollectionName,
+		bson.M{"_id": bson.M{"$in": objIDs}, "org_id": orgID, "deleted": bson.M{"$ne": true}})
+	if active != int64(len(members)) {
+		return nil, ErrGroupMembersOnly
+	}
+
+	return members, nil
+}
+
+// groupRole checks the role given to a group's members. Only members who manage roles
+// can give one, and not one holding more than they do.
+func groupRole(r *http.Request, orgID, role string) (string, error) {
+	loggedInUser, _ := r.Context().Value("user").(*auth.AuthUser)
+	if loggedInUser == nil || !auth.GrantsAll(loggedInUser.Permissions, []string{auth.PermRolesManage}) {
+		return "", auth.ErrPermissionDenied
+	}
+
+	role = strings.ToLower(strings.TrimSpace(role))
+	if role == "" {
+		return "", nil
+	}
+
+	if role == OwnerRole {
+		return "", errors.New("the owner role can't be given to a group")
+	}
+
+	perms, err := auth.RolePermissions(r.Context(), orgID, role)
+	if err != nil {
+		return "", err
+	}
+
+	if !auth.GrantsAll(loggedInUser.Permissions, perms) {
+		return "", auth.ErrRoleEscalation
+	}
+
+	return role, nil
+}
+
+// callerMemberID returns the member id of the logged in user in orgID.
+func callerMemberID(r *http.Request, orgID string) string {
+	loggedInUser, _ := r.Context().Value("user").(*auth.AuthUser)
+	if loggedInUser == nil {
+		return ""
+	}
+
+	var member struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+
+	filter := bson.M{"org_id": orgID, "email": strings.ToLower(loggedInUser.Email), "deleted": bson.M{"$ne": true}}
+	if err := utils.GetCollection(MemberCollectionName).FindOne(r.Context(), filter).Decode(&member); err != nil {
+		return ""
+	}
+
+	return member.ID.Hex()
+}
+
+// canManageGroupMembers checks the logged in user may change who is in g: they manage
+// groups or g, and hold the permissions of the role g gives.
+func canManageGroupMembers(r *http.Request, g *UserGroup) error {
+	loggedInUser, _ := r.Context().Value("user").(*auth.AuthUser)
+	if loggedInUser == nil {
+		return ErrGroupManagersOnly
+	}
+
+	if !auth.GrantsAll(loggedInUser.Permissions, []string{auth.PermGroupsManage}) && !contains(g.Managers, callerMemberID(r, g.OrgID)) {
+		return ErrGroupManagersOnly
+	}
+
+	if g.Role == "" {
+		return nil
+	}
+
+	perms, err := auth.RolePermissions(r.Context(), g.OrgID, g.Role)
+	if err != nil || !auth.GrantsAll(loggedInUser.Permissions, perms) {
+		return auth.ErrRoleEscalation
+	}
+
+	return nil
+}
+
+// FetchUserGroup returns the user group matching filter.
+func FetchUserGroup(ctx context.Context, filter bson.M) (*UserGroup, error) {
+	group := &UserGroup{}
+	if err := utils.GetCollection(UserGroupCollectionName).FindOne(ctx, filter).Decode(group); err != nil {
+		return nil, ErrGroupNotFound
+	}
+
+	return group, nil
+}
+
+// orgGroup returns the user group in the request path, it must belong to the organization.
+func orgGroup(w http.ResponseWriter, r *http.Request) (*UserGroup, bool) {
+	vars := mux.Vars(r)
+
+	groupID, err := primitive.ObjectIDFromHex(vars["group_id"])
+	if err != nil {
+		utils.GetError(errors.New("invalid group id"), http.StatusBadRequest, w)
+		return nil, false
+	}
+
+	group, err := FetchUserGroup(r.Context(), bson.M{"_id": groupID, "org_id": vars["id"]})
+	if err != nil {
+		utils.GetError(err, http.StatusNotFound, w)
+		return nil, false
+	}
+
+	return group, true
+}
+
+// activeOrgGroup is orgGroup for changes, which archived groups don't take.
+func activeOrgGroup(w http.ResponseWriter, r *http.Request) (*UserGroup, bool) {
+	group, ok := orgGroup(w, r)
+	if ok && group.Archived {
+		utils.GetError(ErrGroupArchived, http.StatusBadRequest, w)
+		return nil, false
+	}
+
+	return group, ok
+}
+
+// publishGroupChange tells subscribers of the organization and its plugins about a
+// change to a group, memberIDs are the members the change is about.
+func publishGroupChange(g *UserGroup, eventName, syncEvent string, memberIDs []string) {
+	eventChannel := fmt.Sprintf("organizations_%s", g.OrgID)
+	payload := map[string]interface{}{"handle": g.Handle, "member_ids": memberIDs}
+	event := utils.Event{Identifier: g.ID.Hex(), Type: "UserGroup", Event: eventName, Channel: eventChannel, Payload: payload}
+
+	go utils.Emitter(event)
+
+	message := UserGroupMessage{OrganizationID: g.OrgID, GroupID: g.ID.Hex(), Handle: g.Handle, MemberIDs: memberIDs}
+	if err := AddSyncMessage(g.OrgID, syncEvent, message); err != nil {
+		log.Printf("sync error: %v", err)
+	}
+}
+
+// Create a user group, its handle must not be taken by another group or a member.
+func (oh *OrganizationHandler) CreateUserGroup(w http.ResponseWriter, r *http.Request) {
+	orgID := mux.Vars(r)["id"]
+
+	var req CreateUserGroupRequest
+	if err := utils.ParseJSONFromRequest(r, &req); err != nil {
+		utils.GetError(err, http.StatusUnprocessableEntity, w)
+		return
+	}
+
+	if err := validator.New().Struct(req); err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	handle, err := normalizeGroupHandle(req.Handle)
+	if err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	if !groupHandleFree(r.Context(), orgID, handle) {
+		utils.GetError(ErrGroupHandleTaken, http.StatusConflict, w)
+		return
+	}
+
+	role := ""
+
+	if req.Role != "" {
+		if role, err = groupRole(r, orgID, req.Role); err != nil {
+			utils.GetError(err, http.StatusForbidden, w)
+			return
+		}
+	}
+
+	members, err := groupMembers(r.Context(), orgID, req.Members)
+	if err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	managers, err := groupMembers(r.Context(), orgID, req.Managers)
+	if err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	now := time.Now()
+	group := UserGroup{
+		OrgID:       orgID,
+		Handle:      handle,
+		Name:        strings.TrimSpace(req.Name),
+		Description: strings.TrimSpace(req.Description),
+		Role:        role,
+		Members:     members,
+		Managers:    managers,
+		CreatedBy:   callerMemberID(r, orgID),
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+
+	res, err := utils.GetCollection(UserGroupCollectionName).InsertOne(r.Context(), group)
+	if mongo.IsDuplicateKeyError(err) {
+		utils.GetError(ErrGroupHandleTaken, http.StatusConflict, w)
+		return
+	}
+
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	group.ID, _ = res.InsertedID.(primitive.ObjectID)
+
+	audit.Record(r, audit.Event{OrgID: orgID, Action: audit.ActionGroupCreate, TargetType: audit.TargetGroup, TargetID: group.ID.Hex(),
+		After: map[string]interface{}{"handle": handle, "role": role, "members": members, "managers": managers}})
+
+	publishGroupChange(&group, CreateOrganizationUserGroup, "user_group_created", members)
+
+	utils.GetSuccess("user group created", group, w)
+}
+
+// List the user groups of an organization by handle. Archived groups are left out
+// unless archived=true, member_id only lists the groups of a member.
+func (oh *OrganizationHandler) GetUserGroups(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := bson.M{"org_id": mux.Vars(r)["id"]}
+
+	if query.Get("archived") != "true" {
+		filter["archived"] = bson.M{"$ne": true}
+	}
+
+	if memberID := query.Get("member_id"); memberID != "" {
+		filter["members"] = memberID
+	}
+
+	cursor, err := utils.GetCollection(UserGroupCollectionName).Find(r.Context(), filter, options.Find().SetSort(bson.M{"handle": 1}))
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	groups := []UserGroup{}
+	if err := cursor.All(r.Context(), &groups); err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	utils.GetSuccess("user groups retrieved", groups, w)
+}
+
+// Get a user group of an organization.
+func (oh *OrganizationHandler) GetUserGroup(w http.ResponseWriter, r *http.Request) {
+	group, ok := orgGroup(w, r)
+	if !ok {
+		return
+	}
+
+	utils.GetSuccess("user group retrieved", group, w)
+}
+
+// Resolve a mention to the active user group with the handle, with or without its @.
+func (oh *OrganizationHandler) ResolveUserGroup(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	handle, err := normalizeGroupHandle(vars["handle"])
+	if err != nil {
+		utils.GetError(ErrGroupNotFound, http.StatusNotFound, w)
+		return
+	}
+
+	group, err := FetchUserGroup(r.Context(), bson.M{"org_id": vars["id"], "handle": handle, "archived": bson.M{"$ne": true}})
+	if err != nil {
+		utils.GetError(err, http.StatusNotFound, w)
+		return
+	}
+
+	utils.GetSuccess("user group retrieved", group, w)
+}
+
+// Rename a user group or change its description or role.
+func (oh *OrganizationHandler) UpdateUserGroup(w http.ResponseWriter, r *http.Request) {
+	before, ok := activeOrgGroup(w, r)
+	if !ok {
+		return
+	}
+
+	var req UpdateUserGroupRequest
+	if err := utils.ParseJSONFromRequest(r, &req); err != nil {
+		utils.GetError(err, http.StatusUnprocessableEntity, w)
+		return
+	}
+
+	if err := validator.New().Struct(req); err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	group := *before
+	group.UpdatedAt = time.Now()
+
+	if req.Handle != nil {
+		handle, err := normalizeGroupHandle(*req.Handle)
+		if err != nil {
+			utils.GetError(err, http.StatusBadRequest, w)
+			return
+		}
+
+		if handle != before.Handle && !groupHandleFree(r.Context(), group.OrgID, handle) {
+			utils.GetError(ErrGroupHandleTaken, http.StatusConflict, w)
+			return
+		}
+
+		group.Handle = handle
+	}
+
+	if req.Name != nil {
+		if group.Name = strings.TrimSpace(*req.Name); group.Name == "" {
+			utils.GetError(errors.New("name can't be empty"), http.StatusBadRequest, w)
+			return
+		}
+	}
+
+	if req.Description != nil {
+		group.Description = strings.TrimSpace(*req.Description)
+	}
+
+	if req.Role != nil && strings.ToLower(strings.TrimSpace(*req.Role)) != before.Role {
+		// taking away a role is as much out of reach as giving it
+		if before.Role != "" {
+			if _, err := groupRole(r, group.OrgID, before.Role); err != nil {
+				utils.GetError(err, http.StatusForbidden, w)
+				return
+			}
+		}
+
+		role, err := groupRole(r, group.OrgID, *req.Role)
+		if err != nil {
+			utils.GetError(err, http.StatusForbidden, w)
+			return
+		}
+
+		group.Role = role
+	}
+
+	update := bson.M{
+		"handle": group.Handle, "name": group.Name, "description": group.Description, "role": group.Role, "updated_at": group.UpdatedAt,
+	}
+
+	_, err := utils.GetCollection(UserGroupCollectionName).UpdateOne(r.Context(), bson.M{"_id": group.ID}, bson.M{"$set": update})
+	if mongo.IsDuplicateKeyError(err) {
+		utils.GetError(ErrGroupHandleTaken, http.StatusConflict, w)
+		return
+	}
+
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	from, to := audit.Diff(
+		bson.M{"handle": before.Handle, "name": before.Name, "description": before.Description, "role": before.Role},
+		bson.M{"handle": group.Handle, "name": group.Name, "description": group.Description, "role": group.Role},
+	)
+	audit.Record(r, audit.Event{OrgID: group.OrgID, Action: audit.ActionGroupUpdate, TargetType: audit.TargetGroup, TargetID: group.ID.Hex(), Before: from, After: to})
+
+	publishGroupChange(&group, UpdateOrganizationUserGroup, "user_group_updated", nil)
+
+	utils.GetSuccess("user group updated", group, w)
+}
+
+// Archive a user group. It keeps its handle and members, but can't be mentioned and
+// gives no role until it is unarchived.
+func (oh *OrganizationHandler) ArchiveUserGroup(w http.ResponseWriter, r *http.Request) {
+	oh.setUserGroupArchived(w, r, true)
+}
+
+// Unarchive a user group.
+func (oh *OrganizationHandler) UnarchiveUserGroup(w http.ResponseWriter, r *http.Request) {
+	oh.setUserGroupArchived(w, r, false)
+}
+
+func (oh *OrganizationHandler) setUserGroupArchived(w http.ResponseWriter, r *http.Request, archived bool) {
+	group, ok := orgGroup(w, r)
+	if !ok {
+		return
+	}
+
+	if group.Archived == archived {
+		utils.GetSuccess("user group unchanged", group, w)
+		return
+	}
+
+	// archiving takes the group's role away from its members and unarchiving gives it back
+	if group.Role != "" {
+		if _, err := groupRole(r, group.OrgID, group.Role); err != nil {
+			utils.GetError(err, http.StatusForbidden, w)
+			return
+		}
+	}
+
+	now := time.Now()
+	action, event, syncEvent := audit.ActionGroupUnarchive, UnarchiveOrganizationUserGroup, "user_group_unarchived"
+	group.Archived, group.ArchivedAt, group.UpdatedAt = archived, nil, now
+
+	if archived {
+		action, event, syncEvent = audit.ActionGroupArchive, ArchiveOrganizationUserGroup, "user_group_archived"
+		group.ArchivedAt = &now
+	}
+
+	update := bson.M{"archived": group.Archived, "archived_at": group.ArchivedAt, "updated_at": group.UpdatedAt}
+	if _, err := utils.GetCollection(UserGroupCollectionName).UpdateOne(r.Context(), bson.M{"_id": group.ID}, bson.M{"$set": update}); err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	audit.Record(r, audit.Event{OrgID: group.OrgID, Action: action, TargetType: audit.TargetGroup, TargetID: group.ID.Hex()})
+
+	publishGroupChange(group, event, syncEvent, group.Members)
+
+	utils.GetSuccess("user group updated", group, w)
+}
+
+// Replace the managers of a user group.
+func (oh *OrganizationHandler) SetUserGroupManagers(w http.ResponseWriter, r *http.Request) {
+	group, ok := activeOrgGroup(w, r)
+	if !ok {
+		return
+	}
+
+	var req UserGroupManagersRequest
+	if err := utils.ParseJSONFromRequest(r, &req); err != nil {
+		utils.GetError(err, http.StatusUnprocessableEntity, w)
+		return
+	}
+
+	managers, err := groupMembers(r.Context(), group.OrgID, req.Managers)
+	if err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	update := bson.M{"managers": managers, "updated_at": time.Now()}
+	if _, err := utils.GetCollection(UserGroupCollectionName).UpdateOne(r.Context(), bson.M{"_id": group.ID}, bson.M{"$set": update}); err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	audit.Record(r, audit.Event{OrgID: group.OrgID, Action: audit.ActionGroupManagers, TargetType: audit.TargetGroup, TargetID: group.ID.Hex(),
+		Before: map[string]interface{}{"managers": group.Managers}, After: map[string]interface{}{"managers": managers}})
+
+	group.Managers = managers
+
+	publishGroupChange(group, UpdateOrganizationUserGroup, "user_group_updated", nil)
+
+	utils.GetSuccess("user group managers updated", group, w)
+}
+
+// Add members to a user group.
+func (oh *OrganizationHandler) AddUserGroupMembers(w http.ResponseWriter, r *http.Request) {
+	group, ok := activeOrgGroup(w, r)
+	if !ok {
+		return
+	}
+
+	if err := canManageGroupMembers(r, group); err != nil {
+		utils.GetError(err, http.StatusForbidden, w)
+		return
+	}
+
+	var req UserGroupMembersRequest
+	if err := utils.ParseJSONFromRequest(r, &req); err != nil {
+		utils.GetError(err, http.StatusUnprocessableEntity, w)
+		return
+	}
+
+	if err := validator.New().Struct(req); err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	members, err := groupMembers(r.Context(), group.OrgID, req.MemberIDs)
+	if err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	added := []string{}
+
+	for _, id := range members {
+		if !contains(group.Members, id) {
+			added = append(added, id)
+		}
+	}
+
+	if len(added) > 0 {
+		update := bson.M{"$addToSet": bson.M{"members": bson.M{"$each": added}}, "$set": bson.M{"updated_at": time.Now()}}
+		if _, err := utils.GetCollection(UserGroupCollectionName).UpdateOne(r.Context(), bson.M{"_id": group.ID}, update); err != nil {
+			utils.GetError(err, http.StatusInternalServerError, w)
+			return
+		}
+
+		audit.Record(r, audit.Event{OrgID: group.OrgID, Action: audit.ActionGroupMembersAdd, TargetType: audit.TargetGroup, TargetID: group.ID.Hex(),
+			After: map[string]interface{}{"members": added}})
+
+		publishGroupChange(group, UpdateOrganizationUserGroupMembers, "user_group_members_added", added)
+	}
+
+	utils.GetSuccess("members added to user group", utils.M{"added": added}, w)
+}
+
+// Remove a member from a user group, members can always leave a group themselves.
+func (oh *OrganizationHandler) RemoveUserGroupMember(w http.ResponseWriter, r *http.Request) {
+	group, ok := activeOrgGroup(w, r)
+	if !ok {
+		return
+	}
+
+	memberID := mux.Vars(r)["mem_id"]
+
+	if memberID != callerMemberID(r, group.OrgID) {
+		if err := canManageGroupMembers(r, group); err != nil {
+			utils.GetError(err, http.StatusForbidden, w)
+			return
+		}
+	}
+
+	update := bson.M{"$pull": bson.M{"members": memberID}, "$set": bson.M{"updated_at": time.Now()}}
+
+	res, err := utils.GetCollection(UserGroupCollectionName).UpdateOne(r.Context(), bson.M{"_id": group.ID, "members": memberID}, update)
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	if res.MatchedCount == 0 {
+		utils.GetError(ErrNotGroupMember, http.StatusNotFound, w)
+		return
+	}
+
+	audit.Record(r, audit.Event{OrgID: group.OrgID, Action: audit.ActionGroupMemberRemove, TargetType: audit.TargetGroup, TargetID: group.ID.Hex(),
+		Before: map[string]interface{}{"members": []string{memberID}}})
+
+	publishGroupChange(group, UpdateOrganizationUserGroupMembers, "user_group_members_removed", []string{memberID})
+
+	utils.GetSuccess("member removed from user group", nil, w)
+}
@@ -0,0 +1,23 @@
+package organizations
+
+import "testing"
+
+func TestNormalizeGroupHandle(t *testing.T) {
+	for in, want := range map[string]string{"@Design": "design", " oncall ": "oncall", "qa.team-2": "qa.team-2", "9ers": "9ers"} {
+		got, err := normalizeGroupHandle(in)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", in, err)
+			continue
+		}
+
+		if got != want {
+			t.Errorf("%q: got %q, want %q", in, got, want)
+		}
+	}
+
+	for _, handle := range []string{"", "a", "@here", "everyone", "-design", "design team", "ops!", "abcdefghijklmnopqrstuvwxyz1234567"} {
+		if _, err := normalizeGroupHandle(handle); err == nil {
+			t.Errorf("expected %q to be rejected", handle)
+		}
+	}
+}
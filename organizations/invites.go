@@ -0,0 +1,269 @@
+package organizations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"zuri.chat/zccore/audit"
+	"zuri.chat/zccore/auth"
+	"zuri.chat/zccore/logger"
+	"zuri.chat/zccore/service"
+	"zuri.chat/zccore/utils"
+)
+
+var (
+	ErrInviteNotFound     = errors.New("invite not found")
+	ErrInviteAccepted     = errors.New("this invite has already been accepted")
+	ErrInviteRevoked      = errors.New("this invite has been revoked")
+	ErrInviteExpired      = errors.New("this invite has expired, ask for a new one")
+	ErrInviteResendLimit  = errors.New("this invite can't be sent again, revoke it and send a new one")
+	ErrInviteRoleNotValid = errors.New("role is not valid")
+)
+
+// State returns the status of the invite at now, expired once it is past its expiry.
+func (i *Invite) State(now time.Time) string {
+	status := i.Status
+
+	if status == "" {
+		status = InvitePending
+		if i.HasAccepted {
+			status = InviteAccepted
+		}
+	}
+
+	if status == InvitePending && i.ExpiresAt != nil && !i.ExpiresAt.After(now) {
+		return InviteExpired
+	}
+
+	return status
+}
+
+// inviteStatusFilter matches the invites of an organization that are in status at now,
+// the query counterpart of Invite.State.
+func inviteStatusFilter(orgID, status string, now time.Time) bson.M {
+	legacy := bson.M{"$exists": false}
+
+	switch status {
+	case InvitePending:
+		return bson.M{
+			"org_id":       orgID,
+			"status":       bson.M{"$in": bson.A{InvitePending, nil}},
+			"has_accepted": bson.M{"$ne": true},
+			"$or":          bson.A{bson.M{"expires_at": legacy}, bson.M{"expires_at": bson.M{"$gt": now}}},
+		}
+	case InviteAccepted:
+		return bson.M{
+			"org_id": orgID,
+			"$or":    bson.A{bson.M{"status": InviteAccepted}, bson.M{"status": legacy, "has_accepted": true}},
+		}
+	case InviteExpired:
+		return bson.M{
+			"org_id": orgID,
+			"$or":    bson.A{bson.M{"status": InviteExpired}, bson.M{"status": InvitePending, "expires_at": bson.M{"$lte": now}}},
+		}
+	default:
+		return bson.M{"org_id": orgID, "status": status}
+	}
+}
+
+// FetchInvite returns the invite matching filter.
+func FetchInvite(ctx context.Context, filter bson.M) (*Invite, error) {
+	invite := &Invite{}
+	if err := utils.GetCollection(OrganizationInviteCollectionName).FindOne(ctx, filter).Decode(invite); err != nil {
+		return nil, ErrInviteNotFound
+	}
+
+	return invite, nil
+}
+
+// openInvite returns the invite with uuid if it can still be accepted.
+func openInvite(ctx context.Context, uuid string) (*Invite, error) {
+	invite, err := FetchInvite(ctx, bson.M{"uuid": uuid})
+	if err != nil {
+		return nil, err
+	}
+
+	switch invite.State(time.Now()) {
+	case InviteAccepted:
+		return nil, ErrInviteAccepted
+	case InviteRevoked:
+		return nil, ErrInviteRevoked
+	case InviteExpired:
+		return nil, ErrInviteExpired
+	}
+
+	return invite, nil
+}
+
+// inviteRole checks the role guests are invited with, members can't invite to a role
+// that holds more than they do.
+func inviteRole(r *http.Request, orgID, role string) (string, error) {
+	role = strings.ToLower(strings.TrimSpace(role))
+	if role == "" {
+		return MemberRole, nil
+	}
+
+	if role == OwnerRole {
+		return "", errors.New("ownership can only be transferred by the owner")
+	}
+
+	perms, err := auth.RolePermissions(r.Context(), orgID, role)
+	if err != nil {
+		return "", ErrInviteRoleNotValid
+	}
+
+	loggedInUser, _ := r.Context().Value("user").(*auth.AuthUser)
+	if loggedInUser == nil || !auth.GrantsAll(loggedInUser.Permissions, perms) {
+		return "", auth.ErrRoleEscalation
+	}
+
+	return role, nil
+}
+
+// createInvite replaces the pending invites of email to the organization with a new
+// one and mails it. It returns the id of the invite.
+func (oh *OrganizationHandler) createInvite(ctx context.Context, orgID, orgName, inviter, email, role string, profile *InviteProfile) (interface{}, error) {
+	now := time.Now()
+	expiresAt := now.Add(time.Duration(oh.configs.InviteTTL) * time.Second)
+
+	// a new invite replaces the ones the guest hasn't accepted yet
+	pending := inviteStatusFilter(orgID, InvitePending, now)
+	pending["email"] = email
+
+	if _, err := utils.GetCollection(OrganizationInviteCollectionName).UpdateMany(ctx, pending,
+		bson.M{"$set": bson.M{"status": InviteRevoked}}); err != nil {
+		return nil, err
+	}
+
+	uuid := utils.GenUUID()
+
+	invite := Invite{
+		OrgID:     orgID,
+		UUID:      uuid,
+		Email:     email,
+		Role:      role,
+		InvitedBy: inviter,
+		Status:    InvitePending,
+		CreatedAt: now,
+		ExpiresAt: &expiresAt,
+		Profile:   profile,
+	}
+
+	res, err := utils.GetCollection(OrganizationInviteCollectionName).InsertOne(ctx, invite)
+	if err != nil {
+		return nil, err
+	}
+
+	oh.sendInviteMail(inviter, orgName, email, uuid)
+
+	return res.InsertedID, nil
+}
+
+func (oh *OrganizationHandler) sendInviteMail(inviter, orgName, email, uuid string) {
+	inviteLink := fmt.Sprintf("%s/%s", os.Getenv("INVITE_DOMAIN"), uuid)
+
+	msger := oh.mailService.NewMail(
+		[]string{email}, "Zuri Chat Workspace Invite", service.WorkSpaceInvite, map[string]interface{}{
+			"Username":   inviter,
+			"OrgName":    orgName,
+			"InviteLink": inviteLink,
+		})
+
+	if err := oh.mailService.SendMail(msger); err != nil {
+		logger.Error("Error occurred while sending mail: %s", err.Error())
+	}
+}
+
+// orgInvite returns the invite in the request path, it must belong to the organization.
+func orgInvite(w http.ResponseWriter, r *http.Request) (*Invite, bool) {
+	vars := mux.Vars(r)
+
+	inviteID, err := primitive.ObjectIDFromHex(vars["invite_id"])
+	if err != nil {
+		utils.GetError(errors.New("invalid invite id"), http.StatusBadRequest, w)
+		return nil, false
+	}
+
+	invite, err := FetchInvite(r.Context(), bson.M{"_id": inviteID, "org_id": vars["id"]})
+	if err != nil {
+		utils.GetError(err, http.StatusNotFound, w)
+		return nil, false
+	}
+
+	return invite, true
+}
+
+// Revoke a pending invite, its link stops working.
+func (oh *OrganizationHandler) RevokeInvite(w http.ResponseWriter, r *http.Request) {
+	invite, ok := orgInvite(w, r)
+	if !ok {
+		return
+	}
+
+	if state := invite.State(time.Now()); state != InvitePending {
+		utils.GetError(fmt.Errorf("only pending invites can be revoked, this one is %s", state), http.StatusBadRequest, w)
+		return
+	}
+
+	if _, err := utils.UpdateOneMongoDBDoc(OrganizationInviteCollectionName, invite.ID, bson.M{"status": InviteRevoked}); err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	audit.Record(r, audit.Event{OrgID: invite.OrgID, Action: audit.ActionInviteRevoke, TargetType: audit.TargetInvite, TargetID: invite.Email})
+
+	utils.GetSuccess("invite revoked", nil, w)
+}
+
+// Send a pending or expired invite again, it can be accepted for another InviteTTL.
+func (oh *OrganizationHandler) ResendInvite(w http.ResponseWriter, r *http.Request) {
+	invite, ok := orgInvite(w, r)
+	if !ok {
+		return
+	}
+
+	switch invite.State(time.Now()) {
+	case InviteAccepted:
+		utils.GetError(ErrInviteAccepted, http.StatusBadRequest, w)
+		return
+	case InviteRevoked:
+		utils.GetError(ErrInviteRevoked, http.StatusBadRequest, w)
+		return
+	}
+
+	if invite.ResendCount >= oh.configs.InviteMaxResends {
+		utils.GetError(ErrInviteResendLimit, http.StatusBadRequest, w)
+		return
+	}
+
+	orgID, _ := primitive.ObjectIDFromHex(invite.OrgID)
+
+	org, _ := utils.GetMongoDBDoc(OrganizationCollectionName, bson.M{"_id": orgID})
+	if org == nil {
+		utils.GetError(fmt.Errorf("organization %s not found", invite.OrgID), http.StatusNotFound, w)
+		return
+	}
+
+	expiresAt := time.Now().Add(time.Duration(oh.configs.InviteTTL) * time.Second)
+
+	update := bson.M{"status": InvitePending, "expires_at": expiresAt, "resend_count": invite.ResendCount + 1}
+	if _, err := utils.UpdateOneMongoDBDoc(OrganizationInviteCollectionName, invite.ID, update); err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	loggedInUser, _ := r.Context().Value("user").(*auth.AuthUser)
+	go oh.sendInviteMail(loggedInUser.Email, fmt.Sprintf("%v", org["name"]), invite.Email, invite.UUID)
+
+	audit.Record(r, audit.Event{OrgID: invite.OrgID, Action: audit.ActionInviteResend, TargetType: audit.TargetInvite, TargetID: invite.Email})
+
+	utils.GetSuccess("invite sent again", utils.M{"expires_at": expiresAt, "resend_count": invite.ResendCount + 1}, w)
+}
@@ -0,0 +1,373 @@
+package organizations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"zuri.chat/zccore/audit"
+	"zuri.chat/zccore/auth"
+	"zuri.chat/zccore/user"
+	"zuri.chat/zccore/utils"
+)
+
+const inviteLinkTokenBytes = 24
+
+var (
+	ErrJoinDisabled      = errors.New("this workspace doesn't accept new members without an invite")
+	ErrAlreadyMember     = errors.New("user is already in this organization")
+	ErrInviteLinkInvalid = errors.New("this invite link is invalid, has expired or has been used up")
+	ErrDomainNotAllowed  = errors.New("your email domain is not allowed to join this workspace")
+	ErrEmailNotVerified  = errors.New("verify your email address to join this workspace")
+
+	domainRegex = regexp.MustCompile(`^([a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?\.)+[a-z]{2,63}$`)
+
+	// publicEmailDomains can't be allowed, anyone can get an address there.
+	publicEmailDomains = map[string]bool{
+		"gmail.com": true, "googlemail.com": true, "yahoo.com": true, "outlook.com": true, "hotmail.com": true,
+		"live.com": true, "icloud.com": true, "aol.com": true, "proton.me": true, "protonmail.com": true,
+	}
+)
+
+// normalizeEmailDomains lower cases and dedupes the domains an organization accepts
+// members from, rejecting malformed and public email domains.
+func normalizeEmailDomains(domains []string) ([]string, error) {
+	normalized := []string{}
+	seen := map[string]bool{}
+
+	for _, d := range domains {
+		d = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(d), "@"))
+
+		if !domainRegex.MatchString(d) {
+			return nil, fmt.Errorf("%q is not a valid email domain", d)
+		}
+
+		if publicEmailDomains[d] {
+			return nil, fmt.Errorf("%s is a public email domain and can't be allowed", d)
+		}
+
+		if !seen[d] {
+			seen[d] = true
+			normalized = append(normalized, d)
+		}
+	}
+
+	return normalized, nil
+}
+
+// joinableOrg returns the organization if it accepts members without an email invite.
+func joinableOrg(ctx context.Context, orgID string) (*Organization, error) {
+	objID, err := primitive.ObjectIDFromHex(orgID)
+	if err != nil {
+		return nil, errors.New("invalid id")
+	}
+
+	org := &Organization{}
+	if err := utils.GetCollection(OrganizationCollectionName).FindOne(ctx, bson.M{"_id": objID}).Decode(org); err != nil {
+		return nil, fmt.Errorf("organization %s not found", orgID)
+	}
+
+	if !org.Settings.Permissions.Invitations {
+		return nil, ErrJoinDisabled
+	}
+
+	return org, nil
+}
+
+// joiningUser returns the account of the logged in user, it must not be a member of orgID.
+func joiningUser(w http.ResponseWriter, r *http.Request, orgID string) (*user.User, bool) {
+	loggedInUser, _ := r.Context().Value("user").(*auth.AuthUser)
+	if loggedInUser == nil {
+		utils.GetError(errors.New("invalid user"), http.StatusBadRequest, w)
+		return nil, false
+	}
+
+	u, err := auth.FetchUserByEmail(bson.M{"email": strings.ToLower(loggedInUser.Email)})
+	if err != nil {
+		utils.GetError(auth.ErrUserNotFound, http.StatusNotFound, w)
+		return nil, false
+	}
+
+	if utils.CountCollection(r.Context(), MemberCollectionName, bson.M{"org_id": orgID, "email": u.Email}) > 0 {
+		utils.GetError(ErrAlreadyMember, http.StatusBadRequest, w)
+		return nil, false
+	}
+
+	return u, true
+}
+
+func addJoiningMember(w http.ResponseWriter, r *http.Request, u *user.User, orgID, role, via string) {
+	member := NewMember(u.Email, strings.Split(u.Email, "@")[0], orgID, role)
+
+	memberID, err := AddMember(r.Context(), u, member)
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	audit.Record(r, audit.Event{OrgID: orgID, Action: audit.ActionMemberJoin, TargetType: audit.TargetMember, TargetID: memberID,
+		After: map[string]interface{}{"role": role, "via": via}})
+
+	utils.GetSuccess("Member created successfully", utils.M{"member_id": memberID, "organization_id": orgID}, w)
+}
+
+// Create a shareable invite link, the link is only returned once.
+func (oh *OrganizationHandler) CreateInviteLink(w http.ResponseWriter, r *http.Request) {
+	orgID := mux.Vars(r)["id"]
+
+	var req CreateInviteLinkRequest
+	if err := utils.ParseJSONFromRequest(r, &req); err != nil {
+		utils.GetError(err, http.StatusUnprocessableEntity, w)
+		return
+	}
+
+	if err := validator.New().Struct(req); err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	if _, err := joinableOrg(r.Context(), orgID); err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	role, err := inviteRole(r, orgID, req.Role)
+	if err != nil {
+		utils.GetError(err, http.StatusForbidden, w)
+		return
+	}
+
+	expiresIn := req.ExpiresIn
+	if expiresIn == 0 {
+		expiresIn = oh.configs.InviteTTL
+	}
+
+	token, err := utils.RandomToken(inviteLinkTokenBytes)
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	loggedInUser, _ := r.Context().Value("user").(*auth.AuthUser)
+	link := InviteLink{
+		OrgID:     orgID,
+		TokenHash: utils.HashToken(token),
+		Role:      role,
+		MaxUses:   req.MaxUses,
+		CreatedBy: loggedInUser.Email,
+		CreatedAt: time.Now(),
+		ExpiresAt: time.Now().Add(time.Duration(expiresIn) * time.Second),
+	}
+
+	res, err := utils.GetCollection(InviteLinkCollectionName).InsertOne(r.Context(), link)
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	link.ID, _ = res.InsertedID.(primitive.ObjectID)
+
+	audit.Record(r, audit.Event{OrgID: orgID, Action: audit.ActionInviteLinkCreate, TargetType: audit.TargetInviteLink, TargetID: link.ID.Hex(),
+		After: map[string]interface{}{"role": role, "max_uses": link.MaxUses, "expires_at": link.ExpiresAt}})
+
+	utils.GetSuccess("invite link created", utils.M{
+		"invite_link": link,
+		"link":        fmt.Sprintf("%s/%s", os.Getenv("INVITE_DOMAIN"), token),
+		"token":       token,
+	}, w)
+}
+
+// List the invite links of an organization, newest first.
+func (oh *OrganizationHandler) GetInviteLinks(w http.ResponseWriter, r *http.Request) {
+	orgID := mux.Vars(r)["id"]
+
+	cursor, err := utils.GetCollection(InviteLinkCollectionName).Find(r.Context(), bson.M{"org_id": orgID},
+		options.Find().SetSort(bson.M{"created_at": -1}))
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	links := []InviteLink{}
+	if err := cursor.All(r.Context(), &links); err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	utils.GetSuccess("successful", links, w)
+}
+
+// Revoke an invite link, it can't be used to join anymore.
+func (oh *OrganizationHandler) RevokeInviteLink(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	linkID, err := primitive.ObjectIDFromHex(vars["link_id"])
+	if err != nil {
+		utils.GetError(errors.New("invalid invite link id"), http.StatusBadRequest, w)
+		return
+	}
+
+	res, err := utils.GetCollection(InviteLinkCollectionName).UpdateOne(r.Context(),
+		bson.M{"_id": linkID, "org_id": vars["id"]}, bson.M{"$set": bson.M{"revoked": true}})
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	if res.MatchedCount == 0 {
+		utils.GetError(errors.New("invite link not found"), http.StatusNotFound, w)
+		return
+	}
+
+	audit.Record(r, audit.Event{OrgID: vars["id"], Action: audit.ActionInviteLinkRevoke, TargetType: audit.TargetInviteLink, TargetID: linkID.Hex()})
+
+	utils.GetSuccess("invite link revoked", nil, w)
+}
+
+// usableInviteLink returns the link for token if it can still be used.
+func usableInviteLink(ctx context.Context, token string) (*InviteLink, error) {
+	link := &InviteLink{}
+	if err := utils.GetCollection(InviteLinkCollectionName).FindOne(ctx, usableInviteLinkFilter(token)).Decode(link); err != nil {
+		return nil, ErrInviteLinkInvalid
+	}
+
+	return link, nil
+}
+
+func usableInviteLinkFilter(token string) bson.M {
+	return bson.M{
+		"token_hash": utils.HashToken(token),
+		"revoked":    false,
+		"expires_at": bson.M{"$gt": time.Now()},
+		"$or":        bson.A{bson.M{"max_uses": 0}, bson.M{"$expr": bson.M{"$lt": bson.A{"$uses", "$max_uses"}}}},
+	}
+}
+
+// checkInviteLink tells which organization an invite link joins, before signing in.
+func checkInviteLink(w http.ResponseWriter, r *http.Request, token string) {
+	link, err := usableInviteLink(r.Context(), token)
+	if err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	org, err := joinableOrg(r.Context(), link.OrgID)
+	if err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	utils.GetSuccess("invite link is valid", user.JoinableOrganization{
+		ID:           org.ID,
+		Name:         org.Name,
+		LogoURL:      org.LogoURL,
+		WorkspaceURL: org.WorkspaceURL,
+	}, w)
+}
+
+// Join an organization as the logged in user with an invite link.
+func (oh *OrganizationHandler) JoinWithInviteLink(w http.ResponseWriter, r *http.Request) {
+	token := mux.Vars(r)["token"]
+
+	link, err := usableInviteLink(r.Context(), token)
+	if err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	if _, err = joinableOrg(r.Context(), link.OrgID); err != nil {
+		utils.GetError(err, http.StatusForbidden, w)
+		return
+	}
+
+	u, ok := joiningUser(w, r, link.OrgID)
+	if !ok {
+		return
+	}
+
+	// counting the use and checking the cap in one update keeps concurrent joins under it
+	res, err := utils.GetCollection(InviteLinkCollectionName).UpdateOne(r.Context(), usableInviteLinkFilter(token),
+		bson.M{"$inc": bson.M{"uses": 1}})
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	if res.ModifiedCount == 0 {
+		utils.GetError(ErrInviteLinkInvalid, http.StatusBadRequest, w)
+		return
+	}
+
+	// the role may have been deleted since the link was created
+	role := link.Role
+	if _, err = auth.RolePermissions(r.Context(), link.OrgID, role); err != nil {
+		role = MemberRole
+	}
+
+	addJoiningMember(w, r, u, link.OrgID, role, "invite_link")
+}
+
+// List the organizations the logged in user can join with their email domain.
+func (oh *OrganizationHandler) GetJoinableOrganizations(w http.ResponseWriter, r *http.Request) {
+	loggedInUser, _ := r.Context().Value("user").(*auth.AuthUser)
+	if loggedInUser == nil {
+		utils.GetError(errors.New("invalid user"), http.StatusBadRequest, w)
+		return
+	}
+
+	orgs, err := user.JoinableOrganizations(r.Context(), strings.ToLower(loggedInUser.Email))
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	utils.GetSuccess("successful", orgs, w)
+}
+
+// Join an organization that accepts the domain of the logged in user's verified email.
+func (oh *OrganizationHandler) JoinByEmailDomain(w http.ResponseWriter, r *http.Request) {
+	orgID := mux.Vars(r)["id"]
+
+	org, err := joinableOrg(r.Context(), orgID)
+	if err != nil {
+		utils.GetError(err, http.StatusForbidden, w)
+		return
+	}
+
+	u, ok := joiningUser(w, r, orgID)
+	if !ok {
+		return
+	}
+
+	if !u.IsVerified {
+		utils.GetError(ErrEmailNotVerified, http.StatusForbidden, w)
+		return
+	}
+
+	if !contains(org.Settings.Permissions.AllowedEmailDomains, user.EmailDomain(u.Email)) {
+		utils.GetError(ErrDomainNotAllowed, http.StatusForbidden, w)
+		return
+	}
+
+	addJoiningMember(w, r, u, orgID, MemberRole, "email_domain")
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
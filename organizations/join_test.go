@@ -0,0 +1,23 @@
+package organizations
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeEmailDomains(t *testing.T) {
+	got, err := normalizeEmailDomains([]string{" Zuri.Chat ", "@zuri.chat", "mail.hng.tech"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if want := []string{"zuri.chat", "mail.hng.tech"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	for _, domain := range []string{"gmail.com", "zuri", "-zuri.chat", "zuri..chat", ""} {
+		if _, err := normalizeEmailDomains([]string{domain}); err == nil {
+			t.Errorf("expected %q to be rejected", domain)
+		}
+	}
+}
@@ -0,0 +1,470 @@
+package organizations
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"zuri.chat/zccore/audit"
+	"zuri.chat/zccore/auth"
+	"zuri.chat/zccore/logger"
+	"zuri.chat/zccore/utils"
+)
+
+const (
+	maxImportSize = 2 << 20
+	maxImportRows = 1000
+)
+
+// importColumns maps the accepted CSV headers to the fields they fill.
+var importColumns = map[string]string{
+	"name":          "name",
+	"full_name":     "name",
+	"first_name":    "first_name",
+	"last_name":     "last_name",
+	"email":         "email",
+	"email_address": "email",
+	"role":          "role",
+	"display_name":  "display_name",
+	"timezone":      "time_zone",
+	"time_zone":     "time_zone",
+}
+
+var (
+	memberExportHeader = []string{
+		"id", "email", "user_name", "first_name", "last_name", "display_name", "role", "time_zone",
+		"phone", "pronouns", "joined_at", "deactivated", "deactivated_at",
+	}
+	importResultsHeader = []string{"row", "email", "role", "result", "error"}
+)
+
+// parseImportCSV reads the rows of a member import, columns are matched by their header.
+func parseImportCSV(r io.Reader) ([]MemberImportRow, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, errors.New("the file has no header row")
+	}
+
+	cols := map[string]int{}
+
+	for i, h := range header {
+		h = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(h, "\ufeff")))
+		h = strings.NewReplacer(" ", "_", "-", "_").Replace(h)
+
+		if field, ok := importColumns[h]; ok {
+			if _, dup := cols[field]; !dup {
+				cols[field] = i
+			}
+		}
+	}
+
+	if _, ok := cols["email"]; !ok {
+		return nil, errors.New("the file has no email column")
+	}
+
+	var rows []MemberImportRow
+
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("the file is not valid CSV: %w", err)
+		}
+
+		if len(rows) == maxImportRows {
+			return nil, fmt.Errorf("imports are limited to %d rows", maxImportRows)
+		}
+
+		cell := func(field string) string {
+			if i, ok := cols[field]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+
+			return ""
+		}
+
+		row := MemberImportRow{
+			Row:   len(rows) + 1,
+			Email: strings.ToLower(cell("email")),
+			Role:  cell("role"),
+			Profile: InviteProfile{
+				DisplayName: cell("display_name"),
+				TimeZone:    cell("time_zone"),
+			},
+		}
+
+		if names := strings.Fields(cell("name")); len(names) > 0 {
+			row.Profile.FirstName, row.Profile.LastName = names[0], strings.Join(names[1:], " ")
+		}
+
+		if first := cell("first_name"); first != "" {
+			row.Profile.FirstName = first
+		}
+
+		if last := cell("last_name"); last != "" {
+			row.Profile.LastName = last
+		}
+
+		rows = append(rows, row)
+	}
+
+	if len(rows) == 0 {
+		return nil, errors.New("the file has no rows")
+	}
+
+	return rows, nil
+}
+
+// checkImportRows validates rows and decides what importing each does: members are
+// skipped, accounts added and everyone else invited.
+func checkImportRows(r *http.Request, orgID string, rows []MemberImportRow) error {
+	seen := map[string]bool{}
+	emails := bson.A{}
+
+	for i := range rows {
+		row := &rows[i]
+
+		role, err := inviteRole(r, orgID, row.Role)
+
+		switch {
+		case !utils.IsValidEmail(row.Email):
+			err = errors.New("invalid email address")
+		case seen[row.Email]:
+			err = errors.New("duplicate of an earlier row")
+		case err == nil && row.Profile.TimeZone != "":
+			if _, tzErr := time.LoadLocation(row.Profile.TimeZone); tzErr != nil {
+				err = errors.New("unknown time zone")
+			}
+		}
+
+		seen[row.Email] = true
+
+		if err != nil {
+			row.Result, row.Error = ImportRowFailed, err.Error()
+			continue
+		}
+
+		row.Role = role
+		emails = append(emails, row.Email)
+	}
+
+	members, err := utils.GetCollection(MemberCollectionName).Distinct(r.Context(), "email",
+		bson.M{"org_id": orgID, "email": bson.M{"$in": emails}})
+	if err != nil {
+		return err
+	}
+
+	accounts, err := utils.GetCollection(UserCollectionName).Distinct(r.Context(), "email", bson.M{"email": bson.M{"$in": emails}})
+	if err != nil {
+		return err
+	}
+
+	for i := range rows {
+		row := &rows[i]
+
+		switch {
+		case row.Result == ImportRowFailed:
+		case containsValue(members, row.Email):
+			row.Result, row.Error = ImportRowSkipped, ErrAlreadyMember.Error()
+		case containsValue(accounts, row.Email):
+			row.Result = ImportRowAdded
+		default:
+			row.Result = ImportRowInvited
+		}
+	}
+
+	return nil
+}
+
+func containsValue(values []interface{}, v interface{}) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+
+	return false
+}
+
+func importSummary(rows []MemberImportRow) map[string]int {
+	summary := map[string]int{ImportRowAdded: 0, ImportRowInvited: 0, ImportRowSkipped: 0, ImportRowFailed: 0}
+	for _, row := range rows {
+		summary[row.Result]++
+	}
+
+	return summary
+}
+
+// Import members from a CSV file. With dry_run=true the rows are only validated,
+// otherwise the import runs in the background and its results are kept.
+func (oh *OrganizationHandler) ImportMembers(w http.ResponseWriter, r *http.Request) {
+	orgID := mux.Vars(r)["id"]
+	dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dry_run"))
+
+	objID, err := primitive.ObjectIDFromHex(orgID)
+	if err != nil {
+		utils.GetError(errors.New("invalid id"), http.StatusBadRequest, w)
+		return
+	}
+
+	org, _ := utils.GetMongoDBDoc(OrganizationCollectionName, bson.M{"_id": objID})
+	if org == nil {
+		utils.GetError(fmt.Errorf("organization %s not found", orgID), http.StatusNotFound, w)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		utils.GetError(errors.New("upload a CSV file of at most 2MB in the file field"), http.StatusBadRequest, w)
+		return
+	}
+	defer file.Close()
+
+	rows, err := parseImportCSV(file)
+	if err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	if err = checkImportRows(r, orgID, rows); err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	if dryRun {
+		utils.GetSuccess("dry run, nothing was imported", utils.M{"summary": importSummary(rows), "rows": rows}, w)
+		return
+	}
+
+	loggedInUser, _ := r.Context().Value("user").(*auth.AuthUser)
+	imp := &MemberImport{
+		OrgID:     orgID,
+		Status:    ImportPending,
+		CreatedBy: loggedInUser.Email,
+		Summary:   importSummary(rows),
+		Rows:      rows,
+		CreatedAt: time.Now(),
+	}
+
+	res, err := utils.GetCollection(MemberImportCollectionName).InsertOne(r.Context(), imp)
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	imp.ID, _ = res.InsertedID.(primitive.ObjectID)
+
+	audit.Record(r, audit.Event{OrgID: orgID, Action: audit.ActionMemberImport, TargetType: audit.TargetOrganization, TargetID: orgID,
+		After: map[string]interface{}{"import_id": imp.ID.Hex(), "rows": len(rows)}})
+
+	// the job gets its own rows, the response still reads these
+	job := *imp
+	job.Rows = append([]MemberImportRow(nil), rows...)
+
+	go oh.runMemberImport(job, fmt.Sprintf("%v", org["name"]))
+
+	utils.GetSuccess("import started", imp, w)
+}
+
+// runMemberImport adds and invites the members of an import and records the result of
+// each row.
+func (oh *OrganizationHandler) runMemberImport(imp MemberImport, orgName string) {
+	ctx := context.Background()
+
+	for i := range imp.Rows {
+		row := &imp.Rows[i]
+
+		var err error
+
+		switch row.Result {
+		case ImportRowAdded:
+			err = importMember(ctx, imp.OrgID, row)
+		case ImportRowInvited:
+			_, err = oh.createInvite(ctx, imp.OrgID, orgName, imp.CreatedBy, row.Email, row.Role, &row.Profile)
+		default:
+			continue
+		}
+
+		switch {
+		case errors.Is(err, ErrAlreadyMember):
+			row.Result, row.Error = ImportRowSkipped, err.Error()
+		case err != nil:
+			row.Result, row.Error = ImportRowFailed, err.Error()
+		}
+	}
+
+	completedAt := time.Now()
+
+	if _, err := utils.GetCollection(MemberImportCollectionName).UpdateOne(ctx, bson.M{"_id": imp.ID}, bson.M{"$set": bson.M{
+		"status":       ImportCompleted,
+		"rows":         imp.Rows,
+		"summary":      importSummary(imp.Rows),
+		"completed_at": completedAt,
+	}}); err != nil {
+		logger.Error("Error saving member import %s: %s", imp.ID.Hex(), err.Error())
+	}
+}
+
+func importMember(ctx context.Context, orgID string, row *MemberImportRow) error {
+	u, err := auth.FetchUserByEmail(bson.M{"email": row.Email})
+	if err != nil {
+		return err
+	}
+
+	// the user may have joined since the rows were checked
+	if utils.CountCollection(ctx, MemberCollectionName, bson.M{"org_id": orgID, "email": u.Email}) > 0 {
+		return ErrAlreadyMember
+	}
+
+	m := NewMember(u.Email, strings.Split(u.Email, "@")[0], orgID, row.Role)
+	m.FirstName, m.LastName = row.Profile.FirstName, row.Profile.LastName
+	m.DisplayName, m.TimeZone = row.Profile.DisplayName, row.Profile.TimeZone
+
+	_, err = AddMember(ctx, u, m)
+
+	return err
+}
+
+// orgMemberImport returns the import in the request path, it must belong to the organization.
+func orgMemberImport(w http.ResponseWriter, r *http.Request) (*MemberImport, bool) {
+	vars := mux.Vars(r)
+
+	importID, err := primitive.ObjectIDFromHex(vars["import_id"])
+	if err != nil {
+		utils.GetError(errors.New("invalid import id"), http.StatusBadRequest, w)
+		return nil, false
+	}
+
+	imp := &MemberImport{}
+	if err := utils.GetCollection(MemberImportCollectionName).FindOne(r.Context(),
+		bson.M{"_id": importID, "org_id": vars["id"]}).Decode(imp); err != nil {
+		utils.GetError(errors.New("import not found"), http.StatusNotFound, w)
+		return nil, false
+	}
+
+	return imp, true
+}
+
+// Get the status and row results of a member import.
+func (oh *OrganizationHandler) GetMemberImport(w http.ResponseWriter, r *http.Request) {
+	imp, ok := orgMemberImport(w, r)
+	if !ok {
+		return
+	}
+
+	utils.GetSuccess("successful", imp, w)
+}
+
+// Download the row results of a member import as CSV.
+func (oh *OrganizationHandler) DownloadMemberImportResults(w http.ResponseWriter, r *http.Request) {
+	imp, ok := orgMemberImport(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"member-import-%s.csv\"", imp.ID.Hex()))
+
+	out := csv.NewWriter(w)
+
+	//nolint:errcheck //CODEI8: a failed write means the client went away
+	out.Write(importResultsHeader)
+
+	for _, row := range imp.Rows {
+		record := []string{strconv.Itoa(row.Row), row.Email, row.Role, row.Result, row.Error}
+		for i := range record {
+			record[i] = utils.CSVSafe(record[i])
+		}
+
+		if err := out.Write(record); err != nil {
+			break
+		}
+	}
+
+	out.Flush()
+}
+
+// Export the member directory of an organization as CSV.
+func (oh *OrganizationHandler) ExportMembers(w http.ResponseWriter, r *http.Request) {
+	orgID := mux.Vars(r)["id"]
+
+	cursor, err := utils.GetCollection(MemberCollectionName).Find(r.Context(), bson.M{"org_id": orgID},
+		options.Find().SetSort(bson.D{{Key: "joined_at", Value: 1}, {Key: "_id", Value: 1}}))
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+	defer cursor.Close(r.Context())
+
+	audit.Record(r, audit.Event{OrgID: orgID, Action: audit.ActionMemberExport, TargetType: audit.TargetOrganization, TargetID: orgID})
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"members-%s.csv\"", orgID))
+
+	out := csv.NewWriter(w)
+
+	//nolint:errcheck //CODEI8: a failed write means the client went away
+	out.Write(memberExportHeader)
+
+	for cursor.Next(r.Context()) {
+		var m Member
+		if err := cursor.Decode(&m); err != nil {
+			logger.Error("Error exporting members: %s", err.Error())
+			break
+		}
+
+		if err := out.Write(memberExportRow(&m)); err != nil {
+			break
+		}
+	}
+
+	out.Flush()
+}
+
+func memberExportRow(m *Member) []string {
+	deactivatedAt := ""
+	if m.Deleted && !m.DeletedAt.IsZero() {
+		deactivatedAt = m.DeletedAt.UTC().Format(time.RFC3339)
+	}
+
+	row := []string{
+		m.ID,
+		m.Email,
+		m.UserName,
+		m.FirstName,
+		m.LastName,
+		m.DisplayName,
+		m.Role,
+		m.TimeZone,
+		m.Phone,
+		m.Pronouns,
+		m.JoinedAt.UTC().Format(time.RFC3339),
+		strconv.FormatBool(m.Deleted),
+		deactivatedAt,
+	}
+
+	for i := range row {
+		row[i] = utils.CSVSafe(row[i])
+	}
+
+	return row
+}
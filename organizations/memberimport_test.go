@@ -0,0 +1,68 @@
+package organizations
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseImportCSV(t *testing.T) {
+	file := "\ufeffEmail,Full Name,Role,Display-Name,Timezone\n" +
+		"Ada@Zuri.chat, Ada King Lovelace ,admin,ada,Europe/London\n" +
+		"grace@zuri.chat,Grace,,,\n"
+
+	rows, err := parseImportCSV(strings.NewReader(file))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(rows) != 2 {
+		t.Fatalf("got %d rows, want 2", len(rows))
+	}
+
+	ada := rows[0]
+	if ada.Row != 1 || ada.Email != "ada@zuri.chat" || ada.Role != "admin" {
+		t.Errorf("unexpected row %+v", ada)
+	}
+
+	if p := ada.Profile; p.FirstName != "Ada" || p.LastName != "King Lovelace" || p.DisplayName != "ada" || p.TimeZone != "Europe/London" {
+		t.Errorf("unexpected profile %+v", p)
+	}
+
+	if rows[1].Profile.FirstName != "Grace" || rows[1].Profile.LastName != "" {
+		t.Errorf("unexpected profile %+v", rows[1].Profile)
+	}
+
+	for name, file := range map[string]string{
+		"no email column": "name,role\nAda,admin\n",
+		"no rows":         "email\n",
+		"empty":           "",
+	} {
+		if _, err := parseImportCSV(strings.NewReader(file)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	many := "email\n" + strings.Repeat("a@zuri.chat\n", maxImportRows+1)
+	if _, err := parseImportCSV(strings.NewReader(many)); err == nil {
+		t.Errorf("expected imports over %d rows to be rejected", maxImportRows)
+	}
+}
+
+func TestMemberExportRow(t *testing.T) {
+	joined := time.Date(2021, 9, 1, 10, 0, 0, 0, time.UTC)
+	m := &Member{ID: "6145d4a4", Email: "ada@zuri.chat", DisplayName: "=cmd()", Role: "admin", JoinedAt: joined, Deleted: true}
+
+	row := memberExportRow(m)
+	if len(row) != len(memberExportHeader) {
+		t.Fatalf("got %d cells, want %d", len(row), len(memberExportHeader))
+	}
+
+	if row[5] != "'=cmd()" {
+		t.Errorf("display name should be escaped, got %q", row[5])
+	}
+
+	if row[10] != "2021-09-01T10:00:00Z" || row[11] != "true" || row[12] != "" {
+		t.Errorf("unexpected dates or state %v", row[10:])
+	}
+}
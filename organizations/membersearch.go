@@ -0,0 +1,292 @@
+package organizations
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"zuri.chat/zccore/logger"
+	"zuri.chat/zccore/user"
+	"zuri.chat/zccore/utils"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+	maxSearchTerms     = 5
+)
+
+var (
+	ErrInvalidCursor = errors.New("invalid cursor")
+
+	// searchSorts are the fields members can be sorted by.
+	searchSorts = map[string]bool{
+		"joined_at": true, "first_name": true, "last_name": true, "display_name": true, "user_name": true, "email": true,
+	}
+
+	// searchProjection is what the people picker needs of a member.
+	searchProjection = bson.M{
+		"org_id": 1, "email": 1, "user_name": 1, "first_name": 1, "last_name": 1, "display_name": 1, "image_url": 1,
+		"role": 1, "presence": 1, "status": 1, "time_zone": 1, "joined_at": 1, "deleted": 1, "deleted_at": 1,
+	}
+)
+
+// memberSearch is a parsed member search: the filter, the sort and where the page starts.
+type memberSearch struct {
+	filter bson.M
+	sort   string
+	desc   bool
+	limit  int
+}
+
+// searchCursor is the position after the last member of a page.
+type searchCursor struct {
+	Value interface{} `json:"v"`
+	ID    string      `json:"id"`
+}
+
+// parseMemberSearch builds the search of an organization's members from the query.
+// Terms of q must each prefix one of the member's search_keys, the lower cased
+// user.MemberSearchFields, with an anchored case sensitive regex the index can serve.
+func parseMemberSearch(orgID string, query url.Values) (*memberSearch, error) {
+	s := &memberSearch{filter: bson.M{"org_id": orgID}, sort: "joined_at", limit: defaultSearchLimit}
+	and := bson.A{}
+
+	if terms := strings.Fields(query.Get("q")); len(terms) > 0 {
+		if len(terms) > maxSearchTerms {
+			terms = terms[:maxSearchTerms]
+		}
+
+		for _, term := range terms {
+			prefix := primitive.Regex{Pattern: "^" + regexp.QuoteMeta(strings.ToLower(term))}
+			and = append(and, bson.M{"search_keys": prefix})
+		}
+	}
+
+	if roles := splitParam(query.Get("role")); len(roles) > 0 {
+		s.filter["role"] = bson.M{"$in": roles}
+	}
+
+	if presence := query.Get("presence"); presence != "" {
+		s.filter["presence"] = presence
+	}
+
+	if zones := splitParam(query.Get("time_zone")); len(zones) > 0 {
+		s.filter["time_zone"] = bson.M{"$in": zones}
+	}
+
+	// deactivated members are left out unless asked for
+	switch deactivated := query.Get("deactivated"); deactivated {
+	case "", "false":
+		s.filter["deleted"] = bson.M{"$ne": true}
+	case "true":
+		s.filter["deleted"] = true
+	case "any":
+	default:
+		return nil, fmt.Errorf("deactivated must be true, false or any, not %q", deactivated)
+	}
+
+	joined := bson.M{}
+
+	for param, op := range map[string]string{"joined_after": "$gte", "joined_before": "$lt"} {
+		if v := query.Get(param); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return nil, fmt.Errorf("%s must be an RFC 3339 date", param)
+			}
+
+			joined[op] = t
+		}
+	}
+
+	if len(joined) > 0 {
+		s.filter["joined_at"] = joined
+	}
+
+	if sort := query.Get("sort"); sort != "" {
+		s.desc = strings.HasPrefix(sort, "-")
+		s.sort = strings.TrimPrefix(sort, "-")
+
+		if !searchSorts[s.sort] {
+			return nil, fmt.Errorf("members can't be sorted by %s", s.sort)
+		}
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 {
+			return nil, errors.New("limit must be a positive number")
+		}
+
+		if n > maxSearchLimit {
+			n = maxSearchLimit
+		}
+
+		s.limit = n
+	}
+
+	if cursor := query.Get("cursor"); cursor != "" {
+		after, err := s.after(cursor)
+		if err != nil {
+			return nil, err
+		}
+
+		and = append(and, after)
+	}
+
+	if len(and) > 0 {
+		s.filter["$and"] = and
+	}
+
+	return s, nil
+}
+
+// after matches the members that come after cursor in the sort order, the member id
+// breaks ties.
+func (s *memberSearch) after(cursor string) (bson.M, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var c searchCursor
+	if err := json.Unmarshal(raw, &c); err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	id, err := primitive.ObjectIDFromHex(c.ID)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	// anything but a plain value would be read as an operator in the match below
+	str, ok := c.Value.(string)
+	if !ok {
+		return nil, ErrInvalidCursor
+	}
+
+	var value interface{} = str
+
+	if s.sort == "joined_at" {
+		t, err := time.Parse(time.RFC3339Nano, str)
+		if err != nil {
+			return nil, ErrInvalidCursor
+		}
+
+		value = t
+	}
+
+	op := "$gt"
+	if s.desc {
+		op = "$lt"
+	}
+
+	return bson.M{"$or": bson.A{
+		bson.M{s.sort: bson.M{op: value}},
+		bson.M{s.sort: value, "_id": bson.M{op: id}},
+	}}, nil
+}
+
+func (s *memberSearch) options() *options.FindOptions {
+	order := 1
+	if s.desc {
+		order = -1
+	}
+
+	return options.Find().
+		SetSort(bson.D{{Key: s.sort, Value: order}, {Key: "_id", Value: order}}).
+		SetLimit(int64(s.limit + 1)).
+		SetProjection(searchProjection)
+}
+
+// nextCursor returns the cursor of the page after the one ending with last.
+func (s *memberSearch) nextCursor(last bson.M) string {
+	id, _ := last["_id"].(primitive.ObjectID)
+	value := last[s.sort]
+
+	if t, ok := value.(primitive.DateTime); ok {
+		value = t.Time().UTC().Format(time.RFC3339Nano)
+	}
+
+	raw, err := json.Marshal(searchCursor{Value: value, ID: id.Hex()})
+	if err != nil {
+		return ""
+	}
+
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// refreshSearchKeys keeps the search keys of the members matching filter in step with
+// a write to their names or email.
+func refreshSearchKeys(ctx context.Context, filter bson.M) {
+	if err := user.RefreshMemberSearchKeys(ctx, filter); err != nil {
+		logger.Error("Error refreshing member search keys: %s", err.Error())
+	}
+}
+
+// MigrateMemberSearchKeys fills in the search keys of members added before the
+// directory search was indexed.
+func (oh *OrganizationHandler) MigrateMemberSearchKeys(ctx context.Context) error {
+	if utils.GetDefaultMongoClient() == nil {
+		return nil
+	}
+
+	return user.RefreshMemberSearchKeys(ctx, bson.M{"search_keys": bson.M{"$exists": false}})
+}
+
+func splitParam(v string) []string {
+	var values []string
+
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			values = append(values, s)
+		}
+	}
+
+	return values
+}
+
+// Search the members of an organization by name or email, with filters, a sort and
+// cursor pagination.
+func (oh *OrganizationHandler) SearchMembers(w http.ResponseWriter, r *http.Request) {
+	orgID := mux.Vars(r)["id"]
+
+	search, err := parseMemberSearch(orgID, r.URL.Query())
+	if err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	cursor, err := utils.GetCollection(MemberCollectionName).Find(r.Context(), search.filter, search.options())
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	members := []bson.M{}
+	if err := cursor.All(r.Context(), &members); err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	next := ""
+
+	if len(members) > search.limit {
+		members = members[:search.limit]
+		next = search.nextCursor(members[len(members)-1])
+	}
+
+	utils.GetSuccess("Members retrieved successfully", utils.M{"members": members, "next_cursor": next}, w)
+}
@@ -0,0 +1,107 @@
+package organizations
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestParseMemberSearch(t *testing.T) {
+	query := url.Values{
+		"q":            {"ada L.ove"},
+		"role":         {"admin, editor"},
+		"deactivated":  {"any"},
+		"joined_after": {"2021-09-01T00:00:00Z"},
+		"sort":         {"-first_name"},
+		"limit":        {"500"},
+	}
+
+	s, err := parseMemberSearch("6145d4a4", query)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if s.sort != "first_name" || !s.desc || s.limit != maxSearchLimit {
+		t.Errorf("unexpected sort %s desc %v limit %d", s.sort, s.desc, s.limit)
+	}
+
+	if _, ok := s.filter["deleted"]; ok {
+		t.Error("deactivated=any should not filter on deleted")
+	}
+
+	roles, _ := s.filter["role"].(bson.M)
+	if in, _ := roles["$in"].([]string); len(in) != 2 || in[1] != "editor" {
+		t.Errorf("unexpected role filter %v", s.filter["role"])
+	}
+
+	and, _ := s.filter["$and"].(bson.A)
+	if len(and) != 2 {
+		t.Fatalf("expected a clause per term, got %v", and)
+	}
+
+	if re, _ := and[1].(bson.M)["search_keys"].(primitive.Regex); re.Pattern != `^l\.ove` || re.Options != "" {
+		t.Errorf("terms should be escaped lower cased prefixes of the search keys, got %v", re)
+	}
+
+	for name, query := range map[string]url.Values{
+		"sort":        {"sort": {"password"}},
+		"limit":       {"limit": {"0"}},
+		"deactivated": {"deactivated": {"maybe"}},
+		"joined":      {"joined_before": {"yesterday"}},
+		"cursor":      {"cursor": {"not-a-cursor"}},
+	} {
+		if _, err := parseMemberSearch("6145d4a4", query); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestMemberSearchCursor(t *testing.T) {
+	id := primitive.NewObjectID()
+	joined := time.Date(2021, 9, 1, 10, 0, 0, 5000000, time.UTC)
+
+	s := &memberSearch{sort: "joined_at"}
+	cursor := s.nextCursor(bson.M{"_id": id, "joined_at": primitive.NewDateTimeFromTime(joined)})
+
+	after, err := s.after(cursor)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	or, _ := after["$or"].(bson.A)
+	tie, _ := or[1].(bson.M)
+
+	if got, _ := tie["joined_at"].(time.Time); !got.Equal(joined) {
+		t.Errorf("cursor time = %v, want %v", got, joined)
+	}
+
+	if got, _ := tie["_id"].(bson.M)["$gt"].(primitive.ObjectID); got != id {
+		t.Errorf("cursor id = %v, want %v", got, id)
+	}
+
+	s = &memberSearch{sort: "email", desc: true}
+
+	after, err = s.after(s.nextCursor(bson.M{"_id": id, "email": "ada@zuri.chat"}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if first, _ := after["$or"].(bson.A)[0].(bson.M)["email"].(bson.M); first["$lt"] != "ada@zuri.chat" {
+		t.Errorf("descending cursor should page with $lt, got %v", first)
+	}
+}
+
+func TestMemberSearchCursorValue(t *testing.T) {
+	s := &memberSearch{sort: "email"}
+
+	for _, raw := range []string{`{"v":{"$ne":null},"id":"6145d0b9285e4a184020742c"}`, `{"v":1,"id":"6145d0b9285e4a184020742c"}`} {
+		if _, err := s.after(base64.RawURLEncoding.EncodeToString([]byte(raw))); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("%s: got %v, want ErrInvalidCursor", raw, err)
+		}
+	}
+}
@@ -5,6 +5,8 @@ import (
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"zuri.chat/zccore/service"
+	"zuri.chat/zccore/sso"
+	"zuri.chat/zccore/user"
 	"zuri.chat/zccore/utils"
 )
 
@@ -13,6 +15,11 @@ const (
 	TokenTransactionCollectionName   = "token_transaction"
 	InstalledPluginsCollectionName   = "installed_plugins"
 	OrganizationInviteCollectionName = "organizations_invites"
+	InviteLinkCollectionName         = "organization_invite_links"
+	MemberImportCollectionName       = "member_imports"
+	UserGroupCollectionName          = "user_groups"
+	OwnershipTransferCollectionName  = "ownership_transfers"
+	URLRedirectCollectionName        = "workspace_url_redirects"
 	MemberCollectionName             = "members"
 	CardCollectionName               = "cards"
 	UserCollectionName               = "users"
@@ -21,6 +28,14 @@ const (
 
 const (
 	CreateOrganizationMember              = "CreateOrganizationMember"
+	CreateOrganizationUserGroup           = "CreateOrganizationUserGroup"
+	UpdateOrganizationUserGroup           = "UpdateOrganizationUserGroup"
+	ArchiveOrganizationUserGroup          = "ArchiveOrganizationUserGroup"
+	UnarchiveOrganizationUserGroup        = "UnarchiveOrganizationUserGroup"
+	UpdateOrganizationUserGroupMembers    = "UpdateOrganizationUserGroupMembers"
+	ScheduleOrganizationDeletion          = "ScheduleOrganizationDeletion"
+	CancelOrganizationDeletion            = "CancelOrganizationDeletion"
+	PurgeOrganization                     = "PurgeOrganization"
 	UpdateOrganizationName                = "UpdateOrganizationName"
 	UpdateOrganizationMemberPic           = "UpdateOrganizationMemberPic"
 	UpdateOrganizationURL                 = "UpdateOrganizationUrl"
@@ -96,6 +111,58 @@ type Organization struct {
 	Tokens       float64                `json:"tokens" bson:"tokens"`
 	Version      string                 `json:"version" bson:"version"`
 	Billing      Billing                `json:"billing" bson:"billing"`
+	// DeletionScheduledAt is when the organization will be purged, its owner can cancel
+	// the deletion until then.
+	DeletionScheduledAt *time.Time `json:"deletion_scheduled_at,omitempty" bson:"deletion_scheduled_at,omitempty"`
+	DeletionRequestedBy string     `json:"deletion_requested_by,omitempty" bson:"deletion_requested_by,omitempty"`
+}
+
+// Ownership transfer states, a pending transfer past its expiry has lapsed.
+const (
+	TransferPending   = "pending"
+	TransferAccepted  = "accepted"
+	TransferDeclined  = "declined"
+	TransferCancelled = "cancelled"
+)
+
+// OwnershipTransfer is the owner's offer of an organization to one of its members. The
+// roles only change once the member accepts it, from the link emailed to them.
+type OwnershipTransfer struct {
+	ID           primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	OrgID        string             `json:"org_id" bson:"org_id"`
+	FromMemberID string             `json:"from_member_id" bson:"from_member_id"`
+	FromEmail    string             `json:"from_email" bson:"from_email"`
+	ToMemberID   string             `json:"to_member_id" bson:"to_member_id"`
+	ToEmail      string             `json:"to_email" bson:"to_email"`
+	TokenHash    string             `json:"-" bson:"token_hash"`
+	Status       string             `json:"status" bson:"status"`
+	CreatedAt    time.Time          `json:"created_at" bson:"created_at"`
+	ExpiresAt    time.Time          `json:"expires_at" bson:"expires_at"`
+	CompletedAt  *time.Time         `json:"completed_at,omitempty" bson:"completed_at,omitempty"`
+}
+
+type TransferOwnershipRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+// URLRedirect keeps a previous URL of a workspace resolving to it until ExpiresAt.
+type URLRedirect struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	URL       string             `json:"url" bson:"url"`
+	OrgID     string             `json:"org_id" bson:"org_id"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+	ExpiresAt time.Time          `json:"expires_at" bson:"expires_at"`
+}
+
+// DeleteOrganizationRequest is the owner's confirmation of an organization deletion.
+type DeleteOrganizationRequest struct {
+	Name     string `json:"name"`
+	Password string `json:"password"`
+}
+
+// OrganizationMessage is the plugin sync message of a change to a whole organization.
+type OrganizationMessage struct {
+	OrganizationID string `json:"organization_id" bson:"organization_id"`
 }
 
 type Billing struct {
@@ -134,12 +201,153 @@ type TokenTransaction struct {
 	TransactionID string    `json:"transaction_id" bson:"transaction_id"`
 }
 
+// Invite states.
+const (
+	InvitePending  = "pending"
+	InviteAccepted = "accepted"
+	InviteRevoked  = "revoked"
+	InviteExpired  = "expired"
+)
+
+// Invite lets a guest join an organization with Role until it expires. Invites sent
+// before they had a status or expiry are pending until accepted.
 type Invite struct {
-	ID          string `json:"_id,omitempty" bson:"_id,omitempty"`
-	OrgID       string `json:"org_id" bson:"org_id"`
-	UUID        string `json:"uuid" bson:"uuid"`
-	Email       string `json:"email" bson:"email"`
-	HasAccepted bool   `json:"has_accepted" bson:"has_accepted"`
+	ID          string     `json:"_id,omitempty" bson:"_id,omitempty"`
+	OrgID       string     `json:"org_id" bson:"org_id"`
+	UUID        string     `json:"uuid" bson:"uuid"`
+	Email       string     `json:"email" bson:"email"`
+	HasAccepted bool       `json:"has_accepted" bson:"has_accepted"`
+	Role        string     `json:"role" bson:"role,omitempty"`
+	InvitedBy   string     `json:"invited_by" bson:"invited_by,omitempty"`
+	Status      string     `json:"status" bson:"status,omitempty"`
+	ResendCount int        `json:"resend_count" bson:"resend_count"`
+	CreatedAt   time.Time  `json:"created_at" bson:"created_at,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty" bson:"expires_at,omitempty"`
+	AcceptedAt  *time.Time `json:"accepted_at,omitempty" bson:"accepted_at,omitempty"`
+	// Profile fills the member profile when the invite is accepted.
+	Profile *InviteProfile `json:"profile,omitempty" bson:"profile,omitempty"`
+}
+
+type InviteProfile struct {
+	FirstName   string `json:"first_name" bson:"first_name"`
+	LastName    string `json:"last_name" bson:"last_name"`
+	DisplayName string `json:"display_name" bson:"display_name"`
+	TimeZone    string `json:"time_zone" bson:"time_zone"`
+}
+
+// Member import states, and what happened to each row.
+const (
+	ImportPending   = "pending"
+	ImportCompleted = "completed"
+
+	ImportRowAdded   = "added"
+	ImportRowInvited = "invited"
+	ImportRowSkipped = "skipped"
+	ImportRowFailed  = "failed"
+)
+
+// MemberImport is a CSV import of members, processed in the background. Rows with an
+// account are added as members, the others are invited.
+type MemberImport struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	OrgID       string             `json:"org_id" bson:"org_id"`
+	Status      string             `json:"status" bson:"status"`
+	CreatedBy   string             `json:"created_by" bson:"created_by"`
+	Summary     map[string]int     `json:"summary" bson:"summary"`
+	Rows        []MemberImportRow  `json:"rows" bson:"rows"`
+	CreatedAt   time.Time          `json:"created_at" bson:"created_at"`
+	CompletedAt *time.Time         `json:"completed_at,omitempty" bson:"completed_at,omitempty"`
+}
+
+type MemberImportRow struct {
+	Row     int           `json:"row" bson:"row"`
+	Email   string        `json:"email" bson:"email"`
+	Role    string        `json:"role" bson:"role"`
+	Profile InviteProfile `json:"profile" bson:"profile"`
+	Result  string        `json:"result" bson:"result"`
+	Error   string        `json:"error,omitempty" bson:"error,omitempty"`
+}
+
+// InviteLink is a shareable link anyone signed in can join the organization with, as
+// Role, until it expires or has been used MaxUses times. A MaxUses of 0 sets no limit.
+type InviteLink struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	OrgID     string             `json:"org_id" bson:"org_id"`
+	TokenHash string             `json:"-" bson:"token_hash"`
+	Role      string             `json:"role" bson:"role"`
+	MaxUses   int                `json:"max_uses" bson:"max_uses"`
+	Uses      int                `json:"uses" bson:"uses"`
+	Revoked   bool               `json:"revoked" bson:"revoked"`
+	CreatedBy string             `json:"created_by" bson:"created_by"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+	ExpiresAt time.Time          `json:"expires_at" bson:"expires_at"`
+}
+
+type CreateInviteLinkRequest struct {
+	Role      string `json:"role"`
+	MaxUses   int    `json:"max_uses" validate:"min=0"`
+	ExpiresIn int    `json:"expires_in" validate:"min=0"`
+}
+
+// UserGroup is a set of members mentionable by handle, like @design. Members of a group
+// get the permissions of its Role on top of their own, and Managers can change who is
+// in it without holding groups.manage. Members and Managers are member ids.
+type UserGroup struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	OrgID       string             `json:"org_id" bson:"org_id"`
+	Handle      string             `json:"handle" bson:"handle"`
+	Name        string             `json:"name" bson:"name"`
+	Description string             `json:"description" bson:"description"`
+	Role        string             `json:"role" bson:"role"`
+	Members     []string           `json:"members" bson:"members"`
+	Managers    []string           `json:"managers" bson:"managers"`
+	Archived    bool               `json:"archived" bson:"archived"`
+	CreatedBy   string             `json:"created_by" bson:"created_by"`
+	CreatedAt   time.Time          `json:"created_at" bson:"created_at"`
+	UpdatedAt   time.Time          `json:"updated_at" bson:"updated_at"`
+	ArchivedAt  *time.Time         `json:"archived_at" bson:"archived_at"`
+}
+
+type CreateUserGroupRequest struct {
+	Handle      string   `json:"handle" validate:"required"`
+	Name        string   `json:"name" validate:"required,max=80"`
+	Description string   `json:"description" validate:"max=250"`
+	Role        string   `json:"role"`
+	Members     []string `json:"members"`
+	Managers    []string `json:"managers"`
+}
+
+type UpdateUserGroupRequest struct {
+	Handle      *string `json:"handle"`
+	Name        *string `json:"name" validate:"omitempty,max=80"`
+	Description *string `json:"description" validate:"omitempty,max=250"`
+	Role        *string `json:"role"`
+}
+
+type UserGroupMembersRequest struct {
+	MemberIDs []string `json:"member_ids" validate:"required,min=1"`
+}
+
+type UserGroupManagersRequest struct {
+	Managers []string `json:"managers"`
+}
+
+// UserGroupMessage is the plugin sync message of a change to a user group, MemberIDs
+// holds the members added or removed.
+type UserGroupMessage struct {
+	OrganizationID string   `json:"organization_id" bson:"organization_id"`
+	GroupID        string   `json:"group_id" bson:"group_id"`
+	Handle         string   `json:"handle" bson:"handle"`
+	MemberIDs      []string `json:"member_ids,omitempty" bson:"member_ids,omitempty"`
+}
+
+// InviteStatsResponse counts the invites of an organization per status.
+type InviteStatsResponse struct {
+	Pending  int64 `json:"pending"`
+	Accepted int64 `json:"accepted"`
+	Revoked  int64 `json:"revoked"`
+	Expired  int64 `json:"expired"`
+	Total    int64 `json:"total"`
 }
 type SendInviteResponse struct {
 	InvalidEmails []interface{}
@@ -163,6 +371,7 @@ type InstalledPlugin struct {
 
 type SendInviteBody struct {
 	Emails []string `json:"emails" bson:"emails"`
+	Role   string   `json:"role" bson:"role"`
 }
 
 type OrganizationAdmin struct {
@@ -232,6 +441,8 @@ type Member struct {
 	DeletedAt   time.Time `json:"deleted_at" bson:"deleted_at"`
 	Socials     []Social  `json:"socials" bson:"socials"`
 	Language    string    `json:"language" bson:"language"`
+	// ExternalID is the id a provisioning client such as a SCIM identity system knows the member by.
+	ExternalID string `json:"external_id,omitempty" bson:"external_id,omitempty"`
 }
 
 type Profile struct {
@@ -290,11 +501,32 @@ type OrganizationPreference struct {
 }
 
 type OrgAuthentication struct {
-	AuthenticationMethod                 map[string]interface{} `json:"authenticationmethod" bson:"authenticationmethod"`
+	AuthenticationMethod AuthenticationMethod `json:"authenticationmethod" bson:"authenticationmethod"`
+	// WorkspaceWideTwoFactorAuthentication{"required": true} blocks members without 2FA from org routes.
 	WorkspaceWideTwoFactorAuthentication map[string]interface{} `json:"workspacewidetwofactorauthentication" bson:"workspacewidetwofactorauthentication"`
 	SessionDuration                      string                 `json:"sessionduration" bson:"sessionduration"`
 	ForcedPasswordReset                  map[string]interface{} `json:"forcedpasswordreset" bson:"forcedpasswordreset"`
 	AutomaticallyOpen                    map[string]interface{} `json:"automaticallyopen" bson:"automaticallyopen"`
+	// PasswordPolicy tightens the global password policy for members of the organization.
+	PasswordPolicy *user.PasswordPolicy `json:"password_policy,omitempty" bson:"password_policy,omitempty"`
+}
+
+// AuthenticationMethod configures single sign-on for the organization. It is managed
+// through the /sso/saml endpoints rather than the authentication settings update.
+type AuthenticationMethod struct {
+	SAML *SAMLSettings `json:"saml,omitempty" bson:"saml,omitempty"`
+	// SSORequired blocks password login for members while SAML is enabled.
+	SSORequired bool `json:"sso_required" bson:"sso_required"`
+}
+
+// SAMLSettings holds the organization's identity provider and how its assertions map to members.
+type SAMLSettings struct {
+	Enabled          bool                 `json:"enabled" bson:"enabled"`
+	IdentityProvider sso.IdentityProvider `json:"identity_provider" bson:"identity_provider"`
+	// AttributeMapping maps member fields, e.g. "first_name", to the SAML attribute that fills them.
+	AttributeMapping map[string]string `json:"attribute_mapping" bson:"attribute_mapping"`
+	// JITProvisioning creates accounts and memberships for unknown users on first sign in.
+	JITProvisioning bool `json:"jit_provisioning" bson:"jit_provisioning"`
 }
 
 type OrgSettings struct {
@@ -310,11 +542,14 @@ type OrgSettings struct {
 }
 
 type OrgPermissions struct {
-	Messaging         map[string]interface{} `json:"messaging" bson:"messaging"`
-	Invitations       bool                   `json:"invitations" bson:"invitations"`
-	MessageSettings   MessageSettings        `json:"messagesettings" bson:"messagesettings"`
-	CustomEmoji       map[string]interface{} `json:"customemoji" bson:"customemoji"`
-	PublicFileSharing bool                   `json:"publicfilesharing" bson:"publicfilesharing"`
+	Messaging map[string]interface{} `json:"messaging" bson:"messaging"`
+	// Invitations lets people join without an email invite: through invite links, and
+	// when their verified email address is at one of AllowedEmailDomains.
+	Invitations         bool                   `json:"invitations" bson:"invitations"`
+	AllowedEmailDomains []string               `json:"allowed_email_domains" bson:"allowed_email_domains"`
+	MessageSettings     MessageSettings        `json:"messagesettings" bson:"messagesettings"`
+	CustomEmoji         map[string]interface{} `json:"customemoji" bson:"customemoji"`
+	PublicFileSharing   bool                   `json:"publicfilesharing" bson:"publicfilesharing"`
 }
 
 type MessageSettings struct {
@@ -5,7 +5,6 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
-	"os"
 	"strings"
 	"time"
 
@@ -14,6 +13,7 @@ import (
 	"github.com/mitchellh/mapstructure"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"zuri.chat/zccore/audit"
 	"zuri.chat/zccore/auth"
 	"zuri.chat/zccore/logger"
 	"zuri.chat/zccore/service"
@@ -62,6 +62,14 @@ func (oh *OrganizationHandler) GetOrganizationByURL(w http.ResponseWriter, r *ht
 	orgURL := mux.Vars(r)["url"]
 	data, err := utils.GetMongoDBDoc(OrganizationCollectionName, bson.M{"workspace_url": orgURL})
 
+	// a renamed workspace is still found by its previous url for a while
+	if data == nil {
+		if redirect, _ := activeRedirect(r.Context(), strings.ToLower(orgURL)); redirect != nil {
+			objID, _ := primitive.ObjectIDFromHex(redirect.OrgID)
+			data, err = utils.GetMongoDBDoc(OrganizationCollectionName, bson.M{"_id": objID})
+		}
+	}
+
 	if data == nil {
 		logger.Error("workspace with url %s doesn't exist!", orgURL)
 		utils.GetError(errors.New("organization does not exist"), http.StatusNotFound, w)
@@ -110,9 +118,17 @@ func (oh *OrganizationHandler) Create(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	newOrg.Name = strings.TrimSpace(newOrg.Name)
+	if newOrg.Name == "" {
+		newOrg.Name = "Zuri Chat"
+	}
+
 	// generate workspace url
-	newOrg.Name = "Zuri Chat"
-	newOrg.WorkspaceURL = utils.GenWorkspaceURL(newOrg.Name)
+	newOrg.WorkspaceURL, err = freeWorkspaceURL(r.Context(), newOrg.Name)
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
 
 	userEmail := strings.ToLower(newOrg.CreatorEmail)
 	userName := strings.Split(userEmail, "@")[0]
@@ -170,11 +186,14 @@ func (oh *OrganizationHandler) Create(w http.ResponseWriter, r *http.Request) {
 
 	// add new member to member collection
 	coll := utils.GetCollection(MemberCollectionName)
-	if _, err = coll.InsertOne(r.Context(), newMember); err != nil {
+	res, err := coll.InsertOne(r.Context(), newMember)
+	if err != nil {
 		utils.GetError(err, http.StatusInternalServerError, w)
 		return
 	}
 
+	refreshSearchKeys(r.Context(), bson.M{"_id": res.InsertedID})
+
 	// add organisation id to user organisations list
 	updateFields := make(map[string]interface{})
 
@@ -204,48 +223,6 @@ func (oh *OrganizationHandler) GetOrganizations(w http.ResponseWriter, r *http.R
 	utils.GetSuccess("organizations retrieved successfully", save, w)
 }
 
-// Delete an organization record.
-func (oh *OrganizationHandler) DeleteOrganization(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-
-	orgID := mux.Vars(r)["id"]
-	response, err := utils.DeleteOneMongoDBDoc(OrganizationCollectionName, orgID)
-
-	if err != nil {
-		utils.GetError(err, http.StatusInternalServerError, w)
-		return
-	}
-
-	if response.DeletedCount == 0 {
-		utils.GetError(errors.New("operation failed"), http.StatusInternalServerError, w)
-		return
-	}
-	filter := bson.M{"org_id": orgID}
-	response1, err2 := utils.DeleteManyMongoDBDoc(MemberCollectionName,filter)
-
-	if err2 != nil {
-		utils.GetError(err, http.StatusInternalServerError, w)
-		return
-	}
-
-	if response1.DeletedCount == 0 {
-		utils.GetError(errors.New("operation failed"), http.StatusInternalServerError, w)
-		return
-	}
-
-	utils.GetSuccess("organization deleted successfully", nil, w)
-}
-
-// Update an organization workspace url.
-func (oh *OrganizationHandler) UpdateURL(w http.ResponseWriter, r *http.Request) {
-	OrganizationUpdate(w, r, updateParam{
-		orgFilterKey:   "workspace_url",
-		requestDataKey: "url",
-		eventKey:       UpdateOrganizationName,
-		successMessage: "organization url",
-	})
-}
-
 // Update organization name.
 func (oh *OrganizationHandler) UpdateName(w http.ResponseWriter, r *http.Request) {
 	OrganizationUpdate(w, r, updateParam{
@@ -256,103 +233,6 @@ func (oh *OrganizationHandler) UpdateName(w http.ResponseWriter, r *http.Request
 	})
 }
 
-// Transfer workspace ownership.
-func (oh *OrganizationHandler) TransferOwnership(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-
-	orgID := mux.Vars(r)["id"]
-
-	// Checks if organization id is valid
-	orgIDHex, err := primitive.ObjectIDFromHex(orgID)
-	if err != nil {
-		utils.GetError(errors.New("invalid organization id"), http.StatusBadRequest, w)
-		return
-	}
-
-	// Checks if organization exists in the database
-	orgDoc, _ := utils.GetMongoDBDoc(OrganizationCollectionName, bson.M{"_id": orgIDHex})
-	if orgDoc == nil {
-		utils.GetError(errors.New("organization does not exist"), http.StatusBadRequest, w)
-		return
-	}
-
-	requestData := make(map[string]string)
-	if err = utils.ParseJSONFromRequest(r, &requestData); err != nil {
-		utils.GetError(err, http.StatusUnprocessableEntity, w)
-		return
-	}
-
-	// email is that of the proposed new owner
-	email := requestData["email"]
-
-	// confirms if email supplied is valid
-	if !utils.IsValidEmail(strings.ToLower(email)) {
-		utils.GetError(errors.New("email is not valid"), http.StatusBadRequest, w)
-		return
-	}
-
-	// fetches the details of the proposed new owner patterned after member's struct
-	orgMember, err := FetchMember(bson.M{"org_id": orgID, "email": email})
-
-	if err != nil {
-		utils.GetError(errors.New("user not a member of this work space"), http.StatusBadRequest, w)
-		return
-	}
-
-	// checks if proposed owner does not have an ownership status already
-	if orgMember.Role == "owner" {
-		utils.GetError(errors.New("this member already owns this organization"), http.StatusBadRequest, w)
-		return
-	}
-
-	// member ID of the proposed new owner
-	memberID := orgMember.ID
-
-	// upgrades status from member to owner
-	updateRes, err := utils.UpdateOneMongoDBDoc(MemberCollectionName, memberID, bson.M{"role": OwnerRole})
-
-	if err != nil {
-		utils.GetError(errors.New("operation failed"), http.StatusInternalServerError, w)
-		return
-	}
-
-	if updateRes.ModifiedCount == 0 {
-		utils.GetError(errors.New("could not upgrade member's role"), http.StatusInternalServerError, w)
-		return
-	}
-
-	// fetches details of the former owner so we can get keys to downgrade status to member
-	// checks like isOwner and memberExists are not made since auth.IsAuthorized function already
-	// this user pass marks
-
-	loggedInUser, ok := r.Context().Value("user").(*auth.AuthUser)
-	if !ok {
-		utils.GetError(errors.New("invalid user"), http.StatusBadRequest, w)
-		return
-	}
-
-	formerOwner, _ := FetchMember(bson.M{"org_id": orgID, "email": loggedInUser.Email})
-
-	// ID of former owner
-	formerOwnerID := formerOwner.ID
-
-	// role downgraded from owner to member
-	update, err := utils.UpdateOneMongoDBDoc(MemberCollectionName, formerOwnerID, bson.M{"role": AdminRole})
-
-	if err != nil {
-		utils.GetError(errors.New("operation failed"), http.StatusInternalServerError, w)
-		return
-	}
-
-	if update.ModifiedCount == 0 {
-		utils.GetError(errors.New("could not downgrade owner's role"), http.StatusInternalServerError, w)
-		return
-	}
-
-	// and we are done!!!
-	utils.GetSuccess("workspace owner changed successfully", nil, w)
-}
-
 // Update organization logo.
 func (oh *OrganizationHandler) UpdateLogo(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("content-Type", "application/json")
@@ -366,6 +246,7 @@ func (oh *OrganizationHandler) UpdateLogo(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	before := orgSnapshot(orgID, "logo_url")
 	uploadPath := "logo/" + orgID
 
 	imgURL, err := service.ProfileImageUpload(uploadPath, logoWidth, logoHeight, r)
@@ -391,10 +272,12 @@ func (oh *OrganizationHandler) UpdateLogo(w http.ResponseWriter, r *http.Request
 
 	go utils.Emitter(event)
 
+	recordOrgChange(r, orgID, audit.ActionOrgUpdate, before, bson.M{"logo_url": imgURL})
+
 	utils.GetSuccess("Logo updated successfully", imgURL, w)
 }
 
-// Send invite to a list of emails.
+// Send invite to a list of emails, guests join with the role they are invited with.
 func (oh *OrganizationHandler) SendInvite(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
@@ -426,9 +309,16 @@ func (oh *OrganizationHandler) SendInvite(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	role, err := inviteRole(r, sOrgID, guests.Role)
+	if err != nil {
+		utils.GetError(err, http.StatusForbidden, w)
+		return
+	}
+
 	var invalidEmails []interface{}
 
-	inviteIDs := make([]interface{}, len(guests.Emails))
+	inviteIDs := make([]interface{}, 0, len(guests.Emails))
+	orgName := fmt.Sprintf("%v", org["name"])
 
 	for _, email := range guests.Emails {
 		// Check the validity of email send
@@ -437,47 +327,18 @@ func (oh *OrganizationHandler) SendInvite(w http.ResponseWriter, r *http.Request
 			invalidEmails = append(invalidEmails, email)
 			continue
 		}
-		// Generate new UUI for invite and
-		uuid := utils.GenUUID()
-
-		newInvite := Invite{OrgID: sOrgID, UUID: uuid, Email: email, HasAccepted: false}
-
-		var invInterface map[string]interface{}
-
-		inrec, _ := json.Marshal(newInvite)
-		err = json.Unmarshal(inrec, &invInterface)
 
+		inviteID, err := oh.createInvite(r.Context(), sOrgID, orgName, loggedInUser.Email, email, role, nil)
 		if err != nil {
 			utils.GetError(err, http.StatusInternalServerError, w)
 			return
 		}
 
-		// Save newly generated uuid and associated info in the database
-		save, err := utils.CreateMongoDBDoc(OrganizationInviteCollectionName, invInterface)
-		if err != nil {
-			utils.GetError(err, http.StatusInternalServerError, w)
-
-			return
-		}
-
 		// Append new invite to array of generated invites
-		inviteIDs = append(inviteIDs, save.InsertedID)
-
-		// Parse data for customising email template
-		
-		inviteLink := fmt.Sprintf("%s/%s", os.Getenv("INVITE_DOMAIN"), uuid)
-		orgName := fmt.Sprintf("%v", org["name"])
-
-		msger := oh.mailService.NewMail(
-			[]string{email}, "Zuri Chat Workspace Invite", service.WorkSpaceInvite, map[string]interface{}{
-				"Username":   loggedInUser.Email,
-				"OrgName":    orgName,
-				"InviteLink": inviteLink,
-			})
-		// error with sending main
-		if err := oh.mailService.SendMail(msger); err != nil {
-			logger.Error("Error occurred while sending mail: %s", err.Error())
-		}
+		inviteIDs = append(inviteIDs, inviteID)
+
+		audit.Record(r, audit.Event{OrgID: sOrgID, Action: audit.ActionMemberInvite, TargetType: audit.TargetInvite, TargetID: email,
+			After: map[string]interface{}{"role": role}})
 	}
 
 	response := SendInviteResponse{InvalidEmails: invalidEmails, InviteIDs: inviteIDs}
@@ -485,18 +346,22 @@ func (oh *OrganizationHandler) SendInvite(w http.ResponseWriter, r *http.Request
 	utils.GetSuccess("Organization invite operation result", response, w)
 }
 
-// Get invite records of an organization.
+// Count the invites of an organization per status.
 func (oh *OrganizationHandler) InviteStats(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
 	orgID := mux.Vars(r)["id"]
+	now := time.Now()
 
-	invites, err := utils.GetMongoDBDocs(OrganizationInviteCollectionName, bson.M{"org_id": orgID})
-	if err != nil {
-		utils.GetError(err, http.StatusNotFound, w)
+	stats := InviteStatsResponse{
+		Pending:  utils.CountCollection(r.Context(), OrganizationInviteCollectionName, inviteStatusFilter(orgID, InvitePending, now)),
+		Accepted: utils.CountCollection(r.Context(), OrganizationInviteCollectionName, inviteStatusFilter(orgID, InviteAccepted, now)),
+		Revoked:  utils.CountCollection(r.Context(), OrganizationInviteCollectionName, inviteStatusFilter(orgID, InviteRevoked, now)),
+		Expired:  utils.CountCollection(r.Context(), OrganizationInviteCollectionName, inviteStatusFilter(orgID, InviteExpired, now)),
 	}
+	stats.Total = stats.Pending + stats.Accepted + stats.Revoked + stats.Expired
 
-	utils.GetSuccess("successful", invites, w)
+	utils.GetSuccess("successful", stats, w)
 }
 
 // Upgrade services to Pro.
@@ -535,6 +400,8 @@ func (oh *OrganizationHandler) UpgradeToPro(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	recordOrgChange(r, orgID, audit.ActionOrgUpgrade, bson.M{"version": FreeVersion}, updateData)
+
 	utils.GetSuccess("Organization successfully updated to pro", nil, w)
 }
 
@@ -648,6 +515,8 @@ func (oh *OrganizationHandler) UpdateOrganizationSettings(w http.ResponseWriter,
 		return
 	}
 
+	recordOrgChange(r, orgID, audit.ActionOrgSettingsUpdate, bson.M{"settings": org.Settings}, orgFilter)
+
 	utils.GetSuccess("organization settings updated successfully", nil, w)
 }
 
@@ -665,6 +534,12 @@ func (oh *OrganizationHandler) UpdateOrganizationPermission(w http.ResponseWrite
 		return
 	}
 
+	orgPermissions.AllowedEmailDomains, err = normalizeEmailDomains(orgPermissions.AllowedEmailDomains)
+	if err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
 	objID, err := primitive.ObjectIDFromHex(orgID)
 	if err != nil {
 		utils.GetError(err, http.StatusUnprocessableEntity, w)
@@ -718,6 +593,8 @@ func (oh *OrganizationHandler) UpdateOrganizationPermission(w http.ResponseWrite
 		return
 	}
 
+	recordOrgChange(r, orgID, audit.ActionOrgSettingsUpdate, bson.M{"settings": org.Settings}, orgFilter)
+
 	utils.GetSuccess("organization settings updated successfully", nil, w)
 }
 
@@ -744,6 +621,11 @@ func (oh *OrganizationHandler) UpdateOrganizationAuthentication(w http.ResponseW
 
 	validate := validator.New()
 
+	if err = validate.Struct(orgAuthentication); err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
 	// get previous settings
 	save, _ := utils.GetMongoDBDoc(OrganizationCollectionName, bson.M{"_id": objID})
 
@@ -768,6 +650,10 @@ func (oh *OrganizationHandler) UpdateOrganizationAuthentication(w http.ResponseW
 		utils.GetError(err, http.StatusBadRequest, w)
 		return
 	}
+
+	// single sign-on is managed through the saml endpoints
+	orgAuthentication.AuthenticationMethod = org.Settings.Authentication.AuthenticationMethod
+
 	// adds new settings with existing settings
 	orgPref := OrganizationPreference{
 		org.Settings.Settings,
@@ -789,6 +675,8 @@ func (oh *OrganizationHandler) UpdateOrganizationAuthentication(w http.ResponseW
 		return
 	}
 
+	recordOrgChange(r, orgID, audit.ActionOrgSettingsUpdate, bson.M{"settings": org.Settings}, orgFilter)
+
 	utils.GetSuccess("organization settings updated successfully", nil, w)
 }
 
@@ -860,6 +748,8 @@ func (oh *OrganizationHandler) UpdateOrganizationPrefixes(w http.ResponseWriter,
 		return
 	}
 
+	recordOrgChange(r, orgID, audit.ActionOrgSettingsUpdate, bson.M{"customize": org.Customize}, orgFilter)
+
 	utils.GetSuccess("organization channelprefixes updated successfully", nil, w)
 }
 
@@ -931,6 +821,8 @@ func (oh *OrganizationHandler) UpdateSlackBotResponses(w http.ResponseWriter, r
 		return
 	}
 
+	recordOrgChange(r, orgID, audit.ActionOrgSettingsUpdate, bson.M{"customize": org.Customize}, orgFilter)
+
 	utils.GetSuccess("organization slackbotresponse updated successfully", nil, w)
 }
 
@@ -1001,5 +893,7 @@ func (oh *OrganizationHandler) AddSlackCustomEmoji(w http.ResponseWriter, r *htt
 		return
 	}
 
+	recordOrgChange(r, orgID, audit.ActionOrgSettingsUpdate, bson.M{"customize": org.Customize}, orgFilter)
+
 	utils.GetSuccess("organization customemoji updated successfully", nil, w)
 }
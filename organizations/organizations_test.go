@@ -2,13 +2,17 @@ package organizations
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"zuri.chat/zccore/auth"
+	"zuri.chat/zccore/service"
 	"zuri.chat/zccore/utils"
 )
 
@@ -161,10 +165,10 @@ func TestDeleteOrganization(t *testing.T) {
 
 		response := getHTTPResponse(t, r, req)
 
-		assertStatusCode(t, response.Code, http.StatusInternalServerError)
+		assertStatusCode(t, response.Code, http.StatusBadRequest)
 	})
-	
-	t.Run("test can delete organization", func(t *testing.T) {
+
+	t.Run("test deletion needs the owner", func(t *testing.T) {
 		id, err := setUpOrganization()
 		if err != nil{
 			t.Fail()
@@ -176,7 +180,53 @@ func TestDeleteOrganization(t *testing.T) {
 		
 		response := getHTTPResponse(t, r, req)
 
+		assertStatusCode(t, response.Code, http.StatusForbidden)
+	})
+
+	t.Run("test deletion is scheduled and can be cancelled", func(t *testing.T) {
+		id, err := setUpOrganization()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		oh := NewOrganizationHandler(configs, service.NewZcMailService(configs))
+		owner := &auth.AuthUser{Email: defaultUser, Role: OwnerRole}
+
+		r := getRouter()
+		r.HandleFunc("/organizations/{id}", oh.DeleteOrganization).Methods("DELETE")
+		r.HandleFunc("/organizations/{id}/deletion/cancel", oh.CancelOrganizationDeletion).Methods("POST")
+
+		req, _ := http.NewRequest("DELETE", fmt.Sprintf("/organizations/%s", id), bytes.NewBufferString(`{"name": "Zuri Chat"}`))
+		req = req.WithContext(context.WithValue(req.Context(), "user", owner))
+
+		response := getHTTPResponse(t, r, req)
+		assertStatusCode(t, response.Code, http.StatusOK)
+
+		objID, _ := primitive.ObjectIDFromHex(id)
+
+		var org Organization
+		if err := utils.GetCollection(OrganizationCollectionName).FindOne(context.TODO(), bson.M{"_id": objID}).Decode(&org); err != nil {
+			t.Fatalf("organization should be kept until its grace period ends: %v", err)
+		}
+
+		if org.DeletionScheduledAt == nil || !org.DeletionScheduledAt.After(time.Now()) {
+			t.Errorf("expected the deletion to be scheduled, got %v", org.DeletionScheduledAt)
+		}
+
+		req, _ = http.NewRequest("POST", fmt.Sprintf("/organizations/%s/deletion/cancel", id), nil)
+		req = req.WithContext(context.WithValue(req.Context(), "user", owner))
+
+		response = getHTTPResponse(t, r, req)
 		assertStatusCode(t, response.Code, http.StatusOK)
+
+		org = Organization{}
+		if err := utils.GetCollection(OrganizationCollectionName).FindOne(context.TODO(), bson.M{"_id": objID}).Decode(&org); err != nil {
+			t.Fatal(err)
+		}
+
+		if org.DeletionScheduledAt != nil {
+			t.Errorf("expected the deletion to be cancelled, got %v", org.DeletionScheduledAt)
+		}
 	})
 }
 
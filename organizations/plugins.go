@@ -14,6 +14,8 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 
+	"zuri.chat/zccore/audit"
+	"zuri.chat/zccore/auth"
 	"zuri.chat/zccore/logger"
 	"zuri.chat/zccore/utils"
 )
@@ -69,7 +71,7 @@ func (oh *OrganizationHandler) AddOrganizationPlugin(w http.ResponseWriter, r *h
 		return
 	}
 
-	if member.Role != OwnerRole && member.Role != AdminRole {
+	if !auth.RoleHasPermission(r.Context(), OrgID, member.Role, auth.PermPluginsInstall) {
 		utils.GetError(errors.New("access denied"), http.StatusForbidden, w)
 		return
 	}
@@ -161,6 +163,8 @@ func (oh *OrganizationHandler) AddOrganizationPlugin(w http.ResponseWriter, r *h
 		"plugin_id": orgPlugin.PluginID,
 	}
 
+	audit.Record(r, audit.Event{OrgID: OrgID, Action: audit.ActionPluginInstall, TargetType: audit.TargetPlugin, TargetID: orgPlugin.PluginID})
+
 	utils.GetSuccess("plugin saved successfully", data, w)
 }
 
@@ -279,7 +283,7 @@ func (oh *OrganizationHandler) RemoveOrganizationPlugin(w http.ResponseWriter, r
 		return
 	}
 
-	if member.Role != OwnerRole && member.Role != AdminRole {
+	if !auth.RoleHasPermission(r.Context(), orgID, member.Role, auth.PermPluginsInstall) {
 		utils.GetError(errors.New("access denied"), http.StatusForbidden, w)
 		return
 	}
@@ -338,5 +342,7 @@ func (oh *OrganizationHandler) RemoveOrganizationPlugin(w http.ResponseWriter, r
 		return
 	}
 
+	audit.Record(r, audit.Event{OrgID: orgID, Action: audit.ActionPluginRemove, TargetType: audit.TargetPlugin, TargetID: pluginID})
+
 	utils.GetSuccess("plugin removed successfully", nil, w)
 }
@@ -0,0 +1,656 @@
+package organizations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"zuri.chat/zccore/audit"
+	"zuri.chat/zccore/auth"
+	"zuri.chat/zccore/sso"
+	"zuri.chat/zccore/user"
+	"zuri.chat/zccore/utils"
+)
+
+const (
+	SAMLRequestCollectionName   = "saml_requests"
+	SAMLAssertionCollectionName = "saml_assertions"
+
+	samlRequestTTL     = 10 * time.Minute
+	samlProviderPrefix = "saml:"
+	samlEmailField     = "email"
+)
+
+var (
+	ErrSAMLNotConfigured     = errors.New("saml single sign-on is not enabled for this organization")
+	ErrSAMLMetadataRequired  = errors.New("provide the identity provider metadata or identity_provider settings")
+	ErrSAMLAttributeMapping  = errors.New("attribute_mapping can only map email, first_name, last_name, display_name, phone, pronouns, time_zone and bio")
+	ErrSSORequiresSAML       = errors.New("single sign-on can only be required while saml is enabled")
+	ErrSSORedirectURI        = errors.New("redirect_uri is not an allowed single sign-on destination")
+	ErrSAMLResponseMissing   = errors.New("SAMLResponse not provided")
+	ErrSAMLRequest           = errors.New("sign in request has expired or was not issued by this organization, kindly sign in again")
+	ErrSAMLReplay            = errors.New("this sign in response has already been used")
+	ErrSAMLEmail             = errors.New("identity provider did not send a valid email address")
+	ErrSSONotMember          = errors.New("you are not a member of this organization, kindly ask an admin for an invite")
+	ErrSSOAccountConflict    = errors.New("an account with this email already exists, kindly ask an admin to add it to the organization")
+	ErrSSOAccountDeactivated = errors.New("this account or membership has been deactivated")
+)
+
+// samlMemberFields lists the member fields identity provider attributes can fill.
+var samlMemberFields = map[string]bool{
+	"first_name":   true,
+	"last_name":    true,
+	"display_name": true,
+	"phone":        true,
+	"pronouns":     true,
+	"time_zone":    true,
+	"bio":          true,
+}
+
+// SAMLRequest remembers an SP-initiated login until the identity provider answers it.
+type SAMLRequest struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty"`
+	RequestID   string             `bson:"request_id"`
+	OrgID       string             `bson:"org_id"`
+	RedirectURI string             `bson:"redirect_uri"`
+	ExpiresAt   time.Time          `bson:"expires_at"`
+	CreatedAt   time.Time          `bson:"created_at"`
+}
+
+// SAMLAssertionRecord remembers an accepted assertion until it expires, so it can't be replayed.
+type SAMLAssertionRecord struct {
+	AssertionID string    `bson:"assertion_id"`
+	OrgID       string    `bson:"org_id"`
+	ExpiresAt   time.Time `bson:"expires_at"`
+}
+
+type SAMLSettingsRequest struct {
+	// Metadata is the identity provider metadata XML. IdentityProvider can be sent instead.
+	Metadata         string                `json:"metadata"`
+	IdentityProvider *sso.IdentityProvider `json:"identity_provider"`
+	AttributeMapping map[string]string     `json:"attribute_mapping"`
+	JITProvisioning  bool                  `json:"jit_provisioning"`
+	Enabled          bool                  `json:"enabled"`
+	SSORequired      bool                  `json:"sso_required"`
+}
+
+type SAMLServiceProviderInfo struct {
+	EntityID    string `json:"entity_id"`
+	ACSURL      string `json:"acs_url"`
+	MetadataURL string `json:"metadata_url"`
+	LoginURL    string `json:"login_url"`
+}
+
+type SAMLSettingsResponse struct {
+	SAML             *SAMLSettings           `json:"saml"`
+	SSORequired      bool                    `json:"sso_required"`
+	Certificates     []sso.CertificateInfo   `json:"certificates"`
+	CertificateError string                  `json:"certificate_error,omitempty"`
+	ServiceProvider  SAMLServiceProviderInfo `json:"service_provider"`
+}
+
+// Get the SAML single sign-on settings of an organization.
+func (oh *OrganizationHandler) GetSAMLSettings(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	orgID := mux.Vars(r)["id"]
+
+	method, err := ssoSettings(r.Context(), orgID)
+	if err != nil {
+		writeSSOSettingsError(w, orgID, err)
+		return
+	}
+
+	utils.GetSuccess("saml settings retrieved successfully", oh.samlSettingsResponse(orgID, method), w)
+}
+
+// Configure SAML single sign-on from the identity provider metadata.
+func (oh *OrganizationHandler) UpdateSAMLSettings(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	orgID := mux.Vars(r)["id"]
+
+	objID, err := primitive.ObjectIDFromHex(orgID)
+	if err != nil {
+		utils.GetError(errors.New("invalid id"), http.StatusBadRequest, w)
+		return
+	}
+
+	var req SAMLSettingsRequest
+	if err = utils.ParseJSONFromRequest(r, &req); err != nil {
+		utils.GetError(err, http.StatusUnprocessableEntity, w)
+		return
+	}
+
+	idp := req.IdentityProvider
+
+	if strings.TrimSpace(req.Metadata) != "" {
+		if idp, err = sso.ParseIdPMetadata([]byte(req.Metadata)); err != nil {
+			utils.GetError(err, http.StatusBadRequest, w)
+			return
+		}
+	}
+
+	if idp == nil {
+		utils.GetError(ErrSAMLMetadataRequired, http.StatusBadRequest, w)
+		return
+	}
+
+	if err = validator.New().Struct(idp); err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	if _, err = idp.Validate(time.Now()); err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	for field, attr := range req.AttributeMapping {
+		if (field != samlEmailField && !samlMemberFields[field]) || strings.TrimSpace(attr) == "" {
+			utils.GetError(ErrSAMLAttributeMapping, http.StatusBadRequest, w)
+			return
+		}
+	}
+
+	if req.SSORequired && !req.Enabled {
+		utils.GetError(ErrSSORequiresSAML, http.StatusBadRequest, w)
+		return
+	}
+
+	method := AuthenticationMethod{
+		SAML: &SAMLSettings{
+			Enabled:          req.Enabled,
+			IdentityProvider: *idp,
+			AttributeMapping: req.AttributeMapping,
+			JITProvisioning:  req.JITProvisioning,
+		},
+		SSORequired: req.SSORequired,
+	}
+
+	before, _ := ssoSettings(r.Context(), orgID)
+
+	res, err := utils.GetCollection(OrganizationCollectionName).UpdateOne(r.Context(), bson.M{"_id": objID},
+		bson.M{"$set": bson.M{"settings.authentication.authenticationmethod": method}})
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	if res.MatchedCount == 0 {
+		utils.GetError(fmt.Errorf("organization %s not found", orgID), http.StatusNotFound, w)
+		return
+	}
+
+	recordOrgChange(r, orgID, audit.ActionSSOUpdate, bson.M{"sso": before}, bson.M{"sso": method})
+
+	utils.GetSuccess("saml settings updated successfully", oh.samlSettingsResponse(orgID, &method), w)
+}
+
+// Remove SAML single sign-on from an organization. Linked identities stay on the
+// users so the settings can be restored without relinking.
+func (oh *OrganizationHandler) DeleteSAMLSettings(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	orgID := mux.Vars(r)["id"]
+
+	objID, err := primitive.ObjectIDFromHex(orgID)
+	if err != nil {
+		utils.GetError(errors.New("invalid id"), http.StatusBadRequest, w)
+		return
+	}
+
+	res, err := utils.GetCollection(OrganizationCollectionName).UpdateOne(r.Context(), bson.M{"_id": objID},
+		bson.M{"$set": bson.M{"settings.authentication.authenticationmethod": AuthenticationMethod{}}})
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	if res.MatchedCount == 0 {
+		utils.GetError(fmt.Errorf("organization %s not found", orgID), http.StatusNotFound, w)
+		return
+	}
+
+	audit.Record(r, audit.Event{OrgID: orgID, Action: audit.ActionSSODelete, TargetType: audit.TargetOrganization, TargetID: orgID})
+
+	utils.GetSuccess("saml settings removed successfully", nil, w)
+}
+
+// Serve the service provider metadata to register with the identity provider.
+func (oh *OrganizationHandler) SAMLMetadata(w http.ResponseWriter, r *http.Request) {
+	orgID := mux.Vars(r)["id"]
+
+	if _, err := ssoSettings(r.Context(), orgID); err != nil {
+		writeSSOSettingsError(w, orgID, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/samlmetadata+xml")
+
+	if _, err := w.Write(oh.serviceProvider(orgID).Metadata()); err != nil {
+		log.Printf("saml metadata: %v", err)
+	}
+}
+
+// Start SP-initiated single sign-on by redirecting to the identity provider.
+func (oh *OrganizationHandler) SAMLLogin(w http.ResponseWriter, r *http.Request) {
+	orgID := mux.Vars(r)["id"]
+
+	redirectURI, ok := oh.ssoRedirectURI(r.URL.Query().Get("redirect_uri"))
+	if !ok {
+		utils.GetError(ErrSSORedirectURI, http.StatusBadRequest, w)
+		return
+	}
+
+	settings, ok := oh.enabledSAML(w, r, orgID)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+
+	location, requestID, err := oh.serviceProvider(orgID).AuthnRequestURL(&settings.IdentityProvider, "", now)
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	req := SAMLRequest{
+		RequestID:   requestID,
+		OrgID:       orgID,
+		RedirectURI: redirectURI,
+		ExpiresAt:   now.Add(samlRequestTTL),
+		CreatedAt:   now,
+	}
+
+	if _, err := utils.GetCollection(SAMLRequestCollectionName).InsertOne(r.Context(), req); err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	http.Redirect(w, r, location, http.StatusFound)
+}
+
+// Assertion consumer service: verify the identity provider's response, provision the
+// member and hand the client a one-time code to exchange for a session.
+func (oh *OrganizationHandler) SAMLAssertionConsumer(w http.ResponseWriter, r *http.Request) {
+	orgID := mux.Vars(r)["id"]
+
+	if err := r.ParseForm(); err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	encoded := r.PostFormValue("SAMLResponse")
+	if encoded == "" {
+		utils.GetError(ErrSAMLResponseMissing, http.StatusBadRequest, w)
+		return
+	}
+
+	settings, ok := oh.enabledSAML(w, r, orgID)
+	if !ok {
+		return
+	}
+
+	assertion, err := oh.serviceProvider(orgID).ParseResponse(&settings.IdentityProvider, encoded, time.Now())
+	if err != nil {
+		utils.GetError(err, http.StatusUnauthorized, w)
+		return
+	}
+
+	redirectURI, err := oh.samlRedirectURI(r.Context(), orgID, assertion, r.PostFormValue("RelayState"))
+	if err != nil {
+		utils.GetError(err, http.StatusUnauthorized, w)
+		return
+	}
+
+	if err = rememberAssertion(r.Context(), orgID, assertion); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, ErrSAMLReplay) {
+			status = http.StatusUnauthorized
+		}
+
+		utils.GetError(err, status, w)
+
+		return
+	}
+
+	u, err := provisionSAMLUser(r.Context(), orgID, settings, assertion)
+	if err != nil {
+		status := http.StatusInternalServerError
+
+		switch {
+		case errors.Is(err, ErrSAMLEmail):
+			status = http.StatusBadRequest
+		case errors.Is(err, ErrSSONotMember), errors.Is(err, ErrSSOAccountConflict), errors.Is(err, ErrSSOAccountDeactivated):
+			status = http.StatusForbidden
+		}
+
+		utils.GetError(err, status, w)
+
+		return
+	}
+
+	code, err := auth.IssueSSOHandoff(r.Context(), u.ID)
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	target, _ := url.Parse(redirectURI)
+	q := target.Query()
+	q.Set("code", code)
+	target.RawQuery = q.Encode()
+
+	http.Redirect(w, r, target.String(), http.StatusSeeOther)
+}
+
+// serviceProvider returns the SAML identity of zuri chat for one organization.
+func (oh *OrganizationHandler) serviceProvider(orgID string) sso.ServiceProvider {
+	base := oh.configs.ServerName + "/organizations/" + orgID + "/sso/saml"
+
+	return sso.ServiceProvider{EntityID: base + "/metadata", ACSURL: base + "/acs"}
+}
+
+func (oh *OrganizationHandler) samlSettingsResponse(orgID string, method *AuthenticationMethod) SAMLSettingsResponse {
+	sp := oh.serviceProvider(orgID)
+	resp := SAMLSettingsResponse{
+		SAML:        method.SAML,
+		SSORequired: method.SSORequired,
+		ServiceProvider: SAMLServiceProviderInfo{
+			EntityID:    sp.EntityID,
+			ACSURL:      sp.ACSURL,
+			MetadataURL: sp.EntityID,
+			LoginURL:    oh.configs.ServerName + "/organizations/" + orgID + "/sso/saml/login",
+		},
+	}
+
+	if method.SAML != nil {
+		infos, err := method.SAML.IdentityProvider.Validate(time.Now())
+		if err != nil {
+			resp.CertificateError = err.Error()
+		}
+
+		resp.Certificates = infos
+	}
+
+	return resp
+}
+
+// ssoRedirectURI returns the client page to send the sign in code to, defaulting to
+// the first configured one.
+func (oh *OrganizationHandler) ssoRedirectURI(uri string) (string, bool) {
+	if len(oh.configs.SSORedirectURIs) == 0 {
+		return "", false
+	}
+
+	if uri == "" {
+		return oh.configs.SSORedirectURIs[0], true
+	}
+
+	for _, allowed := range oh.configs.SSORedirectURIs {
+		if uri == allowed {
+			return uri, true
+		}
+	}
+
+	return "", false
+}
+
+// samlRedirectURI matches the response to the login request it answers. Responses
+// to no request come from IdP-initiated logins and may only name an allowed page.
+func (oh *OrganizationHandler) samlRedirectURI(ctx context.Context, orgID string, a *sso.Assertion, relayState string) (string, error) {
+	if a.InResponseTo == "" {
+		if uri, ok := oh.ssoRedirectURI(relayState); ok {
+			return uri, nil
+		}
+
+		if uri, ok := oh.ssoRedirectURI(""); ok {
+			return uri, nil
+		}
+
+		return "", ErrSSORedirectURI
+	}
+
+	var req SAMLRequest
+
+	// deleting on lookup makes every request answerable once
+	err := utils.GetCollection(SAMLRequestCollectionName).
+		FindOneAndDelete(ctx, bson.M{"request_id": a.InResponseTo, "org_id": orgID}).
+		Decode(&req)
+	if err != nil || time.Now().After(req.ExpiresAt) {
+		return "", ErrSAMLRequest
+	}
+
+	return req.RedirectURI, nil
+}
+
+// rememberAssertion records the assertion ID and rejects IDs that were seen before.
+func rememberAssertion(ctx context.Context, orgID string, a *sso.Assertion) error {
+	coll := utils.GetCollection(SAMLAssertionCollectionName)
+
+	//nolint:errcheck //CODEI8: best effort cleanup
+	coll.DeleteMany(ctx, bson.M{"expires_at": bson.M{"$lt": time.Now()}})
+
+	_, err := coll.InsertOne(ctx, SAMLAssertionRecord{
+		AssertionID: orgID + ":" + a.ID,
+		OrgID:       orgID,
+		ExpiresAt:   a.NotOnOrAfter.Add(sso.MaxClockSkew),
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrSAMLReplay
+	}
+
+	return err
+}
+
+// enabledSAML loads the SAML settings of the organization, writing the error response
+// if SAML is not enabled.
+func (oh *OrganizationHandler) enabledSAML(w http.ResponseWriter, r *http.Request, orgID string) (*SAMLSettings, bool) {
+	method, err := ssoSettings(r.Context(), orgID)
+	if err != nil {
+		writeSSOSettingsError(w, orgID, err)
+		return nil, false
+	}
+
+	if method.SAML == nil || !method.SAML.Enabled {
+		utils.GetError(ErrSAMLNotConfigured, http.StatusNotFound, w)
+		return nil, false
+	}
+
+	return method.SAML, true
+}
+
+func ssoSettings(ctx context.Context, orgID string) (*AuthenticationMethod, error) {
+	objID, err := primitive.ObjectIDFromHex(orgID)
+	if err != nil {
+		return nil, err
+	}
+
+	var org struct {
+		Settings struct {
+			Authentication struct {
+				AuthenticationMethod AuthenticationMethod `bson:"authenticationmethod"`
+			} `bson:"authentication"`
+		} `bson:"settings"`
+	}
+
+	err = utils.GetCollection(OrganizationCollectionName).FindOne(ctx, bson.M{"_id": objID}).Decode(&org)
+	if err != nil {
+		return nil, err
+	}
+
+	return &org.Settings.Authentication.AuthenticationMethod, nil
+}
+
+func writeSSOSettingsError(w http.ResponseWriter, orgID string, err error) {
+	switch {
+	case errors.Is(err, mongo.ErrNoDocuments):
+		utils.GetError(fmt.Errorf("organization %s not found", orgID), http.StatusNotFound, w)
+	case errors.Is(err, primitive.ErrInvalidHex):
+		utils.GetError(errors.New("invalid id"), http.StatusBadRequest, w)
+	default:
+		utils.GetError(err, http.StatusInternalServerError, w)
+	}
+}
+
+// provisionSAMLUser finds or, with just-in-time provisioning, creates the account and
+// membership for a verified assertion. Accounts that already exist are only matched
+// by email when they are members of the organization, since the identity provider
+// only speaks for the organization's own members.
+func provisionSAMLUser(ctx context.Context, orgID string, settings *SAMLSettings, a *sso.Assertion) (*user.User, error) {
+	provider := samlProviderPrefix + orgID
+	fields := samlAttributes(settings, a)
+
+	email := a.NameID
+	if attr := settings.AttributeMapping[samlEmailField]; attr != "" {
+		email = a.Attribute(attr)
+	}
+
+	email = strings.ToLower(strings.TrimSpace(email))
+
+	u, err := auth.FindIdentityUser(provider, a.NameID)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		u, err = samlAccount(ctx, orgID, settings, email, fields)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Deactivated {
+		return nil, ErrSSOAccountDeactivated
+	}
+
+	if linked, ok := u.Social.Find(provider); !ok || linked.ID != a.NameID {
+		identity := user.Social{ID: a.NameID, Provider: provider, Email: email, LinkedAt: time.Now()}
+		if err := auth.LinkIdentity(ctx, u, identity); err != nil {
+			return nil, err
+		}
+	}
+
+	return u, provisionSAMLMember(ctx, orgID, settings, u, fields)
+}
+
+// samlAccount returns the account for an identity seen for the first time.
+func samlAccount(ctx context.Context, orgID string, settings *SAMLSettings, email string, fields map[string]string) (*user.User, error) {
+	if !utils.IsValidEmail(email) {
+		return nil, ErrSAMLEmail
+	}
+
+	u, err := auth.FetchUserByEmail(bson.M{"email": email})
+	if err == nil {
+		members, err := utils.GetCollection(MemberCollectionName).CountDocuments(ctx, bson.M{"org_id": orgID, "email": email})
+		if err != nil {
+			return nil, err
+		}
+
+		if members == 0 {
+			return nil, ErrSSOAccountConflict
+		}
+
+		return u, nil
+	}
+
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, err
+	}
+
+	if !settings.JITProvisioning {
+		return nil, ErrSSONotMember
+	}
+
+	return CreateProvisionedUser(ctx, email, fields["first_name"], fields["last_name"])
+}
+
+// provisionSAMLMember refreshes the mapped fields of an existing member, or adds the
+// user to the organization when just-in-time provisioning is enabled.
+func provisionSAMLMember(ctx context.Context, orgID string, settings *SAMLSettings, u *user.User, fields map[string]string) error {
+	coll := utils.GetCollection(MemberCollectionName)
+	filter := bson.M{"org_id": orgID, "email": u.Email}
+
+	var member Member
+
+	err := coll.FindOne(ctx, filter).Decode(&member)
+	if err == nil {
+		if member.Deleted {
+			return ErrSSOAccountDeactivated
+		}
+
+		if len(fields) == 0 {
+			return nil
+		}
+
+		if _, err = coll.UpdateOne(ctx, filter, bson.M{"$set": fields}); err != nil {
+			return err
+		}
+
+		refreshSearchKeys(ctx, filter)
+
+		return nil
+	}
+
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return err
+	}
+
+	if !settings.JITProvisioning {
+		return ErrSSONotMember
+	}
+
+	member = NewMember(u.Email, strings.Split(u.Email, "@")[0], orgID, MemberRole)
+	applySAMLAttributes(&member, fields)
+
+	_, err = AddMember(ctx, u, member)
+
+	return err
+}
+
+// samlAttributes maps the assertion attributes to member fields, skipping empty values.
+func samlAttributes(settings *SAMLSettings, a *sso.Assertion) map[string]string {
+	fields := make(map[string]string)
+
+	for field, attr := range settings.AttributeMapping {
+		if !samlMemberFields[field] {
+			continue
+		}
+
+		if v := strings.TrimSpace(a.Attribute(attr)); v != "" {
+			fields[field] = v
+		}
+	}
+
+	return fields
+}
+
+func applySAMLAttributes(m *Member, fields map[string]string) {
+	for field, v := range fields {
+		switch field {
+		case "first_name":
+			m.FirstName = v
+		case "last_name":
+			m.LastName = v
+		case "display_name":
+			m.DisplayName = v
+		case "phone":
+			m.Phone = v
+		case "pronouns":
+			m.Pronouns = v
+		case "time_zone":
+			m.TimeZone = v
+		case "bio":
+			m.Bio = v
+		}
+	}
+}
@@ -0,0 +1,352 @@
+package organizations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"zuri.chat/zccore/audit"
+	"zuri.chat/zccore/auth"
+	"zuri.chat/zccore/logger"
+	"zuri.chat/zccore/service"
+	"zuri.chat/zccore/utils"
+)
+
+const transferTokenBytes = 32
+
+var (
+	ErrTransferNotFound      = errors.New("there is no pending ownership transfer")
+	ErrTransferInvalid       = errors.New("this ownership transfer is invalid, has expired or has been withdrawn")
+	ErrTransferRecipientOnly = errors.New("this ownership transfer was offered to someone else")
+	ErrTransferStale         = errors.New("the roles in the organization have changed since this transfer was offered, ask for a new one")
+)
+
+// activeMember returns the active member of orgID matching filter.
+func activeMember(ctx context.Context, orgID string, filter bson.M) (*Member, error) {
+	filter["org_id"], filter["deleted"] = orgID, bson.M{"$ne": true}
+
+	member := &Member{}
+	if err := utils.GetCollection(MemberCollectionName).FindOne(ctx, filter).Decode(member); err != nil {
+		return nil, err
+	}
+
+	return member, nil
+}
+
+// transferBillingContacts returns the billing contacts of an organization whose billing
+// mail goes to the owner, once ownership moved from one address to another.
+func transferBillingContacts(contacts []Contact, from, to string) []Contact {
+	moved := []Contact{{Email: to}}
+
+	for _, c := range contacts {
+		if !strings.EqualFold(c.Email, from) && !strings.EqualFold(c.Email, to) {
+			moved = append(moved, c)
+		}
+	}
+
+	return moved
+}
+
+// swapOwner makes the recipient of t the owner of the organization and the former owner
+// an admin, and closes t. It all happens in one transaction, so the organization never
+// has two owners or none, and fails if either member's role changed in the meantime.
+func swapOwner(ctx context.Context, t *OwnershipTransfer) error {
+	fromID, _ := primitive.ObjectIDFromHex(t.FromMemberID)
+	toID, _ := primitive.ObjectIDFromHex(t.ToMemberID)
+	orgID, _ := primitive.ObjectIDFromHex(t.OrgID)
+
+	session, err := utils.GetDefaultMongoClient().StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		now := time.Now()
+
+		res, err := utils.GetCollection(OwnershipTransferCollectionName).UpdateOne(sc,
+			bson.M{"_id": t.ID, "status": TransferPending, "expires_at": bson.M{"$gt": now}},
+			bson.M{"$set": bson.M{"status": TransferAccepted, "completed_at": now}})
+		if err != nil {
+			return nil, err
+		}
+
+		if res.MatchedCount == 0 {
+			return nil, ErrTransferInvalid
+		}
+
+		members := utils.GetCollection(MemberCollectionName)
+
+		for _, swap := range []struct {
+			filter bson.M
+			role   string
+		}{
+			{bson.M{"_id": fromID, "org_id": t.OrgID, "role": OwnerRole, "deleted": bson.M{"$ne": true}}, AdminRole},
+			{bson.M{"_id": toID, "org_id": t.OrgID, "email": t.ToEmail, "deleted": bson.M{"$ne": true}}, OwnerRole},
+		} {
+			res, err := members.UpdateOne(sc, swap.filter, bson.M{"$set": bson.M{"role": swap.role}})
+			if err != nil {
+				return nil, err
+			}
+
+			if res.MatchedCount == 0 {
+				return nil, ErrTransferStale
+			}
+		}
+
+		var org Organization
+		if err := utils.GetCollection(OrganizationCollectionName).FindOne(sc, bson.M{"_id": orgID}).Decode(&org); err != nil {
+			return nil, err
+		}
+
+		if !org.Billing.Contact.ToDefaultEmail {
+			return nil, nil
+		}
+
+		contacts := transferBillingContacts(org.Billing.Contact.Contact, t.FromEmail, t.ToEmail)
+		_, err = utils.GetCollection(OrganizationCollectionName).UpdateOne(sc, bson.M{"_id": orgID},
+			bson.M{"$set": bson.M{"billing.contact.contacts": contacts}})
+
+		return nil, err
+	})
+
+	return err
+}
+
+// pendingTransfer returns the transfer in the request path if it can still be accepted
+// or declined by the logged in user.
+func pendingTransfer(w http.ResponseWriter, r *http.Request) (*OwnershipTransfer, bool) {
+	t := &OwnershipTransfer{}
+
+	filter := bson.M{"token_hash": utils.HashToken(mux.Vars(r)["token"]), "status": TransferPending, "expires_at": bson.M{"$gt": time.Now()}}
+	if err := utils.GetCollection(OwnershipTransferCollectionName).FindOne(r.Context(), filter).Decode(t); err != nil {
+		utils.GetError(ErrTransferInvalid, http.StatusNotFound, w)
+		return nil, false
+	}
+
+	loggedInUser, _ := r.Context().Value("user").(*auth.AuthUser)
+	if loggedInUser == nil || strings.ToLower(loggedInUser.Email) != t.ToEmail {
+		utils.GetError(ErrTransferRecipientOnly, http.StatusForbidden, w)
+		return nil, false
+	}
+
+	return t, true
+}
+
+// Offer the ownership of an organization to one of its members. Nothing changes until
+// they accept from the link emailed to them, a new offer withdraws the previous one.
+func (oh *OrganizationHandler) TransferOwnership(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	org, ok := ownedOrg(w, r)
+	if !ok {
+		return
+	}
+
+	var req TransferOwnershipRequest
+	if err := utils.ParseJSONFromRequest(r, &req); err != nil {
+		utils.GetError(err, http.StatusUnprocessableEntity, w)
+		return
+	}
+
+	if err := validator.New().Struct(req); err != nil {
+		utils.GetError(errors.New("email is not valid"), http.StatusBadRequest, w)
+		return
+	}
+
+	loggedInUser, _ := r.Context().Value("user").(*auth.AuthUser)
+
+	owner, err := activeMember(r.Context(), org.ID, bson.M{"email": strings.ToLower(loggedInUser.Email)})
+	if err != nil {
+		utils.GetError(ErrOwnerOnly, http.StatusForbidden, w)
+		return
+	}
+
+	recipient, err := activeMember(r.Context(), org.ID, bson.M{"email": strings.ToLower(strings.TrimSpace(req.Email))})
+	if err != nil {
+		utils.GetError(errors.New("user not a member of this work space"), http.StatusBadRequest, w)
+		return
+	}
+
+	if recipient.Role == OwnerRole {
+		utils.GetError(errors.New("this member already owns this organization"), http.StatusBadRequest, w)
+		return
+	}
+
+	token, err := utils.RandomToken(transferTokenBytes)
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	coll := utils.GetCollection(OwnershipTransferCollectionName)
+
+	if _, err := coll.UpdateMany(r.Context(), bson.M{"org_id": org.ID, "status": TransferPending},
+		bson.M{"$set": bson.M{"status": TransferCancelled, "completed_at": time.Now()}}); err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	now := time.Now()
+	transfer := OwnershipTransfer{
+		OrgID:        org.ID,
+		FromMemberID: owner.ID,
+		FromEmail:    owner.Email,
+		ToMemberID:   recipient.ID,
+		ToEmail:      recipient.Email,
+		TokenHash:    utils.HashToken(token),
+		Status:       TransferPending,
+		CreatedAt:    now,
+		ExpiresAt:    now.Add(time.Duration(oh.configs.OwnershipTransferTTL) * time.Second),
+	}
+
+	res, err := coll.InsertOne(r.Context(), transfer)
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	transfer.ID, _ = res.InsertedID.(primitive.ObjectID)
+
+	ownerName := owner.DisplayName
+	if ownerName == "" {
+		ownerName = owner.Email
+	}
+
+	msger := oh.mailService.NewMail([]string{recipient.Email}, fmt.Sprintf("Become the owner of %s", org.Name), service.OwnershipTransfer, map[string]interface{}{
+		"FirstName": recipient.FirstName,
+		"OwnerName": ownerName,
+		"OrgName":   org.Name,
+		"Link":      oh.configs.OwnershipTransferURL + "?token=" + url.QueryEscape(token),
+		"ExpiresOn": transfer.ExpiresAt.UTC().Format("January 2, 2006 15:04 MST"),
+	})
+
+	go func() {
+		if err := oh.mailService.SendMail(msger); err != nil {
+			logger.Error("Error occurred while sending mail: %s", err.Error())
+		}
+	}()
+
+	audit.Record(r, audit.Event{OrgID: org.ID, Action: audit.ActionTransferOffer, TargetType: audit.TargetMember, TargetID: recipient.ID,
+		After: map[string]interface{}{"owner": recipient.Email, "expires_at": transfer.ExpiresAt}})
+
+	utils.GetSuccess("ownership transfer offered, it completes once the member accepts it", transfer, w)
+}
+
+// Get the pending ownership transfer of an organization.
+func (oh *OrganizationHandler) GetOwnershipTransfer(w http.ResponseWriter, r *http.Request) {
+	transfer := &OwnershipTransfer{}
+
+	filter := bson.M{"org_id": mux.Vars(r)["id"], "status": TransferPending, "expires_at": bson.M{"$gt": time.Now()}}
+	if err := utils.GetCollection(OwnershipTransferCollectionName).FindOne(r.Context(), filter).Decode(transfer); err != nil {
+		utils.GetError(ErrTransferNotFound, http.StatusNotFound, w)
+		return
+	}
+
+	utils.GetSuccess("ownership transfer retrieved", transfer, w)
+}
+
+// Withdraw the pending ownership transfer of an organization.
+func (oh *OrganizationHandler) CancelOwnershipTransfer(w http.ResponseWriter, r *http.Request) {
+	org, ok := ownedOrg(w, r)
+	if !ok {
+		return
+	}
+
+	res, err := utils.GetCollection(OwnershipTransferCollectionName).UpdateMany(r.Context(),
+		bson.M{"org_id": org.ID, "status": TransferPending, "expires_at": bson.M{"$gt": time.Now()}},
+		bson.M{"$set": bson.M{"status": TransferCancelled, "completed_at": time.Now()}})
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	if res.ModifiedCount == 0 {
+		utils.GetError(ErrTransferNotFound, http.StatusNotFound, w)
+		return
+	}
+
+	audit.Record(r, audit.Event{OrgID: org.ID, Action: audit.ActionTransferCancel, TargetType: audit.TargetOrganization, TargetID: org.ID})
+
+	utils.GetSuccess("ownership transfer cancelled", nil, w)
+}
+
+// Accept the ownership of an organization offered to the logged in user. The former
+// owner becomes an admin.
+func (oh *OrganizationHandler) AcceptOwnershipTransfer(w http.ResponseWriter, r *http.Request) {
+	t, ok := pendingTransfer(w, r)
+	if !ok {
+		return
+	}
+
+	err := swapOwner(r.Context(), t)
+
+	switch {
+	case errors.Is(err, ErrTransferInvalid):
+		utils.GetError(err, http.StatusNotFound, w)
+		return
+	case errors.Is(err, ErrTransferStale):
+		utils.GetError(err, http.StatusConflict, w)
+		return
+	case err != nil:
+		utils.GetError(fmt.Errorf("could not transfer ownership: %w", err), http.StatusInternalServerError, w)
+		return
+	}
+
+	audit.Record(r, audit.Event{OrgID: t.OrgID, Action: audit.ActionOwnershipTransfer, TargetType: audit.TargetMember, TargetID: t.ToMemberID,
+		Before: map[string]interface{}{"owner": t.FromEmail}, After: map[string]interface{}{"owner": t.ToEmail}})
+
+	for _, change := range []struct{ memberID, from, to string }{
+		{t.FromMemberID, OwnerRole, AdminRole},
+		{t.ToMemberID, "", OwnerRole},
+	} {
+		before := map[string]interface{}{}
+		if change.from != "" {
+			before["role"] = change.from
+		}
+
+		audit.Record(r, audit.Event{OrgID: t.OrgID, Action: audit.ActionMemberRoleUpdate, TargetType: audit.TargetMember, TargetID: change.memberID,
+			Before: before, After: map[string]interface{}{"role": change.to}})
+
+		eventChannel := fmt.Sprintf("organizations_%s", t.OrgID)
+		event := utils.Event{Identifier: change.memberID, Type: "User", Event: UpdateOrganizationMemberRole, Channel: eventChannel, Payload: make(map[string]interface{})}
+
+		go utils.Emitter(event)
+	}
+
+	utils.GetSuccess("you are now the owner of this organization", utils.M{"organization_id": t.OrgID}, w)
+}
+
+// Decline the ownership of an organization offered to the logged in user.
+func (oh *OrganizationHandler) DeclineOwnershipTransfer(w http.ResponseWriter, r *http.Request) {
+	t, ok := pendingTransfer(w, r)
+	if !ok {
+		return
+	}
+
+	res, err := utils.GetCollection(OwnershipTransferCollectionName).UpdateOne(r.Context(),
+		bson.M{"_id": t.ID, "status": TransferPending},
+		bson.M{"$set": bson.M{"status": TransferDeclined, "completed_at": time.Now()}})
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	if res.MatchedCount == 0 {
+		utils.GetError(ErrTransferInvalid, http.StatusNotFound, w)
+		return
+	}
+
+	audit.Record(r, audit.Event{OrgID: t.OrgID, Action: audit.ActionTransferDecline, TargetType: audit.TargetMember, TargetID: t.ToMemberID})
+
+	utils.GetSuccess("ownership transfer declined", nil, w)
+}
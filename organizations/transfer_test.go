@@ -0,0 +1,21 @@
+package organizations
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTransferBillingContacts(t *testing.T) {
+	contacts := []Contact{{Email: "Owner@zuri.chat"}, {Email: "finance@zuri.chat"}, {Email: "new@zuri.chat"}}
+
+	got := transferBillingContacts(contacts, "owner@zuri.chat", "new@zuri.chat")
+	want := []Contact{{Email: "new@zuri.chat"}, {Email: "finance@zuri.chat"}}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if got := transferBillingContacts(nil, "owner@zuri.chat", "new@zuri.chat"); !reflect.DeepEqual(got, []Contact{{Email: "new@zuri.chat"}}) {
+		t.Errorf("got %v, want only the new owner", got)
+	}
+}
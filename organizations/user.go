@@ -14,6 +14,7 @@ import (
 	"github.com/mitchellh/mapstructure"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"zuri.chat/zccore/audit"
 	"zuri.chat/zccore/auth"
 	"zuri.chat/zccore/service"
 	"zuri.chat/zccore/utils"
@@ -258,6 +259,8 @@ func (oh *OrganizationHandler) CreateMember(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	refreshSearchKeys(r.Context(), bson.M{"_id": res.InsertedID})
+
 	// update user organizations collection
 	updateFields := make(map[string]interface{})
 
@@ -277,6 +280,8 @@ func (oh *OrganizationHandler) CreateMember(w http.ResponseWriter, r *http.Reque
 
 	go utils.Emitter(event)
 
+	audit.Record(r, audit.Event{OrgID: sOrgID, Action: audit.ActionMemberAdd, TargetType: audit.TargetMember, TargetID: res.InsertedID.(primitive.ObjectID).Hex()})
+
 	utils.GetSuccess("Member created successfully", utils.M{"member_id": res.InsertedID}, w)
 
 	enterOrgMessage := EnterLeaveMessage{
@@ -651,35 +656,19 @@ func (oh *OrganizationHandler) DeactivateMember(w http.ResponseWriter, r *http.R
 		return
 	}
 
-	deleteUpdate := bson.M{"deleted": true, "deleted_at": time.Now()}
-	res, err := utils.UpdateOneMongoDBDoc(MemberCollectionName, memberID, deleteUpdate)
+	if err = SetMemberActive(orgID, memberID, false); err != nil {
+		if errors.Is(err, ErrMemberUnchanged) {
+			err = errors.New("an error occurred, failed to deactivate member")
+		}
 
-	if err != nil {
-		utils.GetError(fmt.Errorf("an error occurred: %s", err), http.StatusInternalServerError, w)
-	}
+		utils.GetError(err, http.StatusInternalServerError, w)
 
-	if res.ModifiedCount != 1 {
-		utils.GetError(errors.New("an error occurred, failed to deactivate member"), http.StatusInternalServerError, w)
 		return
 	}
 
-	// publish update to subscriber
-	eventChannel := fmt.Sprintf("organizations_%s", orgID)
-	event := utils.Event{Identifier: memberID, Type: "User", Event: DeactivateOrganizationMember, Channel: eventChannel, Payload: make(map[string]interface{})}
-
-	go utils.Emitter(event)
+	audit.Record(r, audit.Event{OrgID: orgID, Action: audit.ActionMemberDeactivate, TargetType: audit.TargetMember, TargetID: memberID})
 
 	utils.GetSuccess("successfully deactivated member", nil, w)
-
-	enterOrgMessage := EnterLeaveMessage{
-		OrganizationID: orgID,
-		MemberID:       memberID,
-	}
-	eee := AddSyncMessage(orgID, "leave_organization", enterOrgMessage)
-
-	if eee != nil {
-		log.Printf("sync error: %v", eee)
-	}
 }
 
 // Update a member profile.
@@ -737,6 +726,9 @@ func (oh *OrganizationHandler) UpdateProfile(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
+	memberObjID, _ := primitive.ObjectIDFromHex(memberID)
+	refreshSearchKeys(r.Context(), bson.M{"_id": memberObjID})
+
 	// publish update to subscriber
 	eventChannel := fmt.Sprintf("organizations_%s", orgID)
 	event := utils.Event{Identifier: memberID, Type: "User", Event: UpdateOrganizationMemberProfile, Channel: eventChannel, Payload: make(map[string]interface{})}
@@ -912,50 +904,44 @@ func (oh *OrganizationHandler) ReactivateMember(w http.ResponseWriter, r *http.R
 		return
 	}
 
-	ActivatedMember := bson.M{"deleted": false, "deleted_at": time.Time{}}
-	res, err := utils.UpdateOneMongoDBDoc(MemberCollectionName, memberID, ActivatedMember)
+	if err = SetMemberActive(orgID, memberID, true); err != nil {
+		if errors.Is(err, ErrMemberUnchanged) {
+			err = errors.New("an error occurred, cannot activate user")
+		}
 
-	if err != nil {
-		utils.GetError(fmt.Errorf("an error occurred: %s", err), http.StatusInternalServerError, w)
-	}
+		utils.GetError(err, http.StatusInternalServerError, w)
 
-	if res.ModifiedCount != 1 {
-		utils.GetError(errors.New("an error occurred, cannot activate user"), http.StatusInternalServerError, w)
 		return
 	}
 
-	// publish update to subscriber
-	eventChannel := fmt.Sprintf("organizations_%s", orgID)
-	event := utils.Event{Identifier: memberID, Type: "User", Event: ReactivateOrganizationMember, Channel: eventChannel, Payload: make(map[string]interface{})}
-
-	go utils.Emitter(event)
+	audit.Record(r, audit.Event{OrgID: orgID, Action: audit.ActionMemberReactivate, TargetType: audit.TargetMember, TargetID: memberID})
 
 	utils.GetSuccess("successfully reactivated member", nil, w)
 }
 
-// Check the guest status of an email embedded in an invite UUID.
+// Check the guest status of an email embedded in an invite UUID, or the organization
+// an invite link joins.
 func (oh *OrganizationHandler) CheckGuestStatus(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	// 0. Extract and validate UUID
+	// 0. Extract and validate UUID, anything else is an invite link token
 	guestUUID := mux.Vars(r)["uuid"]
 	_, err := utils.ValidateUUID(guestUUID)
 
 	if err != nil {
-		utils.GetError(err, http.StatusBadRequest, w)
+		checkInviteLink(w, r, guestUUID)
 		return
 	}
 
-	// 1. Query organization invites collection for uuid
-	res, err := utils.GetMongoDBDoc(OrganizationInviteCollectionName, bson.M{"uuid": guestUUID})
+	// 1. Query organization invites collection for uuid, it must still be open
+	invite, err := openInvite(r.Context(), guestUUID)
 	if err != nil {
 		utils.GetError(err, http.StatusBadRequest, w)
 		return
 	}
 
 	// 2. Check if email already is registered in zurichat (return 403 user already exist)
-	guestEmail := res["email"]
-	_, err = utils.GetMongoDBDoc(UserCollectionName, bson.M{"email": guestEmail})
+	_, err = utils.GetMongoDBDoc(UserCollectionName, bson.M{"email": invite.Email})
 
 	if err != nil {
 		utils.GetError(
@@ -983,18 +969,14 @@ func (oh *OrganizationHandler) GuestToOrganization(w http.ResponseWriter, r *htt
 		return
 	}
 
-	res, err := utils.GetMongoDBDoc(OrganizationInviteCollectionName, bson.M{"uuid": gUUID})
+	invite, err := openInvite(r.Context(), gUUID)
 	if err != nil {
 		utils.GetError(err, http.StatusBadRequest, w)
 		return
 	}
 
 	// // TODO 0: Check that organization exists
-	orgID, ok := res["org_id"].(string)
-	if !ok {
-		utils.GetError(errors.New("invalid email address"), http.StatusBadRequest, w)
-		return
-	}
+	orgID := invite.OrgID
 
 	validOrgID, err := primitive.ObjectIDFromHex(orgID)
 
@@ -1009,11 +991,7 @@ func (oh *OrganizationHandler) GuestToOrganization(w http.ResponseWriter, r *htt
 		return
 	}
 
-	email, ok := res["email"].(string)
-	if !ok {
-		utils.GetError(errors.New("invalid email address"), http.StatusBadRequest, w)
-		return
-	}
+	email := invite.Email
 
 	// TODO 2: Verify guest email
 	if !utils.IsValidEmail(email) {
@@ -1035,7 +1013,13 @@ func (oh *OrganizationHandler) GuestToOrganization(w http.ResponseWriter, r *htt
 		return
 	}
 
-	// TODO 5: Create a member profile for the guest
+	// TODO 5: Create a member profile for the guest, with the role of the invite unless
+	// it was deleted since
+	role := invite.Role
+	if _, err = auth.RolePermissions(r.Context(), orgID, role); err != nil {
+		role = MemberRole
+	}
+
 	setting := new(Settings)
 	username := strings.Split(user.Email, "@")[0]
 
@@ -1043,13 +1027,18 @@ func (oh *OrganizationHandler) GuestToOrganization(w http.ResponseWriter, r *htt
 		Email:    user.Email,
 		UserName: username,
 		OrgID:    validOrgID.Hex(),
-		Role:     "member",
+		Role:     role,
 		Presence: "true",
 		JoinedAt: time.Now(),
 		Settings: setting,
 		Deleted:  false,
 	}
 
+	if p := invite.Profile; p != nil {
+		memberStruct.FirstName, memberStruct.LastName = p.FirstName, p.LastName
+		memberStruct.DisplayName, memberStruct.TimeZone = p.DisplayName, p.TimeZone
+	}
+
 	data, err := utils.StructToMap(memberStruct)
 	if err != nil {
 		utils.GetError(err, http.StatusInternalServerError, w)
@@ -1062,6 +1051,8 @@ func (oh *OrganizationHandler) GuestToOrganization(w http.ResponseWriter, r *htt
 		return
 	}
 
+	refreshSearchKeys(r.Context(), bson.M{"_id": resp.InsertedID})
+
 	// TODO 6: Add member to organization
 	organizationStruct := new(Organization)
 	err = mapstructure.Decode(orgDoc, &organizationStruct)
@@ -1084,9 +1075,11 @@ func (oh *OrganizationHandler) GuestToOrganization(w http.ResponseWriter, r *htt
 		return
 	}
 	// update invite status
-	inviteID := res["_id"].(primitive.ObjectID).Hex()
-
-	_, err = utils.UpdateOneMongoDBDoc(OrganizationInviteCollectionName, inviteID, bson.M{"has_accepted": true})
+	_, err = utils.UpdateOneMongoDBDoc(OrganizationInviteCollectionName, invite.ID, bson.M{
+		"has_accepted": true,
+		"status":       InviteAccepted,
+		"accepted_at":  time.Now(),
+	})
 	if err != nil {
 		utils.GetError(errors.New("invite update failed"), http.StatusInternalServerError, w)
 		return
@@ -1122,11 +1115,25 @@ func (oh *OrganizationHandler) UpdateMemberRole(w http.ResponseWriter, r *http.R
 
 	role := strings.ToLower(RequestData["role"])
 
-	if _, ok := Roles[role]; !ok {
+	// ownership only moves through a transfer
+	if role == OwnerRole {
+		utils.GetError(errors.New("ownership can only be transferred by the owner"), http.StatusBadRequest, w)
+		return
+	}
+
+	perms, err := auth.RolePermissions(r.Context(), orgID, role)
+	if err != nil {
 		utils.GetError(errors.New("role is not valid"), http.StatusBadRequest, w)
 		return
 	}
 
+	// members can't hand out more than they hold, nor change the role of someone who holds more
+	loggedInUser, _ := r.Context().Value("user").(*auth.AuthUser)
+	if !auth.GrantsAll(loggedInUser.Permissions, perms) {
+		utils.GetError(auth.ErrRoleEscalation, http.StatusForbidden, w)
+		return
+	}
+
 	memID, _ := primitive.ObjectIDFromHex(memberID)
 
 	orgMember, err := FetchMember(bson.M{"org_id": orgID, "_id": memID})
@@ -1136,6 +1143,12 @@ func (oh *OrganizationHandler) UpdateMemberRole(w http.ResponseWriter, r *http.R
 		return
 	}
 
+	current, _ := auth.RolePermissions(r.Context(), orgID, orgMember.Role)
+	if orgMember.Role == OwnerRole || !auth.GrantsAll(loggedInUser.Permissions, current) {
+		utils.GetError(auth.ErrRoleEscalation, http.StatusForbidden, w)
+		return
+	}
+
 	if orgMember.Role == role {
 		errorMessage := fmt.Sprintf("member role is already %s", role)
 		utils.GetError(errors.New(errorMessage), http.StatusBadRequest, w)
@@ -1143,26 +1156,24 @@ func (oh *OrganizationHandler) UpdateMemberRole(w http.ResponseWriter, r *http.R
 		return
 	}
 
-	// ID of the user whose role is being updated
-	memberIDHex := orgMember.ID
-
-	updateRes, err := utils.UpdateOneMongoDBDoc(MemberCollectionName, memberIDHex, bson.M{"role": role})
+	if err = SetMemberRole(orgID, memberID, role); err != nil {
+		if errors.Is(err, ErrMemberUnchanged) {
+			err = errors.New("could not update member role")
+		}
 
-	if err != nil {
-		utils.GetError(errors.New("operation failed"), http.StatusInternalServerError, w)
-		return
-	}
+		utils.GetError(err, http.StatusInternalServerError, w)
 
-	if updateRes.ModifiedCount == 0 {
-		utils.GetError(errors.New("could not update member role"), http.StatusInternalServerError, w)
 		return
 	}
 
-	// publish update to subscriber
-	eventChannel := fmt.Sprintf("organizations_%s", orgID)
-	event := utils.Event{Identifier: memberID, Type: "User", Event: UpdateOrganizationMemberRole, Channel: eventChannel, Payload: make(map[string]interface{})}
-
-	go utils.Emitter(event)
+	audit.Record(r, audit.Event{
+		OrgID:      orgID,
+		Action:     audit.ActionMemberRoleUpdate,
+		TargetType: audit.TargetMember,
+		TargetID:   memberID,
+		Before:     map[string]interface{}{"role": orgMember.Role},
+		After:      map[string]interface{}{"role": role},
+	})
 
 	utils.GetSuccess("member role updated successfully", nil, w)
 }
@@ -16,8 +16,10 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 
+	"zuri.chat/zccore/audit"
 	"zuri.chat/zccore/auth"
 	"zuri.chat/zccore/service"
+	"zuri.chat/zccore/user"
 	"zuri.chat/zccore/utils"
 )
 
@@ -96,6 +98,115 @@ func NewMember(email, userName, orgID, role string) Member {
 	}
 }
 
+// ErrMemberUnchanged is returned when a member update matched nothing to change.
+var ErrMemberUnchanged = errors.New("member was not updated")
+
+// AddMember inserts m into its organization, adds the organization to u's workspaces
+// and notifies subscribers and plugins. It returns the new member id.
+func AddMember(ctx context.Context, u *user.User, m Member) (string, error) {
+	res, err := utils.GetCollection(MemberCollectionName).InsertOne(ctx, m)
+	if err != nil {
+		return "", err
+	}
+
+	refreshSearchKeys(ctx, bson.M{"_id": res.InsertedID})
+
+	userID, _ := primitive.ObjectIDFromHex(u.ID)
+
+	_, err = utils.GetCollection(UserCollectionName).UpdateByID(ctx, userID, bson.M{"$addToSet": bson.M{"workspaces": m.OrgID}})
+	if err != nil {
+		return "", err
+	}
+
+	memberID, _ := res.InsertedID.(primitive.ObjectID)
+
+	// publish update to subscriber
+	eventChannel := fmt.Sprintf("organizations_%s", m.OrgID)
+	event := utils.Event{Identifier: res.InsertedID, Type: "User", Event: CreateOrganizationMember, Channel: eventChannel, Payload: make(map[string]interface{})}
+
+	go utils.Emitter(event)
+
+	if err := AddSyncMessage(m.OrgID, "enter_organization", EnterLeaveMessage{OrganizationID: m.OrgID, MemberID: memberID.Hex()}); err != nil {
+		log.Printf("sync error: %v", err)
+	}
+
+	return memberID.Hex(), nil
+}
+
+// SetMemberActive deactivates or reactivates a member and notifies subscribers.
+func SetMemberActive(orgID, memberID string, active bool) error {
+	update, eventName := bson.M{"deleted": true, "deleted_at": time.Now()}, DeactivateOrganizationMember
+	if active {
+		update, eventName = bson.M{"deleted": false, "deleted_at": time.Time{}}, ReactivateOrganizationMember
+	}
+
+	res, err := utils.UpdateOneMongoDBDoc(MemberCollectionName, memberID, update)
+	if err != nil {
+		return err
+	}
+
+	if res.ModifiedCount != 1 {
+		return ErrMemberUnchanged
+	}
+
+	// publish update to subscriber
+	eventChannel := fmt.Sprintf("organizations_%s", orgID)
+	event := utils.Event{Identifier: memberID, Type: "User", Event: eventName, Channel: eventChannel, Payload: make(map[string]interface{})}
+
+	go utils.Emitter(event)
+
+	if !active {
+		if err := AddSyncMessage(orgID, "leave_organization", EnterLeaveMessage{OrganizationID: orgID, MemberID: memberID}); err != nil {
+			log.Printf("sync error: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// SetMemberRole changes a member's role and notifies subscribers.
+func SetMemberRole(orgID, memberID, role string) error {
+	res, err := utils.UpdateOneMongoDBDoc(MemberCollectionName, memberID, bson.M{"role": role})
+	if err != nil {
+		return err
+	}
+
+	if res.ModifiedCount == 0 {
+		return ErrMemberUnchanged
+	}
+
+	// publish update to subscriber
+	eventChannel := fmt.Sprintf("organizations_%s", orgID)
+	event := utils.Event{Identifier: memberID, Type: "User", Event: UpdateOrganizationMemberRole, Channel: eventChannel, Payload: make(map[string]interface{})}
+
+	go utils.Emitter(event)
+
+	return nil
+}
+
+// CreateProvisionedUser creates a verified account without a password for someone an
+// organization's identity system signs in or provisions. The user can set a password
+// later through a password reset.
+func CreateProvisionedUser(ctx context.Context, email, firstName, lastName string) (*user.User, error) {
+	detail, _ := utils.StructToMap(&user.User{
+		FirstName:  firstName,
+		LastName:   lastName,
+		Email:      email,
+		IsVerified: true,
+		Timezone:   "Africa/Lagos", // set default timezone
+		CreatedAt:  time.Now(),
+	})
+
+	res, err := utils.CreateMongoDBDoc(UserCollectionName, detail)
+	if err != nil {
+		return nil, err
+	}
+
+	id, _ := res.InsertedID.(primitive.ObjectID)
+
+	return auth.FetchUserByID(id.Hex())
+}
+
 // clear a member's status after a duration.
 func ClearStatusRoutine(orgID, memberID string, ch chan int64, clearOld chan bool) {
 	// get period from channel
@@ -220,6 +331,7 @@ func OrganizationUpdate(w http.ResponseWriter, r *http.Request, updateParam upda
 
 	orgFilter := make(map[string]interface{})
 	orgFilter[updateParam.orgFilterKey] = RequestData[updateParam.requestDataKey]
+	before := orgSnapshot(orgID, updateParam.orgFilterKey)
 	update, err := utils.UpdateOneMongoDBDoc(OrganizationCollectionName, orgID, orgFilter)
 
 	if err != nil {
@@ -237,6 +349,8 @@ func OrganizationUpdate(w http.ResponseWriter, r *http.Request, updateParam upda
 
 	go utils.Emitter(event)
 
+	recordOrgChange(r, orgID, audit.ActionOrgUpdate, before, orgFilter)
+
 	utils.GetSuccess(fmt.Sprintf("%s updated successfully", updateParam.successMessage), nil, w)
 }
 
@@ -386,6 +500,7 @@ func updateBilling(w http.ResponseWriter, r *http.Request, settingsPayload setti
 
 	orgFilter := make(map[string]interface{})
 	orgFilter[settingsPayload.field] = settingsPayload.settings
+	before := orgSnapshot(orgID, settingsPayload.field)
 
 	update, err := utils.UpdateOneMongoDBDoc(OrganizationCollectionName, orgID, orgFilter)
 	if err != nil {
@@ -404,5 +519,22 @@ func updateBilling(w http.ResponseWriter, r *http.Request, settingsPayload setti
 
 	go utils.Emitter(event)
 
+	recordOrgChange(r, orgID, audit.ActionOrgBillingUpdate, before, orgFilter)
+
 	utils.GetSuccess("organization billing updated successfully", nil, w)
 }
+
+// orgSnapshot returns the current value of an organization field, to diff an update
+// against in the audit log.
+func orgSnapshot(orgID, field string) bson.M {
+	objID, _ := primitive.ObjectIDFromHex(orgID)
+	doc, _ := utils.GetMongoDBDoc(OrganizationCollectionName, bson.M{"_id": objID})
+
+	return bson.M{field: doc[field]}
+}
+
+// recordOrgChange records a change to the organization document in the audit log.
+func recordOrgChange(r *http.Request, orgID, action string, before, after interface{}) {
+	from, to := audit.Diff(before, after)
+	audit.Record(r, audit.Event{OrgID: orgID, Action: action, TargetType: audit.TargetOrganization, TargetID: orgID, Before: from, After: to})
+}
@@ -0,0 +1,372 @@
+package organizations
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"zuri.chat/zccore/audit"
+	"zuri.chat/zccore/utils"
+)
+
+// maxURLAttempts is how many generated URLs are tried for a new organization.
+const maxURLAttempts = 5
+
+var (
+	ErrWorkspaceURLInvalid  = errors.New("workspace url must be 3 to 32 lowercase letters, digits or hyphens, and can't start or end with a hyphen")
+	ErrWorkspaceURLReserved = errors.New("workspace url is reserved")
+	ErrWorkspaceURLTaken    = errors.New("workspace url is already taken")
+
+	workspaceSlugPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{1,30}[a-z0-9]$`)
+
+	// reservedWorkspaceSlugs name our own hosts and pages, workspaces can't use them.
+	reservedWorkspaceSlugs = map[string]bool{
+		"www": true, "api": true, "app": true, "apps": true, "admin": true, "auth": true, "login": true,
+		"signup": true, "signin": true, "logout": true, "account": true, "billing": true, "help": true,
+		"support": true, "docs": true, "blog": true, "status": true, "mail": true, "email": true,
+		"static": true, "assets": true, "files": true, "cdn": true, "dev": true, "staging": true,
+		"marketplace": true, "plugins": true, "zuri": true, "zurichat": true, "zuri-chat": true,
+	}
+)
+
+// normalizeWorkspaceURL turns a slug, or a full workspace URL, into the URL stored on
+// the organization, e.g. "Acme-Team" -> "acme-team.zurichat.com".
+func normalizeWorkspaceURL(raw string) (string, error) {
+	slug := strings.ToLower(strings.TrimSpace(raw))
+	slug = strings.TrimPrefix(strings.TrimPrefix(slug, "https://"), "http://")
+	slug = strings.TrimSuffix(strings.TrimSuffix(slug, "/"), utils.WorkspaceURLDomain)
+
+	if !workspaceSlugPattern.MatchString(slug) {
+		return "", ErrWorkspaceURLInvalid
+	}
+
+	if reservedWorkspaceSlugs[slug] {
+		return "", ErrWorkspaceURLReserved
+	}
+
+	return slug + utils.WorkspaceURLDomain, nil
+}
+
+// workspaceURLOwner returns the id of the organization using url, or redirecting from
+// it, and "" when url is free.
+func workspaceURLOwner(ctx context.Context, url string) (string, error) {
+	var org struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+
+	err := utils.GetCollection(OrganizationCollectionName).FindOne(ctx, bson.M{"workspace_url": url},
+		options.FindOne().SetProjection(bson.M{"_id": 1})).Decode(&org)
+
+	switch {
+	case err == nil:
+		return org.ID.Hex(), nil
+	case !errors.Is(err, mongo.ErrNoDocuments):
+		return "", err
+	}
+
+	redirect, err := activeRedirect(ctx, url)
+	if err != nil || redirect == nil {
+		return "", err
+	}
+
+	return redirect.OrgID, nil
+}
+
+// activeRedirect returns the redirect from url that hasn't expired, or nil.
+func activeRedirect(ctx context.Context, url string) (*URLRedirect, error) {
+	var redirect URLRedirect
+
+	err := utils.GetCollection(URLRedirectCollectionName).FindOne(ctx,
+		bson.M{"url": url, "expires_at": bson.M{"$gt": time.Now()}}).Decode(&redirect)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &redirect, nil
+}
+
+// freeWorkspaceURL generates a URL no organization uses from the organization's name.
+func freeWorkspaceURL(ctx context.Context, name string) (string, error) {
+	for i := 0; i < maxURLAttempts; i++ {
+		url := utils.GenWorkspaceURL(name)
+
+		owner, err := workspaceURLOwner(ctx, url)
+		if err != nil {
+			return "", err
+		}
+
+		if owner == "" {
+			return url, nil
+		}
+	}
+
+	return "", ErrWorkspaceURLTaken
+}
+
+// moveWorkspaceURL gives the organization its new url, and keeps the previous one
+// redirecting to it until redirectUntil.
+func moveWorkspaceURL(ctx context.Context, org *Organization, url string, redirectUntil time.Time) error {
+	objID, _ := primitive.ObjectIDFromHex(org.ID)
+
+	session, err := utils.GetDefaultMongoClient().StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		if _, err := utils.GetCollection(OrganizationCollectionName).UpdateOne(sc, bson.M{"_id": objID},
+			bson.M{"$set": bson.M{"workspace_url": url}}); err != nil {
+			return nil, err
+		}
+
+		redirects := utils.GetCollection(URLRedirectCollectionName)
+
+		// an organization taking back one of its previous urls no longer redirects from it
+		if _, err := redirects.DeleteOne(sc, bson.M{"url": url, "org_id": org.ID}); err != nil {
+			return nil, err
+		}
+
+		if org.WorkspaceURL == "" {
+			return nil, nil
+		}
+
+		_, err := redirects.UpdateOne(sc, bson.M{"url": org.WorkspaceURL}, bson.M{"$set": bson.M{
+			"org_id":     org.ID,
+			"created_at": time.Now(),
+			"expires_at": redirectUntil,
+		}}, options.Update().SetUpsert(true))
+
+		return nil, err
+	})
+
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrWorkspaceURLTaken
+	}
+
+	return err
+}
+
+// urlChange moves an organization to a new workspace url, Redirect is the previous
+// url to keep resolving to it, if any.
+type urlChange struct {
+	OrgID    string
+	To       string
+	Redirect string
+}
+
+// dedupeWorkspaceURLs plans the changes giving every organization a valid url no other
+// one uses. orgs come oldest first, the oldest keeps a shared url and the others get
+// one generated from their name.
+func dedupeWorkspaceURLs(orgs []Organization, generate func(name string) string) []urlChange {
+	claimed := make(map[string]bool, len(orgs))
+	shared := make(map[string]int, len(orgs))
+	urls := make([]string, len(orgs))
+
+	for i := range orgs {
+		shared[strings.ToLower(strings.TrimSpace(orgs[i].WorkspaceURL))]++
+
+		url, err := normalizeWorkspaceURL(orgs[i].WorkspaceURL)
+		if err == nil && !claimed[url] {
+			claimed[url] = true
+			urls[i] = url
+		}
+	}
+
+	var changes []urlChange
+
+	for i := range orgs {
+		org := &orgs[i]
+
+		if urls[i] == "" {
+			url := generate(org.Name)
+			for claimed[url] {
+				url = generate(org.Name)
+			}
+
+			claimed[url] = true
+			urls[i] = url
+		}
+
+		if urls[i] == org.WorkspaceURL {
+			continue
+		}
+
+		// a url other organizations shared can't redirect to just one of them
+		redirect := strings.ToLower(strings.TrimSpace(org.WorkspaceURL))
+		if redirect == urls[i] || claimed[redirect] || shared[redirect] > 1 {
+			redirect = ""
+		}
+
+		changes = append(changes, urlChange{OrgID: org.ID, To: urls[i], Redirect: redirect})
+	}
+
+	return changes
+}
+
+// MigrateWorkspaceURLs gives the organizations created before workspace urls were
+// validated a valid and unique one, then makes workspace urls unique in the database.
+func (oh *OrganizationHandler) MigrateWorkspaceURLs(ctx context.Context) error {
+	if utils.GetDefaultMongoClient() == nil {
+		return nil
+	}
+
+	coll := utils.GetCollection(OrganizationCollectionName)
+
+	cursor, err := coll.Find(ctx, bson.M{}, options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}}).
+		SetProjection(bson.M{"name": 1, "workspace_url": 1}))
+	if err != nil {
+		return err
+	}
+
+	var orgs []Organization
+	if err := cursor.All(ctx, &orgs); err != nil {
+		return err
+	}
+
+	generate := func(name string) string {
+		url := utils.GenWorkspaceURL(name)
+
+		for i := 1; i < maxURLAttempts; i++ {
+			if owner, err := workspaceURLOwner(ctx, url); err == nil && owner == "" {
+				break
+			}
+
+			url = utils.GenWorkspaceURL(name)
+		}
+
+		return url
+	}
+
+	redirectUntil := time.Now().Add(time.Duration(oh.configs.WorkspaceURLRedirectTTL) * time.Second)
+
+	for _, c := range dedupeWorkspaceURLs(orgs, generate) {
+		objID, _ := primitive.ObjectIDFromHex(c.OrgID)
+
+		if _, err := coll.UpdateOne(ctx, bson.M{"_id": objID}, bson.M{"$set": bson.M{"workspace_url": c.To}}); err != nil {
+			return err
+		}
+
+		if c.Redirect == "" {
+			continue
+		}
+
+		if _, err := utils.GetCollection(URLRedirectCollectionName).UpdateOne(ctx, bson.M{"url": c.Redirect},
+			bson.M{"$set": bson.M{"org_id": c.OrgID, "created_at": time.Now(), "expires_at": redirectUntil}},
+			options.Update().SetUpsert(true)); err != nil {
+			return err
+		}
+	}
+
+	return utils.CreatePartialUniqueIndex(OrganizationCollectionName, "workspace_url",
+		bson.M{"workspace_url": bson.M{"$type": "string"}})
+}
+
+// Check whether an organization can change its workspace url to the one in the "url"
+// query parameter.
+func (oh *OrganizationHandler) CheckURLAvailability(w http.ResponseWriter, r *http.Request) {
+	orgID := mux.Vars(r)["id"]
+	raw := r.URL.Query().Get("url")
+
+	url, err := normalizeWorkspaceURL(raw)
+	if err != nil {
+		utils.GetSuccess("workspace url is not available", utils.M{"url": raw, "available": false, "reason": err.Error()}, w)
+		return
+	}
+
+	owner, err := workspaceURLOwner(r.Context(), url)
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	if owner != "" && owner != orgID {
+		utils.GetSuccess("workspace url is not available", utils.M{"url": url, "available": false, "reason": ErrWorkspaceURLTaken.Error()}, w)
+		return
+	}
+
+	utils.GetSuccess("workspace url is available", utils.M{"url": url, "available": true}, w)
+}
+
+// Update an organization workspace url. The previous url keeps resolving to the
+// organization for WorkspaceURLRedirectTTL seconds.
+func (oh *OrganizationHandler) UpdateURL(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	orgID := mux.Vars(r)["id"]
+
+	objID, err := primitive.ObjectIDFromHex(orgID)
+	if err != nil {
+		utils.GetError(errors.New("invalid id"), http.StatusBadRequest, w)
+		return
+	}
+
+	var req struct {
+		URL string `json:"url"`
+	}
+
+	if err := utils.ParseJSONFromRequest(r, &req); err != nil {
+		utils.GetError(err, http.StatusUnprocessableEntity, w)
+		return
+	}
+
+	url, err := normalizeWorkspaceURL(req.URL)
+	if err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	var org Organization
+	if err := utils.GetCollection(OrganizationCollectionName).FindOne(r.Context(), bson.M{"_id": objID}).Decode(&org); err != nil {
+		utils.GetError(errors.New("organization does not exist"), http.StatusNotFound, w)
+		return
+	}
+
+	if org.WorkspaceURL == url {
+		utils.GetSuccess("organization url updated successfully", utils.M{"workspace_url": url}, w)
+		return
+	}
+
+	owner, err := workspaceURLOwner(r.Context(), url)
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	if owner != "" && owner != orgID {
+		utils.GetError(ErrWorkspaceURLTaken, http.StatusConflict, w)
+		return
+	}
+
+	redirectUntil := time.Now().Add(time.Duration(oh.configs.WorkspaceURLRedirectTTL) * time.Second)
+
+	if err := moveWorkspaceURL(r.Context(), &org, url, redirectUntil); err != nil {
+		if errors.Is(err, ErrWorkspaceURLTaken) {
+			utils.GetError(err, http.StatusConflict, w)
+			return
+		}
+
+		utils.GetError(err, http.StatusInternalServerError, w)
+
+		return
+	}
+
+	emitOrgEvent(orgID, UpdateOrganizationURL, map[string]interface{}{"workspace_url": url, "previous_url": org.WorkspaceURL})
+
+	recordOrgChange(r, orgID, audit.ActionOrgUpdate, bson.M{"workspace_url": org.WorkspaceURL}, bson.M{"workspace_url": url})
+
+	utils.GetSuccess("organization url updated successfully", utils.M{"workspace_url": url}, w)
+}
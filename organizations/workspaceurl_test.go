@@ -0,0 +1,60 @@
+package organizations
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeWorkspaceURL(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want string
+		err  error
+	}{
+		{"Acme-Team", "acme-team.zurichat.com", nil},
+		{" https://acme.zurichat.com/ ", "acme.zurichat.com", nil},
+		{"ab", "", ErrWorkspaceURLInvalid},
+		{"-acme", "", ErrWorkspaceURLInvalid},
+		{"acme team", "", ErrWorkspaceURLInvalid},
+		{"www", "", ErrWorkspaceURLReserved},
+	}
+
+	for _, tt := range tests {
+		got, err := normalizeWorkspaceURL(tt.raw)
+		if got != tt.want || !errors.Is(err, tt.err) {
+			t.Errorf("normalizeWorkspaceURL(%q) = %q, %v, want %q, %v", tt.raw, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestDedupeWorkspaceURLs(t *testing.T) {
+	orgs := []Organization{
+		{ID: "1", Name: "Zuri Chat", WorkspaceURL: "zurichat-abc1234.zurichat.com"},
+		{ID: "2", Name: "Zuri Chat", WorkspaceURL: "zurichat-abc1234.zurichat.com"},
+		{ID: "3", Name: "Acme", WorkspaceURL: "Acme.zurichat.com"},
+		{ID: "4", Name: "Legacy", WorkspaceURL: "https://www.zuri.chat/legacy"},
+		{ID: "5", Name: "Fresh"},
+	}
+
+	// the first url generated collides with one already taken
+	generated := []string{"acme.zurichat.com", "zuri-chat-x.zurichat.com", "legacy-y.zurichat.com", "fresh-z.zurichat.com"}
+	generate := func(string) string {
+		url := generated[0]
+		generated = generated[1:]
+
+		return url
+	}
+
+	got := dedupeWorkspaceURLs(orgs, generate)
+	want := []urlChange{
+		{OrgID: "2", To: "zuri-chat-x.zurichat.com"},
+		{OrgID: "3", To: "acme.zurichat.com"},
+		{OrgID: "4", To: "legacy-y.zurichat.com", Redirect: "https://www.zuri.chat/legacy"},
+		{OrgID: "5", To: "fresh-z.zurichat.com"},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("dedupeWorkspaceURLs() = %+v, want %+v", got, want)
+	}
+}
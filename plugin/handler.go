@@ -10,6 +10,7 @@ import (
 	"github.com/mitchellh/mapstructure"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"zuri.chat/zccore/audit"
 )
 
 type Handler struct {
@@ -84,6 +85,8 @@ func (h *Handler) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	audit.Record(r, audit.Event{Action: audit.ActionPluginRegister, TargetType: audit.TargetPlugin, TargetID: newPlugin.ID.Hex()})
+
 	h.successResponse(w, http.StatusCreated, "plugin created", D{"plugin": newPlugin})
 }
 
@@ -112,6 +115,9 @@ func (h *Handler) Update(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	_, changes := audit.Diff(nil, pp)
+	audit.Record(r, audit.Event{Action: audit.ActionPluginUpdate, TargetType: audit.TargetPlugin, TargetID: id, After: changes})
+
 	h.successResponse(w, http.StatusOK, "plugin updated", nil)
 }
 
@@ -134,6 +140,8 @@ func(h *Handler) Delete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	audit.Record(r, audit.Event{Action: audit.ActionPluginDelete, TargetType: audit.TargetPlugin, TargetID: id})
+
 	h.successResponse(w, http.StatusOK, "plugin deleted", nil)
 }
 
@@ -34,6 +34,11 @@ type Plugin struct {
 	CreatedAt      string             `json:"created_at" bson:"created_at"`
 	UpdatedAt      string             `json:"updated_at" bson:"updated_at"`
 	SyncRequestURL string             `json:"sync_request_url" bson:"sync_request_url"`
+	Featured       bool               `json:"featured" bson:"featured"`
+	FeaturedOrder  int                `json:"featured_order" bson:"featured_order"`
+	Delisted       bool               `json:"delisted" bson:"delisted"`
+	DelistReason   string             `json:"delist_reason,omitempty" bson:"delist_reason,omitempty"`
+	DelistedAt     string             `json:"delisted_at,omitempty" bson:"delisted_at,omitempty"`
 	Queue          []MessageModel     `json:"queue" bson:"queue"`
 	QueuePID       int                `json:"queuepid" bson:"queuepid"`
 }
@@ -62,7 +62,7 @@ func Auth(w http.ResponseWriter, r *http.Request) {
 	// 2.1: Validate token
 	conf := utils.NewConfigurations()
 
-	claims, err := TokenStringClaims(token, []byte(conf.HmacSampleSecret))
+	claims, err := TokenStringClaims(token, conf.SigningKeys)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
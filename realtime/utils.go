@@ -135,9 +135,8 @@ func CentrifugoNotAuthenticatedResponse(w http.ResponseWriter) {
 func CentifugoConnectAuth(r *http.Request) (userID string, err error) {
 	// 1. Validate the token
 	configuration := utils.NewConfigurations()
-	signingKey := configuration.HmacSampleSecret
 
-	status, sessionData, err := auth.GetSessionDataFromToken(r, []byte(signingKey))
+	status, sessionData, err := auth.GetSessionDataFromToken(r, configuration.SigningKeys)
 	if err != nil {
 		return "", err
 	}
@@ -179,21 +178,19 @@ func UserIDFromSession(sessionData *auth.ResToken, conf *utils.Configurations) (
 }
 
 // Get session data from token string.
-func TokenStringClaims(bearerToken string, hmacSampleSecret []byte) (claimsInfo map[string]interface{}, err error) {
+func TokenStringClaims(bearerToken string, keys utils.SigningKeys) (claimsInfo map[string]interface{}, err error) {
 	if bearerToken == "" {
 		return nil, errors.New("authorization access failed")
 	}
 
-	tokenKey, err := jwt.Parse(bearerToken, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return hmacSampleSecret, nil
-	})
+	tokenKey, err := jwt.Parse(bearerToken, keys.Keyfunc)
+	if err != nil {
+		return nil, err
+	}
 
-	if claims, ok := tokenKey.Claims.(jwt.MapClaims); ok && tokenKey.Valid {
+	if claims, ok := tokenKey.Claims.(jwt.MapClaims); ok && tokenKey.Valid && claims.VerifyExpiresAt(time.Now().Unix(), true) {
 		return claims, nil
 	}
 
-	return nil, err
+	return nil, errors.New("authorization access failed")
 }
@@ -0,0 +1,593 @@
+package scim
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// filter is a parsed SCIM filter expression (RFC 7644, section 3.4.2.2).
+type filter interface{}
+
+type compareFilter struct {
+	Path  string // lower case, sub attributes joined with a dot
+	Op    string // lower case operator
+	Value interface{}
+}
+
+type logicalFilter struct {
+	Op          string // "and" or "or"
+	Left, Right filter
+}
+
+type notFilter struct {
+	Filter filter
+}
+
+// valuePathFilter matches when one value of a multi-valued attribute matches Filter.
+type valuePathFilter struct {
+	Path   string
+	Filter filter
+}
+
+var operators = map[string]bool{
+	"eq": true, "ne": true, "co": true, "sw": true, "ew": true,
+	"gt": true, "ge": true, "lt": true, "le": true, "pr": true,
+}
+
+type filterParser struct {
+	tokens []string
+	pos    int
+}
+
+func parseFilter(s string) (filter, error) {
+	tokens, err := tokenizeFilter(s)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("%w: empty filter", ErrInvalidFilter)
+	}
+
+	p := &filterParser{tokens: tokens}
+
+	f, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.pos != len(p.tokens) {
+		return nil, fmt.Errorf("%w: unexpected %q", ErrInvalidFilter, p.tokens[p.pos])
+	}
+
+	return f, nil
+}
+
+// tokenizeFilter splits a filter into brackets, quoted strings and words.
+func tokenizeFilter(s string) ([]string, error) {
+	var tokens []string
+
+	for i := 0; i < len(s); {
+		c := s[i]
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(' || c == ')' || c == '[' || c == ']':
+			tokens = append(tokens, string(c))
+			i++
+		case c == '"':
+			j := i + 1
+
+			for ; j < len(s) && s[j] != '"'; j++ {
+				if s[j] == '\\' {
+					j++
+				}
+			}
+
+			if j >= len(s) {
+				return nil, fmt.Errorf("%w: unterminated string", ErrInvalidFilter)
+			}
+
+			tokens = append(tokens, s[i:j+1])
+			i = j + 1
+		default:
+			j := i
+			for j < len(s) && !strings.ContainsRune(" \t\n\r()[]\"", rune(s[j])) {
+				j++
+			}
+
+			tokens = append(tokens, s[i:j])
+			i = j
+		}
+	}
+
+	return tokens, nil
+}
+
+func (p *filterParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+
+	return ""
+}
+
+func (p *filterParser) next() string {
+	t := p.peek()
+	p.pos++
+
+	return t
+}
+
+func (p *filterParser) expect(t string) error {
+	if got := p.next(); got != t {
+		return fmt.Errorf("%w: expected %q", ErrInvalidFilter, t)
+	}
+
+	return nil
+}
+
+func (p *filterParser) parseOr() (filter, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for strings.EqualFold(p.peek(), "or") {
+		p.next()
+
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+
+		left = logicalFilter{Op: "or", Left: left, Right: right}
+	}
+
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (filter, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for strings.EqualFold(p.peek(), "and") {
+		p.next()
+
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+
+		left = logicalFilter{Op: "and", Left: left, Right: right}
+	}
+
+	return left, nil
+}
+
+func (p *filterParser) parseUnary() (filter, error) {
+	switch t := p.peek(); {
+	case strings.EqualFold(t, "not"):
+		p.next()
+
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+
+		f, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+
+		return notFilter{Filter: f}, p.expect(")")
+	case t == "(":
+		p.next()
+
+		f, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+
+		return f, p.expect(")")
+	default:
+		return p.parseAttribute()
+	}
+}
+
+func (p *filterParser) parseAttribute() (filter, error) {
+	path := attributePath(p.next())
+	if path == "" || strings.ContainsAny(path, "()[]\"") {
+		return nil, fmt.Errorf("%w: expected an attribute", ErrInvalidFilter)
+	}
+
+	if p.peek() != "[" {
+		return p.parseComparison(path)
+	}
+
+	p.next()
+
+	inner, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.expect("]"); err != nil {
+		return nil, err
+	}
+
+	// emails[type eq "work"].value eq "x" matches a single value with both properties
+	if sub := p.peek(); strings.HasPrefix(sub, ".") {
+		p.next()
+
+		cmp, err := p.parseComparison(strings.ToLower(sub[1:]))
+		if err != nil {
+			return nil, err
+		}
+
+		inner = logicalFilter{Op: "and", Left: inner, Right: cmp}
+	}
+
+	return valuePathFilter{Path: path, Filter: inner}, nil
+}
+
+func (p *filterParser) parseComparison(path string) (filter, error) {
+	op := strings.ToLower(p.next())
+	if !operators[op] {
+		return nil, fmt.Errorf("%w: unknown operator %q", ErrInvalidFilter, op)
+	}
+
+	if op == "pr" {
+		return compareFilter{Path: path, Op: op}, nil
+	}
+
+	value, err := parseFilterValue(p.next())
+	if err != nil {
+		return nil, err
+	}
+
+	return compareFilter{Path: path, Op: op, Value: value}, nil
+}
+
+func parseFilterValue(t string) (interface{}, error) {
+	if t == "" {
+		return nil, fmt.Errorf("%w: missing comparison value", ErrInvalidFilter)
+	}
+
+	var v interface{}
+	if err := json.Unmarshal([]byte(t), &v); err != nil {
+		return nil, fmt.Errorf("%w: invalid comparison value %s", ErrInvalidFilter, t)
+	}
+
+	return v, nil
+}
+
+// attributePath lower cases a path and drops a schema URN prefix, so
+// "urn:ietf:params:scim:schemas:core:2.0:User:name.givenName" becomes "name.givenname".
+func attributePath(s string) string {
+	if i := strings.LastIndex(s, ":"); i >= 0 && strings.HasPrefix(strings.ToLower(s), "urn:") {
+		s = s[i+1:]
+	}
+
+	return strings.ToLower(s)
+}
+
+// attributeKind says how a SCIM attribute is stored in a mongo document.
+type attributeKind int
+
+const (
+	stringAttribute attributeKind = iota
+	exactStringAttribute
+	objectIDAttribute
+	dateAttribute
+	// inactiveAttribute is the boolean "active", stored inverted as "deleted"
+	inactiveAttribute
+	// constantAttribute is not stored, every resource has the same value
+	constantAttribute
+)
+
+type mongoAttribute struct {
+	Field    string
+	Kind     attributeKind
+	Constant interface{}
+}
+
+// matchNone never matches a document.
+var matchNone = bson.M{"_id": bson.M{"$exists": false}}
+
+// mongoFilter translates f into a mongo query over documents described by attrs.
+func mongoFilter(f filter, attrs map[string]mongoAttribute) (bson.M, error) {
+	return compileFilter(f, "", attrs)
+}
+
+func compileFilter(f filter, prefix string, attrs map[string]mongoAttribute) (bson.M, error) {
+	switch f := f.(type) {
+	case logicalFilter:
+		left, err := compileFilter(f.Left, prefix, attrs)
+		if err != nil {
+			return nil, err
+		}
+
+		right, err := compileFilter(f.Right, prefix, attrs)
+		if err != nil {
+			return nil, err
+		}
+
+		return bson.M{"$" + f.Op: []bson.M{left, right}}, nil
+	case notFilter:
+		inner, err := compileFilter(f.Filter, prefix, attrs)
+		if err != nil {
+			return nil, err
+		}
+
+		return bson.M{"$nor": []bson.M{inner}}, nil
+	case valuePathFilter:
+		return compileFilter(f.Filter, prefix+f.Path+".", attrs)
+	case compareFilter:
+		path := prefix + f.Path
+
+		attr, ok := attrs[path]
+		if !ok {
+			return nil, fmt.Errorf("%w: unsupported attribute %s", ErrInvalidFilter, path)
+		}
+
+		return compileComparison(attr, f)
+	default:
+		return nil, ErrInvalidFilter
+	}
+}
+
+func compileComparison(attr mongoAttribute, f compareFilter) (bson.M, error) {
+	switch attr.Kind {
+	case constantAttribute:
+		if compareValues(attr.Constant, f.Op, f.Value) {
+			return bson.M{}, nil
+		}
+
+		return matchNone, nil
+	case inactiveAttribute:
+		if f.Op == "pr" {
+			return bson.M{}, nil
+		}
+
+		active, ok := f.Value.(bool)
+		if !ok || (f.Op != "eq" && f.Op != "ne") {
+			return nil, fmt.Errorf("%w: active can only be compared with eq or ne and a boolean", ErrInvalidFilter)
+		}
+
+		if active == (f.Op == "eq") {
+			return bson.M{attr.Field: bson.M{"$ne": true}}, nil
+		}
+
+		return bson.M{attr.Field: true}, nil
+	case objectIDAttribute:
+		s, ok := f.Value.(string)
+		if f.Op == "pr" {
+			return bson.M{}, nil
+		}
+
+		if !ok || (f.Op != "eq" && f.Op != "ne") {
+			return nil, fmt.Errorf("%w: id can only be compared with eq or ne", ErrInvalidFilter)
+		}
+
+		id, err := primitive.ObjectIDFromHex(s)
+		if err != nil {
+			if f.Op == "eq" {
+				return matchNone, nil
+			}
+
+			return bson.M{}, nil
+		}
+
+		return bson.M{attr.Field: bson.M{"$" + f.Op: id}}, nil
+	case dateAttribute:
+		if f.Op == "pr" {
+			return bson.M{attr.Field: bson.M{"$exists": true}}, nil
+		}
+
+		s, _ := f.Value.(string)
+
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil || f.Op == "co" || f.Op == "sw" || f.Op == "ew" {
+			return nil, fmt.Errorf("%w: dates are compared with eq, ne, gt, ge, lt or le and an RFC 3339 timestamp", ErrInvalidFilter)
+		}
+
+		return bson.M{attr.Field: bson.M{"$" + f.Op: t}}, nil
+	}
+
+	if f.Op == "pr" {
+		return bson.M{attr.Field: bson.M{"$exists": true, "$nin": []interface{}{"", nil}}}, nil
+	}
+
+	s, ok := f.Value.(string)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s is compared with a string", ErrInvalidFilter, f.Path)
+	}
+
+	if attr.Kind == exactStringAttribute {
+		switch f.Op {
+		case "eq", "ne", "gt", "ge", "lt", "le":
+			return bson.M{attr.Field: bson.M{"$" + f.Op: s}}, nil
+		}
+	}
+
+	quoted := regexp.QuoteMeta(s)
+	options := "i"
+
+	if attr.Kind == exactStringAttribute {
+		options = ""
+	}
+
+	switch f.Op {
+	case "eq":
+		return bson.M{attr.Field: primitive.Regex{Pattern: "^" + quoted + "$", Options: options}}, nil
+	case "ne":
+		return bson.M{attr.Field: bson.M{"$not": primitive.Regex{Pattern: "^" + quoted + "$", Options: options}}}, nil
+	case "co":
+		return bson.M{attr.Field: primitive.Regex{Pattern: quoted, Options: options}}, nil
+	case "sw":
+		return bson.M{attr.Field: primitive.Regex{Pattern: "^" + quoted, Options: options}}, nil
+	case "ew":
+		return bson.M{attr.Field: primitive.Regex{Pattern: quoted + "$", Options: options}}, nil
+	default:
+		return bson.M{attr.Field: bson.M{"$" + f.Op: s}}, nil
+	}
+}
+
+// matchFilter evaluates f against a resource in its JSON form.
+func matchFilter(f filter, resource map[string]interface{}) bool {
+	switch f := f.(type) {
+	case logicalFilter:
+		if f.Op == "and" {
+			return matchFilter(f.Left, resource) && matchFilter(f.Right, resource)
+		}
+
+		return matchFilter(f.Left, resource) || matchFilter(f.Right, resource)
+	case notFilter:
+		return !matchFilter(f.Filter, resource)
+	case valuePathFilter:
+		for _, v := range asList(lookup(resource, f.Path)) {
+			if m, ok := v.(map[string]interface{}); ok && matchFilter(f.Filter, m) {
+				return true
+			}
+		}
+
+		return false
+	case compareFilter:
+		for _, v := range lookupAll(resource, strings.Split(f.Path, ".")) {
+			if compareValues(v, f.Op, f.Value) {
+				return true
+			}
+		}
+
+		return false
+	default:
+		return false
+	}
+}
+
+// lookupAll resolves a dotted path, descending into every value of multi-valued attributes.
+func lookupAll(resource map[string]interface{}, path []string) []interface{} {
+	var out []interface{}
+
+	for _, v := range asList(lookup(resource, path[0])) {
+		if len(path) == 1 {
+			out = append(out, v)
+			continue
+		}
+
+		if m, ok := v.(map[string]interface{}); ok {
+			out = append(out, lookupAll(m, path[1:])...)
+		}
+	}
+
+	return out
+}
+
+// lookup returns the attribute of resource with a case insensitive name.
+func lookup(resource map[string]interface{}, name string) interface{} {
+	if key, ok := findKey(resource, name); ok {
+		return resource[key]
+	}
+
+	return nil
+}
+
+func findKey(resource map[string]interface{}, name string) (string, bool) {
+	for k := range resource {
+		if strings.EqualFold(k, name) {
+			return k, true
+		}
+	}
+
+	return "", false
+}
+
+func asList(v interface{}) []interface{} {
+	switch v := v.(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		return v
+	default:
+		return []interface{}{v}
+	}
+}
+
+// compareValues applies op to a resource value and a filter value. Strings compare
+// case insensitively.
+func compareValues(have interface{}, op string, want interface{}) bool {
+	if op == "pr" {
+		return have != nil && have != ""
+	}
+
+	switch h := have.(type) {
+	case string:
+		w, ok := want.(string)
+		if !ok {
+			return op == "ne"
+		}
+
+		h, w = strings.ToLower(h), strings.ToLower(w)
+
+		switch op {
+		case "eq":
+			return h == w
+		case "ne":
+			return h != w
+		case "co":
+			return strings.Contains(h, w)
+		case "sw":
+			return strings.HasPrefix(h, w)
+		case "ew":
+			return strings.HasSuffix(h, w)
+		case "gt":
+			return h > w
+		case "ge":
+			return h >= w
+		case "lt":
+			return h < w
+		case "le":
+			return h <= w
+		}
+	case bool:
+		w, ok := want.(bool)
+
+		switch op {
+		case "eq":
+			return ok && h == w
+		case "ne":
+			return !ok || h != w
+		}
+	case float64:
+		w, ok := want.(float64)
+		if !ok {
+			return op == "ne"
+		}
+
+		switch op {
+		case "eq":
+			return h == w
+		case "ne":
+			return h != w
+		case "gt":
+			return h > w
+		case "ge":
+			return h >= w
+		case "lt":
+			return h < w
+		case "le":
+			return h <= w
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,156 @@
+package scim
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestParseFilter(t *testing.T) {
+	tests := []struct {
+		in   string
+		want filter
+	}{
+		{`userName eq "ada@example.com"`, compareFilter{Path: "username", Op: "eq", Value: "ada@example.com"}},
+		{`urn:ietf:params:scim:schemas:core:2.0:User:userName EQ "a"`, compareFilter{Path: "username", Op: "eq", Value: "a"}},
+		{`active eq true`, compareFilter{Path: "active", Op: "eq", Value: true}},
+		{`title pr`, compareFilter{Path: "title", Op: "pr"}},
+		{
+			`name.givenName sw "A" and not (active eq false) or externalId eq "x"`,
+			logicalFilter{
+				Op: "or",
+				Left: logicalFilter{
+					Op:    "and",
+					Left:  compareFilter{Path: "name.givenname", Op: "sw", Value: "A"},
+					Right: notFilter{Filter: compareFilter{Path: "active", Op: "eq", Value: false}},
+				},
+				Right: compareFilter{Path: "externalid", Op: "eq", Value: "x"},
+			},
+		},
+		{
+			`emails[type eq "work" and value co "@example.com"]`,
+			valuePathFilter{Path: "emails", Filter: logicalFilter{
+				Op:    "and",
+				Left:  compareFilter{Path: "type", Op: "eq", Value: "work"},
+				Right: compareFilter{Path: "value", Op: "co", Value: "@example.com"},
+			}},
+		},
+	}
+
+	for _, tt := range tests {
+		got, err := parseFilter(tt.in)
+		if err != nil {
+			t.Errorf("parseFilter(%q) error: %v", tt.in, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseFilter(%q) = %#v, want %#v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseFilterErrors(t *testing.T) {
+	for _, in := range []string{
+		`userName`,
+		`userName eq`,
+		`userName like "a"`,
+		`(userName eq "a"`,
+		`userName eq "a" and`,
+		`emails[value eq "a"`,
+		`userName eq "unterminated`,
+	} {
+		if _, err := parseFilter(in); !errors.Is(err, ErrInvalidFilter) {
+			t.Errorf("parseFilter(%q) error = %v, want ErrInvalidFilter", in, err)
+		}
+	}
+}
+
+func TestMongoFilter(t *testing.T) {
+	id := primitive.NewObjectID()
+
+	tests := []struct {
+		in   string
+		want bson.M
+	}{
+		{`userName eq "a.b@example.com"`, bson.M{"email": primitive.Regex{Pattern: `^a\.b@example\.com$`, Options: "i"}}},
+		{`emails[value sw "ada"]`, bson.M{"email": primitive.Regex{Pattern: "^ada", Options: "i"}}},
+		{`externalId eq "X1"`, bson.M{"external_id": bson.M{"$eq": "X1"}}},
+		{`id eq "` + id.Hex() + `"`, bson.M{"_id": bson.M{"$eq": id}}},
+		{`id eq "not-an-id"`, matchNone},
+		{`active eq true`, bson.M{"deleted": bson.M{"$ne": true}}},
+		{`active ne true`, bson.M{"deleted": true}},
+		{`emails.primary eq true`, bson.M{}},
+		{`emails.type eq "home"`, matchNone},
+		{
+			`not (roles.value eq "admin")`,
+			bson.M{"$nor": []bson.M{{"role": primitive.Regex{Pattern: "^admin$", Options: "i"}}}},
+		},
+	}
+
+	for _, tt := range tests {
+		f, err := parseFilter(tt.in)
+		if err != nil {
+			t.Fatalf("parseFilter(%q) error: %v", tt.in, err)
+		}
+
+		got, err := mongoFilter(f, userAttributes)
+		if err != nil {
+			t.Errorf("mongoFilter(%q) error: %v", tt.in, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("mongoFilter(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+
+	for _, in := range []string{`password eq "x"`, `active eq "yes"`, `meta.created co "2021"`} {
+		f, err := parseFilter(in)
+		if err != nil {
+			t.Fatalf("parseFilter(%q) error: %v", in, err)
+		}
+
+		if _, err := mongoFilter(f, userAttributes); !errors.Is(err, ErrInvalidFilter) {
+			t.Errorf("mongoFilter(%q) error = %v, want ErrInvalidFilter", in, err)
+		}
+	}
+}
+
+func TestMatchFilter(t *testing.T) {
+	group := map[string]interface{}{
+		"id":          "admin",
+		"displayName": "Admins",
+		"members": []interface{}{
+			map[string]interface{}{"value": "1", "display": "ada@example.com"},
+			map[string]interface{}{"value": "2", "display": "grace@example.com"},
+		},
+	}
+
+	tests := []struct {
+		in   string
+		want bool
+	}{
+		{`displayName eq "admins"`, true},
+		{`displayName eq "Owners"`, false},
+		{`members[value eq "2"]`, true},
+		{`members.value eq "3"`, false},
+		{`members.display ew "@example.com" and id sw "ad"`, true},
+		{`not (displayName pr)`, false},
+		{`externalId pr or displayName co "min"`, true},
+	}
+
+	for _, tt := range tests {
+		f, err := parseFilter(tt.in)
+		if err != nil {
+			t.Fatalf("parseFilter(%q) error: %v", tt.in, err)
+		}
+
+		if got := matchFilter(f, group); got != tt.want {
+			t.Errorf("matchFilter(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
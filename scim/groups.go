@@ -0,0 +1,339 @@
+package scim
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"zuri.chat/zccore/organizations"
+	"zuri.chat/zccore/utils"
+)
+
+// groupRoles are the roles exposed as Groups, in listing order. Groups are fixed:
+// membership changes a member's role and members removed from a group become plain
+// members again.
+var groupRoles = []string{
+	organizations.OwnerRole,
+	organizations.AdminRole,
+	organizations.EditorRole,
+	organizations.MemberRole,
+	organizations.GuestRole,
+}
+
+// Group is a SCIM Group resource for one member role.
+type Group struct {
+	Schemas     []string     `json:"schemas"`
+	ID          string       `json:"id"`
+	DisplayName string       `json:"displayName"`
+	Members     []MultiValue `json:"members,omitempty"`
+	Meta        *Meta        `json:"meta,omitempty"`
+}
+
+// groupName is the display name of the group of a role, e.g. "Admins".
+func groupName(role string) string {
+	if role == "" {
+		return ""
+	}
+
+	return strings.ToUpper(role[:1]) + role[1:] + "s"
+}
+
+func isGroup(id string) bool {
+	for _, role := range groupRoles {
+		if id == role {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (h *Handler) toGroup(ctx context.Context, orgID, role string, withMembers bool) (*Group, error) {
+	g := &Group{
+		Schemas:     []string{SchemaGroup},
+		ID:          role,
+		DisplayName: groupName(role),
+		Meta:        &Meta{ResourceType: "Group", Location: h.baseURL(orgID) + "/Groups/" + role},
+	}
+
+	if !withMembers {
+		return g, nil
+	}
+
+	members, err := roleMembers(ctx, orgID, role)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, m := range members {
+		g.Members = append(g.Members, MultiValue{Value: m.ID, Display: m.Email, Ref: h.baseURL(orgID) + "/Users/" + m.ID})
+	}
+
+	return g, nil
+}
+
+// roleMembers returns the active members with a role.
+func roleMembers(ctx context.Context, orgID, role string) ([]organizations.Member, error) {
+	opts := options.Find().SetProjection(bson.M{"_id": 1, "email": 1, "role": 1}).SetSort(bson.M{"_id": 1})
+
+	cursor, err := utils.GetCollection(organizations.MemberCollectionName).Find(ctx,
+		bson.M{"org_id": orgID, "role": role, "deleted": bson.M{"$ne": true}}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var members []organizations.Member
+
+	return members, cursor.All(ctx, &members)
+}
+
+// List the role groups of the organization.
+func (h *Handler) GetGroups(w http.ResponseWriter, r *http.Request) {
+	orgID := mux.Vars(r)["org"]
+
+	params, err := parseListParams(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	withMembers := !params.ExcludedAttributes["members"]
+
+	var matched []interface{}
+
+	for _, role := range groupRoles {
+		g, err := h.toGroup(r.Context(), orgID, role, withMembers || params.Filter != nil)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		if params.Filter != nil {
+			resource, err := toMap(g)
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+
+			if !matchFilter(params.Filter, resource) {
+				continue
+			}
+		}
+
+		if !withMembers {
+			g.Members = nil
+		}
+
+		matched = append(matched, g)
+	}
+
+	var page []interface{}
+
+	if start := int(params.StartIndex - 1); start < len(matched) {
+		page = matched[start:]
+	}
+
+	if len(page) > params.Count {
+		page = page[:params.Count]
+	}
+
+	writeResource(w, http.StatusOK, newListResponse(int64(len(matched)), params, page))
+}
+
+// Get a role group.
+func (h *Handler) GetGroup(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	if !isGroup(vars["group_id"]) {
+		writeError(w, ErrNotFound)
+		return
+	}
+
+	excluded := strings.Contains(strings.ToLower(r.URL.Query().Get("excludedAttributes")), "members")
+
+	g, err := h.toGroup(r.Context(), vars["org"], vars["group_id"], !excluded)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeResource(w, http.StatusOK, g)
+}
+
+// Groups are the fixed set of roles, so they can't be created or deleted.
+func (h *Handler) CreateGroup(w http.ResponseWriter, r *http.Request) {
+	writeError(w, fmt.Errorf("%w: groups are the organization's roles and can't be created", ErrMutability))
+}
+
+func (h *Handler) DeleteGroup(w http.ResponseWriter, r *http.Request) {
+	writeError(w, fmt.Errorf("%w: groups are the organization's roles and can't be deleted", ErrMutability))
+}
+
+// Replace the members of a role group.
+func (h *Handler) ReplaceGroup(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	current, ok := h.loadGroup(w, r, vars["org"], vars["group_id"])
+	if !ok {
+		return
+	}
+
+	var g Group
+	if err := decodeResource(r, &g); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	h.saveGroup(w, r, current, &g)
+}
+
+// Patch the members of a role group.
+func (h *Handler) PatchGroup(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	current, ok := h.loadGroup(w, r, vars["org"], vars["group_id"])
+	if !ok {
+		return
+	}
+
+	var req PatchRequest
+	if err := decodeResource(r, &req); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	resource, err := toMap(current)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := applyPatch(resource, req.Operations); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	var g Group
+	if err := fromMap(resource, &g); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	h.saveGroup(w, r, current, &g)
+}
+
+func (h *Handler) loadGroup(w http.ResponseWriter, r *http.Request, orgID, role string) (*Group, bool) {
+	if !isGroup(role) {
+		writeError(w, ErrNotFound)
+		return nil, false
+	}
+
+	g, err := h.toGroup(r.Context(), orgID, role, true)
+	if err != nil {
+		writeError(w, err)
+		return nil, false
+	}
+
+	return g, true
+}
+
+// saveGroup gives the role to members added to the group and makes members removed
+// from it plain members.
+func (h *Handler) saveGroup(w http.ResponseWriter, r *http.Request, current, g *Group) {
+	orgID, role := mux.Vars(r)["org"], current.ID
+
+	if g.DisplayName != "" && !strings.EqualFold(g.DisplayName, current.DisplayName) {
+		writeError(w, fmt.Errorf("%w: group names are fixed", ErrMutability))
+		return
+	}
+
+	if role == organizations.OwnerRole || role == organizations.MemberRole {
+		if !sameMembers(current.Members, g.Members) {
+			writeError(w, fmt.Errorf("%w: members of the %s group are changed through the other groups", ErrMutability, current.DisplayName))
+			return
+		}
+	}
+
+	before, after := memberSet(current.Members), memberSet(g.Members)
+
+	var added, removed []*organizations.Member
+
+	for id := range after {
+		if before[id] {
+			continue
+		}
+
+		m, err := findMember(r.Context(), orgID, id)
+		if err != nil {
+			writeError(w, fmt.Errorf("%w: member %s is not in the organization", ErrInvalidValue, id))
+			return
+		}
+
+		if err := checkRoleChange(r.Context(), m, role); err != nil {
+			writeError(w, err)
+			return
+		}
+
+		added = append(added, m)
+	}
+
+	for id := range before {
+		if !after[id] {
+			removed = append(removed, &organizations.Member{ID: id})
+		}
+	}
+
+	for _, m := range added {
+		if err := organizations.SetMemberRole(orgID, m.ID, role); err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+
+	for _, m := range removed {
+		if err := organizations.SetMemberRole(orgID, m.ID, organizations.MemberRole); err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+
+	updated, err := h.toGroup(r.Context(), orgID, role, true)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeResource(w, http.StatusOK, updated)
+}
+
+func memberSet(values []MultiValue) map[string]bool {
+	set := make(map[string]bool, len(values))
+
+	for _, v := range values {
+		if _, err := primitive.ObjectIDFromHex(v.Value); err == nil {
+			set[v.Value] = true
+		}
+	}
+
+	return set
+}
+
+func sameMembers(a, b []MultiValue) bool {
+	x, y := memberSet(a), memberSet(b)
+	if len(x) != len(y) {
+		return false
+	}
+
+	for id := range x {
+		if !y[id] {
+			return false
+		}
+	}
+
+	return true
+}
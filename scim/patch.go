@@ -0,0 +1,337 @@
+package scim
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// PatchRequest is a SCIM PATCH body (RFC 7644, section 3.5.2).
+type PatchRequest struct {
+	Schemas    []string         `json:"schemas"`
+	Operations []PatchOperation `json:"Operations"`
+}
+
+type PatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
+
+// patchPath is a parsed PATCH path: attr, attr.sub, attr[filter] or attr[filter].sub.
+type patchPath struct {
+	Attr   string
+	Filter filter
+	Sub    string
+}
+
+// readOnlyAttributes can't be changed through PATCH.
+var readOnlyAttributes = map[string]bool{"id": true, "meta": true, "schemas": true}
+
+func parsePatchPath(s string) (patchPath, error) {
+	var p patchPath
+
+	// the URN prefix ends at the last colon before any value filter
+	head := s
+	if i := strings.Index(head, "["); i >= 0 {
+		head = head[:i]
+	}
+
+	if strings.HasPrefix(strings.ToLower(head), "urn:") {
+		if i := strings.LastIndex(head, ":"); i >= 0 {
+			s = s[i+1:]
+		}
+	}
+
+	if i := strings.Index(s, "["); i >= 0 {
+		j := strings.LastIndex(s, "]")
+		if j < i {
+			return p, fmt.Errorf("%w: %s", ErrInvalidPath, s)
+		}
+
+		f, err := parseFilter(s[i+1 : j])
+		if err != nil {
+			return p, fmt.Errorf("%w: %v", ErrInvalidPath, err)
+		}
+
+		p.Attr, p.Filter = s[:i], f
+
+		rest := s[j+1:]
+		if rest != "" {
+			if !strings.HasPrefix(rest, ".") || len(rest) == 1 {
+				return p, fmt.Errorf("%w: %s", ErrInvalidPath, s)
+			}
+
+			p.Sub = rest[1:]
+		}
+	} else if i := strings.Index(s, "."); i >= 0 {
+		p.Attr, p.Sub = s[:i], s[i+1:]
+	} else {
+		p.Attr = s
+	}
+
+	if p.Attr == "" || strings.ContainsAny(p.Attr+p.Sub, "[]. ") {
+		return p, fmt.Errorf("%w: %s", ErrInvalidPath, s)
+	}
+
+	if readOnlyAttributes[strings.ToLower(p.Attr)] {
+		return p, fmt.Errorf("%w: %s is read only", ErrMutability, p.Attr)
+	}
+
+	return p, nil
+}
+
+// applyPatch applies the operations, in order, to a resource in its JSON form.
+func applyPatch(resource map[string]interface{}, ops []PatchOperation) error {
+	if len(ops) == 0 {
+		return fmt.Errorf("%w: no operations", ErrInvalidValue)
+	}
+
+	for _, op := range ops {
+		var value interface{}
+
+		if len(op.Value) > 0 {
+			if err := json.Unmarshal(op.Value, &value); err != nil {
+				return fmt.Errorf("%w: %v", ErrInvalidValue, err)
+			}
+		}
+
+		if err := applyOperation(resource, strings.ToLower(op.Op), op.Path, value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func applyOperation(resource map[string]interface{}, op, path string, value interface{}) error {
+	if op != "add" && op != "replace" && op != "remove" {
+		return fmt.Errorf("%w: unknown op %q", ErrInvalidSyntax, op)
+	}
+
+	if path == "" {
+		if op == "remove" {
+			return fmt.Errorf("%w: remove needs a path", ErrNoTarget)
+		}
+
+		// without a path the value holds the attributes to change, which some clients
+		// also key by sub attribute path, e.g. {"name.givenName": "Ada"}
+		attrs, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%w: expected an object of attributes", ErrInvalidValue)
+		}
+
+		for k, v := range attrs {
+			if err := applyOperation(resource, op, k, v); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	p, err := parsePatchPath(path)
+	if err != nil {
+		return err
+	}
+
+	if op != "remove" && value == nil {
+		return fmt.Errorf("%w: %s needs a value", ErrInvalidValue, op)
+	}
+
+	if p.Filter != nil {
+		return patchValues(resource, op, p, value)
+	}
+
+	key, exists := findKey(resource, p.Attr)
+	if !exists {
+		key = p.Attr
+	}
+
+	if p.Sub != "" {
+		return patchSubAttribute(resource, key, op, p.Sub, value)
+	}
+
+	switch op {
+	case "remove":
+		if list, ok := resource[key].([]interface{}); ok && value != nil {
+			resource[key] = removeValues(list, value)
+			return nil
+		}
+
+		delete(resource, key)
+	case "add":
+		if list, ok := resource[key].([]interface{}); ok {
+			resource[key] = append(list, asList(value)...)
+			return nil
+		}
+
+		if complexValue, ok := value.(map[string]interface{}); ok {
+			if existing, ok := resource[key].(map[string]interface{}); ok {
+				for k, v := range complexValue {
+					existing[k] = v
+				}
+
+				return nil
+			}
+		}
+
+		resource[key] = value
+	default:
+		resource[key] = value
+	}
+
+	return nil
+}
+
+func patchSubAttribute(resource map[string]interface{}, key, op, sub string, value interface{}) error {
+	parent, ok := resource[key].(map[string]interface{})
+	if !ok {
+		if op == "remove" {
+			return nil
+		}
+
+		parent = map[string]interface{}{}
+		resource[key] = parent
+	}
+
+	subKey, exists := findKey(parent, sub)
+	if !exists {
+		subKey = sub
+	}
+
+	if op == "remove" {
+		delete(parent, subKey)
+	} else {
+		parent[subKey] = value
+	}
+
+	return nil
+}
+
+// patchValues changes the values of a multi-valued attribute that match the path filter.
+func patchValues(resource map[string]interface{}, op string, p patchPath, value interface{}) error {
+	key, exists := findKey(resource, p.Attr)
+	if !exists {
+		key = p.Attr
+	}
+
+	list := asList(resource[key])
+	kept := make([]interface{}, 0, len(list))
+	matched := false
+
+	for _, item := range list {
+		m, ok := item.(map[string]interface{})
+		if !ok || !matchFilter(p.Filter, m) {
+			kept = append(kept, item)
+			continue
+		}
+
+		matched = true
+
+		switch {
+		case op == "remove" && p.Sub == "":
+			continue
+		case op == "remove":
+			if subKey, ok := findKey(m, p.Sub); ok {
+				delete(m, subKey)
+			}
+		case p.Sub != "":
+			if subKey, ok := findKey(m, p.Sub); ok {
+				m[subKey] = value
+			} else {
+				m[p.Sub] = value
+			}
+		default:
+			replacement, ok := value.(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("%w: expected an object for %s", ErrInvalidValue, p.Attr)
+			}
+
+			item = replacement
+		}
+
+		kept = append(kept, item)
+	}
+
+	// a filter that matches nothing on add or replace creates the value it describes,
+	// e.g. phoneNumbers[type eq "mobile"].value
+	if !matched && op != "remove" {
+		created := filterDefaults(p.Filter)
+		if created == nil {
+			return fmt.Errorf("%w: no value of %s matches the filter", ErrNoTarget, p.Attr)
+		}
+
+		if p.Sub != "" {
+			created[p.Sub] = value
+		} else if m, ok := value.(map[string]interface{}); ok {
+			for k, v := range m {
+				created[k] = v
+			}
+		}
+
+		kept = append(kept, created)
+	}
+
+	resource[key] = kept
+
+	return nil
+}
+
+// filterDefaults returns the attributes an equality filter pins down, or nil if the
+// filter doesn't describe a single value.
+func filterDefaults(f filter) map[string]interface{} {
+	switch f := f.(type) {
+	case compareFilter:
+		if f.Op != "eq" || strings.Contains(f.Path, ".") {
+			return nil
+		}
+
+		return map[string]interface{}{f.Path: f.Value}
+	case logicalFilter:
+		if f.Op != "and" {
+			return nil
+		}
+
+		left, right := filterDefaults(f.Left), filterDefaults(f.Right)
+		if left == nil || right == nil {
+			return nil
+		}
+
+		for k, v := range right {
+			left[k] = v
+		}
+
+		return left
+	default:
+		return nil
+	}
+}
+
+// removeValues drops the values of a multi-valued attribute that have the same "value"
+// as one of the given values, as sent by clients that remove group members by value.
+func removeValues(list []interface{}, remove interface{}) []interface{} {
+	drop := map[string]bool{}
+
+	for _, v := range asList(remove) {
+		if m, ok := v.(map[string]interface{}); ok {
+			if s, ok := lookup(m, "value").(string); ok {
+				drop[s] = true
+			}
+		}
+	}
+
+	kept := list[:0:0]
+
+	for _, item := range list {
+		if m, ok := item.(map[string]interface{}); ok {
+			if s, ok := lookup(m, "value").(string); ok && drop[s] {
+				continue
+			}
+		}
+
+		kept = append(kept, item)
+	}
+
+	return kept
+}
@@ -0,0 +1,151 @@
+package scim
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func testUserResource() map[string]interface{} {
+	return map[string]interface{}{
+		"id":       "1",
+		"userName": "ada@example.com",
+		"active":   true,
+		"name":     map[string]interface{}{"givenName": "Ada", "familyName": "Lovelace"},
+		"phoneNumbers": []interface{}{
+			map[string]interface{}{"type": "work", "value": "+100"},
+		},
+	}
+}
+
+func patchOps(t *testing.T, ops string) []PatchOperation {
+	t.Helper()
+
+	var req PatchRequest
+	if err := json.Unmarshal([]byte(`{"Operations":`+ops+`}`), &req); err != nil {
+		t.Fatal(err)
+	}
+
+	return req.Operations
+}
+
+func TestApplyPatch(t *testing.T) {
+	tests := []struct {
+		name string
+		ops  string
+		want func(map[string]interface{})
+	}{
+		{
+			"replace simple attribute",
+			`[{"op":"Replace","path":"active","value":false}]`,
+			func(r map[string]interface{}) { r["active"] = false },
+		},
+		{
+			"replace sub attribute with schema prefix",
+			`[{"op":"replace","path":"urn:ietf:params:scim:schemas:core:2.0:User:name.givenName","value":"Augusta"}]`,
+			func(r map[string]interface{}) { r["name"].(map[string]interface{})["givenName"] = "Augusta" },
+		},
+		{
+			"path-less replace keyed by paths",
+			`[{"op":"replace","value":{"active":false,"name.familyName":"King"}}]`,
+			func(r map[string]interface{}) {
+				r["active"] = false
+				r["name"].(map[string]interface{})["familyName"] = "King"
+			},
+		},
+		{
+			"add merges complex attribute",
+			`[{"op":"add","path":"name","value":{"middleName":"Byron"}}]`,
+			func(r map[string]interface{}) { r["name"].(map[string]interface{})["middleName"] = "Byron" },
+		},
+		{
+			"replace filtered value",
+			`[{"op":"replace","path":"phoneNumbers[type eq \"work\"].value","value":"+200"}]`,
+			func(r map[string]interface{}) {
+				r["phoneNumbers"] = []interface{}{map[string]interface{}{"type": "work", "value": "+200"}}
+			},
+		},
+		{
+			"filter without a match creates the value",
+			`[{"op":"add","path":"phoneNumbers[type eq \"mobile\"].value","value":"+300"}]`,
+			func(r map[string]interface{}) {
+				r["phoneNumbers"] = []interface{}{
+					map[string]interface{}{"type": "work", "value": "+100"},
+					map[string]interface{}{"type": "mobile", "value": "+300"},
+				}
+			},
+		},
+		{
+			"remove filtered value",
+			`[{"op":"remove","path":"phoneNumbers[type eq \"work\"]"}]`,
+			func(r map[string]interface{}) { r["phoneNumbers"] = []interface{}{} },
+		},
+		{
+			"remove attribute",
+			`[{"op":"remove","path":"name.familyName"},{"op":"remove","path":"active"}]`,
+			func(r map[string]interface{}) {
+				delete(r["name"].(map[string]interface{}), "familyName")
+				delete(r, "active")
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		got, want := testUserResource(), testUserResource()
+		tt.want(want)
+
+		if err := applyPatch(got, patchOps(t, tt.ops)); err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, want)
+		}
+	}
+}
+
+func TestApplyPatchGroupMembers(t *testing.T) {
+	group := map[string]interface{}{
+		"displayName": "Admins",
+		"members":     []interface{}{map[string]interface{}{"value": "1"}, map[string]interface{}{"value": "2"}},
+	}
+
+	ops := patchOps(t, `[
+		{"op":"add","path":"members","value":[{"value":"3"}]},
+		{"op":"remove","path":"members","value":[{"value":"1"}]},
+		{"op":"remove","path":"members[value eq \"2\"]"}
+	]`)
+
+	if err := applyPatch(group, ops); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []interface{}{map[string]interface{}{"value": "3"}}
+	if !reflect.DeepEqual(group["members"], want) {
+		t.Errorf("members = %v, want %v", group["members"], want)
+	}
+}
+
+func TestApplyPatchErrors(t *testing.T) {
+	tests := []struct {
+		ops  string
+		want error
+	}{
+		{`[]`, ErrInvalidValue},
+		{`[{"op":"move","path":"active","value":true}]`, ErrInvalidSyntax},
+		{`[{"op":"replace","path":"id","value":"2"}]`, ErrMutability},
+		{`[{"op":"remove"}]`, ErrNoTarget},
+		{`[{"op":"replace","path":"active"}]`, ErrInvalidValue},
+		{`[{"op":"replace","value":"x"}]`, ErrInvalidValue},
+		{`[{"op":"replace","path":"emails[type","value":"x"}]`, ErrInvalidPath},
+		{`[{"op":"replace","path":"phoneNumbers[type ne \"work\"].value","value":"x"}]`, ErrNoTarget},
+	}
+
+	for _, tt := range tests {
+		if err := applyPatch(testUserResource(), patchOps(t, tt.ops)); !errors.Is(err, tt.want) {
+			t.Errorf("applyPatch(%s) error = %v, want %v", tt.ops, err, tt.want)
+		}
+	}
+}
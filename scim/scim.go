@@ -0,0 +1,307 @@
+// Package scim serves a SCIM 2.0 (RFC 7643, RFC 7644) provisioning API per
+// organization, so identity systems can manage workspace membership. Users map onto
+// organization members and Groups onto member roles.
+package scim
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"zuri.chat/zccore/utils"
+)
+
+const (
+	TokenCollectionName = "scim_tokens"
+
+	SchemaUser            = "urn:ietf:params:scim:schemas:core:2.0:User"
+	SchemaGroup           = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	SchemaListResponse    = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	SchemaPatchOp         = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	SchemaError           = "urn:ietf:params:scim:api:messages:2.0:Error"
+	schemaServiceProvider = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	schemaResourceType    = "urn:ietf:params:scim:schemas:core:2.0:ResourceType"
+
+	contentType     = "application/scim+json"
+	defaultCount    = 100
+	maxCount        = 200
+	tokenPrefix     = "zcs_"
+	tokenBytes      = 32
+	tokenHintLength = 8
+)
+
+var (
+	ErrInvalidFilter = errors.New("invalid filter")
+	ErrInvalidPath   = errors.New("invalid path")
+	ErrInvalidValue  = errors.New("invalid value")
+	ErrInvalidSyntax = errors.New("invalid request")
+	ErrNoTarget      = errors.New("path matched no attribute")
+	ErrMutability    = errors.New("attribute can't be changed")
+	ErrUniqueness    = errors.New("a member with this userName already exists in the organization")
+	ErrNotFound      = errors.New("resource not found")
+	ErrUnauthorized  = errors.New("a valid scim token for this organization is required")
+)
+
+// scimErrors maps errors to their HTTP status and SCIM error type.
+var scimErrors = []struct {
+	err      error
+	status   int
+	scimType string
+}{
+	{ErrInvalidFilter, http.StatusBadRequest, "invalidFilter"},
+	{ErrInvalidPath, http.StatusBadRequest, "invalidPath"},
+	{ErrInvalidValue, http.StatusBadRequest, "invalidValue"},
+	{ErrInvalidSyntax, http.StatusBadRequest, "invalidSyntax"},
+	{ErrNoTarget, http.StatusBadRequest, "noTarget"},
+	{ErrMutability, http.StatusBadRequest, "mutability"},
+	{ErrUniqueness, http.StatusConflict, "uniqueness"},
+	{ErrNotFound, http.StatusNotFound, ""},
+	{ErrUnauthorized, http.StatusUnauthorized, ""},
+}
+
+type Handler struct {
+	configs *utils.Configurations
+}
+
+func NewHandler(c *utils.Configurations) *Handler {
+	return &Handler{configs: c}
+}
+
+// Meta is the resource metadata every SCIM resource carries.
+type Meta struct {
+	ResourceType string     `json:"resourceType"`
+	Created      *time.Time `json:"created,omitempty"`
+	LastModified *time.Time `json:"lastModified,omitempty"`
+	Location     string     `json:"location,omitempty"`
+}
+
+type ListResponse struct {
+	Schemas      []string      `json:"schemas"`
+	TotalResults int64         `json:"totalResults"`
+	StartIndex   int64         `json:"startIndex"`
+	ItemsPerPage int           `json:"itemsPerPage"`
+	Resources    []interface{} `json:"Resources"`
+}
+
+type ErrorResponse struct {
+	Schemas  []string `json:"schemas"`
+	Status   string   `json:"status"`
+	ScimType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail"`
+}
+
+// Bool accepts booleans sent as strings, as some identity systems do for "active".
+type Bool bool
+
+func (b *Bool) UnmarshalJSON(data []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+
+	switch v := v.(type) {
+	case bool:
+		*b = Bool(v)
+	case string:
+		parsed, err := strconv.ParseBool(v)
+		if err != nil {
+			return err
+		}
+
+		*b = Bool(parsed)
+	case nil:
+		*b = false
+	default:
+		return ErrInvalidValue
+	}
+
+	return nil
+}
+
+// listParams are the filtering and paging query parameters of a list request.
+type listParams struct {
+	Filter     filter
+	StartIndex int64 // 1-based
+	Count      int
+	// ExcludedAttributes holds lower case top level attribute names.
+	ExcludedAttributes map[string]bool
+}
+
+func parseListParams(r *http.Request) (*listParams, error) {
+	q := r.URL.Query()
+	p := &listParams{StartIndex: 1, Count: defaultCount, ExcludedAttributes: map[string]bool{}}
+
+	if v := q.Get("startIndex"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, ErrInvalidValue
+		}
+
+		// values below one are interpreted as one
+		if n > 1 {
+			p.StartIndex = n
+		}
+	}
+
+	if v := q.Get("count"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, ErrInvalidValue
+		}
+
+		switch {
+		case n < 0:
+			p.Count = 0
+		case n > maxCount:
+			p.Count = maxCount
+		default:
+			p.Count = n
+		}
+	}
+
+	if v := strings.TrimSpace(q.Get("filter")); v != "" {
+		f, err := parseFilter(v)
+		if err != nil {
+			return nil, err
+		}
+
+		p.Filter = f
+	}
+
+	for _, attr := range strings.Split(q.Get("excludedAttributes"), ",") {
+		if attr = attributePath(strings.TrimSpace(attr)); attr != "" {
+			p.ExcludedAttributes[attr] = true
+		}
+	}
+
+	return p, nil
+}
+
+func newListResponse(total int64, p *listParams, resources []interface{}) ListResponse {
+	if resources == nil {
+		resources = []interface{}{}
+	}
+
+	return ListResponse{
+		Schemas:      []string{SchemaListResponse},
+		TotalResults: total,
+		StartIndex:   p.StartIndex,
+		ItemsPerPage: len(resources),
+		Resources:    resources,
+	}
+}
+
+func writeResource(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Error sending response: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	resp := ErrorResponse{Schemas: []string{SchemaError}, Detail: err.Error()}
+	status := http.StatusInternalServerError
+
+	for _, e := range scimErrors {
+		if errors.Is(err, e.err) {
+			status, resp.ScimType = e.status, e.scimType
+			break
+		}
+	}
+
+	resp.Status = strconv.Itoa(status)
+
+	if status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="scim"`)
+	}
+
+	writeResource(w, status, resp)
+}
+
+// decodeResource reads a SCIM request body, which clients send as application/scim+json.
+func decodeResource(r *http.Request, v interface{}) error {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return ErrInvalidSyntax
+	}
+
+	return nil
+}
+
+// baseURL is the SCIM endpoint of an organization.
+func (h *Handler) baseURL(orgID string) string {
+	return h.configs.ServerName + "/scim/v2/" + orgID
+}
+
+// Authenticate requires a SCIM bearer token issued for the organization in the route.
+func (h *Handler) Authenticate(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Authorization")
+		if len(header) < len("Bearer ") || !strings.EqualFold(header[:len("Bearer ")], "Bearer ") {
+			writeError(w, ErrUnauthorized)
+			return
+		}
+
+		token := strings.TrimSpace(header[len("Bearer "):])
+
+		res := utils.GetCollection(TokenCollectionName).FindOneAndUpdate(r.Context(),
+			bson.M{"token_hash": utils.HashToken(token), "org_id": mux.Vars(r)["org"]},
+			bson.M{"$set": bson.M{"last_used_at": time.Now()}})
+		if res.Err() != nil {
+			writeError(w, ErrUnauthorized)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// ServiceProviderConfig describes the SCIM features this API supports.
+func (h *Handler) ServiceProviderConfig(w http.ResponseWriter, r *http.Request) {
+	supported := func(v bool) map[string]interface{} { return map[string]interface{}{"supported": v} }
+
+	writeResource(w, http.StatusOK, map[string]interface{}{
+		"schemas":        []string{schemaServiceProvider},
+		"patch":          supported(true),
+		"bulk":           map[string]interface{}{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":         map[string]interface{}{"supported": true, "maxResults": maxCount},
+		"changePassword": supported(false),
+		"sort":           supported(false),
+		"etag":           supported(false),
+		"authenticationSchemes": []map[string]interface{}{{
+			"type":        "oauthbearertoken",
+			"name":        "OAuth Bearer Token",
+			"description": "Authentication with a SCIM token created by an organization admin",
+		}},
+		"meta": Meta{ResourceType: "ServiceProviderConfig", Location: h.baseURL(mux.Vars(r)["org"]) + "/ServiceProviderConfig"},
+	})
+}
+
+// ResourceTypes lists the resources this API serves.
+func (h *Handler) ResourceTypes(w http.ResponseWriter, r *http.Request) {
+	base := h.baseURL(mux.Vars(r)["org"])
+	resourceType := func(name, endpoint, schema string) interface{} {
+		return map[string]interface{}{
+			"schemas":  []string{schemaResourceType},
+			"id":       name,
+			"name":     name,
+			"endpoint": endpoint,
+			"schema":   schema,
+			"meta":     Meta{ResourceType: "ResourceType", Location: base + "/ResourceTypes/" + name},
+		}
+	}
+
+	resources := []interface{}{
+		resourceType("User", "/Users", SchemaUser),
+		resourceType("Group", "/Groups", SchemaGroup),
+	}
+
+	writeResource(w, http.StatusOK, newListResponse(int64(len(resources)), &listParams{StartIndex: 1}, resources))
+}
@@ -0,0 +1,139 @@
+package scim
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"zuri.chat/zccore/auth"
+	"zuri.chat/zccore/organizations"
+	"zuri.chat/zccore/utils"
+)
+
+// Token authenticates an identity system against one organization's SCIM API. Only
+// a hash of the token is stored, the token itself is shown once when it is created.
+type Token struct {
+	ID         primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	OrgID      string             `json:"org_id" bson:"org_id"`
+	Name       string             `json:"name" bson:"name"`
+	TokenHash  string             `json:"-" bson:"token_hash"`
+	Hint       string             `json:"hint" bson:"hint"`
+	CreatedBy  string             `json:"created_by" bson:"created_by"`
+	CreatedAt  time.Time          `json:"created_at" bson:"created_at"`
+	LastUsedAt *time.Time         `json:"last_used_at" bson:"last_used_at,omitempty"`
+}
+
+type CreateTokenRequest struct {
+	Name string `json:"name" validate:"required,max=100"`
+}
+
+type CreateTokenResponse struct {
+	Token
+	// Secret is the bearer token to configure in the identity system.
+	Secret string `json:"token"`
+	// BaseURL is the SCIM endpoint of the organization.
+	BaseURL string `json:"base_url"`
+}
+
+// Create a SCIM token for an organization.
+func (h *Handler) CreateToken(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	orgID := mux.Vars(r)["id"]
+	loggedIn, _ := r.Context().Value("user").(*auth.AuthUser)
+
+	if err := organizations.ValidateOrg(orgID); err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	var req CreateTokenRequest
+	if err := utils.ParseJSONFromRequest(r, &req); err != nil {
+		utils.GetError(err, http.StatusUnprocessableEntity, w)
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+
+	if err := validate.Struct(req); err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	secret, err := utils.RandomToken(tokenBytes)
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	secret = tokenPrefix + secret
+
+	token := Token{
+		OrgID:     orgID,
+		Name:      req.Name,
+		TokenHash: utils.HashToken(secret),
+		Hint:      secret[:len(tokenPrefix)+tokenHintLength],
+		CreatedBy: loggedIn.Email,
+		CreatedAt: time.Now(),
+	}
+
+	res, err := utils.GetCollection(TokenCollectionName).InsertOne(r.Context(), token)
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	token.ID, _ = res.InsertedID.(primitive.ObjectID)
+
+	utils.GetSuccess("scim token created successfully, it will not be shown again",
+		CreateTokenResponse{Token: token, Secret: secret, BaseURL: h.baseURL(orgID)}, w)
+}
+
+// List the SCIM tokens of an organization.
+func (h *Handler) GetTokens(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cursor, err := utils.GetCollection(TokenCollectionName).Find(r.Context(), bson.M{"org_id": mux.Vars(r)["id"]})
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	tokens := []Token{}
+	if err := cursor.All(r.Context(), &tokens); err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	utils.GetSuccess("scim tokens retrieved successfully", tokens, w)
+}
+
+// Revoke a SCIM token.
+func (h *Handler) DeleteToken(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	vars := mux.Vars(r)
+
+	tokenID, err := primitive.ObjectIDFromHex(vars["token_id"])
+	if err != nil {
+		utils.GetError(errors.New("invalid id"), http.StatusBadRequest, w)
+		return
+	}
+
+	res, err := utils.GetCollection(TokenCollectionName).DeleteOne(r.Context(), bson.M{"_id": tokenID, "org_id": vars["id"]})
+	if err != nil {
+		utils.GetError(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	if res.DeletedCount == 0 {
+		utils.GetError(errors.New("scim token not found"), http.StatusNotFound, w)
+		return
+	}
+
+	utils.GetSuccess("scim token revoked successfully", nil, w)
+}
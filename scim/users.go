@@ -0,0 +1,542 @@
+package scim
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"zuri.chat/zccore/auth"
+	"zuri.chat/zccore/organizations"
+	"zuri.chat/zccore/user"
+	"zuri.chat/zccore/utils"
+)
+
+var validate = validator.New()
+
+// userAttributes maps filterable User attributes onto member document fields.
+var userAttributes = map[string]mongoAttribute{
+	"id":                 {Field: "_id", Kind: objectIDAttribute},
+	"externalid":         {Field: "external_id", Kind: exactStringAttribute},
+	"username":           {Field: "email"},
+	"emails":             {Field: "email"},
+	"emails.value":       {Field: "email"},
+	"emails.type":        {Kind: constantAttribute, Constant: "work"},
+	"emails.primary":     {Kind: constantAttribute, Constant: true},
+	"name.givenname":     {Field: "first_name"},
+	"name.familyname":    {Field: "last_name"},
+	"displayname":        {Field: "display_name"},
+	"nickname":           {Field: "user_name"},
+	"phonenumbers.value": {Field: "phone"},
+	"timezone":           {Field: "time_zone"},
+	"preferredlanguage":  {Field: "language"},
+	"roles.value":        {Field: "role"},
+	"active":             {Field: "deleted", Kind: inactiveAttribute},
+	"meta.created":       {Field: "joined_at", Kind: dateAttribute},
+	"meta.lastmodified":  {Field: "joined_at", Kind: dateAttribute},
+}
+
+// User is a SCIM User resource. Its id is the member id, so the same person has a
+// different User in every organization.
+type User struct {
+	Schemas           []string     `json:"schemas"`
+	ID                string       `json:"id,omitempty"`
+	ExternalID        string       `json:"externalId,omitempty"`
+	UserName          string       `json:"userName"`
+	Name              *Name        `json:"name,omitempty"`
+	DisplayName       string       `json:"displayName,omitempty"`
+	NickName          string       `json:"nickName,omitempty"`
+	Emails            []MultiValue `json:"emails,omitempty"`
+	PhoneNumbers      []MultiValue `json:"phoneNumbers,omitempty"`
+	Timezone          string       `json:"timezone,omitempty"`
+	PreferredLanguage string       `json:"preferredLanguage,omitempty"`
+	Active            *Bool        `json:"active,omitempty"`
+	Roles             []MultiValue `json:"roles,omitempty"`
+	Groups            []MultiValue `json:"groups,omitempty"`
+	Meta              *Meta        `json:"meta,omitempty"`
+}
+
+type Name struct {
+	Formatted  string `json:"formatted,omitempty"`
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+// MultiValue is one value of a multi-valued attribute such as emails or group members.
+type MultiValue struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+	Type    string `json:"type,omitempty"`
+	Primary Bool   `json:"primary,omitempty"`
+	Ref     string `json:"$ref,omitempty"`
+}
+
+// email returns the address the user signs in with: the userName, or else the primary email.
+func (u *User) email() string {
+	if utils.IsValidEmail(strings.ToLower(u.UserName)) {
+		return strings.ToLower(u.UserName)
+	}
+
+	return strings.ToLower(primaryValue(u.Emails))
+}
+
+// role returns the role the client asked for, if any.
+func (u *User) role() (string, bool) {
+	role := strings.ToLower(primaryValue(u.Roles))
+	return role, role != ""
+}
+
+// fields returns the member fields the resource sets.
+func (u *User) fields() bson.M {
+	fields := bson.M{
+		"display_name": u.DisplayName,
+		"phone":        primaryValue(u.PhoneNumbers),
+		"time_zone":    u.Timezone,
+		"language":     u.PreferredLanguage,
+		"external_id":  u.ExternalID,
+		"first_name":   "",
+		"last_name":    "",
+	}
+
+	if u.Name != nil {
+		fields["first_name"], fields["last_name"] = u.Name.GivenName, u.Name.FamilyName
+	}
+
+	if u.NickName != "" {
+		fields["user_name"] = u.NickName
+	}
+
+	return fields
+}
+
+func primaryValue(values []MultiValue) string {
+	for _, v := range values {
+		if v.Primary {
+			return v.Value
+		}
+	}
+
+	if len(values) > 0 {
+		return values[0].Value
+	}
+
+	return ""
+}
+
+func (h *Handler) toUser(m *organizations.Member) User {
+	active := Bool(!m.Deleted)
+	joined := m.JoinedAt
+	location := h.baseURL(m.OrgID) + "/Users/" + m.ID
+
+	u := User{
+		Schemas:     []string{SchemaUser},
+		ID:          m.ID,
+		ExternalID:  m.ExternalID,
+		UserName:    m.Email,
+		DisplayName: m.DisplayName,
+		NickName:    m.UserName,
+		Emails:      []MultiValue{{Value: m.Email, Type: "work", Primary: true}},
+		Timezone:    m.TimeZone,
+		Active:      &active,
+		Meta:        &Meta{ResourceType: "User", Created: &joined, LastModified: &joined, Location: location},
+
+		PreferredLanguage: m.Language,
+	}
+
+	if m.FirstName != "" || m.LastName != "" {
+		u.Name = &Name{
+			Formatted:  strings.TrimSpace(m.FirstName + " " + m.LastName),
+			GivenName:  m.FirstName,
+			FamilyName: m.LastName,
+		}
+	}
+
+	if m.Phone != "" {
+		u.PhoneNumbers = []MultiValue{{Value: m.Phone, Type: "work"}}
+	}
+
+	if m.Role != "" {
+		u.Roles = []MultiValue{{Value: m.Role, Primary: true}}
+		u.Groups = []MultiValue{{Value: m.Role, Display: groupName(m.Role), Ref: h.baseURL(m.OrgID) + "/Groups/" + m.Role}}
+	}
+
+	return u
+}
+
+// List the members of the organization.
+func (h *Handler) GetUsers(w http.ResponseWriter, r *http.Request) {
+	orgID := mux.Vars(r)["org"]
+
+	params, err := parseListParams(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	query := bson.M{"org_id": orgID, "role": bson.M{"$ne": organizations.Bot}}
+
+	if params.Filter != nil {
+		f, err := mongoFilter(params.Filter, userAttributes)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		query = bson.M{"$and": []bson.M{query, f}}
+	}
+
+	coll := utils.GetCollection(organizations.MemberCollectionName)
+
+	total, err := coll.CountDocuments(r.Context(), query)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	var members []organizations.Member
+
+	if params.Count > 0 {
+		opts := options.Find().SetSort(bson.M{"_id": 1}).SetSkip(params.StartIndex - 1).SetLimit(int64(params.Count))
+
+		cursor, err := coll.Find(r.Context(), query, opts)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		if err := cursor.All(r.Context(), &members); err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+
+	resources := make([]interface{}, 0, len(members))
+	for i := range members {
+		resources = append(resources, h.toUser(&members[i]))
+	}
+
+	writeResource(w, http.StatusOK, newListResponse(total, params, resources))
+}
+
+// Get a member of the organization.
+func (h *Handler) GetUser(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	m, err := findMember(r.Context(), vars["org"], vars["user_id"])
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeResource(w, http.StatusOK, h.toUser(m))
+}
+
+// Provision a member, creating the account if the email has none yet.
+func (h *Handler) CreateUser(w http.ResponseWriter, r *http.Request) {
+	ctx, orgID := r.Context(), mux.Vars(r)["org"]
+
+	var u User
+	if err := decodeResource(r, &u); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	email := u.email()
+	if !utils.IsValidEmail(email) {
+		writeError(w, fmt.Errorf("%w: userName or a primary email must be an email address", ErrInvalidValue))
+		return
+	}
+
+	role := organizations.MemberRole
+	if requested, ok := u.role(); ok {
+		if err := checkRole(ctx, orgID, requested); err != nil {
+			writeError(w, err)
+			return
+		}
+
+		role = requested
+	}
+
+	coll := utils.GetCollection(organizations.MemberCollectionName)
+
+	existing, err := coll.CountDocuments(ctx, bson.M{"org_id": orgID, "email": email})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if existing > 0 {
+		writeError(w, ErrUniqueness)
+		return
+	}
+
+	account, err := auth.FetchUserByEmail(bson.M{"email": email})
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		var first, last string
+		if u.Name != nil {
+			first, last = u.Name.GivenName, u.Name.FamilyName
+		}
+
+		account, err = organizations.CreateProvisionedUser(ctx, email, first, last)
+	}
+
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	userName := u.NickName
+	if userName == "" {
+		userName = strings.Split(email, "@")[0]
+	}
+
+	m := organizations.NewMember(email, userName, orgID, role)
+	m.DisplayName, m.Phone, m.TimeZone = u.DisplayName, primaryValue(u.PhoneNumbers), u.Timezone
+	m.Language, m.ExternalID = u.PreferredLanguage, u.ExternalID
+
+	if u.Name != nil {
+		m.FirstName, m.LastName = u.Name.GivenName, u.Name.FamilyName
+	}
+
+	memberID, err := organizations.AddMember(ctx, account, m)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if u.Active != nil && !*u.Active {
+		if err := organizations.SetMemberActive(orgID, memberID, false); err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+
+	created, err := findMember(ctx, orgID, memberID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	resource := h.toUser(created)
+	w.Header().Set("Location", resource.Meta.Location)
+	writeResource(w, http.StatusCreated, resource)
+}
+
+// Replace a member's attributes.
+func (h *Handler) ReplaceUser(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	m, err := findMember(r.Context(), vars["org"], vars["user_id"])
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	var u User
+	if err := decodeResource(r, &u); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	h.saveUser(w, r, m, &u)
+}
+
+// Patch a member's attributes.
+func (h *Handler) PatchUser(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	m, err := findMember(r.Context(), vars["org"], vars["user_id"])
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	var req PatchRequest
+	if err := decodeResource(r, &req); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	resource, err := toMap(h.toUser(m))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := applyPatch(resource, req.Operations); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	var u User
+	if err := fromMap(resource, &u); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	h.saveUser(w, r, m, &u)
+}
+
+// Deactivate a member. Deprovisioning keeps the member so it can be reactivated.
+func (h *Handler) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	m, err := findMember(r.Context(), vars["org"], vars["user_id"])
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if m.Role == organizations.OwnerRole {
+		writeError(w, fmt.Errorf("%w: the organization owner can't be deactivated", ErrMutability))
+		return
+	}
+
+	if !m.Deleted {
+		if err := organizations.SetMemberActive(m.OrgID, m.ID, false); err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// saveUser applies a full User resource to a member: profile fields, role and status.
+func (h *Handler) saveUser(w http.ResponseWriter, r *http.Request, m *organizations.Member, u *User) {
+	if email := u.email(); email != "" && email != strings.ToLower(m.Email) {
+		writeError(w, fmt.Errorf("%w: userName is the member's email and can't be changed", ErrMutability))
+		return
+	}
+
+	role, changeRole := u.role()
+	changeRole = changeRole && role != m.Role
+
+	if changeRole {
+		if err := checkRoleChange(r.Context(), m, role); err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+
+	changeActive := u.Active != nil && bool(*u.Active) == m.Deleted
+	if changeActive && m.Role == organizations.OwnerRole {
+		writeError(w, fmt.Errorf("%w: the organization owner can't be deactivated", ErrMutability))
+		return
+	}
+
+	memberID, _ := primitive.ObjectIDFromHex(m.ID)
+
+	_, err := utils.GetCollection(organizations.MemberCollectionName).UpdateOne(r.Context(),
+		bson.M{"_id": memberID}, bson.M{"$set": u.fields()})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := user.RefreshMemberSearchKeys(r.Context(), bson.M{"_id": memberID}); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// publish update to subscriber
+	eventChannel := fmt.Sprintf("organizations_%s", m.OrgID)
+	event := utils.Event{Identifier: m.ID, Type: "User", Event: organizations.UpdateOrganizationMemberProfile, Channel: eventChannel, Payload: make(map[string]interface{})}
+
+	go utils.Emitter(event)
+
+	if changeRole {
+		if err := organizations.SetMemberRole(m.OrgID, m.ID, role); err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+
+	if changeActive {
+		if err := organizations.SetMemberActive(m.OrgID, m.ID, bool(*u.Active)); err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+
+	updated, err := findMember(r.Context(), m.OrgID, m.ID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeResource(w, http.StatusOK, h.toUser(updated))
+}
+
+// checkRole only allows the roles an admin could assign, built in or custom roles of the
+// organization. Ownership moves through an ownership transfer, never through provisioning.
+func checkRole(ctx context.Context, orgID, role string) error {
+	if role == organizations.OwnerRole {
+		return fmt.Errorf("%w: %q is not a role that can be provisioned", ErrInvalidValue, role)
+	}
+
+	if _, err := auth.RolePermissions(ctx, orgID, role); err != nil {
+		return fmt.Errorf("%w: %q is not a role that can be provisioned", ErrInvalidValue, role)
+	}
+
+	return nil
+}
+
+func checkRoleChange(ctx context.Context, m *organizations.Member, role string) error {
+	if m.Role == organizations.OwnerRole {
+		return fmt.Errorf("%w: the organization owner's role can't be changed", ErrMutability)
+	}
+
+	return checkRole(ctx, m.OrgID, role)
+}
+
+func findMember(ctx context.Context, orgID, id string) (*organizations.Member, error) {
+	memberID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+
+	var m organizations.Member
+
+	err = utils.GetCollection(organizations.MemberCollectionName).
+		FindOne(ctx, bson.M{"_id": memberID, "org_id": orgID, "role": bson.M{"$ne": organizations.Bot}}).
+		Decode(&m)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNotFound
+	}
+
+	return &m, err
+}
+
+// toMap converts a resource to the JSON form PATCH operations work on.
+func toMap(v interface{}) (map[string]interface{}, error) {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var m map[string]interface{}
+
+	return m, json.Unmarshal(raw, &m)
+}
+
+func fromMap(m map[string]interface{}, v interface{}) error {
+	raw, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(raw, v); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidValue, err)
+	}
+
+	return nil
+}
@@ -39,6 +39,15 @@ const (
 	TokenBillingNotice
 	WorkSpaceInvite
 	WorkSpaceWelcome
+	PluginDelisted
+	NewDeviceLogin
+	AccountLocked
+	MagicLink
+	AccountDeletion
+	EmailChange
+	EmailChangeNotice
+	OrgDeletion
+	OwnershipTransfer
 )
 
 var MailTypes = map[MailType]MailType{
@@ -49,6 +58,15 @@ var MailTypes = map[MailType]MailType{
 	TokenBillingNotice: TokenBillingNotice,
 	WorkSpaceInvite:    WorkSpaceInvite,
 	WorkSpaceWelcome:   WorkSpaceWelcome,
+	PluginDelisted:     PluginDelisted,
+	NewDeviceLogin:     NewDeviceLogin,
+	AccountLocked:      AccountLocked,
+	MagicLink:          MagicLink,
+	AccountDeletion:    AccountDeletion,
+	EmailChange:        EmailChange,
+	EmailChangeNotice:  EmailChangeNotice,
+	OrgDeletion:        OrgDeletion,
+	OwnershipTransfer:  OwnershipTransfer,
 }
 
 type Mail struct {
@@ -80,6 +98,15 @@ func (ms *ZcMailService) LoadTemplate(mailReq *Mail) (string, error) {
 		TokenBillingNotice: ms.configs.TokenBillingNoticeTemplate,
 		WorkSpaceInvite:    ms.configs.WorkSpaceInviteTemplate,
 		WorkSpaceWelcome:   ms.configs.WorkSpaceWelcomeTemplate,
+		PluginDelisted:     ms.configs.PluginDelistedTemplate,
+		NewDeviceLogin:     ms.configs.NewDeviceLoginTemplate,
+		AccountLocked:      ms.configs.AccountLockedTemplate,
+		MagicLink:          ms.configs.MagicLinkTemplate,
+		AccountDeletion:    ms.configs.AccountDeletionTemplate,
+		EmailChange:        ms.configs.EmailChangeTemplate,
+		EmailChangeNotice:  ms.configs.EmailChangeNoticeTemplate,
+		OrgDeletion:        ms.configs.OrgDeletionTemplate,
+		OwnershipTransfer:  ms.configs.OwnershipTransferTemplate,
 	}
 
 	templateFileName, ok := m[mailReq.mtype]
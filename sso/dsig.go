@@ -0,0 +1,203 @@
+package sso
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	// register the digests used by XML signatures
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+)
+
+const (
+	algExcC14N     = "http://www.w3.org/2001/10/xml-exc-c14n#"
+	algEnveloped   = "http://www.w3.org/2000/09/xmldsig#enveloped-signature"
+	algSHA256      = "http://www.w3.org/2001/04/xmlenc#sha256"
+	algSHA512      = "http://www.w3.org/2001/04/xmlenc#sha512"
+	algRSASHA256   = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256"
+	algRSASHA512   = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha512"
+	algECDSASHA256 = "http://www.w3.org/2001/04/xmldsig-more#ecdsa-sha256"
+	algECDSASHA512 = "http://www.w3.org/2001/04/xmldsig-more#ecdsa-sha512"
+)
+
+var (
+	ErrSignatureMissing = errors.New("saml document is not signed")
+	ErrSignatureInvalid = errors.New("saml signature is invalid")
+)
+
+var digestMethods = map[string]crypto.Hash{
+	algSHA256: crypto.SHA256,
+	algSHA512: crypto.SHA512,
+}
+
+var signatureMethods = map[string]crypto.Hash{
+	algRSASHA256:   crypto.SHA256,
+	algRSASHA512:   crypto.SHA512,
+	algECDSASHA256: crypto.SHA256,
+	algECDSASHA512: crypto.SHA512,
+}
+
+// signatureOf returns the enveloped ds:Signature of el, or nil if it has none.
+func signatureOf(el *node) *node {
+	return el.child(nsDSig, "Signature")
+}
+
+// verifySignature checks the enveloped signature of el against certs. SHA-1 based
+// algorithms are not accepted.
+func verifySignature(el *node, certs []*x509.Certificate) error {
+	sig := signatureOf(el)
+	if sig == nil {
+		return ErrSignatureMissing
+	}
+
+	signedInfo := sig.child(nsDSig, "SignedInfo")
+	if signedInfo == nil {
+		return ErrSignatureInvalid
+	}
+
+	c14nMethod := signedInfo.child(nsDSig, "CanonicalizationMethod")
+	if c14nMethod == nil || c14nMethod.attr("Algorithm") != algExcC14N {
+		return fmt.Errorf("%w: unsupported canonicalization method", ErrSignatureInvalid)
+	}
+
+	refs := signedInfo.all(nsDSig, "Reference")
+	if len(refs) != 1 {
+		return fmt.Errorf("%w: expected exactly one reference", ErrSignatureInvalid)
+	}
+
+	if err := verifyReference(el, sig, refs[0]); err != nil {
+		return err
+	}
+
+	method := signedInfo.child(nsDSig, "SignatureMethod")
+	if method == nil {
+		return ErrSignatureInvalid
+	}
+
+	hash, ok := signatureMethods[method.attr("Algorithm")]
+	if !ok {
+		return fmt.Errorf("%w: unsupported signature method %s", ErrSignatureInvalid, method.attr("Algorithm"))
+	}
+
+	sigValue := sig.child(nsDSig, "SignatureValue")
+	if sigValue == nil {
+		return ErrSignatureInvalid
+	}
+
+	signature, err := decodeBase64(sigValue.text())
+	if err != nil {
+		return ErrSignatureInvalid
+	}
+
+	h := hash.New()
+	h.Write(canonicalize(signedInfo, nil, inclusivePrefixes(c14nMethod)))
+	digest := h.Sum(nil)
+
+	for _, cert := range certs {
+		if checkSignature(cert.PublicKey, hash, digest, signature) {
+			return nil
+		}
+	}
+
+	return ErrSignatureInvalid
+}
+
+// verifyReference checks that the reference covers el itself and that its digest matches.
+func verifyReference(el, sig, ref *node) error {
+	id := el.attr("ID")
+	if id == "" || ref.attr("URI") != "#"+id {
+		return fmt.Errorf("%w: reference does not cover the signed element", ErrSignatureInvalid)
+	}
+
+	var (
+		enveloped bool
+		inclusive []string
+	)
+
+	if transforms := ref.child(nsDSig, "Transforms"); transforms != nil {
+		for _, t := range transforms.all(nsDSig, "Transform") {
+			switch t.attr("Algorithm") {
+			case algEnveloped:
+				enveloped = true
+			case algExcC14N:
+				inclusive = inclusivePrefixes(t)
+			default:
+				return fmt.Errorf("%w: unsupported transform %s", ErrSignatureInvalid, t.attr("Algorithm"))
+			}
+		}
+	}
+
+	if !enveloped {
+		return fmt.Errorf("%w: signature must be enveloped", ErrSignatureInvalid)
+	}
+
+	method := ref.child(nsDSig, "DigestMethod")
+	if method == nil {
+		return ErrSignatureInvalid
+	}
+
+	hash, ok := digestMethods[method.attr("Algorithm")]
+	if !ok {
+		return fmt.Errorf("%w: unsupported digest method %s", ErrSignatureInvalid, method.attr("Algorithm"))
+	}
+
+	value := ref.child(nsDSig, "DigestValue")
+	if value == nil {
+		return ErrSignatureInvalid
+	}
+
+	want, err := decodeBase64(value.text())
+	if err != nil {
+		return ErrSignatureInvalid
+	}
+
+	h := hash.New()
+	h.Write(canonicalize(el, sig, inclusive))
+
+	if subtle.ConstantTimeCompare(h.Sum(nil), want) != 1 {
+		return fmt.Errorf("%w: digest mismatch", ErrSignatureInvalid)
+	}
+
+	return nil
+}
+
+func inclusivePrefixes(transform *node) []string {
+	if inc := transform.child(algExcC14N, "InclusiveNamespaces"); inc != nil {
+		return strings.Fields(inc.attr("PrefixList"))
+	}
+
+	return nil
+}
+
+func checkSignature(pub crypto.PublicKey, hash crypto.Hash, digest, signature []byte) bool {
+	switch key := pub.(type) {
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(key, hash, digest, signature) == nil
+	case *ecdsa.PublicKey:
+		// XML signatures carry ECDSA signatures as the raw r || s concatenation
+		if len(signature)%2 != 0 {
+			return false
+		}
+
+		half := len(signature) / 2
+		r := new(big.Int).SetBytes(signature[:half])
+		s := new(big.Int).SetBytes(signature[half:])
+
+		return ecdsa.Verify(key, digest, r, s)
+	default:
+		return false
+	}
+}
+
+// decodeBase64 decodes base64 that may be wrapped over several lines.
+func decodeBase64(s string) ([]byte, error) {
+	return base64.StdEncoding.DecodeString(strings.Join(strings.Fields(s), ""))
+}
@@ -0,0 +1,178 @@
+package sso
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	bindingRedirect = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-Redirect"
+	bindingPOST     = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST"
+	nameIDEmail     = "urn:oasis:names:tc:SAML:1.1:nameid-format:emailAddress"
+	minRSAKeyBits   = 2048
+)
+
+var (
+	ErrNoIdPDescriptor  = errors.New("metadata has no identity provider descriptor")
+	ErrNoRedirectSSO    = errors.New("identity provider has no HTTP-Redirect single sign-on service")
+	ErrNoSigningCert    = errors.New("identity provider metadata has no signing certificate")
+	ErrCertificateUsage = errors.New("certificate is not usable for saml signatures")
+)
+
+// IdentityProvider is the SAML configuration of an organization's IdP, usually read
+// from the metadata document the IdP publishes.
+type IdentityProvider struct {
+	EntityID string `json:"entity_id" bson:"entity_id" validate:"required"`
+	SSOURL   string `json:"sso_url" bson:"sso_url" validate:"required,url"`
+	// Certificates holds base64 DER signing certificates. Several can be listed
+	// while the IdP rolls its key.
+	Certificates []string `json:"certificates" bson:"certificates" validate:"required,min=1"`
+}
+
+// CertificateInfo describes a configured certificate without exposing it.
+type CertificateInfo struct {
+	Subject   string    `json:"subject"`
+	NotBefore time.Time `json:"not_before"`
+	NotAfter  time.Time `json:"not_after"`
+}
+
+// ParseIdPMetadata reads an EntityDescriptor, or the first IdP in an EntitiesDescriptor.
+func ParseIdPMetadata(data []byte) (*IdentityProvider, error) {
+	root, err := parseXML(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var entity, idpDesc *node
+
+	root.walk(func(n *node) {
+		if idpDesc == nil && n.is(nsMetadata, "IDPSSODescriptor") && n.parent != nil && n.parent.is(nsMetadata, "EntityDescriptor") {
+			entity, idpDesc = n.parent, n
+		}
+	})
+
+	if idpDesc == nil {
+		return nil, ErrNoIdPDescriptor
+	}
+
+	idp := &IdentityProvider{EntityID: entity.attr("entityID")}
+
+	for _, svc := range idpDesc.all(nsMetadata, "SingleSignOnService") {
+		if svc.attr("Binding") == bindingRedirect {
+			idp.SSOURL = svc.attr("Location")
+			break
+		}
+	}
+
+	if idp.SSOURL == "" {
+		return nil, ErrNoRedirectSSO
+	}
+
+	for _, kd := range idpDesc.all(nsMetadata, "KeyDescriptor") {
+		if use := kd.attr("use"); use != "" && use != "signing" {
+			continue
+		}
+
+		kd.walk(func(n *node) {
+			if n.is(nsDSig, "X509Certificate") {
+				idp.Certificates = append(idp.Certificates, compactBase64(n.text()))
+			}
+		})
+	}
+
+	if len(idp.Certificates) == 0 {
+		return nil, ErrNoSigningCert
+	}
+
+	return idp, nil
+}
+
+// ValidateCertificate parses a base64 DER certificate and checks it is currently
+// valid and carries a key strong enough to trust signatures from. IdP certificates
+// are pinned, so no chain is built.
+func ValidateCertificate(b64 string, now time.Time) (*x509.Certificate, error) {
+	der, err := decodeBase64(b64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCertificateUsage, err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCertificateUsage, err)
+	}
+
+	if now.Before(cert.NotBefore) || now.After(cert.NotAfter) {
+		return nil, fmt.Errorf("%w: certificate for %s is valid from %s to %s", ErrCertificateUsage,
+			cert.Subject.CommonName, cert.NotBefore.Format(time.RFC3339), cert.NotAfter.Format(time.RFC3339))
+	}
+
+	switch key := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		if key.N.BitLen() < minRSAKeyBits {
+			return nil, fmt.Errorf("%w: rsa key shorter than %d bits", ErrCertificateUsage, minRSAKeyBits)
+		}
+	case *ecdsa.PublicKey:
+	default:
+		return nil, fmt.Errorf("%w: unsupported key type", ErrCertificateUsage)
+	}
+
+	return cert, nil
+}
+
+// Validate checks every configured certificate and returns a summary of them.
+func (idp *IdentityProvider) Validate(now time.Time) ([]CertificateInfo, error) {
+	if len(idp.Certificates) == 0 {
+		return nil, ErrNoSigningCert
+	}
+
+	infos := make([]CertificateInfo, 0, len(idp.Certificates))
+
+	for _, c := range idp.Certificates {
+		cert, err := ValidateCertificate(c, now)
+		if err != nil {
+			return nil, err
+		}
+
+		infos = append(infos, CertificateInfo{Subject: cert.Subject.String(), NotBefore: cert.NotBefore, NotAfter: cert.NotAfter})
+	}
+
+	return infos, nil
+}
+
+// certificates returns the parsed certificates that are currently valid.
+func (idp *IdentityProvider) certificates(now time.Time) []*x509.Certificate {
+	var certs []*x509.Certificate
+
+	for _, c := range idp.Certificates {
+		if cert, err := ValidateCertificate(c, now); err == nil {
+			certs = append(certs, cert)
+		}
+	}
+
+	return certs
+}
+
+// Metadata returns the SP metadata document to register with identity providers.
+func (sp ServiceProvider) Metadata() []byte {
+	return []byte(`<?xml version="1.0" encoding="UTF-8"?>` + "\n" +
+		`<md:EntityDescriptor xmlns:md="` + nsMetadata + `" entityID="` + escapeAttr(sp.EntityID) + `">` +
+		`<md:SPSSODescriptor AuthnRequestsSigned="false" WantAssertionsSigned="true" protocolSupportEnumeration="` + nsProtocol + `">` +
+		`<md:NameIDFormat>` + nameIDEmail + `</md:NameIDFormat>` +
+		`<md:AssertionConsumerService Binding="` + bindingPOST + `" Location="` + escapeAttr(sp.ACSURL) + `" index="0" isDefault="true"/>` +
+		`</md:SPSSODescriptor>` +
+		`</md:EntityDescriptor>` + "\n")
+}
+
+func compactBase64(s string) string {
+	b, err := decodeBase64(s)
+	if err != nil {
+		return s
+	}
+
+	return base64.StdEncoding.EncodeToString(b)
+}
@@ -0,0 +1,351 @@
+// Package sso implements the service provider side of SAML 2.0 web browser single
+// sign-on: redirect binding authentication requests, POST binding responses, XML
+// signature verification and metadata handling.
+package sso
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+const (
+	statusSuccess      = "urn:oasis:names:tc:SAML:2.0:status:Success"
+	confirmationBearer = "urn:oasis:names:tc:SAML:2.0:cm:bearer"
+
+	// MaxClockSkew is how far the IdP clock may drift from ours.
+	MaxClockSkew   = 3 * time.Minute
+	requestIDBytes = 20
+)
+
+var (
+	ErrResponseStatus     = errors.New("identity provider did not authenticate the user")
+	ErrResponseInvalid    = errors.New("saml response is invalid")
+	ErrEncryptedAssertion = errors.New("encrypted assertions are not supported, disable assertion encryption at the identity provider")
+)
+
+// ServiceProvider identifies zuri chat to an organization's IdP.
+type ServiceProvider struct {
+	EntityID string
+	ACSURL   string
+}
+
+// Assertion is the verified result of a SAML response.
+type Assertion struct {
+	ID           string
+	Issuer       string
+	NameID       string
+	SessionIndex string
+	InResponseTo string
+	// Attributes are keyed by both Name and FriendlyName.
+	Attributes map[string][]string
+	// NotOnOrAfter is when the assertion stops being acceptable, which bounds how
+	// long its ID has to be remembered to prevent replays.
+	NotOnOrAfter time.Time
+}
+
+// Attribute returns the first value of the named attribute.
+func (a *Assertion) Attribute(name string) string {
+	if v := a.Attributes[name]; len(v) > 0 {
+		return v[0]
+	}
+
+	return ""
+}
+
+// AuthnRequestURL returns the IdP URL that starts SP-initiated login through the
+// HTTP-Redirect binding, and the request ID the response has to answer.
+func (sp ServiceProvider) AuthnRequestURL(idp *IdentityProvider, relayState string, now time.Time) (redirect, requestID string, err error) {
+	id := make([]byte, requestIDBytes)
+	if _, err := rand.Read(id); err != nil {
+		return "", "", err
+	}
+
+	requestID = "_" + hex.EncodeToString(id)
+
+	req := `<samlp:AuthnRequest xmlns:samlp="` + nsProtocol + `" xmlns:saml="` + nsAssertion + `"` +
+		` ID="` + requestID + `" Version="2.0" IssueInstant="` + now.UTC().Format(time.RFC3339) + `"` +
+		` Destination="` + escapeAttr(idp.SSOURL) + `" AssertionConsumerServiceURL="` + escapeAttr(sp.ACSURL) + `"` +
+		` ProtocolBinding="` + bindingPOST + `">` +
+		`<saml:Issuer>` + escapeText(sp.EntityID) + `</saml:Issuer>` +
+		`<samlp:NameIDPolicy Format="` + nameIDEmail + `" AllowCreate="true"/>` +
+		`</samlp:AuthnRequest>`
+
+	var buf bytes.Buffer
+
+	w, err := flate.NewWriter(&buf, flate.DefaultCompression)
+	if err != nil {
+		return "", "", err
+	}
+
+	if _, err := w.Write([]byte(req)); err != nil {
+		return "", "", err
+	}
+
+	if err := w.Close(); err != nil {
+		return "", "", err
+	}
+
+	u, err := url.Parse(idp.SSOURL)
+	if err != nil {
+		return "", "", err
+	}
+
+	q := u.Query()
+	q.Set("SAMLRequest", base64.StdEncoding.EncodeToString(buf.Bytes()))
+
+	if relayState != "" {
+		q.Set("RelayState", relayState)
+	}
+
+	u.RawQuery = q.Encode()
+
+	return u.String(), requestID, nil
+}
+
+// ParseResponse verifies a base64 encoded SAMLResponse from the POST binding. Either
+// the response or its single assertion must carry a valid signature from idp, and
+// only elements covered by that signature are read. The caller still has to check
+// InResponseTo against the requests it issued and reject replayed assertion IDs.
+func (sp ServiceProvider) ParseResponse(idp *IdentityProvider, encoded string, now time.Time) (*Assertion, error) {
+	raw, err := decodeBase64(encoded)
+	if err != nil {
+		return nil, ErrMalformedXML
+	}
+
+	root, err := parseXML(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	if !root.is(nsProtocol, "Response") || root.attr("Version") != "2.0" {
+		return nil, fmt.Errorf("%w: not a saml 2.0 response", ErrResponseInvalid)
+	}
+
+	// duplicate IDs are how signature wrapping attacks smuggle unsigned content
+	ids := map[string]bool{}
+	duplicate := false
+
+	root.walk(func(n *node) {
+		if id := n.attr("ID"); id != "" {
+			duplicate = duplicate || ids[id]
+			ids[id] = true
+		}
+	})
+
+	if duplicate {
+		return nil, fmt.Errorf("%w: duplicate element ids", ErrResponseInvalid)
+	}
+
+	if dest := root.attr("Destination"); dest != "" && dest != sp.ACSURL {
+		return nil, fmt.Errorf("%w: destination %s does not match", ErrResponseInvalid, dest)
+	}
+
+	status := root.child(nsProtocol, "Status")
+	if status == nil {
+		return nil, ErrResponseInvalid
+	}
+
+	if code := status.child(nsProtocol, "StatusCode"); code == nil || code.attr("Value") != statusSuccess {
+		return nil, ErrResponseStatus
+	}
+
+	if len(root.all(nsAssertion, "EncryptedAssertion")) > 0 {
+		return nil, ErrEncryptedAssertion
+	}
+
+	assertions := root.all(nsAssertion, "Assertion")
+	if len(assertions) != 1 {
+		return nil, fmt.Errorf("%w: expected exactly one assertion", ErrResponseInvalid)
+	}
+
+	assertion := assertions[0]
+	certs := idp.certificates(now)
+
+	if err := verifySigned(root, assertion, certs); err != nil {
+		return nil, err
+	}
+
+	if iss := root.child(nsAssertion, "Issuer"); iss != nil && iss.text() != idp.EntityID {
+		return nil, fmt.Errorf("%w: unexpected issuer %s", ErrResponseInvalid, iss.text())
+	}
+
+	a, err := sp.readAssertion(idp, assertion, now)
+	if err != nil {
+		return nil, err
+	}
+
+	if irt := root.attr("InResponseTo"); irt != "" {
+		if a.InResponseTo != "" && a.InResponseTo != irt {
+			return nil, fmt.Errorf("%w: conflicting InResponseTo", ErrResponseInvalid)
+		}
+
+		a.InResponseTo = irt
+	}
+
+	return a, nil
+}
+
+// verifySigned requires a valid signature on the response or the assertion. A
+// signature that is present but invalid always fails, even if the other one is fine.
+func verifySigned(response, assertion *node, certs []*x509.Certificate) error {
+	if len(certs) == 0 {
+		return fmt.Errorf("%w: no valid identity provider certificate configured", ErrSignatureInvalid)
+	}
+
+	signed := false
+
+	for _, el := range []*node{response, assertion} {
+		if signatureOf(el) == nil {
+			continue
+		}
+
+		if err := verifySignature(el, certs); err != nil {
+			return err
+		}
+
+		signed = true
+	}
+
+	if !signed {
+		return ErrSignatureMissing
+	}
+
+	return nil
+}
+
+func (sp ServiceProvider) readAssertion(idp *IdentityProvider, el *node, now time.Time) (*Assertion, error) {
+	a := &Assertion{ID: el.attr("ID"), Issuer: idp.EntityID, Attributes: map[string][]string{}}
+
+	if a.ID == "" || el.attr("Version") != "2.0" {
+		return nil, fmt.Errorf("%w: malformed assertion", ErrResponseInvalid)
+	}
+
+	if iss := el.child(nsAssertion, "Issuer"); iss == nil || iss.text() != idp.EntityID {
+		return nil, fmt.Errorf("%w: assertion issuer does not match the identity provider", ErrResponseInvalid)
+	}
+
+	subject := el.child(nsAssertion, "Subject")
+	if subject == nil {
+		return nil, fmt.Errorf("%w: assertion has no subject", ErrResponseInvalid)
+	}
+
+	if nameID := subject.child(nsAssertion, "NameID"); nameID != nil {
+		a.NameID = nameID.text()
+	}
+
+	if a.NameID == "" {
+		return nil, fmt.Errorf("%w: assertion has no name id", ErrResponseInvalid)
+	}
+
+	if err := sp.checkConfirmation(subject, a, now); err != nil {
+		return nil, err
+	}
+
+	if err := sp.checkConditions(el.child(nsAssertion, "Conditions"), a, now); err != nil {
+		return nil, err
+	}
+
+	if authn := el.child(nsAssertion, "AuthnStatement"); authn != nil {
+		a.SessionIndex = authn.attr("SessionIndex")
+	}
+
+	for _, stmt := range el.all(nsAssertion, "AttributeStatement") {
+		for _, attr := range stmt.all(nsAssertion, "Attribute") {
+			var values []string
+			for _, v := range attr.all(nsAssertion, "AttributeValue") {
+				values = append(values, v.text())
+			}
+
+			for _, key := range []string{attr.attr("Name"), attr.attr("FriendlyName")} {
+				if key != "" {
+					a.Attributes[key] = append(a.Attributes[key], values...)
+				}
+			}
+		}
+	}
+
+	return a, nil
+}
+
+// checkConfirmation requires a bearer confirmation addressed to our ACS URL that has not expired.
+func (sp ServiceProvider) checkConfirmation(subject *node, a *Assertion, now time.Time) error {
+	for _, sc := range subject.all(nsAssertion, "SubjectConfirmation") {
+		if sc.attr("Method") != confirmationBearer {
+			continue
+		}
+
+		data := sc.child(nsAssertion, "SubjectConfirmationData")
+		if data == nil || data.attr("Recipient") != sp.ACSURL {
+			continue
+		}
+
+		notOnOrAfter, err := parseTime(data.attr("NotOnOrAfter"))
+		if err != nil || !now.Add(-MaxClockSkew).Before(notOnOrAfter) {
+			continue
+		}
+
+		a.InResponseTo = data.attr("InResponseTo")
+		a.NotOnOrAfter = notOnOrAfter
+
+		return nil
+	}
+
+	return fmt.Errorf("%w: no valid bearer subject confirmation", ErrResponseInvalid)
+}
+
+// checkConditions enforces the validity window and requires us among the audiences.
+func (sp ServiceProvider) checkConditions(conditions *node, a *Assertion, now time.Time) error {
+	if conditions == nil {
+		return fmt.Errorf("%w: assertion has no conditions", ErrResponseInvalid)
+	}
+
+	if v := conditions.attr("NotBefore"); v != "" {
+		notBefore, err := parseTime(v)
+		if err != nil || now.Add(MaxClockSkew).Before(notBefore) {
+			return fmt.Errorf("%w: assertion is not yet valid", ErrResponseInvalid)
+		}
+	}
+
+	if v := conditions.attr("NotOnOrAfter"); v != "" {
+		notOnOrAfter, err := parseTime(v)
+		if err != nil || !now.Add(-MaxClockSkew).Before(notOnOrAfter) {
+			return fmt.Errorf("%w: assertion has expired", ErrResponseInvalid)
+		}
+
+		if notOnOrAfter.Before(a.NotOnOrAfter) {
+			a.NotOnOrAfter = notOnOrAfter
+		}
+	}
+
+	restrictions := conditions.all(nsAssertion, "AudienceRestriction")
+	if len(restrictions) == 0 {
+		return fmt.Errorf("%w: assertion has no audience restriction", ErrResponseInvalid)
+	}
+
+	// every restriction has to include us
+	for _, r := range restrictions {
+		found := false
+
+		for _, aud := range r.all(nsAssertion, "Audience") {
+			found = found || aud.text() == sp.EntityID
+		}
+
+		if !found {
+			return fmt.Errorf("%w: assertion is intended for another audience", ErrResponseInvalid)
+		}
+	}
+
+	return nil
+}
+
+func parseTime(v string) (time.Time, error) {
+	return time.Parse(time.RFC3339Nano, v)
+}
@@ -0,0 +1,204 @@
+package sso
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testIdP = "https://idp.example.com/metadata"
+	testSP  = "https://api.zuri.chat/organizations/1/sso/saml/metadata"
+	testACS = "https://api.zuri.chat/organizations/1/sso/saml/acs"
+	sigSlot = "<!--signature-->"
+)
+
+var testNow = time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)
+
+func testIdentityProvider(t *testing.T) (*IdentityProvider, *rsa.PrivateKey) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "idp.example.com"},
+		NotBefore:    testNow.Add(-time.Hour),
+		NotAfter:     testNow.Add(365 * 24 * time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &IdentityProvider{
+		EntityID:     testIdP,
+		SSOURL:       "https://idp.example.com/sso",
+		Certificates: []string{base64.StdEncoding.EncodeToString(der)},
+	}, key
+}
+
+func testAssertion(id, audience, nameID string) string {
+	return `<saml:Assertion xmlns:saml="` + nsAssertion + `" ID="` + id + `" Version="2.0" IssueInstant="2021-10-01T11:59:58Z">` +
+		`<saml:Issuer>` + testIdP + `</saml:Issuer>` + sigSlot +
+		`<saml:Subject><saml:NameID Format="` + nameIDEmail + `">` + nameID + `</saml:NameID>` +
+		`<saml:SubjectConfirmation Method="` + confirmationBearer + `">` +
+		`<saml:SubjectConfirmationData InResponseTo="_req1" NotOnOrAfter="2021-10-01T12:05:00Z" Recipient="` + testACS + `"/>` +
+		`</saml:SubjectConfirmation></saml:Subject>` +
+		`<saml:Conditions NotBefore="2021-10-01T11:59:00Z" NotOnOrAfter="2021-10-01T12:05:00Z">` +
+		`<saml:AudienceRestriction><saml:Audience>` + audience + `</saml:Audience></saml:AudienceRestriction></saml:Conditions>` +
+		`<saml:AuthnStatement AuthnInstant="2021-10-01T11:59:58Z" SessionIndex="_session1"/>` +
+		`<saml:AttributeStatement>` +
+		`<saml:Attribute Name="http://schemas.xmlsoap.org/ws/2005/05/identity/claims/givenname" FriendlyName="firstName">` +
+		`<saml:AttributeValue>Ada</saml:AttributeValue></saml:Attribute>` +
+		`</saml:AttributeStatement></saml:Assertion>`
+}
+
+// sign fills the signature slot of doc with an enveloped signature over the element
+// with the given ID, the way an identity provider would.
+func sign(t *testing.T, doc, id string, key *rsa.PrivateKey) string {
+	t.Helper()
+
+	root, err := parseXML([]byte(strings.Replace(doc, sigSlot, "", 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var el *node
+
+	root.walk(func(n *node) {
+		if n.attr("ID") == id {
+			el = n
+		}
+	})
+
+	digest := sha256.Sum256(canonicalize(el, nil, nil))
+	signedInfo := `<ds:SignedInfo>` +
+		`<ds:CanonicalizationMethod Algorithm="` + algExcC14N + `"/>` +
+		`<ds:SignatureMethod Algorithm="` + algRSASHA256 + `"/>` +
+		`<ds:Reference URI="#` + id + `"><ds:Transforms>` +
+		`<ds:Transform Algorithm="` + algEnveloped + `"/><ds:Transform Algorithm="` + algExcC14N + `"/>` +
+		`</ds:Transforms><ds:DigestMethod Algorithm="` + algSHA256 + `"/>` +
+		`<ds:DigestValue>` + base64.StdEncoding.EncodeToString(digest[:]) + `</ds:DigestValue></ds:Reference>` +
+		`</ds:SignedInfo>`
+
+	sigDoc, err := parseXML([]byte(`<ds:Signature xmlns:ds="` + nsDSig + `">` + signedInfo + `</ds:Signature>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sum := sha256.Sum256(canonicalize(sigDoc.child(nsDSig, "SignedInfo"), nil, nil))
+
+	value, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sig := `<ds:Signature xmlns:ds="` + nsDSig + `">` + signedInfo +
+		`<ds:SignatureValue>` + base64.StdEncoding.EncodeToString(value) + `</ds:SignatureValue></ds:Signature>`
+
+	return strings.Replace(doc, sigSlot, sig, 1)
+}
+
+func testResponse(assertions ...string) string {
+	return base64.StdEncoding.EncodeToString([]byte(
+		`<samlp:Response xmlns:samlp="` + nsProtocol + `" ID="_resp1" Version="2.0" IssueInstant="2021-10-01T11:59:58Z"` +
+			` Destination="` + testACS + `" InResponseTo="_req1">` +
+			`<saml:Issuer xmlns:saml="` + nsAssertion + `">` + testIdP + `</saml:Issuer>` +
+			`<samlp:Status><samlp:StatusCode Value="` + statusSuccess + `"/></samlp:Status>` +
+			strings.Join(assertions, "") + `</samlp:Response>`))
+}
+
+func TestParseResponse(t *testing.T) {
+	idp, key := testIdentityProvider(t)
+	sp := ServiceProvider{EntityID: testSP, ACSURL: testACS}
+
+	a, err := sp.ParseResponse(idp, testResponse(sign(t, testAssertion("_a1", testSP, "ada@example.com"), "_a1", key)), testNow)
+	if err != nil {
+		t.Fatalf("ParseResponse returned error: %v", err)
+	}
+
+	if a.NameID != "ada@example.com" || a.InResponseTo != "_req1" || a.SessionIndex != "_session1" {
+		t.Errorf("unexpected assertion %+v", a)
+	}
+
+	if a.Attribute("firstName") != "Ada" {
+		t.Errorf("firstName attribute = %q, want Ada", a.Attribute("firstName"))
+	}
+
+	if _, err := sp.ParseResponse(idp, testResponse(sign(t, testAssertion("_a1", testSP, "ada@example.com"), "_a1", key)),
+		testNow.Add(10*time.Minute)); !errors.Is(err, ErrResponseInvalid) {
+		t.Errorf("expired assertion error = %v, want ErrResponseInvalid", err)
+	}
+}
+
+func TestParseResponseRejectsForgeries(t *testing.T) {
+	idp, key := testIdentityProvider(t)
+	_, otherKey := testIdentityProvider(t)
+	sp := ServiceProvider{EntityID: testSP, ACSURL: testACS}
+	signed := sign(t, testAssertion("_a1", testSP, "ada@example.com"), "_a1", key)
+
+	tests := []struct {
+		name     string
+		response string
+	}{
+		{"unsigned", testResponse(strings.Replace(testAssertion("_a1", testSP, "ada@example.com"), sigSlot, "", 1))},
+		{"tampered name id", testResponse(strings.Replace(signed, "ada@example.com", "admin@example.com", 1))},
+		{"signed by another key", testResponse(sign(t, testAssertion("_a1", testSP, "ada@example.com"), "_a1", otherKey))},
+		{"other audience", testResponse(sign(t, testAssertion("_a1", "https://other.example.com", "ada@example.com"), "_a1", key))},
+		{"wrapped assertion", testResponse(signed, strings.Replace(testAssertion("_a2", testSP, "admin@example.com"), sigSlot, "", 1))},
+		{"duplicate id", testResponse(signed, strings.Replace(testAssertion("_a1", testSP, "admin@example.com"), sigSlot, "", 1))},
+	}
+
+	for _, tt := range tests {
+		if _, err := sp.ParseResponse(idp, tt.response, testNow); err == nil {
+			t.Errorf("%s: response was accepted", tt.name)
+		}
+	}
+}
+
+func TestParseIdPMetadata(t *testing.T) {
+	idp, _ := testIdentityProvider(t)
+
+	metadata := `<?xml version="1.0"?>
+<md:EntityDescriptor xmlns:md="` + nsMetadata + `" xmlns:ds="` + nsDSig + `" entityID="` + testIdP + `">
+  <md:IDPSSODescriptor protocolSupportEnumeration="` + nsProtocol + `">
+    <md:KeyDescriptor use="encryption"><ds:KeyInfo><ds:X509Data><ds:X509Certificate>AAAA</ds:X509Certificate></ds:X509Data></ds:KeyInfo></md:KeyDescriptor>
+    <md:KeyDescriptor use="signing"><ds:KeyInfo><ds:X509Data><ds:X509Certificate>
+` + idp.Certificates[0] + `
+    </ds:X509Certificate></ds:X509Data></ds:KeyInfo></md:KeyDescriptor>
+    <md:SingleSignOnService Binding="` + bindingPOST + `" Location="https://idp.example.com/post"/>
+    <md:SingleSignOnService Binding="` + bindingRedirect + `" Location="https://idp.example.com/sso"/>
+  </md:IDPSSODescriptor>
+</md:EntityDescriptor>`
+
+	got, err := ParseIdPMetadata([]byte(metadata))
+	if err != nil {
+		t.Fatalf("ParseIdPMetadata returned error: %v", err)
+	}
+
+	if got.EntityID != idp.EntityID || got.SSOURL != idp.SSOURL || len(got.Certificates) != 1 || got.Certificates[0] != idp.Certificates[0] {
+		t.Errorf("ParseIdPMetadata() = %+v, want %+v", got, idp)
+	}
+
+	if _, err := got.Validate(testNow); err != nil {
+		t.Errorf("Validate returned error: %v", err)
+	}
+
+	if _, err := got.Validate(testNow.Add(2 * 365 * 24 * time.Hour)); !errors.Is(err, ErrCertificateUsage) {
+		t.Errorf("expired certificate error = %v, want ErrCertificateUsage", err)
+	}
+}
@@ -0,0 +1,312 @@
+package sso
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"sort"
+	"strings"
+)
+
+const (
+	nsXML       = "http://www.w3.org/XML/1998/namespace"
+	nsDSig      = "http://www.w3.org/2000/09/xmldsig#"
+	nsAssertion = "urn:oasis:names:tc:SAML:2.0:assertion"
+	nsProtocol  = "urn:oasis:names:tc:SAML:2.0:protocol"
+	nsMetadata  = "urn:oasis:names:tc:SAML:2.0:metadata"
+)
+
+var ErrMalformedXML = errors.New("malformed saml document")
+
+// node is an element of a parsed document. Names and attributes keep the prefixes
+// they were written with, which canonicalization needs and encoding/xml's
+// namespace translation would lose.
+type node struct {
+	name     xml.Name
+	attrs    []xml.Attr
+	children []interface{} // *node or string
+	parent   *node
+}
+
+// parseXML builds a tree from data. Documents with a DTD are rejected.
+func parseXML(data []byte) (*node, error) {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+
+	var root, cur *node
+
+	for {
+		tok, err := dec.RawToken()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			return nil, ErrMalformedXML
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			n := &node{name: t.Name, attrs: append([]xml.Attr(nil), t.Attr...), parent: cur}
+
+			if cur == nil {
+				if root != nil {
+					return nil, ErrMalformedXML
+				}
+
+				root = n
+			} else {
+				cur.children = append(cur.children, n)
+			}
+
+			cur = n
+		case xml.EndElement:
+			if cur == nil || cur.name != t.Name {
+				return nil, ErrMalformedXML
+			}
+
+			cur = cur.parent
+		case xml.CharData:
+			if cur != nil {
+				cur.children = append(cur.children, string(t))
+			}
+		case xml.Directive:
+			return nil, ErrMalformedXML
+		}
+	}
+
+	if root == nil || cur != nil {
+		return nil, ErrMalformedXML
+	}
+
+	return root, nil
+}
+
+// lookupNS resolves prefix to a namespace URI in the scope of n.
+func (n *node) lookupNS(prefix string) (string, bool) {
+	if prefix == "xml" {
+		return nsXML, true
+	}
+
+	for e := n; e != nil; e = e.parent {
+		for _, a := range e.attrs {
+			if (prefix == "" && a.Name.Space == "" && a.Name.Local == "xmlns") ||
+				(prefix != "" && a.Name.Space == "xmlns" && a.Name.Local == prefix) {
+				return a.Value, true
+			}
+		}
+	}
+
+	return "", prefix == ""
+}
+
+func (n *node) namespace() string {
+	ns, _ := n.lookupNS(n.name.Space)
+	return ns
+}
+
+func (n *node) is(ns, local string) bool {
+	return n.name.Local == local && n.namespace() == ns
+}
+
+// attr returns the value of an unprefixed attribute.
+func (n *node) attr(local string) string {
+	for _, a := range n.attrs {
+		if a.Name.Space == "" && a.Name.Local == local {
+			return a.Value
+		}
+	}
+
+	return ""
+}
+
+func (n *node) elements() []*node {
+	var out []*node
+
+	for _, c := range n.children {
+		if e, ok := c.(*node); ok {
+			out = append(out, e)
+		}
+	}
+
+	return out
+}
+
+func (n *node) all(ns, local string) []*node {
+	var out []*node
+
+	for _, e := range n.elements() {
+		if e.is(ns, local) {
+			out = append(out, e)
+		}
+	}
+
+	return out
+}
+
+func (n *node) child(ns, local string) *node {
+	if all := n.all(ns, local); len(all) > 0 {
+		return all[0]
+	}
+
+	return nil
+}
+
+func (n *node) text() string {
+	var b strings.Builder
+
+	for _, c := range n.children {
+		if s, ok := c.(string); ok {
+			b.WriteString(s)
+		}
+	}
+
+	return strings.TrimSpace(b.String())
+}
+
+// walk calls fn for n and every element below it.
+func (n *node) walk(fn func(*node)) {
+	fn(n)
+
+	for _, e := range n.elements() {
+		e.walk(fn)
+	}
+}
+
+// canonicalize serializes the subtree at n with Exclusive XML Canonicalization 1.0
+// without comments. exclude, if set, is left out together with its subtree, which
+// implements the enveloped signature transform. inclusive lists the prefixes of an
+// InclusiveNamespaces PrefixList.
+func canonicalize(n, exclude *node, inclusive []string) []byte {
+	c := &c14n{exclude: exclude, inclusive: map[string]bool{}}
+
+	for _, p := range inclusive {
+		if p == "#default" {
+			p = ""
+		}
+
+		c.inclusive[p] = true
+	}
+
+	c.element(n, map[string]string{})
+
+	return c.buf.Bytes()
+}
+
+type c14n struct {
+	buf       bytes.Buffer
+	exclude   *node
+	inclusive map[string]bool
+}
+
+type c14nAttr struct {
+	ns, qname, local, value string
+}
+
+func (c *c14n) element(n *node, rendered map[string]string) {
+	// namespaces visibly utilized by the element and its attributes
+	used := map[string]bool{n.name.Space: true}
+
+	var attrs []c14nAttr
+
+	for _, a := range n.attrs {
+		if a.Name.Space == "xmlns" || (a.Name.Space == "" && a.Name.Local == "xmlns") {
+			continue
+		}
+
+		qname, ns := a.Name.Local, ""
+		if a.Name.Space != "" {
+			qname = a.Name.Space + ":" + a.Name.Local
+			ns, _ = n.lookupNS(a.Name.Space)
+
+			if a.Name.Space != "xml" {
+				used[a.Name.Space] = true
+			}
+		}
+
+		attrs = append(attrs, c14nAttr{ns: ns, qname: qname, local: a.Name.Local, value: a.Value})
+	}
+
+	for p := range c.inclusive {
+		if _, ok := n.lookupNS(p); ok {
+			used[p] = true
+		}
+	}
+
+	scope := make(map[string]string, len(rendered))
+	for p, uri := range rendered {
+		scope[p] = uri
+	}
+
+	var prefixes []string
+
+	for p := range used {
+		uri, _ := n.lookupNS(p)
+
+		prev, seen := rendered[p]
+		if (seen && prev == uri) || (p == "" && uri == "" && !seen) {
+			continue
+		}
+
+		prefixes = append(prefixes, p)
+		scope[p] = uri
+	}
+
+	sort.Strings(prefixes)
+	sort.Slice(attrs, func(i, j int) bool {
+		if attrs[i].ns != attrs[j].ns {
+			return attrs[i].ns < attrs[j].ns
+		}
+
+		return attrs[i].local < attrs[j].local
+	})
+
+	qname := n.name.Local
+	if n.name.Space != "" {
+		qname = n.name.Space + ":" + n.name.Local
+	}
+
+	c.buf.WriteString("<" + qname)
+
+	for _, p := range prefixes {
+		if p == "" {
+			c.buf.WriteString(` xmlns="`)
+		} else {
+			c.buf.WriteString(" xmlns:" + p + `="`)
+		}
+
+		c.buf.WriteString(escapeAttr(scope[p]) + `"`)
+	}
+
+	for _, a := range attrs {
+		c.buf.WriteString(" " + a.qname + `="` + escapeAttr(a.value) + `"`)
+	}
+
+	c.buf.WriteString(">")
+
+	for _, child := range n.children {
+		switch v := child.(type) {
+		case *node:
+			if v != c.exclude {
+				c.element(v, scope)
+			}
+		case string:
+			c.buf.WriteString(escapeText(v))
+		}
+	}
+
+	c.buf.WriteString("</" + qname + ">")
+}
+
+var (
+	textEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\r", "&#xD;")
+	attrEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", `"`, "&quot;", "\t", "&#x9;", "\n", "&#xA;", "\r", "&#xD;")
+)
+
+func escapeText(s string) string {
+	return textEscaper.Replace(s)
+}
+
+func escapeAttr(s string) string {
+	return attrEscaper.Replace(s)
+}
@@ -0,0 +1,64 @@
+package sso
+
+import "testing"
+
+func TestCanonicalize(t *testing.T) {
+	tests := []struct {
+		name      string
+		doc       string
+		path      []string
+		inclusive []string
+		want      string
+	}{
+		{
+			// Exclusive XML Canonicalization 1.0, section 2.2
+			name: "only visibly utilized namespaces",
+			doc: `<n0:local xmlns:n0="foo:bar" xmlns:n3="ftp://example.org"><n1:elem2 xmlns:n1="http://example.net" xml:lang="en">` +
+				`<n3:stuff xmlns:n3="ftp://example.org"/></n1:elem2></n0:local>`,
+			path: []string{"elem2"},
+			want: `<n1:elem2 xmlns:n1="http://example.net" xml:lang="en"><n3:stuff xmlns:n3="ftp://example.org"></n3:stuff></n1:elem2>`,
+		},
+		{
+			name: "inclusive prefix list",
+			doc: `<n0:local xmlns:n0="foo:bar" xmlns:n3="ftp://example.org"><n1:elem2 xmlns:n1="http://example.net" xml:lang="en">` +
+				`<n3:stuff xmlns:n3="ftp://example.org"/></n1:elem2></n0:local>`,
+			path:      []string{"elem2"},
+			inclusive: []string{"n3"},
+			want:      `<n1:elem2 xmlns:n1="http://example.net" xmlns:n3="ftp://example.org" xml:lang="en"><n3:stuff></n3:stuff></n1:elem2>`,
+		},
+		{
+			name: "attribute order, escaping and default namespace",
+			doc: `<root xmlns="urn:a" xmlns:b="urn:b"><e z="1" b:y="&quot;2&quot;" a="x&#9;&lt;y" xmlns:c="urn:unused">` +
+				`a &amp; b &gt; c<f xmlns=""/></e></root>`,
+			path: []string{"e"},
+			want: `<e xmlns="urn:a" xmlns:b="urn:b" a="x&#x9;&lt;y" z="1" b:y="&quot;2&quot;">a &amp; b &gt; c<f xmlns=""></f></e>`,
+		},
+	}
+
+	for _, tt := range tests {
+		root, err := parseXML([]byte(tt.doc))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		el := root
+		for _, local := range tt.path {
+			for _, c := range el.elements() {
+				if c.name.Local == local {
+					el = c
+				}
+			}
+		}
+
+		if got := string(canonicalize(el, nil, tt.inclusive)); got != tt.want {
+			t.Errorf("%s:\n got %s\nwant %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseXMLRejectsDTD(t *testing.T) {
+	doc := `<!DOCTYPE r [<!ENTITY x "y">]><r>&x;</r>`
+	if _, err := parseXML([]byte(doc)); err == nil {
+		t.Error("documents with a DTD should be rejected")
+	}
+}
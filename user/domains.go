@@ -0,0 +1,74 @@
+package user
+
+import (
+	"context"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"zuri.chat/zccore/utils"
+)
+
+// JoinableOrganization is an organization a user can join without an invite, because
+// it accepts members from the domain of their email address.
+type JoinableOrganization struct {
+	ID           string `json:"id" bson:"_id"`
+	Name         string `json:"name" bson:"name"`
+	LogoURL      string `json:"logo_url" bson:"logo_url"`
+	WorkspaceURL string `json:"workspace_url" bson:"workspace_url"`
+}
+
+// EmailDomain returns the lower cased domain of an email address, empty if it has none.
+func EmailDomain(email string) string {
+	i := strings.LastIndex(email, "@")
+	if i < 0 {
+		return ""
+	}
+
+	return strings.ToLower(email[i+1:])
+}
+
+// JoinableOrganizations lists the organizations open to the domain of email that the
+// address isn't a member of yet.
+func JoinableOrganizations(ctx context.Context, email string) ([]JoinableOrganization, error) {
+	orgs := []JoinableOrganization{}
+
+	domain := EmailDomain(email)
+	if domain == "" {
+		return orgs, nil
+	}
+
+	joined, err := utils.GetCollection(MemberCollectionName).Distinct(ctx, "org_id", bson.M{"email": email})
+	if err != nil {
+		return nil, err
+	}
+
+	member := make(map[interface{}]bool, len(joined))
+	for _, id := range joined {
+		member[id] = true
+	}
+
+	filter := bson.M{
+		"settings.permissions.invitations":           true,
+		"settings.permissions.allowed_email_domains": domain,
+	}
+
+	cursor, err := utils.GetCollection(OrganizationCollectionName).Find(ctx, filter,
+		options.Find().SetProjection(bson.M{"name": 1, "logo_url": 1, "workspace_url": 1}))
+	if err != nil {
+		return nil, err
+	}
+
+	var found []JoinableOrganization
+	if err := cursor.All(ctx, &found); err != nil {
+		return nil, err
+	}
+
+	for _, org := range found {
+		if !member[org.ID] {
+			orgs = append(orgs, org)
+		}
+	}
+
+	return orgs, nil
+}
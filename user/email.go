@@ -0,0 +1,65 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"zuri.chat/zccore/utils"
+)
+
+var (
+	ErrEmailTaken   = errors.New("this email address is already used by another account")
+	ErrEmailChanged = errors.New("the account email has changed in the meantime, start again")
+)
+
+// ChangeEmail moves an account from one email address to another, along with the
+// workspace profiles and organizations keyed on it. Everything is updated in one
+// transaction, so the account is never left half moved.
+func ChangeEmail(ctx context.Context, userID, from, to string) error {
+	objID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return err
+	}
+
+	session, err := utils.GetDefaultMongoClient().StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		res, err := utils.GetCollection(UserCollectionName).UpdateOne(sc, bson.M{"_id": objID, "email": from},
+			bson.M{"$set": bson.M{"email": to, "updated_at": time.Now()}})
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, ErrEmailTaken
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if res.MatchedCount == 0 {
+			return nil, ErrEmailChanged
+		}
+
+		if _, err := utils.GetCollection(MemberCollectionName).UpdateMany(sc, bson.M{"email": from},
+			bson.M{"$set": bson.M{"email": to}}); err != nil {
+			return nil, err
+		}
+
+		if err := RefreshMemberSearchKeys(sc, bson.M{"email": to}); err != nil {
+			return nil, err
+		}
+
+		_, err = utils.GetCollection(OrganizationCollectionName).UpdateMany(sc, bson.M{"creator_email": from},
+			bson.M{"$set": bson.M{"creator_email": to}})
+
+		return nil, err
+	})
+
+	return err
+}
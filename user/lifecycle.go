@@ -0,0 +1,272 @@
+package user
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"zuri.chat/zccore/utils"
+)
+
+const (
+	AccountExportCollectionName    = "account_exports"
+	ReportCollectionName           = "reports"
+	TokenTransactionCollectionName = "token_transaction"
+
+	// uploadsDir is where the upload service stores files, under folders named after
+	// the organization and member they belong to.
+	uploadsDir = "files"
+)
+
+// Export states.
+const (
+	ExportPending = "pending"
+	ExportReady   = "ready"
+	ExportFailed  = "failed"
+)
+
+// secretFields are never exported nor returned by the API.
+var secretFields = []string{"password", "password_history", "two_factor", "email_verification", "password_resets"}
+
+// AccountExport is an archive of everything stored about a user, built in the
+// background and downloadable until it expires.
+type AccountExport struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserID      string             `json:"-" bson:"user_id"`
+	Status      string             `json:"status" bson:"status"`
+	Path        string             `json:"-" bson:"path,omitempty"`
+	Size        int64              `json:"size,omitempty" bson:"size,omitempty"`
+	Error       string             `json:"error,omitempty" bson:"error,omitempty"`
+	CreatedAt   time.Time          `json:"created_at" bson:"created_at"`
+	CompletedAt *time.Time         `json:"completed_at,omitempty" bson:"completed_at,omitempty"`
+	ExpiresAt   time.Time          `json:"expires_at" bson:"expires_at"`
+}
+
+// String returns the name of the status.
+func (s Status) String() string {
+	switch s {
+	case Active:
+		return "active"
+	case Suspended:
+		return "suspended"
+	case Disabled:
+		return "disabled"
+	default:
+		return fmt.Sprintf("status(%d)", int(s))
+	}
+}
+
+// WriteExport writes a zip archive of the user's account, workspace profiles, reports
+// filed, token transactions of the organizations they own and uploads to w.
+func WriteExport(ctx context.Context, u *User, w io.Writer) error {
+	archive := zip.NewWriter(w)
+
+	userID, _ := primitive.ObjectIDFromHex(u.ID)
+
+	account, err := utils.GetMongoDBDoc(UserCollectionName, bson.M{"_id": userID})
+	if err != nil {
+		return err
+	}
+
+	DeleteMapProps(account, secretFields)
+
+	members, err := utils.GetMongoDBDocs(MemberCollectionName, bson.M{"email": u.Email})
+	if err != nil {
+		return err
+	}
+
+	reports, err := utils.GetMongoDBDocs(ReportCollectionName, bson.M{"reporter_email": u.Email})
+	if err != nil {
+		return err
+	}
+
+	owned := []string{}
+
+	for _, m := range members {
+		if orgID, _ := m["org_id"].(string); m["role"] == "owner" {
+			owned = append(owned, orgID)
+		}
+	}
+
+	transactions, err := utils.GetMongoDBDocs(TokenTransactionCollectionName, bson.M{"org_id": bson.M{"$in": owned}})
+	if err != nil {
+		return err
+	}
+
+	files := map[string]interface{}{
+		"account.json":            account,
+		"workspace_profiles.json": emptyIfNil(members),
+		"reports.json":            emptyIfNil(reports),
+		"token_transactions.json": emptyIfNil(transactions),
+	}
+
+	for name, data := range files {
+		if err := writeJSONFile(archive, name, data); err != nil {
+			return err
+		}
+	}
+
+	for _, m := range members {
+		for _, dir := range memberUploadDirs(m) {
+			if err := addDir(ctx, archive, dir, filepath.Join("uploads", strings.TrimPrefix(dir, uploadsDir))); err != nil {
+				return err
+			}
+		}
+	}
+
+	return archive.Close()
+}
+
+// PurgeUserData erases a user for good: their workspace profiles and uploads, pending
+// invites, codes and data exports, and the account itself. Reports they filed are kept
+// for the organizations' moderators but no longer name them.
+func PurgeUserData(ctx context.Context, u *User) error {
+	members, err := utils.GetMongoDBDocs(MemberCollectionName, bson.M{"email": u.Email})
+	if err != nil {
+		return err
+	}
+
+	for _, m := range members {
+		for _, dir := range memberUploadDirs(m) {
+			if err := os.RemoveAll(dir); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := RemoveExports(ctx, bson.M{"user_id": u.ID}); err != nil {
+		return err
+	}
+
+	deletes := map[string]bson.M{
+		MemberCollectionName:               {"email": u.Email},
+		OrganizationsInvitesCollectionName: {"email": u.Email},
+		OneTimeTokenCollectionName:         {"user_id": u.ID},
+	}
+
+	for coll, filter := range deletes {
+		if _, err := utils.GetCollection(coll).DeleteMany(ctx, filter); err != nil {
+			return err
+		}
+	}
+
+	if _, err := utils.GetCollection(ReportCollectionName).UpdateMany(ctx, bson.M{"reporter_email": u.Email},
+		bson.M{"$set": bson.M{"reporter_email": "", "anonymous": true}}); err != nil {
+		return err
+	}
+
+	userID, _ := primitive.ObjectIDFromHex(u.ID)
+	_, err = utils.GetCollection(UserCollectionName).DeleteOne(ctx, bson.M{"_id": userID})
+
+	return err
+}
+
+// RemoveExports deletes the exports matching filter and their archives.
+func RemoveExports(ctx context.Context, filter bson.M) error {
+	cursor, err := utils.GetCollection(AccountExportCollectionName).Find(ctx, filter)
+	if err != nil {
+		return err
+	}
+
+	var exports []AccountExport
+	if err := cursor.All(ctx, &exports); err != nil {
+		return err
+	}
+
+	for _, e := range exports {
+		if e.Path == "" {
+			continue
+		}
+
+		if err := os.Remove(e.Path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	_, err = utils.GetCollection(AccountExportCollectionName).DeleteMany(ctx, filter)
+
+	return err
+}
+
+// memberUploadDirs lists the folders the upload service keeps a member's files in.
+func memberUploadDirs(m bson.M) []string {
+	orgID, _ := m["org_id"].(string)
+	memberID, _ := m["_id"].(primitive.ObjectID)
+
+	if orgID == "" || memberID.IsZero() || strings.ContainsAny(orgID, `/\.`) {
+		return nil
+	}
+
+	return []string{
+		filepath.Join(uploadsDir, "profile_image", orgID, memberID.Hex()),
+		filepath.Join(uploadsDir, "fileupload", orgID, memberID.Hex()),
+	}
+}
+
+func writeJSONFile(archive *zip.Writer, name string, data interface{}) error {
+	f, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(data)
+}
+
+// addDir copies the files under dir into the archive, under prefix.
+func addDir(ctx context.Context, archive *zip.Writer, dir, prefix string) error {
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		f, err := archive.Create(filepath.ToSlash(filepath.Join(prefix, rel)))
+		if err != nil {
+			return err
+		}
+
+		src, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+
+		_, err = io.Copy(f, src)
+
+		return err
+	})
+
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
+
+func emptyIfNil(docs []bson.M) []bson.M {
+	if docs == nil {
+		return []bson.M{}
+	}
+
+	return docs
+}
@@ -0,0 +1,27 @@
+package user
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"zuri.chat/zccore/utils"
+)
+
+// MemberSearchFields are the member fields the directory search matches, they are
+// kept lower cased in search_keys so a case sensitive prefix can use its index.
+var MemberSearchFields = []string{"first_name", "last_name", "display_name", "user_name", "email"}
+
+// RefreshMemberSearchKeys recomputes the search_keys of the members matching filter,
+// after they are added or their names or email change.
+func RefreshMemberSearchKeys(ctx context.Context, filter bson.M) error {
+	keys := bson.A{}
+	for _, field := range MemberSearchFields {
+		keys = append(keys, bson.M{"$toLower": "$" + field})
+	}
+
+	_, err := utils.GetCollection(MemberCollectionName).UpdateMany(ctx, filter,
+		mongo.Pipeline{{{Key: "$set", Value: bson.M{"search_keys": keys}}}})
+
+	return err
+}
@@ -5,6 +5,7 @@ import (
 
 	"github.com/go-playground/validator/v10"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"golang.org/x/crypto/bcrypt"
 	"zuri.chat/zccore/service"
@@ -27,6 +28,8 @@ const (
 
 type M map[string]interface{}
 
+// Status is the state of an account. Suspended accounts can't sign in, disabled ones
+// are scheduled for deletion and can still sign in to cancel it.
 type Status int
 
 const (
@@ -55,46 +58,91 @@ type UserSettings struct {
 	// Role Role
 }
 
-//nolint:revive //changing name will break a lot of codes
-type UserEmailVerification struct {
-	Verified  bool      `bson:"verified" json:"verified"`
-	Token     string    `bson:"token" json:"token"`
-	ExpiredAt time.Time `bson:"expired_at" json:"expired_at"`
+// Social is an external identity, e.g. a Google account, linked to a user.
+type Social struct {
+	ID       string    `bson:"provider_id" json:"provider_id"`
+	Provider string    `bson:"provider" json:"provider"`
+	Email    string    `bson:"email,omitempty" json:"email,omitempty"`
+	LinkedAt time.Time `bson:"linked_at,omitempty" json:"linked_at,omitempty"`
 }
 
-//nolint:revive //changing name will break a lot of codes
-type UserPasswordReset struct {
-	IPAddress string    `bson:"ip_address" json:"ip_address"`
-	Token     string    `bson:"token" json:"token"`
-	ExpiredAt time.Time `bson:"expired_at" json:"expired_at"`
-	UpdatedAt time.Time `bson:"updated_at" json:"updated_at"`
-	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+// Socials lists every identity linked to a user. Accounts linked before several
+// providers were supported hold a single document, which decodes as a one item list.
+type Socials []Social
+
+func (s *Socials) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+	raw := bson.RawValue{Type: t, Value: data}
+
+	switch t {
+	case bsontype.Array:
+		var list []Social
+		if err := raw.Unmarshal(&list); err != nil {
+			return err
+		}
+
+		*s = list
+	case bsontype.EmbeddedDocument:
+		var one Social
+		if err := raw.Unmarshal(&one); err != nil {
+			return err
+		}
+
+		*s = Socials{one}
+	default:
+		*s = nil
+	}
+
+	return nil
 }
 
-type Social struct {
-	ID       string `bson:"provider_id" json:"provider_id"`
-	Provider string `bson:"provider" json:"provider"`
+// Find returns the identity linked for provider, if any.
+func (s Socials) Find(provider string) (Social, bool) {
+	for _, identity := range s {
+		if identity.Provider == provider {
+			return identity, true
+		}
+	}
+
+	return Social{}, false
+}
+
+// TwoFactor holds a user's TOTP enrollment. Secrets are stored encrypted and
+// recovery codes are stored as SHA-256 hashes, so neither is ever returned by the API.
+type TwoFactor struct {
+	Enabled       bool      `bson:"enabled" json:"enabled"`
+	Secret        string    `bson:"secret" json:"-"`
+	PendingSecret string    `bson:"pending_secret" json:"-"`
+	RecoveryCodes []string  `bson:"recovery_codes" json:"-"`
+	EnabledAt     time.Time `bson:"enabled_at" json:"enabled_at"`
+	// LastStep is the time step of the last TOTP code accepted, codes can't be used twice.
+	LastStep int64 `bson:"last_step" json:"-"`
 }
 
 type User struct {
-	ID                string                 `bson:"_id,omitempty" json:"_id,omitempty"`
-	FirstName         string                 `bson:"first_name" json:"first_name"`
-	LastName          string                 `bson:"last_name" json:"last_name"`
-	Email             string                 `bson:"email" validate:"email,required" json:"email"`
-	Password          string                 `bson:"password" json:"password" validate:"required,min=6"`
-	Phone             string                 `bson:"phone" json:"phone"`
-	Settings          *UserSettings          `bson:"settings" json:"settings"`
-	Timezone          string                 `bson:"time_zone" json:"time_zone"`
-	Role              string                 `bson:"role" json:"role"`
-	CreatedAt         time.Time              `bson:"created_at" json:"created_at"`
-	UpdatedAt         time.Time              `bson:"updated_at" json:"updated_at"`
-	Deactivated       bool                   `default:"false" bson:"deactivated" json:"deactivated"`
-	DeactivatedAt     time.Time              `bson:"deactivated_at" json:"deactivated_at"`
-	IsVerified        bool                   `bson:"isverified" json:"isverified"`
-	Social            *Social                `bson:"social" json:"social"`
-	Organizations     []string               `bson:"workspaces" json:"workspaces"` // should contain (organization) workspace ids
-	EmailVerification *UserEmailVerification `bson:"email_verification" json:"email_verification"`
-	PasswordResets    *UserPasswordReset     `bson:"password_resets" json:"password_resets"` // remove the array
+	ID            string        `bson:"_id,omitempty" json:"_id,omitempty"`
+	FirstName     string        `bson:"first_name" json:"first_name"`
+	LastName      string        `bson:"last_name" json:"last_name"`
+	Email         string        `bson:"email" validate:"email,required" json:"email"`
+	Password      string        `bson:"password" json:"password" validate:"required"`
+	Phone         string        `bson:"phone" json:"phone"`
+	Settings      *UserSettings `bson:"settings" json:"settings"`
+	Timezone      string        `bson:"time_zone" json:"time_zone"`
+	Role          string        `bson:"role" json:"role"`
+	CreatedAt     time.Time     `bson:"created_at" json:"created_at"`
+	UpdatedAt     time.Time     `bson:"updated_at" json:"updated_at"`
+	Deactivated   bool          `default:"false" bson:"deactivated" json:"deactivated"`
+	DeactivatedAt time.Time     `bson:"deactivated_at" json:"deactivated_at"`
+	IsVerified    bool          `bson:"isverified" json:"isverified"`
+	Social        Socials       `bson:"social" json:"social"`
+	Organizations []string      `bson:"workspaces" json:"workspaces"` // should contain (organization) workspace ids
+	TwoFactor     *TwoFactor    `bson:"two_factor,omitempty" json:"-"`
+
+	Status              Status     `bson:"status" json:"status"`
+	StatusReason        string     `bson:"status_reason,omitempty" json:"status_reason,omitempty"`
+	StatusChangedAt     *time.Time `bson:"status_changed_at,omitempty" json:"status_changed_at,omitempty"`
+	DeletionScheduledAt *time.Time `bson:"deletion_scheduled_at,omitempty" json:"deletion_scheduled_at,omitempty"`
+
+	PasswordHistory []string `bson:"password_history,omitempty" json:"-"`
 }
 
 // Struct that user can update directly.
@@ -0,0 +1,102 @@
+package user
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestSocialsUnmarshalBSON(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  bson.M
+		want []string
+	}{
+		{"legacy single identity", bson.M{"social": bson.M{"provider": "google", "provider_id": "1"}}, []string{"google"}},
+		{"identity list", bson.M{"social": bson.A{
+			bson.M{"provider": "google", "provider_id": "1"},
+			bson.M{"provider": "microsoft", "provider_id": "2"},
+		}}, []string{"google", "microsoft"}},
+		{"null", bson.M{"social": nil}, nil},
+	}
+
+	for _, tt := range tests {
+		raw, err := bson.Marshal(tt.doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var u User
+		if err := bson.Unmarshal(raw, &u); err != nil {
+			t.Fatalf("%s: unmarshal failed: %v", tt.name, err)
+		}
+
+		if len(u.Social) != len(tt.want) {
+			t.Fatalf("%s: got %d identities, want %d", tt.name, len(u.Social), len(tt.want))
+		}
+
+		for i, provider := range tt.want {
+			if u.Social[i].Provider != provider {
+				t.Errorf("%s: identity %d provider = %s, want %s", tt.name, i, u.Social[i].Provider, provider)
+			}
+		}
+	}
+
+	if _, ok := (Socials{{Provider: "google"}}).Find("microsoft"); ok {
+		t.Error("Find should not match another provider")
+	}
+}
+
+func TestStatusString(t *testing.T) {
+	for status, want := range map[Status]string{Active: "active", Suspended: "suspended", Disabled: "disabled", Status(9): "status(9)"} {
+		if got := status.String(); got != want {
+			t.Errorf("Status(%d).String() = %s, want %s", int(status), got, want)
+		}
+	}
+}
+
+func TestMemberUploadDirs(t *testing.T) {
+	memberID := primitive.NewObjectID()
+
+	dirs := memberUploadDirs(bson.M{"_id": memberID, "org_id": "6145d4a4"})
+	if len(dirs) != 2 || dirs[0] != filepath.Join("files", "profile_image", "6145d4a4", memberID.Hex()) {
+		t.Errorf("unexpected upload dirs %v", dirs)
+	}
+
+	if dirs := memberUploadDirs(bson.M{"_id": memberID, "org_id": "../.."}); dirs != nil {
+		t.Errorf("expected no upload dirs outside the uploads folder, got %v", dirs)
+	}
+}
+
+func TestInviteOpen(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name   string
+		invite bson.M
+		want   bool
+	}{
+		{"legacy pending", bson.M{"has_accepted": false}, true},
+		{"legacy accepted", bson.M{"has_accepted": true}, false},
+		{"pending", bson.M{"status": "pending", "expires_at": primitive.NewDateTimeFromTime(now.Add(time.Hour))}, true},
+		{"expired", bson.M{"status": "pending", "expires_at": primitive.NewDateTimeFromTime(now.Add(-time.Hour))}, false},
+		{"revoked", bson.M{"status": "revoked"}, false},
+	}
+
+	for _, tt := range tests {
+		if got := inviteOpen(tt.invite, now); got != tt.want {
+			t.Errorf("%s: inviteOpen = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestEmailDomain(t *testing.T) {
+	for email, want := range map[string]string{"ada@Zuri.Chat": "zuri.chat", "a@b@hng.tech": "hng.tech", "nodomain": ""} {
+		if got := EmailDomain(email); got != want {
+			t.Errorf("EmailDomain(%q) = %q, want %q", email, got, want)
+		}
+	}
+}
@@ -0,0 +1,329 @@
+package user
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1" //nolint:gosec //the breached password corpus is keyed by SHA-1
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/crypto/bcrypt"
+	"zuri.chat/zccore/utils"
+)
+
+const (
+	// passwordHistoryLimit caps how many previous hashes are kept per user.
+	passwordHistoryLimit = 24
+	breachPrefixLength   = 5
+	minPersonalInfoLen   = 3
+)
+
+var ErrBreachedPassword = errors.New("this password has appeared in a data breach, kindly choose a different one")
+
+// PasswordPolicy describes what a new password must satisfy. A global policy comes
+// from configuration and organizations may set their own in OrgAuthentication.
+type PasswordPolicy struct {
+	MinLength            int  `json:"min_length" bson:"min_length" validate:"omitempty,min=6,max=128"`
+	RequireUppercase     bool `json:"require_uppercase" bson:"require_uppercase"`
+	RequireLowercase     bool `json:"require_lowercase" bson:"require_lowercase"`
+	RequireDigit         bool `json:"require_digit" bson:"require_digit"`
+	RequireSymbol        bool `json:"require_symbol" bson:"require_symbol"`
+	DisallowPersonalInfo bool `json:"disallow_personal_info" bson:"disallow_personal_info"`
+	HistorySize          int  `json:"history_size" bson:"history_size" validate:"gte=0,max=24"`
+	CheckBreached        bool `json:"check_breached" bson:"check_breached"`
+}
+
+// PolicyError lists every rule a password broke so clients can show them all at once.
+type PolicyError struct {
+	Violations []string
+}
+
+func (e *PolicyError) Error() string {
+	return "password must " + strings.Join(e.Violations, ", ")
+}
+
+// GlobalPasswordPolicy returns the policy configured through the environment.
+func GlobalPasswordPolicy(c *utils.Configurations) PasswordPolicy {
+	return PasswordPolicy{
+		MinLength:            c.PasswordMinLength,
+		RequireUppercase:     c.PasswordRequireUppercase,
+		RequireLowercase:     c.PasswordRequireLowercase,
+		RequireDigit:         c.PasswordRequireDigit,
+		RequireSymbol:        c.PasswordRequireSymbol,
+		DisallowPersonalInfo: c.PasswordDisallowPersonalInfo,
+		HistorySize:          c.PasswordHistorySize,
+		CheckBreached:        c.BreachedPasswordsDir != "",
+	}
+}
+
+// Stricter combines two policies, keeping the stronger requirement of each rule.
+func (p PasswordPolicy) Stricter(o PasswordPolicy) PasswordPolicy {
+	if o.MinLength > p.MinLength {
+		p.MinLength = o.MinLength
+	}
+
+	if o.HistorySize > p.HistorySize {
+		p.HistorySize = o.HistorySize
+	}
+
+	p.RequireUppercase = p.RequireUppercase || o.RequireUppercase
+	p.RequireLowercase = p.RequireLowercase || o.RequireLowercase
+	p.RequireDigit = p.RequireDigit || o.RequireDigit
+	p.RequireSymbol = p.RequireSymbol || o.RequireSymbol
+	p.DisallowPersonalInfo = p.DisallowPersonalInfo || o.DisallowPersonalInfo
+	p.CheckBreached = p.CheckBreached || o.CheckBreached
+
+	return p
+}
+
+// Validate checks password against the composition rules of the policy. u supplies
+// the personal details the password may not contain and can be nil.
+func (p PasswordPolicy) Validate(password string, u *User) error {
+	var violations []string
+
+	if len([]rune(password)) < p.MinLength {
+		violations = append(violations, "be at least "+strconv.Itoa(p.MinLength)+" characters long")
+	}
+
+	var upper, lower, digit, symbol bool
+
+	for _, c := range password {
+		switch {
+		case unicode.IsUpper(c):
+			upper = true
+		case unicode.IsLower(c):
+			lower = true
+		case unicode.IsDigit(c):
+			digit = true
+		case unicode.IsPunct(c) || unicode.IsSymbol(c) || unicode.IsSpace(c):
+			symbol = true
+		}
+	}
+
+	for _, rule := range []struct {
+		required, ok bool
+		msg          string
+	}{
+		{p.RequireUppercase, upper, "contain an uppercase letter"},
+		{p.RequireLowercase, lower, "contain a lowercase letter"},
+		{p.RequireDigit, digit, "contain a digit"},
+		{p.RequireSymbol, symbol, "contain a symbol"},
+	} {
+		if rule.required && !rule.ok {
+			violations = append(violations, rule.msg)
+		}
+	}
+
+	if p.DisallowPersonalInfo && u != nil && containsPersonalInfo(password, u) {
+		violations = append(violations, "not contain your email address or name")
+	}
+
+	if len(violations) > 0 {
+		return &PolicyError{Violations: violations}
+	}
+
+	return nil
+}
+
+// CheckReuse returns an error if password matches the current password or one of the
+// last HistorySize passwords of u.
+func (p PasswordPolicy) CheckReuse(password string, u *User) error {
+	if p.HistorySize == 0 || u == nil {
+		return nil
+	}
+
+	hashes := append([]string{u.Password}, u.PasswordHistory...)
+	if len(hashes) > p.HistorySize {
+		hashes = hashes[:p.HistorySize]
+	}
+
+	for _, h := range hashes {
+		if h != "" && bcrypt.CompareHashAndPassword([]byte(h), []byte(password)) == nil {
+			return &PolicyError{Violations: []string{"not match any of your last " + strconv.Itoa(p.HistorySize) + " passwords"}}
+		}
+	}
+
+	return nil
+}
+
+// IsBreachedPassword looks password up in an offline copy of a k-anonymity breach
+// corpus: dir holds one file per 5 character SHA-1 prefix, each listing the
+// remaining hash suffixes as "SUFFIX:COUNT" lines, the same layout as the
+// Have I Been Pwned range API.
+func IsBreachedPassword(dir, password string) (bool, error) {
+	if dir == "" {
+		return false, nil
+	}
+
+	sum := sha1.Sum([]byte(password)) //nolint:gosec //see import
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:breachPrefixLength], hash[breachPrefixLength:]
+
+	f, err := os.Open(filepath.Join(dir, prefix))
+	if errors.Is(err, os.ErrNotExist) {
+		f, err = os.Open(filepath.Join(dir, prefix+".txt"))
+	}
+
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if i := strings.IndexByte(line, ':'); i >= 0 {
+			line = line[:i]
+		}
+
+		if strings.EqualFold(line, suffix) {
+			return true, nil
+		}
+	}
+
+	return false, scanner.Err()
+}
+
+// CheckNewPassword runs every check a new password for u has to pass: composition,
+// reuse and, when a corpus is configured, the breach list.
+func CheckNewPassword(c *utils.Configurations, p PasswordPolicy, password string, u *User) error {
+	if err := p.Validate(password, u); err != nil {
+		return err
+	}
+
+	if err := p.CheckReuse(password, u); err != nil {
+		return err
+	}
+
+	if p.CheckBreached {
+		breached, err := IsBreachedPassword(c.BreachedPasswordsDir, password)
+		if err != nil {
+			return err
+		}
+
+		if breached {
+			return ErrBreachedPassword
+		}
+	}
+
+	return nil
+}
+
+// PasswordErrorStatus maps an error from CheckNewPassword to an HTTP status.
+func PasswordErrorStatus(err error) int {
+	var policyErr *PolicyError
+	if errors.As(err, &policyErr) || errors.Is(err, ErrBreachedPassword) {
+		return http.StatusBadRequest
+	}
+
+	return http.StatusInternalServerError
+}
+
+// PolicyForOrgs returns global tightened by the policies of the given organizations.
+func PolicyForOrgs(ctx context.Context, global PasswordPolicy, orgIDs []string) PasswordPolicy {
+	ids := make([]primitive.ObjectID, 0, len(orgIDs))
+
+	for _, id := range orgIDs {
+		if oid, err := primitive.ObjectIDFromHex(id); err == nil {
+			ids = append(ids, oid)
+		}
+	}
+
+	if len(ids) == 0 {
+		return global
+	}
+
+	opts := options.Find().SetProjection(bson.M{"settings.authentication.password_policy": 1})
+
+	cursor, err := utils.GetCollection(OrganizationCollectionName).Find(ctx, bson.M{
+		"_id": bson.M{"$in": ids},
+		"settings.authentication.password_policy": bson.M{"$type": "object"},
+	}, opts)
+	if err != nil {
+		return global
+	}
+
+	var orgs []struct {
+		Settings struct {
+			Authentication struct {
+				PasswordPolicy PasswordPolicy `bson:"password_policy"`
+			} `bson:"authentication"`
+		} `bson:"settings"`
+	}
+
+	if err := cursor.All(ctx, &orgs); err != nil {
+		return global
+	}
+
+	policy := global
+	for i := range orgs {
+		policy = policy.Stricter(orgs[i].Settings.Authentication.PasswordPolicy)
+	}
+
+	return policy
+}
+
+// PolicyForUser returns the policy that applies to email across every organization
+// it is a member of.
+func PolicyForUser(ctx context.Context, global PasswordPolicy, email string) PasswordPolicy {
+	members, err := utils.GetMongoDBDocs(MemberCollectionName, bson.M{"email": strings.ToLower(email)},
+		options.Find().SetProjection(bson.M{"org_id": 1}))
+	if err != nil {
+		return global
+	}
+
+	orgIDs := make([]string, 0, len(members))
+
+	for _, m := range members {
+		if id, ok := m["org_id"].(string); ok {
+			orgIDs = append(orgIDs, id)
+		}
+	}
+
+	return PolicyForOrgs(ctx, global, orgIDs)
+}
+
+// SetPassword stores a new bcrypt hash for u and moves the old one into its history.
+func SetPassword(ctx context.Context, u *User, hash string) error {
+	history := append([]string{u.Password}, u.PasswordHistory...)
+	if len(history) > passwordHistoryLimit {
+		history = history[:passwordHistoryLimit]
+	}
+
+	id, err := primitive.ObjectIDFromHex(u.ID)
+	if err != nil {
+		return err
+	}
+
+	_, err = utils.GetCollection(UserCollectionName).UpdateByID(ctx, id, bson.M{
+		"$set": bson.M{"password": hash, "password_history": history},
+	})
+
+	return err
+}
+
+func containsPersonalInfo(password string, u *User) bool {
+	pw := strings.ToLower(password)
+	local := strings.SplitN(strings.ToLower(u.Email), "@", 2)[0]
+
+	for _, s := range []string{local, strings.ToLower(u.FirstName), strings.ToLower(u.LastName)} {
+		if len(s) >= minPersonalInfoLen && strings.Contains(pw, s) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,126 @@
+package user
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestPasswordPolicyValidate(t *testing.T) {
+	u := &User{Email: "ada.lovelace@zuri.chat", FirstName: "Ada", LastName: "Lovelace"}
+	strict := PasswordPolicy{
+		MinLength:            10,
+		RequireUppercase:     true,
+		RequireLowercase:     true,
+		RequireDigit:         true,
+		RequireSymbol:        true,
+		DisallowPersonalInfo: true,
+	}
+
+	tests := []struct {
+		name       string
+		password   string
+		violations int
+	}{
+		{"valid", "Tr0ub4dor&3x", 0},
+		{"too short", "Aa1!", 1},
+		{"missing classes", "abcdefghijkl", 3},
+		{"contains last name", "LOVELACE-1234!x", 1},
+		{"contains email local part", "x1!Ada.Lovelace", 1},
+	}
+
+	for _, tt := range tests {
+		err := strict.Validate(tt.password, u)
+		if tt.violations == 0 {
+			if err != nil {
+				t.Errorf("%s: unexpected error %v", tt.name, err)
+			}
+
+			continue
+		}
+
+		var policyErr *PolicyError
+		if !errors.As(err, &policyErr) {
+			t.Errorf("%s: expected a PolicyError, got %v", tt.name, err)
+			continue
+		}
+
+		if len(policyErr.Violations) != tt.violations {
+			t.Errorf("%s: got violations %q, want %d", tt.name, policyErr.Violations, tt.violations)
+		}
+	}
+}
+
+func TestPasswordPolicyStricter(t *testing.T) {
+	global := PasswordPolicy{MinLength: 8, HistorySize: 3, DisallowPersonalInfo: true}
+	org := PasswordPolicy{MinLength: 6, HistorySize: 5, RequireSymbol: true}
+
+	got := global.Stricter(org)
+	want := PasswordPolicy{MinLength: 8, HistorySize: 5, RequireSymbol: true, DisallowPersonalInfo: true}
+
+	if got != want {
+		t.Errorf("Stricter() = %+v, want %+v", got, want)
+	}
+}
+
+func TestPasswordPolicyCheckReuse(t *testing.T) {
+	hash := func(p string) string {
+		b, err := bcrypt.GenerateFromPassword([]byte(p), bcrypt.MinCost)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return string(b)
+	}
+
+	u := &User{Password: hash("current"), PasswordHistory: []string{hash("previous"), hash("ancient")}}
+	policy := PasswordPolicy{HistorySize: 2}
+
+	for _, p := range []string{"current", "previous"} {
+		if err := policy.CheckReuse(p, u); err == nil {
+			t.Errorf("CheckReuse(%q) should reject a recent password", p)
+		}
+	}
+
+	for _, p := range []string{"ancient", "brand new"} {
+		if err := policy.CheckReuse(p, u); err != nil {
+			t.Errorf("CheckReuse(%q) returned %v", p, err)
+		}
+	}
+}
+
+func TestIsBreachedPassword(t *testing.T) {
+	dir := t.TempDir()
+
+	// SHA-1("password") = 5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8
+	content := "0018A45C4D1DEF81644B54AB7F969B88D65:1\r\n1E4C9B93F3F0682250B6CF8331B7EE68FD8:3861493\r\n"
+	if err := os.WriteFile(filepath.Join(dir, "5BAA6"), []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		password string
+		want     bool
+	}{
+		{"password", true},
+		{"correct horse battery staple", false},
+	}
+
+	for _, tt := range tests {
+		got, err := IsBreachedPassword(dir, tt.password)
+		if err != nil {
+			t.Fatalf("IsBreachedPassword(%q) returned error: %v", tt.password, err)
+		}
+
+		if got != tt.want {
+			t.Errorf("IsBreachedPassword(%q) = %v, want %v", tt.password, got, tt.want)
+		}
+	}
+
+	if got, _ := IsBreachedPassword("", "password"); got {
+		t.Error("IsBreachedPassword should be disabled without a directory")
+	}
+}
@@ -0,0 +1,132 @@
+package user
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"zuri.chat/zccore/utils"
+)
+
+const (
+	OneTimeTokenCollectionName = "one_time_tokens"
+	oneTimeCodeLength          = 6
+	maxTokenAttempts           = 5
+)
+
+// TokenPurpose scopes a one-time token to the flow it was issued for, so a code
+// sent for one flow can never be redeemed in another.
+type TokenPurpose string
+
+const (
+	PurposeEmailVerification TokenPurpose = "email_verification"
+	PurposePasswordReset     TokenPurpose = "password_reset"
+	PurposeEmailChange       TokenPurpose = "email_change"
+)
+
+var tokenLifetimes = map[TokenPurpose]time.Duration{
+	PurposeEmailVerification: 24 * time.Hour,
+	PurposePasswordReset:     30 * time.Minute,
+	PurposeEmailChange:       time.Hour,
+}
+
+var ErrInvalidToken = errors.New("invalid or expired code, confirm and try again or request a new one")
+
+// OneTimeToken is a short numeric code emailed to a user. Only a hash of the code
+// is stored, and it is deleted as soon as it is redeemed, expires or runs out of attempts.
+type OneTimeToken struct {
+	ID        primitive.ObjectID     `bson:"_id,omitempty"`
+	UserID    string                 `bson:"user_id"`
+	Email     string                 `bson:"email"`
+	Purpose   TokenPurpose           `bson:"purpose"`
+	TokenHash string                 `bson:"token_hash"`
+	Attempts  int                    `bson:"attempts"`
+	Data      map[string]interface{} `bson:"data,omitempty"`
+	ExpiresAt time.Time              `bson:"expires_at"`
+	CreatedAt time.Time              `bson:"created_at"`
+}
+
+// IssueToken creates a new code for purpose and returns it in plain text so it can be
+// emailed. Any code previously issued to the user for the same purpose stops working.
+func IssueToken(ctx context.Context, purpose TokenPurpose, userID, email string, data map[string]interface{}) (string, error) {
+	code, err := utils.RandomDigits(oneTimeCodeLength)
+	if err != nil {
+		return "", err
+	}
+
+	email = strings.ToLower(email)
+	coll := utils.GetCollection(OneTimeTokenCollectionName)
+
+	if _, err := coll.DeleteMany(ctx, bson.M{"user_id": userID, "purpose": purpose}); err != nil {
+		return "", err
+	}
+
+	t := OneTimeToken{
+		UserID:    userID,
+		Email:     email,
+		Purpose:   purpose,
+		TokenHash: hashCode(purpose, email, code),
+		Data:      data,
+		ExpiresAt: time.Now().Add(tokenLifetimes[purpose]),
+		CreatedAt: time.Now(),
+	}
+
+	if _, err := coll.InsertOne(ctx, t); err != nil {
+		return "", err
+	}
+
+	return code, nil
+}
+
+// CheckToken validates code without redeeming it, for flows that confirm a code
+// before asking for more input. Failed checks count against the token's attempts.
+func CheckToken(ctx context.Context, purpose TokenPurpose, email, code string) (*OneTimeToken, error) {
+	return findToken(ctx, purpose, email, code, false)
+}
+
+// ConsumeToken validates and redeems code. A token can only be consumed once.
+func ConsumeToken(ctx context.Context, purpose TokenPurpose, email, code string) (*OneTimeToken, error) {
+	return findToken(ctx, purpose, email, code, true)
+}
+
+func findToken(ctx context.Context, purpose TokenPurpose, email, code string, consume bool) (*OneTimeToken, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	coll := utils.GetCollection(OneTimeTokenCollectionName)
+	opts := options.FindOne().SetSort(bson.D{{Key: "created_at", Value: -1}})
+
+	var t OneTimeToken
+	if err := coll.FindOne(ctx, bson.M{"purpose": purpose, "email": email}, opts).Decode(&t); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	if time.Now().After(t.ExpiresAt) || t.Attempts >= maxTokenAttempts {
+		//nolint:errcheck //CODEI8: best effort cleanup
+		coll.DeleteOne(ctx, bson.M{"_id": t.ID})
+		return nil, ErrInvalidToken
+	}
+
+	if subtle.ConstantTimeCompare([]byte(t.TokenHash), []byte(hashCode(purpose, email, strings.TrimSpace(code)))) != 1 {
+		//nolint:errcheck //CODEI8: best effort counter
+		coll.UpdateOne(ctx, bson.M{"_id": t.ID}, bson.M{"$inc": bson.M{"attempts": 1}})
+		return nil, ErrInvalidToken
+	}
+
+	if consume {
+		res, err := coll.DeleteOne(ctx, bson.M{"_id": t.ID})
+		if err != nil || res.DeletedCount == 0 {
+			return nil, ErrInvalidToken
+		}
+	}
+
+	return &t, nil
+}
+
+// hashCode binds the code to its purpose and recipient before hashing.
+func hashCode(purpose TokenPurpose, email, code string) string {
+	return utils.HashToken(string(purpose) + ":" + email + ":" + code)
+}
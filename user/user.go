@@ -13,13 +13,13 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
-	"zuri.chat/zccore/service"
 	"zuri.chat/zccore/utils"
 )
 
 var (
 	errEmailNotValid = errors.New("email address is not valid")
 	errHashingFailed = errors.New("failed to hashed password")
+	errInviteClosed  = errors.New("this invite is no longer valid, ask for a new one")
 )
 
 // An end point to create new users.
@@ -57,24 +57,22 @@ func (uh *UserHandler) Create(response http.ResponseWriter, request *http.Reques
 		return
 	}
 
+	user.Email = userEmail
+	if err := CheckNewPassword(uh.configs, GlobalPasswordPolicy(uh.configs), user.Password, &user); err != nil {
+		utils.GetError(err, PasswordErrorStatus(err), response)
+		return
+	}
+
 	hashPassword, err := GetHash(user.Password)
 	if err != nil {
 		utils.GetError(errHashingFailed, http.StatusBadRequest, response)
 		return
 	}
 
-	randomNumberLimit := 6
-	timeLimit := 24
-	_, comfimationToken := utils.RandomGen(randomNumberLimit, "d")
-
-	con := &UserEmailVerification{false, comfimationToken, time.Now().Add(time.Minute * time.Duration(timeLimit))}
-
-	user.Email = userEmail
 	user.CreatedAt = time.Now()
 	user.Password = hashPassword
 	user.Deactivated = false
 	user.IsVerified = false
-	user.EmailVerification = con
 	user.Social = nil
 	user.Timezone = "Africa/Lagos" // set default timezone
 	detail, _ := utils.StructToMap(user)
@@ -85,33 +83,30 @@ func (uh *UserHandler) Create(response http.ResponseWriter, request *http.Reques
 		utils.GetError(err, http.StatusInternalServerError, response)
 		return
 	}
-	// Email Service <- send confirmation mail
-	msger := uh.mailService.NewMail(
-		[]string{user.Email}, "Account Confirmation", service.MailConfirmation, map[string]interface{}{
-			"Username": user.Email,
-			"Code":     comfimationToken,
-		})
-
-	if err := uh.mailService.SendMail(msger); err != nil {
+	userID, _ := res.InsertedID.(primitive.ObjectID)
+	if err := uh.SendVerificationCode(request.Context(), userID.Hex(), user.Email); err != nil {
 		fmt.Printf("Error occurred while sending mail: %s", err.Error())
 	}
 
 	respse := map[string]interface{}{
-		"user_id":           res.InsertedID,
-		"verification_code": comfimationToken,
+		"user_id": res.InsertedID,
 	}
 
 	utils.GetSuccess("user created", respse, response)
 }
 
-// an endpoint to delete a user record.
+// an endpoint to delete a user record. The account is deactivated right away and
+// purged with its sessions, workspace profiles and uploads by the account jobs once the
+// deletion grace period is over, the same as a deletion the user asks for.
 func (uh *UserHandler) DeleteUser(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
 	params := mux.Vars(r)
 	userID := params["user_id"]
 
-	deactivateUpdate := bson.M{"deactivated": true, "deactivated_at": time.Now()}
+	now := time.Now()
+	deleteAt := now.Add(time.Duration(uh.configs.AccountDeletionGracePeriod) * time.Second)
+	deactivateUpdate := bson.M{"deactivated": true, "deactivated_at": now, "status": Disabled, "status_changed_at": now, "deletion_scheduled_at": deleteAt}
 	deactivate, err := utils.UpdateOneMongoDBDoc(UserCollectionName, userID, deactivateUpdate)
 
 	if err != nil {
@@ -150,7 +145,7 @@ func (uh *UserHandler) GetUser(response http.ResponseWriter, request *http.Reque
 		return
 	}
 
-	DeleteMapProps(res, []string{"password"})
+	DeleteMapProps(res, []string{"password", "password_history", "two_factor", "email_verification", "password_resets"})
 	utils.GetSuccess("user retrieved successfully", res, response)
 }
 
@@ -224,7 +219,7 @@ func (uh *UserHandler) GetUsers(response http.ResponseWriter, request *http.Requ
 	res, _ := utils.GetMongoDBDocs(UserCollectionName, bson.M{"deactivated": false})
 
 	for _, doc := range res {
-		DeleteMapProps(doc, []string{"password"})
+		DeleteMapProps(doc, []string{"password", "password_history", "two_factor", "email_verification", "password_resets"})
 	}
 
 	utils.GetSuccess("users retrieved successfully", res, response)
@@ -346,6 +341,11 @@ func (uh *UserHandler) CreateUserFromUUID(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	if !inviteOpen(res, time.Now()) {
+		utils.GetError(errInviteClosed, http.StatusBadRequest, w)
+		return
+	}
+
 	// Validate email
 	email, _ := res["email"].(string) // extract email from UUID
 	userEmail := strings.ToLower(email)
@@ -367,11 +367,15 @@ func (uh *UserHandler) CreateUserFromUUID(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	// UserEmailVerification
-	randomNumberLimit := 6
-	timeLimit := 24
-	_, comfimationToken := utils.RandomGen(randomNumberLimit, "d")
-	con := &UserEmailVerification{true, comfimationToken, time.Now().Add(time.Minute * time.Duration(timeLimit))}
+	// the workspace the guest was invited to may enforce a stricter policy
+	orgID, _ := res["org_id"].(string)
+	policy := PolicyForOrgs(r.Context(), GlobalPasswordPolicy(uh.configs), []string{orgID})
+
+	candidate := &User{FirstName: uRequest.FirstName, LastName: uRequest.LastName, Email: userEmail}
+	if err = CheckNewPassword(uh.configs, policy, uRequest.Password, candidate); err != nil {
+		utils.GetError(err, PasswordErrorStatus(err), w)
+		return
+	}
 
 	// Hash password
 	hashPassword, err := GetHash(uRequest.Password)
@@ -381,14 +385,13 @@ func (uh *UserHandler) CreateUserFromUUID(w http.ResponseWriter, r *http.Request
 	}
 
 	user := &User{
-		FirstName:         uRequest.FirstName,
-		LastName:          uRequest.LastName,
-		Email:             email,
-		Password:          hashPassword,
-		IsVerified:        true,
-		EmailVerification: con,
-		CreatedAt:         time.Now(),
-		Deactivated:       false,
+		FirstName:   uRequest.FirstName,
+		LastName:    uRequest.LastName,
+		Email:       email,
+		Password:    hashPassword,
+		IsVerified:  true,
+		CreatedAt:   time.Now(),
+		Deactivated: false,
 	}
 
 	// Save user to DB
@@ -403,6 +406,20 @@ func (uh *UserHandler) CreateUserFromUUID(w http.ResponseWriter, r *http.Request
 	utils.GetSuccess("user successfully created", resp, w)
 }
 
+// inviteOpen tells whether a workspace invite can still be used, see organizations.Invite.
+func inviteOpen(invite map[string]interface{}, now time.Time) bool {
+	status, _ := invite["status"].(string)
+	accepted, _ := invite["has_accepted"].(bool)
+
+	if accepted || (status != "" && status != "pending") {
+		return false
+	}
+
+	expiresAt, ok := invite["expires_at"].(primitive.DateTime)
+
+	return !ok || expiresAt.Time().After(now)
+}
+
 func DeleteMapProps(m map[string]interface{}, s []string) {
 	for _, v := range s {
 		delete(m, v)
@@ -1 +1,62 @@
 package user
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"zuri.chat/zccore/service"
+	"zuri.chat/zccore/utils"
+)
+
+// SendVerificationCode issues a fresh email verification code and mails it to the user.
+func (uh *UserHandler) SendVerificationCode(ctx context.Context, userID, email string) error {
+	code, err := IssueToken(ctx, PurposeEmailVerification, userID, email, nil)
+	if err != nil {
+		return err
+	}
+
+	msger := uh.mailService.NewMail(
+		[]string{email}, "Account Confirmation", service.MailConfirmation, map[string]interface{}{
+			"Username": email,
+			"Code":     code,
+		})
+
+	return uh.mailService.SendMail(msger)
+}
+
+// ResendVerificationCode replaces any outstanding verification code of an unverified
+// account. The response is the same whether or not the account exists.
+func (uh *UserHandler) ResendVerificationCode(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("content-type", "application/json")
+
+	req := struct {
+		Email string `json:"email" validate:"email,required"`
+	}{}
+
+	if err := utils.ParseJSONFromRequest(r, &req); err != nil {
+		utils.GetError(err, http.StatusUnprocessableEntity, w)
+		return
+	}
+
+	if err := validate.Struct(req); err != nil {
+		utils.GetError(err, http.StatusBadRequest, w)
+		return
+	}
+
+	var u User
+
+	doc, err := utils.GetMongoDBDoc(UserCollectionName, bson.M{"email": strings.ToLower(req.Email), "isverified": false})
+	if err == nil && utils.BsonToStruct(doc, &u) == nil {
+		// sent in the background so response times don't reveal whether the account exists
+		go func() {
+			if err := uh.SendVerificationCode(context.Background(), u.ID, u.Email); err != nil {
+				fmt.Printf("Error occurred while sending mail: %s", err.Error())
+			}
+		}()
+	}
+
+	utils.GetSuccess("if the account exists and is not yet verified, a new code has been sent", nil, w)
+}
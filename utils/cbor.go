@@ -0,0 +1,217 @@
+package utils
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// maxCBORDepth bounds the nesting of decoded CBOR, which comes from clients.
+const maxCBORDepth = 16
+
+var ErrInvalidCBOR = errors.New("invalid cbor")
+
+// DecodeCBOR decodes the first CBOR (RFC 8949) data item in data and returns it with
+// the bytes that follow it. Only the definite-length encodings authenticators use are
+// supported. Items decode to uint64 or int64, []byte, string, []interface{},
+// map[interface{}]interface{}, bool, float64 or nil; tags are dropped.
+func DecodeCBOR(data []byte) (interface{}, []byte, error) {
+	d := cborDecoder{data: data}
+
+	v, err := d.decode(0)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return v, d.data[d.off:], nil
+}
+
+type cborDecoder struct {
+	data []byte
+	off  int
+}
+
+func (d *cborDecoder) read(n uint64) ([]byte, error) {
+	if n > uint64(len(d.data)-d.off) {
+		return nil, fmt.Errorf("%w: unexpected end of data", ErrInvalidCBOR)
+	}
+
+	b := d.data[d.off : d.off+int(n)]
+	d.off += int(n)
+
+	return b, nil
+}
+
+// head reads an item's major type and argument.
+func (d *cborDecoder) head() (major byte, info byte, arg uint64, err error) {
+	b, err := d.read(1)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	major, info = b[0]>>5, b[0]&0x1f
+
+	switch {
+	case info < 24:
+		return major, info, uint64(info), nil
+	case info <= 27:
+		n, err := d.read(1 << (info - 24))
+		if err != nil {
+			return 0, 0, 0, err
+		}
+
+		switch len(n) {
+		case 1:
+			arg = uint64(n[0])
+		case 2:
+			arg = uint64(binary.BigEndian.Uint16(n))
+		case 4:
+			arg = uint64(binary.BigEndian.Uint32(n))
+		default:
+			arg = binary.BigEndian.Uint64(n)
+		}
+
+		return major, info, arg, nil
+	case info == 31:
+		return 0, 0, 0, fmt.Errorf("%w: indefinite lengths are not supported", ErrInvalidCBOR)
+	default:
+		return 0, 0, 0, fmt.Errorf("%w: reserved additional information %d", ErrInvalidCBOR, info)
+	}
+}
+
+func (d *cborDecoder) decode(depth int) (interface{}, error) {
+	if depth > maxCBORDepth {
+		return nil, fmt.Errorf("%w: nested too deeply", ErrInvalidCBOR)
+	}
+
+	major, info, arg, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+
+	switch major {
+	case 0:
+		return arg, nil
+	case 1:
+		if arg > math.MaxInt64 {
+			return nil, fmt.Errorf("%w: negative integer out of range", ErrInvalidCBOR)
+		}
+
+		return -1 - int64(arg), nil
+	case 2:
+		b, err := d.read(arg)
+		if err != nil {
+			return nil, err
+		}
+
+		return append([]byte(nil), b...), nil
+	case 3:
+		b, err := d.read(arg)
+		if err != nil {
+			return nil, err
+		}
+
+		return string(b), nil
+	case 4:
+		// every item takes at least a byte, which bounds allocations by the input size
+		if arg > uint64(len(d.data)-d.off) {
+			return nil, fmt.Errorf("%w: unexpected end of data", ErrInvalidCBOR)
+		}
+
+		list := make([]interface{}, 0, arg)
+
+		for i := uint64(0); i < arg; i++ {
+			v, err := d.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+
+			list = append(list, v)
+		}
+
+		return list, nil
+	case 5:
+		if arg > uint64(len(d.data)-d.off)/2 {
+			return nil, fmt.Errorf("%w: unexpected end of data", ErrInvalidCBOR)
+		}
+
+		m := make(map[interface{}]interface{}, arg)
+
+		for i := uint64(0); i < arg; i++ {
+			k, err := d.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+
+			switch k.(type) {
+			case uint64, int64, string:
+			default:
+				return nil, fmt.Errorf("%w: unsupported map key type %T", ErrInvalidCBOR, k)
+			}
+
+			if _, ok := m[k]; ok {
+				return nil, fmt.Errorf("%w: duplicate map key %v", ErrInvalidCBOR, k)
+			}
+
+			v, err := d.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+
+			m[k] = v
+		}
+
+		return m, nil
+	case 6:
+		return d.decode(depth + 1)
+	default:
+		return simpleCBOR(info, arg)
+	}
+}
+
+// simpleCBOR decodes the simple values and floats of major type 7.
+func simpleCBOR(info byte, arg uint64) (interface{}, error) {
+	switch info {
+	case 20:
+		return false, nil
+	case 21:
+		return true, nil
+	case 22, 23:
+		return nil, nil
+	case 25:
+		return float16(uint16(arg)), nil
+	case 26:
+		return float64(math.Float32frombits(uint32(arg))), nil
+	case 27:
+		return math.Float64frombits(arg), nil
+	default:
+		return nil, fmt.Errorf("%w: unsupported simple value %d", ErrInvalidCBOR, arg)
+	}
+}
+
+func float16(h uint16) float64 {
+	exp := int(h>>10) & 0x1f
+	mant := float64(h & 0x3ff)
+
+	var v float64
+
+	switch exp {
+	case 0:
+		v = math.Ldexp(mant, -24)
+	case 31:
+		if mant == 0 {
+			v = math.Inf(1)
+		} else {
+			v = math.NaN()
+		}
+	default:
+		v = math.Ldexp(mant+1024, exp-25)
+	}
+
+	if h&0x8000 != 0 {
+		return -v
+	}
+
+	return v
+}
@@ -0,0 +1,92 @@
+package utils
+
+import (
+	"encoding/hex"
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+)
+
+// Examples from RFC 8949 appendix A.
+func TestDecodeCBOR(t *testing.T) {
+	tests := []struct {
+		in   string
+		want interface{}
+	}{
+		{"00", uint64(0)},
+		{"17", uint64(23)},
+		{"1818", uint64(24)},
+		{"1903e8", uint64(1000)},
+		{"1bffffffffffffffff", uint64(math.MaxUint64)},
+		{"20", int64(-1)},
+		{"3903e7", int64(-1000)},
+		{"f90000", float64(0)},
+		{"f93c00", float64(1)},
+		{"f9c400", float64(-4)},
+		{"fa47c35000", float64(100000)},
+		{"fb3ff199999999999a", 1.1},
+		{"f4", false},
+		{"f5", true},
+		{"f6", nil},
+		{"4401020304", []byte{1, 2, 3, 4}},
+		{"6449455446", "IETF"},
+		{"83010203", []interface{}{uint64(1), uint64(2), uint64(3)}},
+		{"8301820203820405", []interface{}{uint64(1), []interface{}{uint64(2), uint64(3)}, []interface{}{uint64(4), uint64(5)}}},
+		{"a201020304", map[interface{}]interface{}{uint64(1): uint64(2), uint64(3): uint64(4)}},
+		{"a26161016162820203", map[interface{}]interface{}{"a": uint64(1), "b": []interface{}{uint64(2), uint64(3)}}},
+		{"c11a514b67b0", uint64(1363896240)},
+	}
+
+	for _, tt := range tests {
+		data, _ := hex.DecodeString(tt.in)
+
+		got, rest, err := DecodeCBOR(data)
+		if err != nil {
+			t.Errorf("DecodeCBOR(%s) returned error: %v", tt.in, err)
+			continue
+		}
+
+		if len(rest) != 0 {
+			t.Errorf("DecodeCBOR(%s) left %d bytes", tt.in, len(rest))
+		}
+
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("DecodeCBOR(%s) = %#v, want %#v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeCBORRest(t *testing.T) {
+	data, _ := hex.DecodeString("a10102ff00")
+
+	_, rest, err := DecodeCBOR(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if hex.EncodeToString(rest) != "ff00" {
+		t.Errorf("rest = %x, want ff00", rest)
+	}
+}
+
+func TestDecodeCBORErrors(t *testing.T) {
+	for _, in := range []string{
+		"",
+		"18",                                   // missing argument
+		"4401",                                 // short byte string
+		"5f42010243030405ff",                   // indefinite length
+		"9a7fffffff",                           // array longer than the input
+		"a20102",                               // map missing an entry
+		"a201020103",                           // duplicate key
+		"a1400102",                             // byte string key
+		"1c",                                   // reserved additional information
+		"818181818181818181818181818181818100", // nested too deeply
+	} {
+		data, _ := hex.DecodeString(in)
+
+		if _, _, err := DecodeCBOR(data); !errors.Is(err, ErrInvalidCBOR) {
+			t.Errorf("DecodeCBOR(%s) error = %v, want ErrInvalidCBOR", in, err)
+		}
+	}
+}
@@ -2,7 +2,8 @@ package utils
 
 import (
 	"fmt"
-	"time"
+	"log"
+	"strings"
 
 	"github.com/spf13/viper"
 )
@@ -35,6 +36,17 @@ type Configurations struct {
 	TokenBillingNoticeTemplate string
 	WorkSpaceInviteTemplate    string
 	WorkSpaceWelcomeTemplate   string
+	PluginDelistedTemplate     string
+	NewDeviceLoginTemplate     string
+	AccountLockedTemplate      string
+	MagicLinkTemplate          string
+	AccountDeletionTemplate    string
+	EmailChangeTemplate        string
+	EmailChangeNoticeTemplate  string
+	OrgDeletionTemplate        string
+	OwnershipTransferTemplate  string
+
+	NewDeviceEmail bool
 
 	CentrifugoKey      string
 	CentrifugoEndpoint string
@@ -42,8 +54,69 @@ type Configurations struct {
 	GoogleOAuthURL   string
 	GoogleOAuthV3URL string
 	FacebookOAuthURL string
+	OIDCProviders    map[string]OIDCProvider
+
+	// ServerName is the public base URL of this API, used to build SAML endpoints.
+	ServerName      string
+	SSORedirectURIs []string
+	// MagicLinkURL is the client page sign-in links point to, it receives the token
+	// in the "token" query parameter.
+	MagicLinkURL string
+	// EmailRevertURL is the client page the link to undo an email change opens, with
+	// the token in the "token" query parameter. The link works EmailRevertTTL seconds.
+	EmailRevertURL string
+	EmailRevertTTL int
+
+	// WebAuthn relying party: passkeys are bound to WebAuthnRPID and can only be used
+	// from WebAuthnOrigins.
+	WebAuthnRPID    string
+	WebAuthnRPName  string
+	WebAuthnOrigins []string
+
+	// TrustedProxies are the IPs or CIDR ranges of the load balancers in front of the
+	// API, the client address is only taken from X-Forwarded-For when they send it.
+	TrustedProxies []string
+
+	// AccountDeletionGracePeriod is how long, in seconds, a deleted account can still be
+	// restored. Data exports are written to AccountExportDir and kept AccountExportTTL seconds.
+	AccountDeletionGracePeriod int
+	AccountExportDir           string
+	AccountExportTTL           int
+
+	// OrgDeletionGracePeriod is how long, in seconds, the owner of a deleted
+	// organization can still cancel the deletion before its data is purged.
+	OrgDeletionGracePeriod int
+	// OwnershipTransferURL is the client page the link to accept the ownership of an
+	// organization opens, with the token in the "token" query parameter. The offer
+	// stands for OwnershipTransferTTL seconds.
+	OwnershipTransferURL string
+	OwnershipTransferTTL int
+	// WorkspaceURLRedirectTTL is how long, in seconds, the previous URL of a workspace
+	// keeps resolving to it after the URL changes.
+	WorkspaceURLRedirectTTL int
+
+	// Workspace invites can be accepted for InviteTTL seconds, and sent again at most
+	// InviteMaxResends times.
+	InviteTTL        int
+	InviteMaxResends int
 
 	HmacSampleSecret string
+	SigningKeys      SigningKeys
+	AccessTokenTTL   int
+	RefreshTokenTTL  int
+	// APITokenTTL is the default lifetime of personal access tokens and API keys,
+	// APITokenMaxTTL the longest one that can be requested.
+	APITokenTTL    int
+	APITokenMaxTTL int
+
+	PasswordMinLength            int
+	PasswordRequireUppercase     bool
+	PasswordRequireLowercase     bool
+	PasswordRequireDigit         bool
+	PasswordRequireSymbol        bool
+	PasswordDisallowPersonalInfo bool
+	PasswordHistorySize          int
+	BreachedPasswordsDir         string
 
 	// Agora details
 	AppId         string
@@ -68,7 +141,11 @@ func NewConfigurations() *Configurations {
 	viper.SetDefault("SECRET_KEY", "5d5c7f94e29ba12a21f682be310d3af4")
 	viper.SetDefault("SESSION_KEY", "f6822af94e29ba112be310d3af45d5c7")
 	viper.SetDefault("HMAC_SECRET", "u7b8be9bd9b9ebd9b9dbdbee")
-	viper.SetDefault("SESSION_MAX_AGE", time.Now().Unix()+(31536000*200))
+	viper.SetDefault("SESSION_MAX_AGE", 2592000)    // 30 days, in seconds
+	viper.SetDefault("ACCESS_TOKEN_TTL", 900)       // 15 minutes, in seconds
+	viper.SetDefault("REFRESH_TOKEN_TTL", 2592000)  // 30 days, in seconds
+	viper.SetDefault("API_TOKEN_TTL", 7776000)      // 90 days, in seconds
+	viper.SetDefault("API_TOKEN_MAX_TTL", 31536000) // 365 days, in seconds
 	viper.SetDefault("USER_COLLECTION", "users")
 	viper.SetDefault("SESSION_COLLECTION", "session_store")
 	viper.SetDefault("CONFIRM_EMAIL_TEMPLATE", "./templates/confirm_email.html")
@@ -78,6 +155,36 @@ func NewConfigurations() *Configurations {
 	viper.SetDefault("TOKEN_BILLING_NOTICE_TEMPLATE", "./templates/token_billing_notice.html")
 	viper.SetDefault("WORKSPACE_INVITE_TEMPLATE", "./templates/workspace_invite.html")
 	viper.SetDefault("WORKSPACE_WELCOME_TEMPLATE", "./templates/workspace_welcome.html")
+	viper.SetDefault("PLUGIN_DELISTED_TEMPLATE", "./templates/plugin_delisted.html")
+	viper.SetDefault("NEW_DEVICE_LOGIN_TEMPLATE", "./templates/new_device_login.html")
+	viper.SetDefault("NEW_DEVICE_EMAIL", true)
+	viper.SetDefault("ACCOUNT_LOCKED_TEMPLATE", "./templates/account_locked.html")
+	viper.SetDefault("MAGIC_LINK_TEMPLATE", "./templates/magic_link.html")
+	viper.SetDefault("ACCOUNT_DELETION_TEMPLATE", "./templates/account_deletion.html")
+	viper.SetDefault("EMAIL_CHANGE_TEMPLATE", "./templates/email_change.html")
+	viper.SetDefault("EMAIL_CHANGE_NOTICE_TEMPLATE", "./templates/email_change_notice.html")
+	viper.SetDefault("ORG_DELETION_TEMPLATE", "./templates/organization_deletion.html")
+	viper.SetDefault("OWNERSHIP_TRANSFER_TEMPLATE", "./templates/ownership_transfer.html")
+	viper.SetDefault("PASSWORD_MIN_LENGTH", 8)
+	viper.SetDefault("PASSWORD_DISALLOW_PERSONAL_INFO", true)
+	viper.SetDefault("PASSWORD_HISTORY", 3)
+	viper.SetDefault("SERVER_NAME", "https://api.zuri.chat/")
+	viper.SetDefault("SSO_REDIRECT_URIS", "https://zuri.chat/sso/callback")
+	viper.SetDefault("MAGIC_LINK_URL", "https://zuri.chat/login/magic")
+	viper.SetDefault("EMAIL_REVERT_URL", "https://zuri.chat/account/email/revert")
+	viper.SetDefault("EMAIL_REVERT_TTL", 259200) // 3 days, in seconds
+	viper.SetDefault("WEBAUTHN_RP_ID", "zuri.chat")
+	viper.SetDefault("WEBAUTHN_RP_NAME", "Zuri Chat")
+	viper.SetDefault("WEBAUTHN_ORIGINS", "https://zuri.chat")
+	viper.SetDefault("ACCOUNT_DELETION_GRACE_PERIOD", 1209600) // 14 days, in seconds
+	viper.SetDefault("ACCOUNT_EXPORT_DIR", "./exports")
+	viper.SetDefault("ACCOUNT_EXPORT_TTL", 604800)         // 7 days, in seconds
+	viper.SetDefault("ORG_DELETION_GRACE_PERIOD", 2592000) // 30 days, in seconds
+	viper.SetDefault("OWNERSHIP_TRANSFER_URL", "https://zuri.chat/ownership/accept")
+	viper.SetDefault("OWNERSHIP_TRANSFER_TTL", 259200)      // 3 days, in seconds
+	viper.SetDefault("WORKSPACE_URL_REDIRECT_TTL", 7776000) // 90 days, in seconds
+	viper.SetDefault("INVITE_TTL", 604800)                  // 7 days, in seconds
+	viper.SetDefault("INVITE_MAX_RESENDS", 5)
 	viper.SetDefault("GOOGLE_OAUTH_V3", "https://www.googleapis.com/oauth2/v3/userinfo?access_token=:access_token")
 
 	configs := &Configurations{
@@ -98,6 +205,16 @@ func NewConfigurations() *Configurations {
 		TokenBillingNoticeTemplate: viper.GetString("TOKEN_BILLING_NOTICE_TEMPLATE"),
 		WorkSpaceInviteTemplate:    viper.GetString("WORKSPACE_INVITE_TEMPLATE"),
 		WorkSpaceWelcomeTemplate:   viper.GetString("WORKSPACE_WELCOME_TEMPLATE"),
+		PluginDelistedTemplate:     viper.GetString("PLUGIN_DELISTED_TEMPLATE"),
+		NewDeviceLoginTemplate:     viper.GetString("NEW_DEVICE_LOGIN_TEMPLATE"),
+		AccountLockedTemplate:      viper.GetString("ACCOUNT_LOCKED_TEMPLATE"),
+		MagicLinkTemplate:          viper.GetString("MAGIC_LINK_TEMPLATE"),
+		AccountDeletionTemplate:    viper.GetString("ACCOUNT_DELETION_TEMPLATE"),
+		EmailChangeTemplate:        viper.GetString("EMAIL_CHANGE_TEMPLATE"),
+		EmailChangeNoticeTemplate:  viper.GetString("EMAIL_CHANGE_NOTICE_TEMPLATE"),
+		OrgDeletionTemplate:        viper.GetString("ORG_DELETION_TEMPLATE"),
+		OwnershipTransferTemplate:  viper.GetString("OWNERSHIP_TRANSFER_TEMPLATE"),
+		NewDeviceEmail:             viper.GetBool("NEW_DEVICE_EMAIL"),
 
 		SMTPUsername:  viper.GetString("SMTP_USERNAME"),
 		SMTPPassword:  viper.GetString("SMTP_PASSWORD"),
@@ -114,12 +231,129 @@ func NewConfigurations() *Configurations {
 		GoogleOAuthV3URL: viper.GetString("GOOGLE_OAUTH_V3"),
 		FacebookOAuthURL: viper.GetString("FACEBOOK_OAUTH"),
 
+		ServerName:      strings.TrimSuffix(viper.GetString("SERVER_NAME"), "/"),
+		SSORedirectURIs: splitList(viper.GetString("SSO_REDIRECT_URIS")),
+		MagicLinkURL:    viper.GetString("MAGIC_LINK_URL"),
+		EmailRevertURL:  viper.GetString("EMAIL_REVERT_URL"),
+		EmailRevertTTL:  viper.GetInt("EMAIL_REVERT_TTL"),
+		WebAuthnRPID:    viper.GetString("WEBAUTHN_RP_ID"),
+		WebAuthnRPName:  viper.GetString("WEBAUTHN_RP_NAME"),
+		WebAuthnOrigins: splitList(viper.GetString("WEBAUTHN_ORIGINS")),
+
+		TrustedProxies: splitList(viper.GetString("TRUSTED_PROXIES")),
+
+		AccountDeletionGracePeriod: viper.GetInt("ACCOUNT_DELETION_GRACE_PERIOD"),
+		AccountExportDir:           viper.GetString("ACCOUNT_EXPORT_DIR"),
+		AccountExportTTL:           viper.GetInt("ACCOUNT_EXPORT_TTL"),
+
+		OrgDeletionGracePeriod: viper.GetInt("ORG_DELETION_GRACE_PERIOD"),
+		OwnershipTransferURL:   viper.GetString("OWNERSHIP_TRANSFER_URL"),
+		OwnershipTransferTTL:   viper.GetInt("OWNERSHIP_TRANSFER_TTL"),
+
+		WorkspaceURLRedirectTTL: viper.GetInt("WORKSPACE_URL_REDIRECT_TTL"),
+
+		InviteTTL:        viper.GetInt("INVITE_TTL"),
+		InviteMaxResends: viper.GetInt("INVITE_MAX_RESENDS"),
+
 		HmacSampleSecret: viper.GetString("HMAC_SECRET"),
+		AccessTokenTTL:   viper.GetInt("ACCESS_TOKEN_TTL"),
+		RefreshTokenTTL:  viper.GetInt("REFRESH_TOKEN_TTL"),
+		APITokenTTL:      viper.GetInt("API_TOKEN_TTL"),
+		APITokenMaxTTL:   viper.GetInt("API_TOKEN_MAX_TTL"),
+
+		PasswordMinLength:            viper.GetInt("PASSWORD_MIN_LENGTH"),
+		PasswordRequireUppercase:     viper.GetBool("PASSWORD_REQUIRE_UPPERCASE"),
+		PasswordRequireLowercase:     viper.GetBool("PASSWORD_REQUIRE_LOWERCASE"),
+		PasswordRequireDigit:         viper.GetBool("PASSWORD_REQUIRE_DIGIT"),
+		PasswordRequireSymbol:        viper.GetBool("PASSWORD_REQUIRE_SYMBOL"),
+		PasswordDisallowPersonalInfo: viper.GetBool("PASSWORD_DISALLOW_PERSONAL_INFO"),
+		PasswordHistorySize:          viper.GetInt("PASSWORD_HISTORY"),
+		BreachedPasswordsDir:         viper.GetString("BREACHED_PASSWORDS_DIR"),
 
 		// Agora details
 		AppId:         viper.GetString("APP_ID"),
 		AppCerificate: viper.GetString("APP_CERTIFICATE"),
 	}
 
+	keys, err := ParseSigningKeys(viper.GetString("JWT_SIGNING_KEYS"), viper.GetString("JWT_ACTIVE_KID"), configs.HmacSampleSecret)
+	if err != nil {
+		log.Fatalf("invalid jwt signing keys: %v", err)
+	}
+
+	configs.SigningKeys = keys
+
+	providers, err := loadOIDCProviders()
+	if err != nil {
+		log.Fatalf("invalid openid connect providers: %v", err)
+	}
+
+	configs.OIDCProviders = providers
+
 	return configs
 }
+
+// OIDCProvider is an OpenID Connect identity provider users can sign in with.
+type OIDCProvider struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+	// RedirectURIs lists the client callback URLs registered with the provider.
+	RedirectURIs []string
+}
+
+// AllowsRedirect reports whether uri is one of the registered redirect URIs.
+func (p OIDCProvider) AllowsRedirect(uri string) bool {
+	for _, allowed := range p.RedirectURIs {
+		if uri == allowed {
+			return true
+		}
+	}
+
+	return false
+}
+
+// loadOIDCProviders reads the providers named in OIDC_PROVIDERS, each configured
+// through OIDC_<NAME>_ISSUER, _CLIENT_ID, _CLIENT_SECRET, _SCOPES and _REDIRECT_URIS.
+func loadOIDCProviders() (map[string]OIDCProvider, error) {
+	providers := make(map[string]OIDCProvider)
+
+	for _, name := range splitList(viper.GetString("OIDC_PROVIDERS")) {
+		name = strings.ToLower(name)
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+
+		p := OIDCProvider{
+			Name:         name,
+			Issuer:       strings.TrimSuffix(viper.GetString(prefix+"ISSUER"), "/"),
+			ClientID:     viper.GetString(prefix + "CLIENT_ID"),
+			ClientSecret: viper.GetString(prefix + "CLIENT_SECRET"),
+			Scopes:       strings.Fields(viper.GetString(prefix + "SCOPES")),
+			RedirectURIs: splitList(viper.GetString(prefix + "REDIRECT_URIS")),
+		}
+
+		if p.Issuer == "" || p.ClientID == "" || len(p.RedirectURIs) == 0 {
+			return nil, fmt.Errorf("provider %q needs %sISSUER, %sCLIENT_ID and %sREDIRECT_URIS", name, prefix, prefix, prefix)
+		}
+
+		if len(p.Scopes) == 0 {
+			p.Scopes = []string{"openid", "email", "profile"}
+		}
+
+		providers[name] = p
+	}
+
+	return providers, nil
+}
+
+func splitList(s string) []string {
+	var out []string
+
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+
+	return out
+}
@@ -45,6 +45,29 @@ func ConnectToDB(clusterURL string) error {
 		ec.Check(CreateUniqueIndex("users", "email", 1))
 		ec.Check(CreateUniqueIndex("plugins", "template_url", 1))
 		ec.Check(CreateTextIndexForPlugins())
+		ec.Check(CreateUniqueIndex("marketplace_collections", "slug", 1))
+		ec.Check(CreateUniqueIndex("two_factor_challenges", "token_hash", 1))
+		ec.Check(CreateUniqueIndex("refresh_tokens", "token_hash", 1))
+		ec.Check(CreateUniqueIndex("login_attempts", "key", 1))
+		ec.Check(CreateUniqueIndex("oidc_states", "state_hash", 1))
+		ec.Check(CreateUniqueIndex("saml_requests", "request_id", 1))
+		ec.Check(CreateUniqueIndex("saml_assertions", "assertion_id", 1))
+		ec.Check(CreateUniqueIndex("sso_handoffs", "token_hash", 1))
+		ec.Check(CreateUniqueIndex("api_tokens", "token_hash", 1))
+		ec.Check(CreateUniqueIndex("magic_links", "token_hash", 1))
+		ec.Check(CreateUniqueIndex("passkeys", "credential_id", 1))
+		ec.Check(CreateUniqueIndex("webauthn_challenges", "challenge_hash", 1))
+		ec.Check(CreateUniqueIndex("scim_tokens", "token_hash", 1))
+		ec.Check(CreateCompoundUniqueIndex("organization_roles", "org_id", "name"))
+		ec.Check(CreateUniqueIndex("email_reverts", "token_hash", 1))
+		ec.Check(CreateUniqueIndex("organization_invite_links", "token_hash", 1))
+		ec.Check(CreateIndex("audit_logs", bson.D{{Key: "org_id", Value: 1}, {Key: "created_at", Value: -1}}))
+		ec.Check(CreateCompoundUniqueIndex("user_groups", "org_id", "handle"))
+		ec.Check(CreateUniqueIndex("ownership_transfers", "token_hash", 1))
+		ec.Check(CreateUniqueIndex("workspace_url_redirects", "url", 1))
+		ec.Check(CreateIndex("user_groups", bson.D{{Key: "org_id", Value: 1}, {Key: "members", Value: 1}}))
+		ec.Check(CreateIndex("members", bson.D{{Key: "org_id", Value: 1}, {Key: "joined_at", Value: 1}, {Key: "_id", Value: 1}}))
+		ec.Check(CreateIndex("members", bson.D{{Key: "org_id", Value: 1}, {Key: "search_keys", Value: 1}}))
 	})
 
 	return ec.err
@@ -298,6 +321,72 @@ func CreateUniqueIndex(collName, field string, order int) error {
 	return nil
 }
 
+// CreateCompoundUniqueIndex makes the combination of fields unique in a collection.
+func CreateCompoundUniqueIndex(collName string, fields ...string) error {
+	collection := defaultMongoHandle.GetCollection(collName)
+
+	keys := bson.D{}
+	for _, field := range fields {
+		keys = append(keys, bson.E{Key: field, Value: 1})
+	}
+
+	indexModel := mongo.IndexModel{
+		Keys:    keys,
+		Options: options.Index().SetUnique(true),
+	}
+
+	timeOutFactor := 3
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeOutFactor)*time.Second)
+
+	defer cancel()
+
+	_, err := collection.Indexes().CreateOne(ctx, indexModel)
+	if err != nil {
+		return fmt.Errorf("failed to create unique index on fields %v in %s", fields, collName)
+	}
+
+	return nil
+}
+
+// CreatePartialUniqueIndex makes field unique among the documents matching filter.
+func CreatePartialUniqueIndex(collName, field string, filter bson.M) error {
+	collection := defaultMongoHandle.GetCollection(collName)
+
+	indexModel := mongo.IndexModel{
+		Keys:    bson.M{field: 1},
+		Options: options.Index().SetUnique(true).SetPartialFilterExpression(filter),
+	}
+
+	timeOutFactor := 3
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeOutFactor)*time.Second)
+
+	defer cancel()
+
+	_, err := collection.Indexes().CreateOne(ctx, indexModel)
+	if err != nil {
+		return fmt.Errorf("failed to create unique index on field %s in %s", field, collName)
+	}
+
+	return nil
+}
+
+// CreateIndex adds a plain index with the given keys to a collection.
+func CreateIndex(collName string, keys bson.D) error {
+	collection := defaultMongoHandle.GetCollection(collName)
+
+	timeOutFactor := 3
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeOutFactor)*time.Second)
+
+	defer cancel()
+
+	_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: keys})
+	if err != nil {
+		return fmt.Errorf("failed to create index %v in %s", keys, collName)
+	}
+
+	return nil
+}
+
 func CreateTextIndexForPlugins() error {
 	collection := defaultMongoHandle.GetCollection("plugins")
 
@@ -0,0 +1,69 @@
+package utils
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+	"testing"
+)
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func TestJWKPublicKey(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	set := JWKSet{Keys: []JWK{
+		{Kid: "rsa", Kty: "RSA", Use: "sig", N: b64(rsaKey.N.Bytes()), E: b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+		{Kid: "ec", Kty: "EC", Crv: "P-256", X: b64(ecKey.X.Bytes()), Y: b64(ecKey.Y.Bytes())},
+		{Kid: "enc", Kty: "RSA", Use: "enc", N: b64(rsaKey.N.Bytes()), E: "AQAB"},
+	}}
+
+	got, err := set.Key("rsa")
+	if err != nil {
+		t.Fatalf("Key(rsa) returned error: %v", err)
+	}
+
+	if pub, ok := got.(*rsa.PublicKey); !ok || !pub.Equal(&rsaKey.PublicKey) {
+		t.Errorf("Key(rsa) = %v, want the generated RSA key", got)
+	}
+
+	got, err = set.Key("ec")
+	if err != nil {
+		t.Fatalf("Key(ec) returned error: %v", err)
+	}
+
+	if pub, ok := got.(*ecdsa.PublicKey); !ok || !pub.Equal(&ecKey.PublicKey) {
+		t.Errorf("Key(ec) = %v, want the generated EC key", got)
+	}
+
+	if _, err := set.Key("enc"); err == nil {
+		t.Error("Key(enc) should skip encryption keys")
+	}
+
+	if _, err := set.Key("missing"); err != ErrUnknownSigningKey {
+		t.Errorf("Key(missing) error = %v, want ErrUnknownSigningKey", err)
+	}
+}
+
+func TestPKCEChallenge(t *testing.T) {
+	// RFC 7636 appendix B
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	want := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+
+	if got := PKCEChallenge(verifier); got != want {
+		t.Errorf("PKCEChallenge() = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,83 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/golang-jwt/jwt"
+)
+
+const defaultSigningKID = "default"
+
+var ErrUnknownSigningKey = errors.New("token signed with an unknown key")
+
+// SigningKeys is the ring of HMAC keys access tokens are signed with. Every token
+// carries the kid of its key in the header, so retired keys can keep verifying
+// tokens until they expire while new tokens are signed with the active key.
+type SigningKeys struct {
+	ActiveKID string
+	Keys      map[string][]byte
+}
+
+// ParseSigningKeys reads a "kid:secret,kid:secret" list. When spec is empty the ring
+// holds only fallback under the "default" kid.
+func ParseSigningKeys(spec, activeKID, fallback string) (SigningKeys, error) {
+	ring := SigningKeys{ActiveKID: activeKID, Keys: map[string][]byte{}}
+
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		parts := strings.SplitN(pair, ":", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return ring, fmt.Errorf("invalid signing key entry %q, expected kid:secret", pair)
+		}
+
+		ring.Keys[parts[0]] = []byte(parts[1])
+	}
+
+	if len(ring.Keys) == 0 {
+		ring.Keys[defaultSigningKID] = []byte(fallback)
+	}
+
+	if ring.ActiveKID == "" {
+		if _, ok := ring.Keys[defaultSigningKID]; !ok {
+			return ring, errors.New("JWT_ACTIVE_KID must be set when JWT_SIGNING_KEYS is")
+		}
+
+		ring.ActiveKID = defaultSigningKID
+	}
+
+	if _, ok := ring.Keys[ring.ActiveKID]; !ok {
+		return ring, fmt.Errorf("active signing key %q is not in the key ring", ring.ActiveKID)
+	}
+
+	return ring, nil
+}
+
+// Sign signs claims with the active key and stamps its kid in the header.
+func (k SigningKeys) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	token.Header["kid"] = k.ActiveKID
+
+	return token.SignedString(k.Keys[k.ActiveKID])
+}
+
+// Keyfunc resolves the verification key for a token from its kid header.
+func (k SigningKeys) Keyfunc(token *jwt.Token) (interface{}, error) {
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+
+	kid, _ := token.Header["kid"].(string)
+
+	key, ok := k.Keys[kid]
+	if !ok {
+		return nil, ErrUnknownSigningKey
+	}
+
+	return key, nil
+}
@@ -0,0 +1,54 @@
+package utils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+func TestParseSigningKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		active  string
+		wantKID string
+		wantErr bool
+	}{
+		{"falls back to hmac secret", "", "", "default", false},
+		{"explicit ring", "k1:one,k2:two", "k2", "k2", false},
+		{"ring without active kid", "k1:one", "", "", true},
+		{"active kid not in ring", "k1:one", "k3", "", true},
+		{"malformed entry", "k1", "k1", "", true},
+	}
+
+	for _, tt := range tests {
+		ring, err := ParseSigningKeys(tt.spec, tt.active, "fallback")
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("%s: unexpected error %v", tt.name, err)
+		}
+
+		if err == nil && ring.ActiveKID != tt.wantKID {
+			t.Errorf("%s: active kid = %q, want %q", tt.name, ring.ActiveKID, tt.wantKID)
+		}
+	}
+}
+
+func TestSigningKeysRotation(t *testing.T) {
+	old, _ := ParseSigningKeys("k1:one", "k1", "")
+	signed, err := old.Sign(jwt.MapClaims{"exp": time.Now().Add(time.Minute).Unix()})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotated, _ := ParseSigningKeys("k1:one,k2:two", "k2", "")
+	if _, err := jwt.Parse(signed, rotated.Keyfunc); err != nil {
+		t.Errorf("token signed with a retired key should still verify: %v", err)
+	}
+
+	retired, _ := ParseSigningKeys("k2:two", "k2", "")
+	if _, err := jwt.Parse(signed, retired.Keyfunc); err == nil {
+		t.Error("token signed with a removed key should not verify")
+	}
+}
@@ -0,0 +1,163 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// trustedProxies are the networks of the load balancers in front of the API, only
+// they are believed about the client address.
+var trustedProxies []*net.IPNet
+
+// SetTrustedProxies sets the proxies ClientIP takes X-Forwarded-For from, as IPs or
+// CIDR ranges.
+func SetTrustedProxies(proxies []string) error {
+	nets := make([]*net.IPNet, 0, len(proxies))
+
+	for _, p := range proxies {
+		if !strings.Contains(p, "/") {
+			if ip := net.ParseIP(p); ip != nil && ip.To4() != nil {
+				p += "/32"
+			} else {
+				p += "/128"
+			}
+		}
+
+		_, n, err := net.ParseCIDR(p)
+		if err != nil {
+			return fmt.Errorf("invalid trusted proxy %q: %w", p, err)
+		}
+
+		nets = append(nets, n)
+	}
+
+	trustedProxies = nets
+
+	return nil
+}
+
+func isTrustedProxy(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+
+	for _, n := range trustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ClientIP returns the originating client address. X-Forwarded-For is only read when
+// the request comes from a trusted proxy, and then the right-most hop that isn't one
+// is the client, anything to its left was sent by the client itself.
+func ClientIP(r *http.Request) string {
+	remote, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remote = r.RemoteAddr
+	}
+
+	if !isTrustedProxy(remote) {
+		return remote
+	}
+
+	if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+		hops := strings.Split(fwd, ",")
+
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if net.ParseIP(hop) == nil {
+				break
+			}
+
+			if !isTrustedProxy(hop) {
+				return hop
+			}
+
+			remote = hop
+		}
+
+		return remote
+	}
+
+	if ip := strings.TrimSpace(r.Header.Get("X-Real-IP")); net.ParseIP(ip) != nil {
+		return ip
+	}
+
+	return remote
+}
+
+// LocationLabel returns an approximate "City, Country" label from the geo headers
+// added by the CDN in front of the API, or an empty string when none are present.
+func LocationLabel(r *http.Request) string {
+	var parts []string
+
+	for _, h := range []string{"CF-IPCity", "X-AppEngine-City"} {
+		if v := r.Header.Get(h); v != "" {
+			parts = append(parts, v)
+			break
+		}
+	}
+
+	for _, h := range []string{"CF-IPCountry", "X-AppEngine-Country", "X-Country-Code"} {
+		if v := r.Header.Get(h); v != "" && v != "XX" {
+			parts = append(parts, strings.ToUpper(v))
+			break
+		}
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+// DeviceLabel turns a user agent into a short human readable label such as
+// "Chrome on Windows".
+func DeviceLabel(userAgent string) string {
+	ua := strings.ToLower(userAgent)
+
+	browser := "Unknown browser"
+
+	// order matters, most user agents also claim to be Safari or Chrome
+	for _, b := range []struct{ token, name string }{
+		{"edg/", "Edge"},
+		{"opr/", "Opera"},
+		{"firefox/", "Firefox"},
+		{"chrome/", "Chrome"},
+		{"safari/", "Safari"},
+		{"okhttp", "Zuri Chat Android"},
+		{"cfnetwork", "Zuri Chat iOS"},
+	} {
+		if strings.Contains(ua, b.token) {
+			browser = b.name
+			break
+		}
+	}
+
+	// electron apps also carry a chrome token
+	if strings.Contains(ua, "electron/") {
+		browser = "Zuri Chat Desktop"
+	}
+
+	os := "unknown OS"
+
+	for _, o := range []struct{ token, name string }{
+		{"android", "Android"},
+		{"iphone", "iOS"},
+		{"ipad", "iPadOS"},
+		{"windows", "Windows"},
+		{"mac os x", "macOS"},
+		{"cros", "ChromeOS"},
+		{"linux", "Linux"},
+	} {
+		if strings.Contains(ua, o.token) {
+			os = o.name
+			break
+		}
+	}
+
+	return browser + " on " + os
+}
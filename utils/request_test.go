@@ -0,0 +1,54 @@
+package utils
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "10.0.0.1:5678"
+
+	if got := ClientIP(r); got != "10.0.0.1" {
+		t.Errorf("ClientIP without proxy headers = %q", got)
+	}
+
+	r.Header.Set("X-Forwarded-For", "198.51.100.9, 203.0.113.7, 10.0.0.2")
+
+	if got := ClientIP(r); got != "10.0.0.1" {
+		t.Errorf("ClientIP from an untrusted peer = %q, want the peer", got)
+	}
+
+	if err := SetTrustedProxies([]string{"10.0.0.0/8"}); err != nil {
+		t.Fatal(err)
+	}
+	defer SetTrustedProxies(nil) //nolint:errcheck //CODEI8: reset
+
+	if got := ClientIP(r); got != "203.0.113.7" {
+		t.Errorf("ClientIP behind proxy = %q, want the right-most untrusted hop", got)
+	}
+
+	if err := SetTrustedProxies([]string{"not a network"}); err == nil {
+		t.Error("SetTrustedProxies accepted an invalid network")
+	}
+}
+
+func TestDeviceLabel(t *testing.T) {
+	tests := []struct {
+		ua   string
+		want string
+	}{
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/95.0.4638.69 Safari/537.36", "Chrome on Windows"},
+		{"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/15.1 Safari/605.1.15", "Safari on macOS"},
+		{"Mozilla/5.0 (X11; Linux x86_64; rv:94.0) Gecko/20100101 Firefox/94.0", "Firefox on Linux"},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/95.0 Safari/537.36 Edg/95.0.1020.53", "Edge on Windows"},
+		{"Mozilla/5.0 (iPhone; CPU iPhone OS 15_1 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/15.1 Mobile/15E148 Safari/604.1", "Safari on iOS"},
+		{"", "Unknown browser on unknown OS"},
+	}
+
+	for _, tt := range tests {
+		if got := DeviceLabel(tt.ua); got != tt.want {
+			t.Errorf("DeviceLabel(%q) = %q, want %q", tt.ua, got, tt.want)
+		}
+	}
+}
@@ -2,7 +2,6 @@ package utils
 
 import (
 	"errors"
-	"net"
 	"net/http"
 	"sync"
 
@@ -13,6 +12,8 @@ import (
 var zcVisitors = make(map[string]*rate.Limiter)
 var mutex sync.Mutex
 
+var errTooManyRequests = errors.New("too many requests, slow down and try again")
+
 // Retrieve and return the rate limiter for the current visitor if it
 // already exists. Else, create a new rate limiter and add it to the
 // visitor map using the visitor's IP address as the key.
@@ -34,17 +35,15 @@ func getVisitor(ip string) *rate.Limiter {
 func Throttle(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// Get the IP address for the current user
-		ip, _, err := net.SplitHostPort(r.RemoteAddr)
-		if err != nil {
-			GetError(errors.New("error extracting ip address"), http.StatusInternalServerError, w)
-			return
-		}
+		ip := ClientIP(r)
 
 		// Call the getVisitor function to retrieve the rate limiter for the
 		// current user
 		limiter := getVisitor(ip)
 		if !limiter.Allow() {
-			GetError(err, http.StatusTooManyRequests, w)
+			w.Header().Set("Retry-After", "1")
+			GetError(errTooManyRequests, http.StatusTooManyRequests, w)
+
 			return
 		}
 
@@ -0,0 +1,45 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"math/big"
+)
+
+// RandomToken returns n cryptographically random bytes encoded as unpadded base64url,
+// suitable for opaque bearer tokens, recovery codes and nonces.
+func RandomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HashToken returns the hex encoded SHA-256 digest of token. Tokens are only ever
+// persisted in this form so a database leak does not leak usable credentials.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// RandomDigits returns a cryptographically random numeric code of length n, for
+// codes users have to type in from an email.
+func RandomDigits(n int) (string, error) {
+	const digits = "0123456789"
+
+	b := make([]byte, n)
+	for i := range b {
+		idx, err := rand.Int(rand.Reader, big.NewInt(int64(len(digits))))
+		if err != nil {
+			return "", err
+		}
+
+		b[i] = digits[idx.Int64()]
+	}
+
+	return string(b), nil
+}
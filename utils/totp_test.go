@@ -0,0 +1,104 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// RFC 6238 appendix B test secret for HMAC-SHA1, "12345678901234567890" in base32.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode(t *testing.T) {
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+	}
+
+	for _, tt := range tests {
+		got, err := TOTPCode(rfcSecret, time.Unix(tt.unix, 0), 8)
+		if err != nil {
+			t.Fatalf("TOTPCode(%d) returned error: %v", tt.unix, err)
+		}
+
+		if got != tt.want {
+			t.Errorf("TOTPCode(%d) = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+
+	tests := []struct {
+		name string
+		code string
+		want bool
+	}{
+		{"current step", "005924", true},
+		{"previous step", mustCode(t, now.Add(-30*time.Second)), true},
+		{"next step", mustCode(t, now.Add(30*time.Second)), true},
+		{"outside drift window", mustCode(t, now.Add(-90*time.Second)), false},
+		{"wrong length", "5924", false},
+		{"garbage", "abcdef", false},
+	}
+
+	for _, tt := range tests {
+		if got := ValidateTOTP(rfcSecret, tt.code, now); got != tt.want {
+			t.Errorf("%s: ValidateTOTP(%q) = %v, want %v", tt.name, tt.code, got, tt.want)
+		}
+	}
+}
+
+func TestMatchTOTP(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	counter := now.Unix() / 30
+
+	if step, ok := MatchTOTP(rfcSecret, mustCode(t, now.Add(-30*time.Second)), now); !ok || step != counter-1 {
+		t.Errorf("MatchTOTP of the previous code = %d, %v, want %d", step, ok, counter-1)
+	}
+
+	if _, ok := MatchTOTP(rfcSecret, "abcdef", now); ok {
+		t.Error("MatchTOTP accepted garbage")
+	}
+}
+
+func TestTOTPProvisioningURI(t *testing.T) {
+	uri := TOTPProvisioningURI(rfcSecret, "Zuri Chat", "jane@example.com")
+
+	if !strings.HasPrefix(uri, "otpauth://totp/Zuri%20Chat:jane@example.com?") {
+		t.Errorf("unexpected label in %s", uri)
+	}
+
+	if !strings.Contains(uri, "secret="+rfcSecret) {
+		t.Errorf("secret missing from %s", uri)
+	}
+}
+
+func TestGenerateTOTPSecret(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := TOTPCode(secret, time.Now(), totpDigits); err != nil {
+		t.Errorf("generated secret %q is not valid base32: %v", secret, err)
+	}
+}
+
+func mustCode(t *testing.T, at time.Time) string {
+	t.Helper()
+
+	code, err := TOTPCode(rfcSecret, at, totpDigits)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return code
+}
@@ -259,20 +259,55 @@ func RandomGen(n int, sType string) (status bool, str string) {
 	return false, "wrong type"
 }
 
+// WorkspaceURLDomain is the domain workspace URLs are hosted under.
+const WorkspaceURLDomain = ".zurichat.com"
+
+// GenWorkspaceURL derives a workspace URL from the name of the organization with a
+// random suffix, e.g. "Zuri Chat" -> "zuri-chat-abc1234.zurichat.com". Callers check
+// the URL is free.
 func GenWorkspaceURL(orgName string) string {
-	organizationsCollection := "organizations"
-	orgNamestr := strings.ReplaceAll(strings.ToLower(orgName), " ", "")
+	const maxNameLen = 24
+
+	name := Slugify(orgName)
+	if len(name) > maxNameLen {
+		name = strings.TrimSuffix(name[:maxNameLen], "-")
+	}
+
+	if name == "" {
+		name = "workspace"
+	}
+
 	lenRandLetters, lenRandNumbers := 3, 4
 	_, randLetters := RandomGen(lenRandLetters, "l")
 	_, randNumbers := RandomGen(lenRandNumbers, "d")
-	wksURL := orgNamestr + "-" + randLetters + randNumbers + ".zurichat.com"
 
-	result, _ := GetMongoDBDoc(organizationsCollection, bson.M{"url": wksURL})
-	if result != nil {
-		GenWorkspaceURL(orgName)
+	return name + "-" + randLetters + randNumbers + WorkspaceURLDomain
+}
+
+// Slugify lowercases s and collapses every run of characters that are not
+// letters or digits into a single hyphen, e.g. "Productivity Picks!" -> "productivity-picks".
+func Slugify(s string) string {
+	var b strings.Builder
+
+	hyphen := false
+
+	for _, c := range strings.ToLower(strings.TrimSpace(s)) {
+		if (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') {
+			b.WriteRune(c)
+
+			hyphen = false
+
+			continue
+		}
+
+		if !hyphen && b.Len() > 0 {
+			b.WriteByte('-')
+
+			hyphen = true
+		}
 	}
 
-	return wksURL
+	return strings.TrimSuffix(b.String(), "-")
 }
 
 func GenJwtToken(data, tokenType string) (string, error) {
@@ -395,3 +430,12 @@ func Emitter(event Event) int {
 
 	return status
 }
+
+// CSVSafe keeps spreadsheets from evaluating cells of exported user controlled values.
+func CSVSafe(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+
+	return s
+}
@@ -0,0 +1,159 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"fmt"
+	"math/big"
+
+	"zuri.chat/zccore/utils"
+)
+
+// COSE algorithm identifiers (RFC 8152) of the signatures we accept.
+const (
+	AlgES256 int64 = -7
+	AlgEdDSA int64 = -8
+	AlgRS256 int64 = -257
+)
+
+// SupportedAlgorithms is the order credential algorithms are offered to authenticators.
+var SupportedAlgorithms = []int64{AlgES256, AlgEdDSA, AlgRS256}
+
+// COSE key parameters.
+const (
+	coseKty    = 1
+	coseAlg    = 3
+	coseCrv    = -1
+	coseX      = -2
+	coseY      = -3
+	coseRSAN   = -1
+	coseRSAE   = -2
+	ktyOKP     = 1
+	ktyEC2     = 2
+	ktyRSA     = 3
+	crvP256    = 1
+	crvEd25519 = 6
+
+	minRSABits = 2048
+)
+
+// PublicKey is a credential public key decoded from its COSE form.
+type PublicKey struct {
+	Algorithm int64
+	Key       crypto.PublicKey
+}
+
+// ParsePublicKey decodes a COSE_Key as found in attested credential data.
+func ParsePublicKey(cose []byte) (*PublicKey, error) {
+	v, rest, err := utils.DecodeCBOR(cose)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPublicKey, err)
+	}
+
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("%w: trailing data", ErrInvalidPublicKey)
+	}
+
+	m, ok := v.(map[interface{}]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%w: not a map", ErrInvalidPublicKey)
+	}
+
+	kty, _ := intValue(m, coseKty)
+	alg, ok := intValue(m, coseAlg)
+
+	if !ok {
+		return nil, fmt.Errorf("%w: missing algorithm", ErrInvalidPublicKey)
+	}
+
+	switch {
+	case kty == ktyEC2 && alg == AlgES256:
+		crv, _ := intValue(m, coseCrv)
+		x, y := bytesValue(m, coseX), bytesValue(m, coseY)
+
+		if crv != crvP256 || len(x) != 32 || len(y) != 32 {
+			return nil, fmt.Errorf("%w: unsupported curve", ErrInvalidPublicKey)
+		}
+
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return nil, fmt.Errorf("%w: point is not on the curve", ErrInvalidPublicKey)
+		}
+
+		return &PublicKey{Algorithm: alg, Key: key}, nil
+	case kty == ktyOKP && alg == AlgEdDSA:
+		crv, _ := intValue(m, coseCrv)
+		x := bytesValue(m, coseX)
+
+		if crv != crvEd25519 || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("%w: unsupported curve", ErrInvalidPublicKey)
+		}
+
+		return &PublicKey{Algorithm: alg, Key: ed25519.PublicKey(x)}, nil
+	case kty == ktyRSA && alg == AlgRS256:
+		n, e := bytesValue(m, coseRSAN), new(big.Int).SetBytes(bytesValue(m, coseRSAE))
+
+		if len(n)*8 < minRSABits || !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("%w: unsupported rsa key", ErrInvalidPublicKey)
+		}
+
+		return &PublicKey{Algorithm: alg, Key: &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(e.Int64())}}, nil
+	default:
+		return nil, fmt.Errorf("%w: unsupported key type %d with algorithm %d", ErrInvalidPublicKey, kty, alg)
+	}
+}
+
+// Verify checks sig over data.
+func (k *PublicKey) Verify(data, sig []byte) bool {
+	switch key := k.Key.(type) {
+	case *ecdsa.PublicKey:
+		digest := sha256.Sum256(data)
+		return ecdsa.VerifyASN1(key, digest[:], sig)
+	case ed25519.PublicKey:
+		return ed25519.Verify(key, data, sig)
+	case *rsa.PublicKey:
+		digest := sha256.Sum256(data)
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig) == nil
+	default:
+		return false
+	}
+}
+
+// intValue reads an integer COSE parameter, CBOR decodes them as uint64 or int64.
+func intValue(m map[interface{}]interface{}, label int64) (int64, bool) {
+	v, ok := m[coseLabel(label)]
+	if !ok {
+		return 0, false
+	}
+
+	switch v := v.(type) {
+	case int64:
+		return v, true
+	case uint64:
+		if v > 1<<63-1 {
+			return 0, false
+		}
+
+		return int64(v), true
+	default:
+		return 0, false
+	}
+}
+
+func bytesValue(m map[interface{}]interface{}, label int64) []byte {
+	b, _ := m[coseLabel(label)].([]byte)
+	return b
+}
+
+// coseLabel is the map key a label decodes to.
+func coseLabel(label int64) interface{} {
+	if label < 0 {
+		return label
+	}
+
+	return uint64(label)
+}
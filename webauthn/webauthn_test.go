@@ -0,0 +1,224 @@
+package webauthn
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+var testRP = &RelyingParty{ID: "zuri.chat", Name: "Zuri Chat", Origins: []string{"https://zuri.chat"}}
+
+// encodeCBOR encodes the few types authenticators produce.
+func encodeCBOR(v interface{}) []byte {
+	head := func(major byte, n uint64) []byte {
+		switch {
+		case n < 24:
+			return []byte{major<<5 | byte(n)}
+		case n < 1<<8:
+			return []byte{major<<5 | 24, byte(n)}
+		case n < 1<<16:
+			b := []byte{major<<5 | 25, 0, 0}
+			binary.BigEndian.PutUint16(b[1:], uint16(n))
+
+			return b
+		default:
+			b := []byte{major<<5 | 26, 0, 0, 0, 0}
+			binary.BigEndian.PutUint32(b[1:], uint32(n))
+
+			return b
+		}
+	}
+
+	switch v := v.(type) {
+	case int:
+		if v < 0 {
+			return head(1, uint64(-1-v))
+		}
+
+		return head(0, uint64(v))
+	case []byte:
+		return append(head(2, uint64(len(v))), v...)
+	case string:
+		return append(head(3, uint64(len(v))), v...)
+	case map[interface{}]interface{}:
+		out := head(5, uint64(len(v)))
+		for k, val := range v {
+			out = append(out, encodeCBOR(k)...)
+			out = append(out, encodeCBOR(val)...)
+		}
+
+		return out
+	default:
+		panic("unsupported type")
+	}
+}
+
+func ecKey(t *testing.T) (*ecdsa.PrivateKey, []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	x, y := make([]byte, 32), make([]byte, 32)
+	key.X.FillBytes(x)
+	key.Y.FillBytes(y)
+
+	return key, encodeCBOR(map[interface{}]interface{}{1: 2, 3: -7, -1: 1, -2: x, -3: y})
+}
+
+func authData(flags byte, signCount uint32, credentialID, publicKey []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(testRP.ID))
+
+	b := append([]byte(nil), rpIDHash[:]...)
+	b = append(b, flags, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(b[33:], signCount)
+
+	if credentialID != nil {
+		b = append(b, make([]byte, 16)...)
+		b = append(b, byte(len(credentialID)>>8), byte(len(credentialID)))
+		b = append(b, credentialID...)
+		b = append(b, publicKey...)
+	}
+
+	return b
+}
+
+func clientData(ceremony, challenge, origin string) []byte {
+	b, _ := json.Marshal(ClientData{Type: ceremony, Challenge: challenge, Origin: origin})
+	return b
+}
+
+func sign(t *testing.T, key *ecdsa.PrivateKey, ad, cd []byte) []byte {
+	t.Helper()
+
+	hash := sha256.Sum256(cd)
+	digest := sha256.Sum256(append(append([]byte(nil), ad...), hash[:]...))
+
+	sig, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return sig
+}
+
+func TestVerifyRegistration(t *testing.T) {
+	_, cose := ecKey(t)
+	challenge, _ := NewChallenge()
+	credentialID := []byte("credential-1")
+
+	attestation := func(flags byte) []byte {
+		return encodeCBOR(map[interface{}]interface{}{
+			"fmt":      "none",
+			"attStmt":  map[interface{}]interface{}{},
+			"authData": authData(flags, 0, credentialID, cose),
+		})
+	}
+
+	cred, err := testRP.VerifyRegistration(challenge, clientData(ceremonyCreate, challenge, "https://zuri.chat"),
+		attestation(FlagUserPresent|FlagUserVerified|FlagAttestedCredential), true)
+	if err != nil {
+		t.Fatalf("VerifyRegistration returned error: %v", err)
+	}
+
+	if string(cred.ID) != string(credentialID) || cred.Algorithm != AlgES256 || !cred.UserVerified {
+		t.Errorf("unexpected credential %+v", cred)
+	}
+
+	tests := []struct {
+		name        string
+		clientData  []byte
+		attestation []byte
+		want        error
+	}{
+		{"wrong ceremony", clientData(ceremonyGet, challenge, "https://zuri.chat"), attestation(FlagUserPresent | FlagUserVerified | FlagAttestedCredential), ErrInvalidClientData},
+		{"wrong challenge", clientData(ceremonyCreate, "other", "https://zuri.chat"), attestation(FlagUserPresent | FlagUserVerified | FlagAttestedCredential), ErrChallengeMismatch},
+		{"wrong origin", clientData(ceremonyCreate, challenge, "https://evil.example"), attestation(FlagUserPresent | FlagUserVerified | FlagAttestedCredential), ErrOriginMismatch},
+		{"not verified", clientData(ceremonyCreate, challenge, "https://zuri.chat"), attestation(FlagUserPresent | FlagAttestedCredential), ErrUserNotVerified},
+		{"not present", clientData(ceremonyCreate, challenge, "https://zuri.chat"), attestation(FlagUserVerified | FlagAttestedCredential), ErrUserNotPresent},
+		{"no credential", clientData(ceremonyCreate, challenge, "https://zuri.chat"), encodeCBOR(map[interface{}]interface{}{
+			"fmt": "none", "attStmt": map[interface{}]interface{}{}, "authData": authData(FlagUserPresent|FlagUserVerified, 0, nil, nil),
+		}), ErrInvalidAuthData},
+		{"garbage", clientData(ceremonyCreate, challenge, "https://zuri.chat"), []byte{0xff}, ErrInvalidAttestation},
+	}
+
+	for _, tt := range tests {
+		if _, err := testRP.VerifyRegistration(challenge, tt.clientData, tt.attestation, true); !errors.Is(err, tt.want) {
+			t.Errorf("%s: error = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestVerifyAssertion(t *testing.T) {
+	key, cose := ecKey(t)
+	challenge, _ := NewChallenge()
+	cd := clientData(ceremonyGet, challenge, "https://zuri.chat")
+	ad := authData(FlagUserPresent|FlagUserVerified, 5, nil, nil)
+
+	got, err := testRP.VerifyAssertion(challenge, cd, ad, sign(t, key, ad, cd), cose, 4, true)
+	if err != nil {
+		t.Fatalf("VerifyAssertion returned error: %v", err)
+	}
+
+	if got.SignCount != 5 {
+		t.Errorf("SignCount = %d, want 5", got.SignCount)
+	}
+
+	if _, err := testRP.VerifyAssertion(challenge, cd, ad, sign(t, key, ad, cd), cose, 5, true); !errors.Is(err, ErrSignCount) {
+		t.Errorf("replayed counter error = %v, want ErrSignCount", err)
+	}
+
+	other, _ := ecKey(t)
+	if _, err := testRP.VerifyAssertion(challenge, cd, ad, sign(t, other, ad, cd), cose, 0, true); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("foreign signature error = %v, want ErrInvalidSignature", err)
+	}
+
+	zero := authData(FlagUserPresent, 0, nil, nil)
+	if _, err := testRP.VerifyAssertion(challenge, cd, zero, sign(t, key, zero, cd), cose, 0, false); err != nil {
+		t.Errorf("counterless authenticator error = %v", err)
+	}
+
+	if _, err := testRP.VerifyAssertion(challenge, cd, zero, sign(t, key, zero, cd), cose, 0, true); !errors.Is(err, ErrUserNotVerified) {
+		t.Errorf("unverified user error = %v, want ErrUserNotVerified", err)
+	}
+
+	other2 := &RelyingParty{ID: "example.com", Origins: testRP.Origins}
+	if _, err := other2.VerifyAssertion(challenge, cd, ad, sign(t, key, ad, cd), cose, 0, true); !errors.Is(err, ErrRPIDMismatch) {
+		t.Errorf("other relying party error = %v, want ErrRPIDMismatch", err)
+	}
+}
+
+func TestParsePublicKey(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := ParsePublicKey(encodeCBOR(map[interface{}]interface{}{1: 1, 3: -8, -1: 6, -2: []byte(pub)}))
+	if err != nil {
+		t.Fatalf("ParsePublicKey returned error: %v", err)
+	}
+
+	if !key.Verify([]byte("data"), ed25519.Sign(priv, []byte("data"))) {
+		t.Error("ed25519 signature did not verify")
+	}
+
+	for name, cose := range map[string][]byte{
+		"unknown algorithm": encodeCBOR(map[interface{}]interface{}{1: 2, 3: -36, -1: 1, -2: make([]byte, 32), -3: make([]byte, 32)}),
+		"point off curve":   encodeCBOR(map[interface{}]interface{}{1: 2, 3: -7, -1: 1, -2: make([]byte, 32), -3: make([]byte, 32)}),
+		"short rsa key":     encodeCBOR(map[interface{}]interface{}{1: 3, 3: -257, -1: make([]byte, 128), -2: []byte{1, 0, 1}}),
+		"not a map":         encodeCBOR("key"),
+	} {
+		if _, err := ParsePublicKey(cose); !errors.Is(err, ErrInvalidPublicKey) {
+			t.Errorf("%s: error = %v, want ErrInvalidPublicKey", name, err)
+		}
+	}
+}